@@ -0,0 +1,402 @@
+// Package anthropic adapts the Anthropic Messages API to the [oai] bridge, so
+// that clients built for Anthropic's SDKs can talk to Claude Code through the
+// same translation layer as OpenAI clients.
+//
+// [MessagesRequest.ChatRequest] converts an inbound /v1/messages request into
+// an [oai.ChatCompletionRequest]; [MessagesFromChat] reshapes the bridged
+// response, and [StreamState] turns streamed chat chunks into Anthropic's
+// typed SSE events (message_start, content_block_delta, and so on).
+package anthropic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+	"github.com/codewandler/cc-sdk-go/oai"
+)
+
+// MessagesRequest represents an Anthropic Messages API request, as accepted
+// by the /v1/messages endpoint. System may be a plain string or an array of
+// text blocks.
+//
+// Temperature, TopP, and MaxTokens are handled as on
+// [oai.ChatCompletionRequest]; output cut at MaxTokens has the stop reason
+// "max_tokens". TopK is accepted for API compatibility but is not forwarded
+// to the Claude Code CLI. StopSequences are honored by the bridge, which
+// cuts the output at the first match; the reply then has the stop reason
+// "stop_sequence". Of ToolChoice, only disable_parallel_tool_use is honored. Thinking of type "enabled" returns
+// the model's thinking blocks alongside the text.
+type MessagesRequest struct {
+	Model         string          `json:"model"`
+	System        Content         `json:"system,omitempty"`
+	Messages      []Message       `json:"messages"`
+	MaxTokens     int             `json:"max_tokens"`
+	Stream        bool            `json:"stream,omitempty"`
+	StopSequences []string        `json:"stop_sequences,omitempty"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	TopK          *int            `json:"top_k,omitempty"`
+	Tools         []Tool          `json:"tools,omitempty"`
+	ToolChoice    *ToolChoice     `json:"tool_choice,omitempty"`
+	Thinking      *Thinking       `json:"thinking,omitempty"`
+	Metadata      json.RawMessage `json:"metadata,omitempty"`
+}
+
+// Message is a single conversation turn. Role is "user" or "assistant".
+type Message struct {
+	Role    string  `json:"role"`
+	Content Content `json:"content"`
+}
+
+// Content is a list of content blocks. In JSON it may also be given as a
+// plain string, which is read as a single text block.
+type Content []ContentBlock
+
+// UnmarshalJSON accepts either a string or an array of content blocks.
+func (c *Content) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*c = Content{{Type: "text", Text: s}}
+		return nil
+	}
+	var blocks []ContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+	*c = blocks
+	return nil
+}
+
+// Text concatenates the text of all text blocks.
+func (c Content) Text() string {
+	var b strings.Builder
+	for _, block := range c {
+		if block.Type == "text" {
+			b.WriteString(block.Text)
+		}
+	}
+	return b.String()
+}
+
+// ContentBlock is one element of a message's content. Which fields apply
+// depends on Type:
+//
+//   - "text": Text.
+//   - "thinking": Thinking and Signature.
+//   - "tool_use": ID, Name, and Input.
+//   - "tool_result": ToolUseID, Content, and IsError.
+type ContentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	Thinking  string          `json:"thinking,omitempty"`
+	Signature string          `json:"signature,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   Content         `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+}
+
+// MarshalJSON always includes the fields Anthropic's SDKs require for the
+// block's type, such as an empty text on a text block that starts a stream.
+func (b ContentBlock) MarshalJSON() ([]byte, error) {
+	switch b.Type {
+	case "text":
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}{b.Type, b.Text})
+	case "thinking":
+		return json.Marshal(struct {
+			Type      string `json:"type"`
+			Thinking  string `json:"thinking"`
+			Signature string `json:"signature"`
+		}{b.Type, b.Thinking, b.Signature})
+	case "tool_use":
+		input := b.Input
+		if len(input) == 0 {
+			input = json.RawMessage("{}")
+		}
+		return json.Marshal(struct {
+			Type  string          `json:"type"`
+			ID    string          `json:"id"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		}{b.Type, b.ID, b.Name, input})
+	}
+	type plain ContentBlock // drops this method
+	return json.Marshal(plain(b))
+}
+
+// Tool is a tool definition. InputSchema is the JSON Schema of the input.
+type Tool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema,omitempty"`
+}
+
+// ToolChoice controls tool use. Type is "auto", "any", "tool", or "none".
+type ToolChoice struct {
+	Type                   string `json:"type"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
+// Thinking configures extended thinking. Type is "enabled" or "disabled".
+type Thinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+// ChatRequest converts the request into an equivalent
+// [oai.ChatCompletionRequest] so the regular chat bridge can be reused:
+//
+//   - System becomes a system message.
+//   - Text blocks of a turn become the message content.
+//   - tool_use blocks on assistant turns become tool calls, and tool_result
+//     blocks on user turns become "tool" messages placed before the turn's
+//     text.
+//   - Thinking blocks are dropped; they are never sent back to the model.
+//
+// It returns an error for an empty conversation, an unknown role, or a
+// content block type the bridge cannot represent, such as an image.
+func (r *MessagesRequest) ChatRequest() (*oai.ChatCompletionRequest, error) {
+	if len(r.Messages) == 0 {
+		return nil, errors.New("messages is required")
+	}
+
+	req := &oai.ChatCompletionRequest{
+		Model:       r.Model,
+		Stream:      r.Stream,
+		Temperature: r.Temperature,
+		TopP:        r.TopP,
+	}
+	if r.MaxTokens > 0 {
+		req.MaxTokens = &r.MaxTokens
+	}
+	if len(r.StopSequences) > 0 {
+		req.Stop = r.StopSequences
+	}
+	if r.ToolChoice != nil && r.ToolChoice.DisableParallelToolUse {
+		parallel := false
+		req.ParallelToolCalls = &parallel
+	}
+	if r.Thinking != nil && r.Thinking.Type == "enabled" {
+		req.IncludeThinking = true
+	}
+	for _, tool := range r.Tools {
+		req.Tools = append(req.Tools, oai.Tool{
+			Type: "function",
+			Function: oai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+
+	if system := r.System.Text(); system != "" {
+		req.Messages = append(req.Messages, oai.ChatMessage{Role: "system", Content: system})
+	}
+	for i, msg := range r.Messages {
+		msgs, err := chatMessages(msg)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		req.Messages = append(req.Messages, msgs...)
+	}
+	return req, nil
+}
+
+// chatMessages converts a single turn into one or more chat messages.
+func chatMessages(msg Message) ([]oai.ChatMessage, error) {
+	if msg.Role != "user" && msg.Role != "assistant" {
+		return nil, fmt.Errorf("unsupported role %q", msg.Role)
+	}
+
+	var out []oai.ChatMessage
+	var text strings.Builder
+	var calls []oai.ToolCall
+	for _, block := range msg.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "thinking", "redacted_thinking":
+		case "tool_use":
+			if msg.Role != "assistant" {
+				return nil, errors.New("tool_use blocks are only allowed in assistant messages")
+			}
+			args := string(block.Input)
+			if args == "" {
+				args = "{}"
+			}
+			calls = append(calls, oai.ToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: oai.FunctionCall{Name: block.Name, Arguments: args},
+			})
+		case "tool_result":
+			if msg.Role != "user" {
+				return nil, errors.New("tool_result blocks are only allowed in user messages")
+			}
+			out = append(out, oai.ChatMessage{Role: "tool", ToolCallID: block.ToolUseID, Content: block.Content.Text()})
+		default:
+			return nil, fmt.Errorf("unsupported content block type %q", block.Type)
+		}
+	}
+
+	if text.Len() > 0 || len(calls) > 0 {
+		out = append(out, oai.ChatMessage{Role: msg.Role, Content: text.String(), ToolCalls: calls})
+	}
+	return out, nil
+}
+
+// MessagesResponse represents an Anthropic Messages API response. Type is
+// always "message" and Role always "assistant".
+type MessagesResponse struct {
+	ID           string  `json:"id"`
+	Type         string  `json:"type"` // "message"
+	Role         string  `json:"role"` // "assistant"
+	Model        string  `json:"model"`
+	Content      Content `json:"content"`
+	StopReason   *string `json:"stop_reason"`
+	StopSequence *string `json:"stop_sequence"`
+	Usage        Usage   `json:"usage"`
+}
+
+// Usage reports token counts. Unlike [oai.Usage], cache reads and writes are
+// counted separately from InputTokens.
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// UsageFromResult returns the token usage reported by result, or zero usage
+// if result is nil.
+func UsageFromResult(result *ccwire.ResultMessage) Usage {
+	if result == nil {
+		return Usage{}
+	}
+	return Usage{
+		InputTokens:              result.Usage.InputTokens,
+		OutputTokens:             result.Usage.OutputTokens,
+		CacheCreationInputTokens: result.Usage.CacheCreationInputTokens,
+		CacheReadInputTokens:     result.Usage.CacheReadInputTokens,
+	}
+}
+
+// StopReason maps an OpenAI finish reason to the Anthropic stop reason:
+// "tool_calls" becomes "tool_use", "length" becomes "max_tokens",
+// "content_filter" becomes "refusal", and everything else "end_turn". A
+// finish reason does not tell whether a stop sequence ended the reply, so
+// "stop_sequence" is left to [MessagesFromChat] and [StreamState.Finish].
+func StopReason(finishReason string) string {
+	switch finishReason {
+	case "tool_calls":
+		return "tool_use"
+	case "length":
+		return "max_tokens"
+	case "content_filter":
+		return "refusal"
+	}
+	return "end_turn"
+}
+
+// MessagesFromChat reshapes a bridged chat completion response into an
+// Anthropic message. Reasoning content becomes a leading thinking block, the
+// text a text block, and each tool call a tool_use block. A reply cut at one
+// of the request's stop sequences has the stop reason "stop_sequence" and
+// reports the sequence as StopSequence. Token usage is taken from result,
+// which may be nil.
+func MessagesFromChat(resp *oai.ChatCompletionResponse, result *ccwire.ResultMessage) *MessagesResponse {
+	out := &MessagesResponse{
+		ID:      messageID(resp.ID),
+		Type:    "message",
+		Role:    "assistant",
+		Model:   resp.Model,
+		Content: Content{},
+		Usage:   UsageFromResult(result),
+	}
+	if len(resp.Choices) == 0 {
+		return out
+	}
+
+	choice := resp.Choices[0]
+	if thinking := choice.Message.ReasoningContent; thinking != "" {
+		out.Content = append(out.Content, ContentBlock{Type: "thinking", Thinking: thinking})
+	}
+	if text := choice.Message.StringContent(); text != "" {
+		out.Content = append(out.Content, ContentBlock{Type: "text", Text: text})
+	}
+	for _, tc := range choice.Message.ToolCalls {
+		out.Content = append(out.Content, toolUseBlock(tc))
+	}
+	reason := StopReason(choice.FinishReason)
+	if reason == "end_turn" && resp.StopSequence != "" {
+		reason = "stop_sequence"
+		out.StopSequence = &resp.StopSequence
+	}
+	out.StopReason = &reason
+	return out
+}
+
+// toolUseBlock converts a parsed tool call into a tool_use block. Arguments
+// that are not valid JSON are passed as an empty input.
+func toolUseBlock(tc oai.ToolCall) ContentBlock {
+	var input json.RawMessage
+	if json.Valid([]byte(tc.Function.Arguments)) {
+		input = json.RawMessage(tc.Function.Arguments)
+	}
+	return ContentBlock{Type: "tool_use", ID: tc.ID, Name: tc.Function.Name, Input: input}
+}
+
+// messageID derives a "msg_" ID from a chat completion ID.
+func messageID(chatID string) string {
+	return "msg_" + strings.TrimPrefix(chatID, "chatcmpl-")
+}
+
+// ErrorResponse is an Anthropic error body. Type is always "error".
+type ErrorResponse struct {
+	Type  string      `json:"type"` // "error"
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes an error. Type is one of Anthropic's error types,
+// such as "invalid_request_error" or "overloaded_error"; see [ErrorType].
+type ErrorDetail struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// NewErrorResponse returns an error body with the Anthropic error type
+// matching the HTTP status.
+func NewErrorResponse(status int, message string) *ErrorResponse {
+	return &ErrorResponse{Type: "error", Error: ErrorDetail{Type: ErrorType(status), Message: message}}
+}
+
+// ErrorType returns the Anthropic error type for an HTTP status.
+func ErrorType(status int) string {
+	switch status {
+	case 400, 405, 422:
+		return "invalid_request_error"
+	case 401:
+		return "authentication_error"
+	case 403:
+		return "permission_error"
+	case 404:
+		return "not_found_error"
+	case 413:
+		return "request_too_large"
+	case 429:
+		return "rate_limit_error"
+	case 503, 529:
+		return "overloaded_error"
+	}
+	return "api_error"
+}
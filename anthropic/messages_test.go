@@ -0,0 +1,174 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+	"github.com/codewandler/cc-sdk-go/oai"
+)
+
+func TestMessagesRequest_ChatRequest(t *testing.T) {
+	body := `{
+		"model": "claude-haiku",
+		"max_tokens": 256,
+		"system": [{"type": "text", "text": "Be brief."}],
+		"stop_sequences": ["END"],
+		"tool_choice": {"type": "auto", "disable_parallel_tool_use": true},
+		"tools": [{"name": "get_weather", "description": "Weather", "input_schema": {"type": "object"}}],
+		"messages": [
+			{"role": "user", "content": "Weather in Paris?"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "hmm", "signature": "sig"},
+				{"type": "text", "text": "Checking."},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "18C"}]},
+				{"type": "text", "text": "Thanks"}
+			]}
+		]
+	}`
+	var mreq MessagesRequest
+	if err := json.Unmarshal([]byte(body), &mreq); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	req, err := mreq.ChatRequest()
+	if err != nil {
+		t.Fatalf("ChatRequest: %v", err)
+	}
+
+	if req.Model != "claude-haiku" || req.MaxTokens == nil || *req.MaxTokens != 256 {
+		t.Errorf("model = %q, max_tokens = %v", req.Model, req.MaxTokens)
+	}
+	if stops := req.StopSequences(); len(stops) != 1 || stops[0] != "END" {
+		t.Errorf("stop sequences = %v", stops)
+	}
+	if req.AllowsParallelToolCalls() {
+		t.Error("disable_parallel_tool_use should disallow parallel tool calls")
+	}
+	if len(req.Tools) != 1 || req.Tools[0].Function.Name != "get_weather" {
+		t.Errorf("tools = %+v", req.Tools)
+	}
+
+	var roles []string
+	for _, m := range req.Messages {
+		roles = append(roles, m.Role)
+	}
+	if got := strings.Join(roles, ","); got != "system,user,assistant,tool,user" {
+		t.Fatalf("roles = %s", got)
+	}
+	if got := req.Messages[0].StringContent(); got != "Be brief." {
+		t.Errorf("system = %q", got)
+	}
+	assistant := req.Messages[2]
+	if assistant.StringContent() != "Checking." || len(assistant.ToolCalls) != 1 {
+		t.Fatalf("assistant = %+v", assistant)
+	}
+	if tc := assistant.ToolCalls[0]; tc.ID != "toolu_1" || tc.Function.Arguments != `{"city": "Paris"}` {
+		t.Errorf("tool call = %+v", tc)
+	}
+	if tool := req.Messages[3]; tool.ToolCallID != "toolu_1" || tool.StringContent() != "18C" {
+		t.Errorf("tool result = %+v", tool)
+	}
+}
+
+func TestMessagesRequest_ChatRequest_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"empty", `{"messages": []}`, "messages is required"},
+		{"role", `{"messages": [{"role": "system", "content": "hi"}]}`, `unsupported role "system"`},
+		{"image", `{"messages": [{"role": "user", "content": [{"type": "image"}]}]}`, `unsupported content block type "image"`},
+		{"tool_use_in_user", `{"messages": [{"role": "user", "content": [{"type": "tool_use", "id": "t"}]}]}`, "only allowed in assistant"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mreq MessagesRequest
+			if err := json.Unmarshal([]byte(tt.body), &mreq); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			_, err := mreq.ChatRequest()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMessagesFromChat(t *testing.T) {
+	resp := &oai.ChatCompletionResponse{
+		ID:    "chatcmpl-sess-1",
+		Model: "claude-haiku",
+		Choices: []oai.Choice{{
+			Message: oai.ChatMessage{
+				Role:             "assistant",
+				Content:          "Let me check.",
+				ReasoningContent: "needs a tool",
+				ToolCalls: []oai.ToolCall{{
+					ID:       "call_1",
+					Type:     "function",
+					Function: oai.FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`},
+				}},
+			},
+			FinishReason: "tool_calls",
+		}},
+	}
+	result := &ccwire.ResultMessage{Usage: ccwire.ResultUsage{InputTokens: 10, OutputTokens: 5, CacheReadInputTokens: 100}}
+
+	got, err := json.Marshal(MessagesFromChat(resp, result))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want := `{
+		"id": "msg_sess-1",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-haiku",
+		"content": [
+			{"type": "thinking", "thinking": "needs a tool", "signature": ""},
+			{"type": "text", "text": "Let me check."},
+			{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": {"city": "Paris"}}
+		],
+		"stop_reason": "tool_use",
+		"stop_sequence": null,
+		"usage": {"input_tokens": 10, "output_tokens": 5, "cache_creation_input_tokens": 0, "cache_read_input_tokens": 100}
+	}`
+	assertJSONEqual(t, string(got), want)
+}
+
+func TestStopReason(t *testing.T) {
+	for finish, want := range map[string]string{"stop": "end_turn", "tool_calls": "tool_use", "length": "max_tokens", "": "end_turn"} {
+		if got := StopReason(finish); got != want {
+			t.Errorf("StopReason(%q) = %q, want %q", finish, got, want)
+		}
+	}
+}
+
+func TestErrorType(t *testing.T) {
+	for status, want := range map[int]string{400: "invalid_request_error", 401: "authentication_error", 429: "rate_limit_error", 503: "overloaded_error", 500: "api_error", 504: "api_error"} {
+		if got := ErrorType(status); got != want {
+			t.Errorf("ErrorType(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func assertJSONEqual(t *testing.T, got, want string) {
+	t.Helper()
+	var g, w any
+	if err := json.Unmarshal([]byte(got), &g); err != nil {
+		t.Fatalf("unmarshal got: %v", err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("unmarshal want: %v", err)
+	}
+	gb, _ := json.Marshal(g)
+	wb, _ := json.Marshal(w)
+	if string(gb) != string(wb) {
+		t.Errorf("JSON mismatch\n got: %s\nwant: %s", gb, wb)
+	}
+}
@@ -0,0 +1,146 @@
+package anthropic
+
+import (
+	"github.com/codewandler/cc-sdk-go/ccwire"
+	"github.com/codewandler/cc-sdk-go/oai"
+)
+
+// StreamEvent is a single Anthropic server-sent event. Type doubles as the
+// SSE event name; which other fields are set depends on it.
+type StreamEvent struct {
+	Type         string            `json:"type"`
+	Message      *MessagesResponse `json:"message,omitempty"`       // message_start
+	Index        *int              `json:"index,omitempty"`         // content_block_*
+	ContentBlock *ContentBlock     `json:"content_block,omitempty"` // content_block_start
+	Delta        *Delta            `json:"delta,omitempty"`         // content_block_delta, message_delta
+	Usage        *Usage            `json:"usage,omitempty"`         // message_delta
+}
+
+// Delta is the payload of a content_block_delta or message_delta event. Block
+// deltas set Type and one of Text, Thinking, or PartialJSON; message deltas
+// set StopReason, and StopSequence if a stop sequence ended the reply.
+type Delta struct {
+	Type         string  `json:"type,omitempty"`
+	Text         string  `json:"text,omitempty"`
+	Thinking     string  `json:"thinking,omitempty"`
+	PartialJSON  string  `json:"partial_json,omitempty"`
+	StopReason   string  `json:"stop_reason,omitempty"`
+	StopSequence *string `json:"stop_sequence,omitempty"`
+}
+
+// StreamState converts the [oai.ChatCompletionChunk] values produced by
+// [oai.StreamState] into Anthropic stream events. Reasoning and text deltas
+// are forwarded into thinking and text content blocks as they arrive, each
+// opened on first use and closed when a different kind of block starts. Tool
+// calls, which the bridge only parses once the reply is complete, become
+// complete tool_use blocks.
+//
+// Call [StreamState.Start] first, [StreamState.Chunk] for every chat chunk,
+// and [StreamState.Finish] once the result is known. Set StopSequence before
+// Finish to the stop sequence the reply was cut at, if any, as reported by
+// [oai.StreamState].StopSequence.
+type StreamState struct {
+	ID           string
+	Model        string
+	StopSequence string
+
+	index      int    // index of the next content block
+	open       string // type of the open content block, or ""
+	stopReason string
+}
+
+// NewStreamState returns a StreamState for a stream with the given chat
+// completion ID and model.
+func NewStreamState(chatID, model string) *StreamState {
+	return &StreamState{ID: messageID(chatID), Model: model}
+}
+
+// Start returns the message_start event.
+func (ss *StreamState) Start() StreamEvent {
+	return StreamEvent{Type: "message_start", Message: &MessagesResponse{
+		ID:      ss.ID,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   ss.Model,
+		Content: Content{},
+	}}
+}
+
+// Chunk returns the events for a single chat chunk, which may be none.
+func (ss *StreamState) Chunk(chunk *oai.ChatCompletionChunk) []StreamEvent {
+	var events []StreamEvent
+	for _, c := range chunk.Choices {
+		if r := c.Delta.ReasoningContent; r != nil && *r != "" {
+			events = append(events, ss.openBlock(ContentBlock{Type: "thinking"})...)
+			events = append(events, ss.delta(&Delta{Type: "thinking_delta", Thinking: *r}))
+		}
+		if t := c.Delta.Content; t != nil && *t != "" {
+			events = append(events, ss.openBlock(ContentBlock{Type: "text"})...)
+			events = append(events, ss.delta(&Delta{Type: "text_delta", Text: *t}))
+		}
+		for _, tc := range c.Delta.ToolCalls {
+			block := toolUseBlock(tc)
+			input := string(block.Input)
+			block.Input = nil // streamed as partial_json below
+			events = append(events, ss.openBlock(block)...)
+			if input != "" {
+				events = append(events, ss.delta(&Delta{Type: "input_json_delta", PartialJSON: input}))
+			}
+			events = append(events, ss.closeBlock()...)
+		}
+		if c.FinishReason != nil {
+			ss.stopReason = StopReason(*c.FinishReason)
+		}
+	}
+	return events
+}
+
+// Finish closes any open content block and returns the closing
+// message_delta, carrying the stop reason and the usage reported by result
+// (which may be nil), and message_stop events. The stop reason is
+// "stop_sequence" if StopSequence is set and the reply otherwise ended its
+// turn.
+func (ss *StreamState) Finish(result *ccwire.ResultMessage) []StreamEvent {
+	events := ss.closeBlock()
+	delta := &Delta{StopReason: ss.stopReason}
+	if delta.StopReason == "" {
+		delta.StopReason = "end_turn"
+	}
+	if delta.StopReason == "end_turn" && ss.StopSequence != "" {
+		delta.StopReason = "stop_sequence"
+		delta.StopSequence = &ss.StopSequence
+	}
+	usage := UsageFromResult(result)
+	return append(events,
+		StreamEvent{Type: "message_delta", Delta: delta, Usage: &usage},
+		StreamEvent{Type: "message_stop"},
+	)
+}
+
+// openBlock starts a content block unless one of the same type is already
+// open. Any other open block is closed first.
+func (ss *StreamState) openBlock(block ContentBlock) []StreamEvent {
+	if ss.open == block.Type && block.Type != "tool_use" {
+		return nil
+	}
+	events := ss.closeBlock()
+	ss.open = block.Type
+	index := ss.index
+	return append(events, StreamEvent{Type: "content_block_start", Index: &index, ContentBlock: &block})
+}
+
+// closeBlock stops the open content block, if any.
+func (ss *StreamState) closeBlock() []StreamEvent {
+	if ss.open == "" {
+		return nil
+	}
+	index := ss.index
+	ss.open = ""
+	ss.index++
+	return []StreamEvent{{Type: "content_block_stop", Index: &index}}
+}
+
+func (ss *StreamState) delta(d *Delta) StreamEvent {
+	index := ss.index
+	return StreamEvent{Type: "content_block_delta", Index: &index, Delta: d}
+}
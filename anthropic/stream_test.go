@@ -0,0 +1,98 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+	"github.com/codewandler/cc-sdk-go/oai"
+)
+
+func TestStreamState(t *testing.T) {
+	str := func(s string) *string { return &s }
+	chunk := func(delta oai.ChunkDelta, finish *string) *oai.ChatCompletionChunk {
+		return &oai.ChatCompletionChunk{Choices: []oai.ChunkChoice{{Delta: delta, FinishReason: finish}}}
+	}
+
+	ss := NewStreamState("chatcmpl-sess-1", "claude-haiku")
+	events := []StreamEvent{ss.Start()}
+	for _, c := range []*oai.ChatCompletionChunk{
+		chunk(oai.ChunkDelta{Role: "assistant"}, nil),
+		chunk(oai.ChunkDelta{ReasoningContent: str("hmm")}, nil),
+		chunk(oai.ChunkDelta{Content: str("Hel")}, nil),
+		chunk(oai.ChunkDelta{Content: str("lo")}, nil),
+		chunk(oai.ChunkDelta{ToolCalls: []oai.ToolCall{
+			{ID: "call_1", Function: oai.FunctionCall{Name: "a", Arguments: `{"x":1}`}},
+			{ID: "call_2", Function: oai.FunctionCall{Name: "b", Arguments: `{}`}},
+		}}, str("tool_calls")),
+	} {
+		events = append(events, ss.Chunk(c)...)
+	}
+	events = append(events, ss.Finish(&ccwire.ResultMessage{Usage: ccwire.ResultUsage{InputTokens: 3, OutputTokens: 7}})...)
+
+	var lines []string
+	for _, ev := range events {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		lines = append(lines, string(data))
+	}
+	want := []string{
+		`{"type":"message_start","message":{"id":"msg_sess-1","type":"message","role":"assistant","model":"claude-haiku","content":[],"stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":0,"output_tokens":0,"cache_creation_input_tokens":0,"cache_read_input_tokens":0}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":"","signature":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"hmm"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hel"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"lo"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"call_1","name":"a","input":{}}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"x\":1}"}}`,
+		`{"type":"content_block_stop","index":2}`,
+		`{"type":"content_block_start","index":3,"content_block":{"type":"tool_use","id":"call_2","name":"b","input":{}}}`,
+		`{"type":"content_block_delta","index":3,"delta":{"type":"input_json_delta","partial_json":"{}"}}`,
+		`{"type":"content_block_stop","index":3}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"input_tokens":3,"output_tokens":7,"cache_creation_input_tokens":0,"cache_read_input_tokens":0}}`,
+		`{"type":"message_stop"}`,
+	}
+	if got := strings.Join(lines, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("events:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestStreamState_EmptyReply(t *testing.T) {
+	ss := NewStreamState("chatcmpl-1", "")
+	stop := "stop"
+	events := ss.Chunk(&oai.ChatCompletionChunk{Choices: []oai.ChunkChoice{{FinishReason: &stop}}})
+	events = append(events, ss.Finish(nil)...)
+
+	if len(events) != 2 || events[0].Type != "message_delta" || events[1].Type != "message_stop" {
+		t.Fatalf("events = %+v", events)
+	}
+	if events[0].Delta.StopReason != "end_turn" {
+		t.Errorf("stop_reason = %q, want end_turn", events[0].Delta.StopReason)
+	}
+}
+
+func TestStreamState_StopSequence(t *testing.T) {
+	for _, tt := range []struct {
+		finish, wantReason string
+		wantSequence       bool
+	}{
+		{"stop", "stop_sequence", true},
+		{"length", "max_tokens", false},
+	} {
+		ss := NewStreamState("chatcmpl-1", "")
+		ss.Chunk(&oai.ChatCompletionChunk{Choices: []oai.ChunkChoice{{FinishReason: &tt.finish}}})
+		ss.StopSequence = "END"
+		delta := ss.Finish(nil)[0].Delta
+		if delta.StopReason != tt.wantReason {
+			t.Errorf("finish %q: stop_reason = %q, want %q", tt.finish, delta.StopReason, tt.wantReason)
+		}
+		if got := delta.StopSequence != nil && *delta.StopSequence == "END"; got != tt.wantSequence {
+			t.Errorf("finish %q: stop_sequence = %v, want set: %v", tt.finish, delta.StopSequence, tt.wantSequence)
+		}
+	}
+}
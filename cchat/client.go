@@ -3,6 +3,14 @@ package cchat
 import (
 	"context"
 	"fmt"
+	"io"
+	"maps"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Client manages Claude Code CLI subprocess interactions. It enforces an
@@ -10,8 +18,42 @@ import (
 // should be reused for the lifetime of the application; it is safe for
 // concurrent use by multiple goroutines.
 type Client struct {
-	cfg ClientConfig
-	sem chan struct{} // concurrency semaphore; nil if unlimited
+	cfg   ClientConfig
+	sem   chan struct{} // concurrency semaphore; nil if unlimited
+	spawn SpawnFunc     // replaces the claude subprocess when set
+
+	versionOnce sync.Once
+	version     string
+
+	drainMu sync.Mutex
+	drained chan struct{} // closed by Drain; created lazily
+
+	inUse     atomic.Int64 // slots held, one per open stream
+	waiting   atomic.Int64 // Query calls blocked on a slot
+	started   atomic.Uint64
+	completed atomic.Uint64
+}
+
+// Stats is a point-in-time snapshot of a [Client]'s concurrency and query
+// counters, as returned by [Client.Stats].
+type Stats struct {
+	// InUse is the number of queries holding a concurrency slot, which is
+	// the number of streams not yet closed.
+	InUse int `json:"in_use"`
+
+	// Waiting is the number of [Client.Query] calls blocked waiting for a
+	// free slot.
+	Waiting int `json:"waiting"`
+
+	// MaxConcurrent is the configured [ClientConfig].MaxConcurrent; 0
+	// means unlimited.
+	MaxConcurrent int `json:"max_concurrent"`
+
+	// Started counts queries whose claude process was started.
+	Started uint64 `json:"started"`
+
+	// Completed counts streams that have been closed.
+	Completed uint64 `json:"completed"`
 }
 
 // NewClient creates a new [Client] with the given configuration. If
@@ -31,36 +73,87 @@ func NewClient(cfg *ClientConfig) *Client {
 	return c
 }
 
+// SpawnFunc produces the NDJSON output for a query in place of a claude
+// subprocess. It receives the query context, prompt, and options that would
+// have been passed to the CLI, and returns a reader over the output. The
+// reader is closed when the [Stream] is closed; it should stop producing
+// output once ctx is done.
+type SpawnFunc func(ctx context.Context, prompt string, opts QueryOptions) (io.ReadCloser, error)
+
+// NewClientWithSpawner creates a [Client] like [NewClient], except that
+// [Client.Query] calls spawn instead of starting the claude CLI. It lets
+// tests exercise code built on a Client with canned output and no claude
+// binary. Concurrency limits and DefaultTimeout apply as usual;
+// [Client.Version] reports the empty string.
+func NewClientWithSpawner(cfg *ClientConfig, spawn SpawnFunc) *Client {
+	c := NewClient(cfg)
+	c.spawn = spawn
+	return c
+}
+
 // Query spawns a new claude CLI process with the given prompt and options,
 // returning a [Stream] for reading the process output.
 //
 // The prompt is delivered to the subprocess via a stdin pipe to avoid OS
 // argument length limits. If [ClientConfig].MaxConcurrent is set and all
-// slots are occupied, Query blocks until a slot is freed or ctx is cancelled.
-// If [ClientConfig].DefaultTimeout is set, a timeout-derived context is
-// layered on top of ctx.
+// slots are occupied, Query blocks until a slot is freed or ctx is
+// cancelled, or returns a [*QueueFullError] once [ClientConfig].MaxQueueWait
+// has passed. When [ClientConfig].AllowedModels is set, a requested model
+// outside it fails with a [*ModelError]. After [Client.Drain], Query fails
+// with a [*ClientClosedError], including calls still waiting for a slot. If
+// [QueryOptions].Timeout or [ClientConfig].DefaultTimeout is set, a
+// timeout-derived context is layered on top of ctx; when it fires, the query
+// fails with a [*TimeoutError].
 //
 // The caller MUST call [Stream.Close] when done to kill the subprocess (if
 // still running), reap the process, and release the concurrency semaphore
 // slot. Failing to close the stream will leak resources.
 func (c *Client) Query(ctx context.Context, prompt string, opts QueryOptions) (*Stream, error) {
-	// Acquire semaphore slot
-	if c.sem != nil {
-		select {
-		case c.sem <- struct{}{}:
-		case <-ctx.Done():
-			return nil, fmt.Errorf("acquiring semaphore: %w", ctx.Err())
-		}
+	model := opts.Model
+	if model == "" {
+		model = c.cfg.Model
+	}
+	ctx, span := StartSpan(ctx, c.cfg.Tracer, "cchat.query", Attribute{AttrModel, model})
+	stream, err := c.query(ctx, prompt, opts, span)
+	if err != nil {
+		span.RecordError(err)
+		span.End()
 	}
+	return stream, err
+}
 
-	// Apply default timeout
+// query implements [Client.Query]; span is the query's span, which the
+// returned stream ends when closed.
+func (c *Client) query(ctx context.Context, prompt string, opts QueryOptions, span Span) (*Stream, error) {
+	if c.Draining() {
+		return nil, &ClientClosedError{}
+	}
+	if err := c.checkModel(opts.Model); err != nil {
+		return nil, err
+	}
+	if err := c.acquireSem(ctx); err != nil {
+		return nil, err
+	}
+
+	// Apply the query's timeout, or the default one
+	callerCtx := ctx
+	timeout := c.cfg.DefaultTimeout
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
 	var timeoutCancel context.CancelFunc
-	if c.cfg.DefaultTimeout > 0 {
-		ctx, timeoutCancel = context.WithTimeout(ctx, c.cfg.DefaultTimeout)
+	if timeout > 0 {
+		ctx, timeoutCancel = context.WithTimeout(ctx, timeout)
 	}
 
-	proc, err := startProcess(ctx, c.cfg, opts, prompt)
+	_, procSpan := StartSpan(ctx, c.cfg.Tracer, "cchat.process")
+	proc, err := c.start(ctx, prompt, opts)
 	if err != nil {
+		procSpan.RecordError(err)
+		procSpan.End()
+		if timeoutErr := timeoutErr(timeout, callerCtx, ctx); timeoutErr != nil {
+			err = timeoutErr
+		}
 		if timeoutCancel != nil {
 			timeoutCancel()
 		}
@@ -68,14 +161,237 @@ func (c *Client) Query(ctx context.Context, prompt string, opts QueryOptions) (*
 		return nil, err
 	}
 
-	// Store timeout cancel on process for cleanup in Stream.Close()
-	proc.timeoutCancel = timeoutCancel
+	c.started.Add(1)
+	// The stream stops the timeout timer in Stream.Close()
+	stream := newStream(callerCtx, ctx, timeoutCancel, proc, c)
+	stream.timeout = timeout
+	stream.span, stream.procSpan = span, procSpan
+	if opts.AutoCloseOnContextDone {
+		stream.closeOnDone(ctx)
+	}
+	return stream, nil
+}
 
-	return newStream(proc, c), nil
+// checkModel validates a requested model against AllowedModels. An empty
+// model selects the configured default and is always allowed.
+func (c *Client) checkModel(model string) error {
+	if c.cfg.AllowedModels == nil || model == "" || slices.Contains(c.cfg.AllowedModels, model) {
+		return nil
+	}
+	if _, ok := c.cfg.ModelAliases[model]; ok {
+		return nil
+	}
+	return &ModelError{Model: model, Allowed: c.Models()}
+}
+
+// Models returns the model names queries may request: AllowedModels followed
+// by the sorted ModelAliases names. It returns nil when AllowedModels is not
+// set, as any model is accepted then.
+func (c *Client) Models() []string {
+	if c.cfg.AllowedModels == nil {
+		return nil
+	}
+	models := slices.Clone(c.cfg.AllowedModels)
+	for _, alias := range slices.Sorted(maps.Keys(c.cfg.ModelAliases)) {
+		if !slices.Contains(models, alias) {
+			models = append(models, alias)
+		}
+	}
+	return models
+}
+
+// ModelInfo returns the models the client offers with their metadata from
+// [ClientConfig].Models, or [DefaultModelInfo] if that is nil. When
+// AllowedModels is set it returns one entry per name from [Client.Models]
+// instead: a ModelAliases name gets the metadata of the model it maps to,
+// and a name without metadata gets only its ID.
+func (c *Client) ModelInfo() []ModelInfo {
+	registry := c.cfg.Models
+	if registry == nil {
+		registry = DefaultModelInfo
+	}
+	names := c.Models()
+	if names == nil {
+		return slices.Clone(registry)
+	}
+	infos := make([]ModelInfo, 0, len(names))
+	for _, name := range names {
+		target := name
+		if alias, ok := c.cfg.ModelAliases[name]; ok {
+			target = alias
+		}
+		info := ModelInfo{ID: name}
+		if i := slices.IndexFunc(registry, func(m ModelInfo) bool { return m.ID == target }); i >= 0 {
+			info = registry[i]
+			info.ID = name
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// SupportsSampling reports whether queries pass Temperature and TopP to the
+// CLI; see [ClientConfig].SamplingFlags.
+func (c *Client) SupportsSampling() bool {
+	return c.cfg.SamplingFlags
+}
+
+// start launches the claude process for a query, or calls the spawner when
+// one is configured.
+func (c *Client) start(ctx context.Context, prompt string, opts QueryOptions) (processInterface, error) {
+	if c.spawn == nil {
+		return startProcess(ctx, c.cfg, opts, prompt)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stdout, err := c.spawn(ctx, prompt, opts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &readerProcess{stdout: stdout, cancel: cancel}, nil
+}
+
+// Version returns the version reported by "claude --version", such as
+// "2.0.14". The CLI is queried on the first call only and the result is
+// cached for the lifetime of the Client. If the CLI cannot be run, Version
+// returns the empty string, and keeps doing so.
+func (c *Client) Version() string {
+	c.versionOnce.Do(func() {
+		if c.spawn != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		out, err := exec.CommandContext(ctx, c.cfg.CLIPath, "--version").Output()
+		if err != nil {
+			return
+		}
+		if fields := strings.Fields(string(out)); len(fields) > 0 {
+			c.version = fields[0]
+		}
+	})
+	return c.version
+}
+
+// timeoutErr returns a [*TimeoutError] if ctx, derived from callerCtx by
+// applying timeout, hit its deadline while callerCtx is still live.
+func timeoutErr(timeout time.Duration, callerCtx, ctx context.Context) error {
+	if timeout <= 0 || callerCtx == nil || callerCtx.Err() != nil {
+		return nil
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		return nil
+	}
+	return &TimeoutError{Timeout: timeout}
+}
+
+// Stats returns a snapshot of the client's counters. It is cheap enough to
+// call on every metrics scrape; the counters are updated atomically and the
+// snapshot does not block queries.
+func (c *Client) Stats() Stats {
+	return Stats{
+		InUse:         int(c.inUse.Load()),
+		Waiting:       int(c.waiting.Load()),
+		MaxConcurrent: c.cfg.MaxConcurrent,
+		Started:       c.started.Load(),
+		Completed:     c.completed.Load(),
+	}
+}
+
+// acquireSem takes a concurrency slot, waiting at most MaxQueueWait if set.
+func (c *Client) acquireSem(ctx context.Context) error {
+	if c.sem == nil {
+		c.inUse.Add(1)
+		return nil
+	}
+	select {
+	case c.sem <- struct{}{}:
+		c.inUse.Add(1)
+		return nil
+	default:
+	}
+
+	c.waiting.Add(1)
+	defer c.waiting.Add(-1)
+	var expired <-chan time.Time
+	if c.cfg.MaxQueueWait > 0 {
+		timer := time.NewTimer(c.cfg.MaxQueueWait)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case c.sem <- struct{}{}:
+		c.inUse.Add(1)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("acquiring semaphore: %w", ctx.Err())
+	case <-expired:
+		return &QueueFullError{MaxConcurrent: cap(c.sem), Wait: c.cfg.MaxQueueWait}
+	case <-c.drainCh():
+		return &ClientClosedError{}
+	}
+}
+
+// Drain stops the client from starting new queries: later [Client.Query]
+// calls, and those waiting for a slot, fail with a [*ClientClosedError].
+// Streams already returned are unaffected and run to completion. Servers
+// call it when graceful shutdown begins, so no process is spawned only to be
+// killed moments later. Drain is idempotent and cannot be undone.
+func (c *Client) Drain() {
+	ch := c.drainCh()
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// Draining reports whether [Client.Drain] has been called.
+func (c *Client) Draining() bool {
+	select {
+	case <-c.drainCh():
+		return true
+	default:
+		return false
+	}
+}
+
+// drainCh returns the channel closed by Drain, creating it on first use so
+// that the zero Client works.
+func (c *Client) drainCh() chan struct{} {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	if c.drained == nil {
+		c.drained = make(chan struct{})
+	}
+	return c.drained
 }
 
 func (c *Client) releaseSem() {
+	if c == nil {
+		return
+	}
+	c.inUse.Add(-1)
 	if c.sem != nil {
 		<-c.sem
 	}
 }
+
+// closeTimeout returns how long Stream.Close waits for a process to exit.
+func (c *Client) closeTimeout() time.Duration {
+	if c == nil || c.cfg.CloseTimeout <= 0 {
+		return DefaultCloseTimeout
+	}
+	return c.cfg.CloseTimeout
+}
+
+// streamClosed records a finished query and releases its slot.
+func (c *Client) streamClosed() {
+	if c == nil {
+		return
+	}
+	c.completed.Add(1)
+	c.releaseSem()
+}
@@ -2,11 +2,20 @@ package cchat
 
 import (
 	"context"
+	"errors"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
 )
 
 func requireCLI(t *testing.T) {
@@ -18,6 +27,393 @@ func requireCLI(t *testing.T) {
 
 // TestDoubleClose verifies that calling Close() multiple times on a Stream
 // is safe and doesn't corrupt the semaphore.
+// writeFakeCLI writes an executable shell script standing in for the claude
+// binary and returns its path. The script body runs after stdin is drained.
+func writeFakeCLI(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "claude")
+	script := "#!/bin/sh\ncat >/dev/null\n" + body + "\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestStreamContextDeadline verifies that a stream killed by its context
+// deadline reports context.DeadlineExceeded rather than EOF or a ProcessError.
+func TestStreamContextDeadline(t *testing.T) {
+	t.Parallel()
+	// exec replaces the shell so killing the process closes stdout
+	client := NewClient(&ClientConfig{CLIPath: writeFakeCLI(t, "exec sleep 10")})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	stream, err := client.Query(ctx, "test", QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer stream.Close()
+
+	_, err = stream.Next()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Next error = %v, want context.DeadlineExceeded", err)
+	}
+	var procErr *ProcessError
+	if errors.As(err, &procErr) {
+		t.Errorf("Next error should not be a ProcessError: %v", err)
+	}
+}
+
+// TestStreamContextCancel verifies that cancelling the query context surfaces
+// context.Canceled from Next.
+func TestStreamContextCancel(t *testing.T) {
+	t.Parallel()
+	client := NewClient(&ClientConfig{CLIPath: writeFakeCLI(t, "exec sleep 10")})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.Query(ctx, "test", QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer stream.Close()
+
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = stream.Next()
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Next error = %v, want context.Canceled", err)
+	}
+	if err == io.EOF {
+		t.Error("cancellation must not look like a clean finish")
+	}
+}
+
+// TestCloseUnblocksNext verifies that Close from another goroutine makes a
+// blocked Next return ErrStreamClosed promptly.
+func TestCloseUnblocksNext(t *testing.T) {
+	t.Parallel()
+	client := NewClient(&ClientConfig{CLIPath: writeFakeCLI(t, "exec sleep 10")})
+
+	stream, err := client.Query(context.Background(), "test", QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := stream.Next()
+		errc <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond) // let Next block on the read
+	stream.Close()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrStreamClosed) {
+			t.Errorf("Next error = %v, want ErrStreamClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Next did not return after Close")
+	}
+
+	if _, err := stream.Next(); !errors.Is(err, ErrStreamClosed) {
+		t.Errorf("Next after Close = %v, want ErrStreamClosed", err)
+	}
+}
+
+// TestAutoCloseOnContextDone verifies that a stream queried with
+// AutoCloseOnContextDone is closed, and its semaphore slot freed, when the
+// context is cancelled even though the caller never calls Close.
+// TestIdleTimeout verifies that a process that stops producing output is
+// killed after IdleTimeout, while slow but steady output is not.
+func TestIdleTimeout(t *testing.T) {
+	t.Parallel()
+	const line = `{"type":"system","subtype":"init","session_id":"sess-1"}` + "\n"
+	client := NewClientWithSpawner(&ClientConfig{IdleTimeout: 100 * time.Millisecond},
+		func(context.Context, string, QueryOptions) (io.ReadCloser, error) {
+			pr, pw := io.Pipe()
+			go func() {
+				// Steady output, each line within the idle window, then a stall
+				for range 3 {
+					time.Sleep(50 * time.Millisecond)
+					if _, err := pw.Write([]byte(line)); err != nil {
+						return
+					}
+				}
+			}()
+			return pr, nil
+		})
+
+	stream, err := client.Query(context.Background(), "test", QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer stream.Close()
+
+	for i := range 3 {
+		if _, err := stream.Next(); err != nil {
+			t.Fatalf("Next #%d: %v", i+1, err)
+		}
+	}
+
+	start := time.Now()
+	_, err = stream.Next()
+	var idleErr *IdleTimeoutError
+	if !errors.As(err, &idleErr) || idleErr.Timeout != 100*time.Millisecond {
+		t.Fatalf("Next error = %v, want *IdleTimeoutError", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("IdleTimeoutError should unwrap to context.DeadlineExceeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Next took %s to time out", elapsed)
+	}
+	if _, err := stream.Next(); !errors.As(err, &idleErr) {
+		t.Errorf("later Next error = %v, want *IdleTimeoutError", err)
+	}
+}
+
+// TestStreamCancel verifies the Cancel lifecycle: a blocked Next returns
+// ErrStreamCancelled, later calls keep returning it, the slot stays held
+// until Close, and Close still releases it.
+func TestStreamCancel(t *testing.T) {
+	t.Parallel()
+	client := NewClientWithSpawner(&ClientConfig{MaxConcurrent: 1},
+		func(context.Context, string, QueryOptions) (io.ReadCloser, error) {
+			pr, pw := io.Pipe()
+			go pw.Write([]byte(`{"type":"system","subtype":"init","session_id":"sess-1"}` + "\n")) // then stall
+			return pr, nil
+		})
+
+	stream, err := client.Query(context.Background(), "test", QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if _, err := stream.Next(); err != nil {
+		t.Fatalf("first Next: %v", err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, stream.Cancel)
+	_, err = stream.Next()
+	if !errors.Is(err, ErrStreamCancelled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Next error = %v, want ErrStreamCancelled wrapping context.Canceled", err)
+	}
+	if _, err := stream.Next(); !errors.Is(err, ErrStreamCancelled) {
+		t.Errorf("later Next error = %v, want ErrStreamCancelled", err)
+	}
+	stream.Cancel() // idempotent
+	if inUse := client.Stats().InUse; inUse != 1 {
+		t.Errorf("InUse = %d after Cancel, want 1 until Close", inUse)
+	}
+
+	stream.Close()
+	if inUse := client.Stats().InUse; inUse != 0 {
+		t.Errorf("InUse = %d after Close, want 0", inUse)
+	}
+	if _, err := stream.Next(); !errors.Is(err, ErrStreamClosed) {
+		t.Errorf("Next after Close = %v, want ErrStreamClosed", err)
+	}
+}
+
+func TestAutoCloseOnContextDone(t *testing.T) {
+	t.Parallel()
+	client := NewClientWithSpawner(&ClientConfig{MaxConcurrent: 1},
+		func(context.Context, string, QueryOptions) (io.ReadCloser, error) {
+			pr, _ := io.Pipe() // never written; only Close unblocks reads
+			return pr, nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.Query(ctx, "test", QueryOptions{AutoCloseOnContextDone: true})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if got := client.Stats().InUse; got != 1 {
+		t.Fatalf("InUse = %d, want 1", got)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := stream.Next()
+		errc <- err
+	}()
+	cancel()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Next error = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Next did not return after the context was cancelled")
+	}
+	if got := client.Stats().InUse; got != 0 {
+		t.Errorf("InUse after cancel = %d, want 0", got)
+	}
+
+	// The freed slot is usable without waiting
+	next, err := client.Query(context.Background(), "again", QueryOptions{AutoCloseOnContextDone: true})
+	if err != nil {
+		t.Fatalf("second Query failed: %v", err)
+	}
+	next.Close()
+	next.Close()
+	if got := client.Stats().InUse; got != 0 {
+		t.Errorf("InUse after Close = %d, want 0", got)
+	}
+}
+
+// TestDefaultTimeoutError verifies that DefaultTimeout expiry is reported as
+// a *TimeoutError rather than a ProcessError with an arbitrary exit code.
+func TestDefaultTimeoutError(t *testing.T) {
+	t.Parallel()
+	client := NewClient(&ClientConfig{
+		CLIPath:        writeFakeCLI(t, "exec sleep 10"),
+		DefaultTimeout: time.Millisecond,
+	})
+
+	// With a 1ms timeout the deadline may already pass during spawn, in which
+	// case Query itself reports it.
+	stream, err := client.Query(context.Background(), "test", QueryOptions{})
+	if err == nil {
+		defer stream.Close()
+		_, err = stream.Next()
+	}
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("error = %v (%T), want *TimeoutError", err, err)
+	}
+	if timeoutErr.Timeout != time.Millisecond {
+		t.Errorf("Timeout = %v, want %v", timeoutErr.Timeout, time.Millisecond)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("TimeoutError should unwrap to context.DeadlineExceeded")
+	}
+}
+
+// TestQueryTimeoutOverride verifies that QueryOptions.Timeout replaces
+// DefaultTimeout for a single query, and that DefaultTimeout applies when it
+// is zero.
+func TestQueryTimeoutOverride(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name           string
+		defaultTimeout time.Duration
+		timeout        time.Duration
+		want           time.Duration
+	}{
+		{"per_query_wins", time.Hour, 50 * time.Millisecond, 50 * time.Millisecond},
+		{"per_query_longer", 50 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond},
+		{"default", 50 * time.Millisecond, 0, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			client := NewClient(&ClientConfig{
+				CLIPath:        writeFakeCLI(t, "exec sleep 10"),
+				DefaultTimeout: tt.defaultTimeout,
+			})
+
+			start := time.Now()
+			stream, err := client.Query(context.Background(), "test", QueryOptions{Timeout: tt.timeout})
+			if err == nil {
+				defer stream.Close()
+				_, err = stream.Next()
+			}
+
+			var timeoutErr *TimeoutError
+			if !errors.As(err, &timeoutErr) {
+				t.Fatalf("error = %v (%T), want *TimeoutError", err, err)
+			}
+			if timeoutErr.Timeout != tt.want {
+				t.Errorf("Timeout = %v, want %v", timeoutErr.Timeout, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed < tt.want || elapsed > 5*time.Second {
+				t.Errorf("query ended after %v, want about %v", elapsed, tt.want)
+			}
+		})
+	}
+}
+
+// TestVersionCached verifies that Version parses "claude --version" output and
+// only runs the CLI once.
+func TestVersionCached(t *testing.T) {
+	t.Parallel()
+	counter := filepath.Join(t.TempDir(), "calls")
+	path := writeFakeCLI(t, "echo x >> "+counter+"\necho '2.0.14 (Claude Code)'")
+	client := NewClient(&ClientConfig{CLIPath: path})
+
+	for i := 0; i < 3; i++ {
+		if got := client.Version(); got != "2.0.14" {
+			t.Fatalf("Version() = %q, want %q", got, "2.0.14")
+		}
+	}
+	calls, _ := os.ReadFile(counter)
+	if n := strings.Count(string(calls), "x"); n != 1 {
+		t.Errorf("CLI invoked %d times, want 1", n)
+	}
+}
+
+// TestQueryEnv verifies that client and query env vars reach the process,
+// with the query value winning.
+func TestQueryEnv(t *testing.T) {
+	t.Parallel()
+	path := writeFakeCLI(t, `echo "{\"type\":\"result\",\"result\":\"$CC_TEST_VAR $CC_TEST_OTHER\"}"`)
+	client := NewClient(&ClientConfig{
+		CLIPath: path,
+		Env:     []string{"CC_TEST_VAR=client", "CC_TEST_OTHER=kept"},
+	})
+
+	stream, err := client.Query(context.Background(), "test", QueryOptions{
+		Env: []string{"CC_TEST_VAR=query"},
+	})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer stream.Close()
+
+	result, err := stream.Result()
+	if err != nil {
+		t.Fatalf("Result failed: %v", err)
+	}
+	if result.Result != "query kept" {
+		t.Errorf("result = %q, want %q", result.Result, "query kept")
+	}
+}
+
+// TestQueryLongSystemPrompt verifies that an oversized system prompt is
+// passed through a temporary file that is removed when the stream closes.
+func TestQueryLongSystemPrompt(t *testing.T) {
+	t.Parallel()
+	// Report the file's path and size, then wait to be closed
+	path := writeFakeCLI(t, `for a in "$@"; do case "$a" in --system-prompt-file=*) f="${a#*=}";; esac; done
+echo "{\"type\":\"result\",\"result\":\"$f $(wc -c <"$f" | tr -d ' ')\"}"
+exec sleep 10`)
+	client := NewClient(&ClientConfig{CLIPath: path})
+
+	prompt := strings.Repeat("x", maxSystemPromptArg+1)
+	stream, err := client.Query(context.Background(), "test", QueryOptions{SystemPrompt: prompt})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	msg, err := stream.Next()
+	if err != nil {
+		stream.Close()
+		t.Fatalf("Next failed: %v", err)
+	}
+	file, size, _ := strings.Cut(msg.(*ccwire.ResultMessage).Result, " ")
+	if file == "" || size != strconv.Itoa(len(prompt)) {
+		t.Fatalf("CLI saw file %q of %s bytes, want %d bytes", file, size, len(prompt))
+	}
+
+	stream.Close()
+	if _, err := os.Stat(file); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("system prompt file still present after Close: %v", err)
+	}
+}
+
 func TestDoubleClose(t *testing.T) {
 	requireCLI(t)
 	t.Parallel()
@@ -368,3 +764,315 @@ func countTimeoutGoroutines() int {
 	// This is a simplified approach; real leak detection would need profiling
 	return int(count.Load())
 }
+
+// TestNewClientWithSpawner verifies that Query reads canned output from the
+// spawner and passes it the prompt and options.
+func TestNewClientWithSpawner(t *testing.T) {
+	t.Parallel()
+	var gotPrompt string
+	var gotOpts QueryOptions
+	client := NewClientWithSpawner(&ClientConfig{MaxConcurrent: 1}, func(ctx context.Context, prompt string, opts QueryOptions) (io.ReadCloser, error) {
+		gotPrompt, gotOpts = prompt, opts
+		return io.NopCloser(strings.NewReader(
+			`{"type":"assistant","message":{"model":"claude-haiku","content":[{"type":"text","text":"PONG"}]}}` + "\n" +
+				`{"type":"result","subtype":"success","session_id":"sess-1","result":"PONG"}` + "\n",
+		)), nil
+	})
+
+	for i := 0; i < 2; i++ { // the second query needs the released slot
+		stream, err := client.Query(context.Background(), "ping", QueryOptions{SystemPrompt: "be brief"})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		result, err := stream.Result()
+		stream.Close()
+		if err != nil {
+			t.Fatalf("Result failed: %v", err)
+		}
+		if result.Result != "PONG" {
+			t.Errorf("result = %q, want PONG", result.Result)
+		}
+	}
+
+	if gotPrompt != "ping" || gotOpts.SystemPrompt != "be brief" {
+		t.Errorf("spawner got prompt %q, opts %+v", gotPrompt, gotOpts)
+	}
+	if v := client.Version(); v != "" {
+		t.Errorf("Version() = %q, want empty for a spawner client", v)
+	}
+}
+
+// TestNewClientWithSpawner_Error verifies that spawner errors are returned
+// from Query and release the concurrency slot.
+func TestNewClientWithSpawner_Error(t *testing.T) {
+	t.Parallel()
+	spawnErr := errors.New("no output")
+	client := NewClientWithSpawner(&ClientConfig{MaxConcurrent: 1}, func(context.Context, string, QueryOptions) (io.ReadCloser, error) {
+		return nil, spawnErr
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Query(context.Background(), "ping", QueryOptions{}); !errors.Is(err, spawnErr) {
+			t.Fatalf("Query error = %v, want %v", err, spawnErr)
+		}
+	}
+}
+
+// TestNewFakeStream verifies that a fake stream yields its messages, caches
+// the result, and reports rate limit errors like a real stream.
+func TestNewFakeStream(t *testing.T) {
+	stream := NewFakeStream([]ccwire.Message{
+		&ccwire.SystemMessage{Subtype: "init"},
+		&ccwire.ResultMessage{SessionID: "sess-1", Result: "done"},
+	})
+	defer stream.Close()
+
+	result, err := stream.Result()
+	if err != nil {
+		t.Fatalf("Result failed: %v", err)
+	}
+	if result.SessionID != "sess-1" {
+		t.Errorf("SessionID = %q, want sess-1", result.SessionID)
+	}
+
+	limited := NewFakeStream([]ccwire.Message{
+		&ccwire.AssistantMessage{Error: "rate_limit"},
+	})
+	defer limited.Close()
+	var rateErr *RateLimitError
+	if _, err := limited.Next(); !errors.As(err, &rateErr) {
+		t.Errorf("Next error = %v, want *RateLimitError", err)
+	}
+}
+
+// TestQueryRateLimit verifies that a rate limit reported by the CLI as an
+// assistant message error surfaces from Next as a *RateLimitError.
+func TestQueryRateLimit(t *testing.T) {
+	t.Parallel()
+	client := NewClient(&ClientConfig{CLIPath: writeFakeCLI(t,
+		`echo '{"type":"assistant","error":"rate_limit","session_id":"s1","message":{"content":[{"type":"text","text":"Usage limit reached"}]}}'`,
+	)})
+
+	stream, err := client.Query(context.Background(), "test", QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer stream.Close()
+
+	_, err = stream.Next()
+	var rateErr *RateLimitError
+	if !errors.As(err, &rateErr) {
+		t.Fatalf("Next error = %v, want *RateLimitError", err)
+	}
+	if rateErr.Message != "Usage limit reached" {
+		t.Errorf("Message = %q, want the CLI's text", rateErr.Message)
+	}
+}
+
+// TestMaxQueueWait verifies that Query gives up with a *QueueFullError when
+// no slot frees up within MaxQueueWait.
+func TestMaxQueueWait(t *testing.T) {
+	t.Parallel()
+	client := NewClientWithSpawner(&ClientConfig{MaxConcurrent: 1, MaxQueueWait: 20 * time.Millisecond},
+		func(context.Context, string, QueryOptions) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("")), nil
+		})
+
+	held, err := client.Query(context.Background(), "first", QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	start := time.Now()
+	_, err = client.Query(context.Background(), "second", QueryOptions{})
+	var queueErr *QueueFullError
+	if !errors.As(err, &queueErr) {
+		t.Fatalf("Query error = %v, want *QueueFullError", err)
+	}
+	if queueErr.MaxConcurrent != 1 || queueErr.Wait != 20*time.Millisecond {
+		t.Errorf("QueueFullError = %+v", queueErr)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Query took %v, want about MaxQueueWait", elapsed)
+	}
+
+	held.Close()
+	stream, err := client.Query(context.Background(), "third", QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query after release failed: %v", err)
+	}
+	stream.Close()
+}
+
+// TestDrain verifies that Drain refuses new and queued queries while an
+// in-flight stream runs to completion.
+func TestDrain(t *testing.T) {
+	t.Parallel()
+	client := NewClientWithSpawner(&ClientConfig{MaxConcurrent: 1},
+		func(context.Context, string, QueryOptions) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(
+				`{"type":"result","subtype":"success","session_id":"s1","result":"done"}` + "\n",
+			)), nil
+		})
+
+	inFlight, err := client.Query(context.Background(), "first", QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer inFlight.Close()
+
+	queued := make(chan error, 1)
+	go func() {
+		_, err := client.Query(context.Background(), "queued", QueryOptions{})
+		queued <- err
+	}()
+	for client.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	client.Drain()
+	client.Drain() // idempotent
+	if !client.Draining() {
+		t.Error("Draining() = false after Drain")
+	}
+
+	var closedErr *ClientClosedError
+	select {
+	case err := <-queued:
+		if !errors.As(err, &closedErr) {
+			t.Errorf("queued Query error = %v, want *ClientClosedError", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued Query still blocked after Drain")
+	}
+	if _, err := client.Query(context.Background(), "late", QueryOptions{}); !errors.As(err, &closedErr) {
+		t.Errorf("Query after Drain error = %v, want *ClientClosedError", err)
+	}
+
+	result, err := inFlight.Result()
+	if err != nil {
+		t.Fatalf("in-flight stream failed after Drain: %v", err)
+	}
+	if result.Result != "done" {
+		t.Errorf("Result = %q, want done", result.Result)
+	}
+}
+
+// TestQuery_AllowedModels verifies that unknown models are rejected before
+// spawning, while defaults, aliases, and an empty model are accepted.
+func TestQuery_AllowedModels(t *testing.T) {
+	t.Parallel()
+	var spawned []string
+	client := NewClientWithSpawner(&ClientConfig{
+		AllowedModels: DefaultModels,
+		ModelAliases:  map[string]string{"gpt-4o": "opus"},
+	}, func(_ context.Context, _ string, opts QueryOptions) (io.ReadCloser, error) {
+		spawned = append(spawned, opts.Model)
+		return io.NopCloser(strings.NewReader("")), nil
+	})
+
+	for _, model := range []string{"sonnet", "gpt-4o", ""} {
+		stream, err := client.Query(context.Background(), "test", QueryOptions{Model: model})
+		if err != nil {
+			t.Errorf("Query(%q) failed: %v", model, err)
+			continue
+		}
+		stream.Close()
+	}
+
+	_, err := client.Query(context.Background(), "test", QueryOptions{Model: "sonet"})
+	var modelErr *ModelError
+	if !errors.As(err, &modelErr) {
+		t.Fatalf("Query(sonet) error = %v, want *ModelError", err)
+	}
+	if want := `unknown model "sonet": must be one of sonnet, opus, haiku, gpt-4o`; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+	if len(spawned) != 3 {
+		t.Errorf("spawned %v, want only the three accepted queries", spawned)
+	}
+}
+
+// TestClientModelInfo verifies the default registry, a custom one, and the
+// entries for AllowedModels names and aliases.
+func TestClientModelInfo(t *testing.T) {
+	t.Parallel()
+	ids := func(infos []ModelInfo) string {
+		var ids []string
+		for _, info := range infos {
+			ids = append(ids, info.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	if got := NewClient(&ClientConfig{}).ModelInfo(); !reflect.DeepEqual(got, DefaultModelInfo) {
+		t.Errorf("default ModelInfo = %+v, want DefaultModelInfo", got)
+	}
+
+	custom := []ModelInfo{
+		{ID: "opus", FullID: "claude-opus-4-1-20250805", ContextWindow: 200000, Tools: true, Thinking: true},
+		{ID: "claude-sonnet-4-5[1m]", ContextWindow: 1000000, Tools: true},
+	}
+	if got := NewClient(&ClientConfig{Models: custom}).ModelInfo(); !reflect.DeepEqual(got, custom) {
+		t.Errorf("custom ModelInfo = %+v, want %+v", got, custom)
+	}
+
+	got := NewClient(&ClientConfig{
+		Models:        custom,
+		AllowedModels: []string{"opus", "haiku"},
+		ModelAliases:  map[string]string{"gpt-4o": "opus"},
+	}).ModelInfo()
+	if ids(got) != "opus,haiku,gpt-4o" {
+		t.Fatalf("allowed ModelInfo ids = %s, want opus,haiku,gpt-4o", ids(got))
+	}
+	if got[1] != (ModelInfo{ID: "haiku"}) {
+		t.Errorf("haiku = %+v, want only an ID, as the registry does not list it", got[1])
+	}
+	if want := custom[0]; got[2].ID != "gpt-4o" || got[2].FullID != want.FullID || got[2].ContextWindow != want.ContextWindow {
+		t.Errorf("gpt-4o = %+v, want the opus metadata", got[2])
+	}
+}
+
+// TestStats verifies the slot and query counters as streams are opened,
+// queued, and closed.
+func TestStats(t *testing.T) {
+	t.Parallel()
+	client := NewClientWithSpawner(&ClientConfig{MaxConcurrent: 2},
+		func(context.Context, string, QueryOptions) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("")), nil
+		})
+
+	var streams []*Stream
+	for i := 0; i < 2; i++ {
+		stream, err := client.Query(context.Background(), "test", QueryOptions{})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		streams = append(streams, stream)
+	}
+	if got := client.Stats(); got != (Stats{InUse: 2, MaxConcurrent: 2, Started: 2}) {
+		t.Errorf("Stats() = %+v with both slots held", got)
+	}
+
+	queued := make(chan *Stream)
+	go func() {
+		stream, _ := client.Query(context.Background(), "test", QueryOptions{})
+		queued <- stream
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for client.Stats().Waiting != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("third Query never reported as waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	streams[0].Close()
+	streams = append(streams[1:], <-queued)
+	for _, stream := range streams {
+		stream.Close()
+	}
+	if got := client.Stats(); got != (Stats{MaxConcurrent: 2, Started: 3, Completed: 3}) {
+		t.Errorf("Stats() = %+v after closing all streams", got)
+	}
+}
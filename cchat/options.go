@@ -38,7 +38,38 @@
 //	}
 package cchat
 
-import "time"
+import (
+	"io"
+	"time"
+)
+
+// DefaultModels are the model aliases the claude CLI accepts on every
+// installation.
+var DefaultModels = []string{"sonnet", "opus", "haiku"}
+
+// ModelInfo describes a model offered by a [Client], for clients choosing
+// between models by capability. Only ID is required; zero fields mean
+// unknown.
+type ModelInfo struct {
+	ID            string // name queries request, e.g. "sonnet"
+	FullID        string // dated model id, e.g. "claude-sonnet-4-5-20250929"
+	ContextWindow int    // context window in tokens
+	Tools         bool   // supports tool calling
+	Thinking      bool   // supports extended thinking
+}
+
+// DefaultModelInfo describes [DefaultModels], in the same order. FullID is
+// the model each alias resolved to when this list was last updated; the
+// CLI may resolve it to a newer one.
+var DefaultModelInfo = []ModelInfo{
+	{ID: "sonnet", FullID: "claude-sonnet-4-5-20250929", ContextWindow: 200000, Tools: true, Thinking: true},
+	{ID: "opus", FullID: "claude-opus-4-1-20250805", ContextWindow: 200000, Tools: true, Thinking: true},
+	{ID: "haiku", FullID: "claude-haiku-4-5-20251001", ContextWindow: 200000, Tools: true, Thinking: true},
+}
+
+// DefaultCloseTimeout is the [ClientConfig].CloseTimeout used when none is
+// set.
+const DefaultCloseTimeout = 5 * time.Second
 
 // ClientConfig holds the configuration for a [Client]. All fields are
 // optional and have sensible zero-value defaults.
@@ -52,21 +83,101 @@ type ClientConfig struct {
 	// [QueryOptions].Model.
 	Model string
 
+	// ModelAliases maps model names to the names passed to the claude CLI,
+	// e.g. "gpt-4o" to "opus", so clients configured for other providers'
+	// models work unchanged. It applies to both Model and
+	// [QueryOptions].Model; names without an alias are passed through as-is.
+	ModelAliases map[string]string
+
+	// AllowedModels, when non-nil, restricts the models a query may request
+	// via [QueryOptions].Model: [Client.Query] rejects any other name with a
+	// [*ModelError] before spawning a process. ModelAliases names are always
+	// allowed. Start from [DefaultModels] and append full model ids to
+	// extend the list. Nil (the default) disables the check.
+	AllowedModels []string
+
+	// Models describes the models the client offers; see
+	// [Client.ModelInfo]. It is metadata only and does not restrict
+	// queries, which is AllowedModels' job. Nil (the default) uses
+	// [DefaultModelInfo].
+	Models []ModelInfo
+
 	// MaxConcurrent limits the number of claude processes that may run
 	// simultaneously. When the limit is reached, [Client.Query] blocks
 	// until a slot is freed or the context is cancelled. A value of 0
 	// (the default) means unlimited concurrency.
 	MaxConcurrent int
 
+	// MaxQueueWait bounds how long [Client.Query] waits for a free slot
+	// when MaxConcurrent is reached. When it elapses, Query returns a
+	// [*QueueFullError]. A value of 0 (the default) waits until a slot is
+	// freed or the context is cancelled.
+	MaxQueueWait time.Duration
+
 	// DefaultTimeout applies a per-process deadline to every query.
 	// The timeout starts when [Client.Query] spawns the subprocess.
 	// A value of 0 (the default) means no timeout is applied beyond
-	// the caller-supplied context.
+	// the caller-supplied context. [QueryOptions].Timeout overrides it
+	// for a single query.
 	DefaultTimeout time.Duration
 
+	// IdleTimeout kills a process that produces no output for this long
+	// while [Stream.Next] is waiting for it, making Next return an
+	// [*IdleTimeoutError]. Unlike DefaultTimeout it does not limit the
+	// total run time, so long generations survive as long as output keeps
+	// coming. Time spent between Next calls does not count. A value of 0
+	// (the default) disables the check.
+	IdleTimeout time.Duration
+
+	// CloseTimeout bounds how long [Stream.Close] waits for a killed
+	// process to exit. When it elapses, Close logs the stuck process and
+	// releases its semaphore slot anyway, so a wedged child cannot exhaust
+	// MaxConcurrent; the process is still reaped in the background once it
+	// exits. A value of 0 (the default) uses [DefaultCloseTimeout].
+	CloseTimeout time.Duration
+
 	// WorkDir sets the working directory for spawned claude processes.
 	// If empty, the processes inherit the parent's working directory.
 	WorkDir string
+
+	// MaxMessageBytes limits the size of a single NDJSON message read from
+	// the claude process. Larger messages make [Stream.Next] return a
+	// [*ccwire.LineTooLongError]. A value of 0 (the default) uses
+	// [ccwire.DefaultMaxLineBytes].
+	MaxMessageBytes int
+
+	// RawOutput, when non-nil, receives a copy of the NDJSON each claude
+	// process writes to stdout, exactly as read and before parsing, so the
+	// CLI output can be captured for debugging. Concurrent queries write to
+	// it from their own goroutines, so it must be safe for concurrent use,
+	// and their output may interleave. If writing fails, the error is
+	// logged and the stream stops copying; parsing is not affected.
+	RawOutput io.Writer
+
+	// Tracer, when non-nil, starts a span for every query and its claude
+	// process; see [Tracer].
+	Tracer Tracer
+
+	// ExtraArgs are passed verbatim to every claude process, after the
+	// flags the SDK builds itself. Since they come later they can override
+	// defaults for flags where the CLI honors the last occurrence. Flags
+	// the output parser depends on (--print, -p, --output-format) are
+	// rejected with [ErrReservedFlag].
+	ExtraArgs []string
+
+	// Env lists "KEY=value" environment variables set on every claude
+	// process, on top of the parent's environment. Use it to give a
+	// client its own credentials or config directory, e.g.
+	// ANTHROPIC_API_KEY or CLAUDE_CONFIG_DIR. Later entries override
+	// earlier ones with the same key.
+	Env []string
+
+	// SamplingFlags passes [QueryOptions].Temperature and TopP to the
+	// CLI as --temperature and --top-p. The claude CLI has no sampling
+	// flags as of this writing, so this is only useful with a CLI build
+	// or wrapper that accepts them; by default the values are ignored.
+	// See [Client.SupportsSampling].
+	SamplingFlags bool
 }
 
 // QueryOptions configures a single [Client.Query] invocation. All fields
@@ -78,6 +189,14 @@ type QueryOptions struct {
 	// always replaced for determinism and better cache hit rates).
 	SystemPrompt string
 
+	// SystemPromptFile names a file holding the system prompt, passed
+	// with the --system-prompt-file flag instead of --system-prompt.
+	// It is an error to set both SystemPrompt and SystemPromptFile.
+	// A SystemPrompt longer than 64 KiB is written to a temporary file
+	// and passed the same way, as the kernel limits the length of a
+	// single argument; the file is removed when the stream is closed.
+	SystemPromptFile string
+
 	// Streaming enables partial message output by adding the
 	// --include-partial-messages flag. When true, the [Stream] will
 	// yield intermediate [ccwire.AssistantMessage] values in addition
@@ -93,4 +212,36 @@ type QueryOptions struct {
 	// "high". If empty, the flag is omitted and the CLI default
 	// applies.
 	Effort string
+
+	// Temperature and TopP are the sampling parameters for this query,
+	// passed as --temperature and --top-p when [ClientConfig].SamplingFlags
+	// is set and ignored otherwise. Nil leaves the CLI default.
+	Temperature *float64
+	TopP        *float64
+
+	// MaxTokens caps the number of tokens the model may generate, passed
+	// through the CLAUDE_CODE_MAX_OUTPUT_TOKENS environment variable as the
+	// claude CLI has no flag for it. Nil leaves the CLI default. An entry
+	// for the same variable in Env takes precedence.
+	MaxTokens *int
+
+	// ExtraArgs are appended verbatim after [ClientConfig].ExtraArgs for
+	// this query only. The same reserved flags are rejected.
+	ExtraArgs []string
+
+	// Timeout overrides [ClientConfig].DefaultTimeout for this query,
+	// e.g. to give a long analysis more time than a quick classification.
+	// Zero or negative values leave the default.
+	Timeout time.Duration
+
+	// Env lists "KEY=value" environment variables for this query only,
+	// applied after [ClientConfig].Env so they win on conflicting keys.
+	Env []string
+
+	// AutoCloseOnContextDone closes the [Stream] as soon as the query
+	// context is done, killing the process and releasing its semaphore
+	// slot even if the caller never calls [Stream.Close]. A helper
+	// goroutine watches the context until then; it exits when the
+	// stream is closed. Calling Close remains good practice.
+	AutoCloseOnContextDone bool
 }
@@ -3,10 +3,15 @@ package cchat
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // processInterface defines the minimal interface for process operations
@@ -20,11 +25,11 @@ type processInterface interface {
 
 // process wraps an exec.Cmd for a Claude Code CLI subprocess.
 type process struct {
-	cmd           *exec.Cmd
-	stdout        io.ReadCloser
-	stderr        *bytes.Buffer
-	cancel        context.CancelFunc
-	timeoutCancel context.CancelFunc // cancel for timeout context, if any
+	cmd      *exec.Cmd
+	stdout   io.ReadCloser
+	stderr   *bytes.Buffer
+	cancel   context.CancelFunc
+	tempFile string // system prompt file to remove on kill, if any
 }
 
 // startProcess spawns a claude CLI process with the given configuration.
@@ -32,12 +37,34 @@ type process struct {
 func startProcess(ctx context.Context, cfg ClientConfig, opts QueryOptions, prompt string) (*process, error) {
 	ctx, cancel := context.WithCancel(ctx)
 
-	args := buildArgs(cfg, opts)
+	var tempFile string
+	if len(opts.SystemPrompt) > maxSystemPromptArg && opts.SystemPromptFile == "" {
+		path, err := writeSystemPromptFile(opts.SystemPrompt)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		tempFile = path
+		opts.SystemPrompt, opts.SystemPromptFile = "", path
+	}
+	fail := func(err error) (*process, error) {
+		cancel()
+		if tempFile != "" {
+			os.Remove(tempFile)
+		}
+		return nil, err
+	}
+
+	args, err := buildArgs(cfg, opts)
+	if err != nil {
+		return fail(err)
+	}
 
 	cmd := exec.CommandContext(ctx, cfg.CLIPath, args...)
 	if cfg.WorkDir != "" {
 		cmd.Dir = cfg.WorkDir
 	}
+	cmd.Env = processEnv(cfg, opts)
 
 	// Set up stdin pipe for prompt delivery
 	cmd.Stdin = strings.NewReader(prompt)
@@ -45,8 +72,7 @@ func startProcess(ctx context.Context, cfg ClientConfig, opts QueryOptions, prom
 	// Capture stdout for NDJSON parsing
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		cancel()
-		return nil, fmt.Errorf("creating stdout pipe: %w", err)
+		return fail(fmt.Errorf("creating stdout pipe: %w", err))
 	}
 
 	// Capture stderr for error reporting
@@ -54,19 +80,106 @@ func startProcess(ctx context.Context, cfg ClientConfig, opts QueryOptions, prom
 	cmd.Stderr = &stderr
 
 	if err := cmd.Start(); err != nil {
-		cancel()
-		return nil, fmt.Errorf("starting claude process: %w", err)
+		return fail(fmt.Errorf("starting claude process: %w", err))
 	}
 
 	return &process{
-		cmd:    cmd,
-		stdout: stdout,
-		stderr: &stderr,
-		cancel: cancel,
+		cmd:      cmd,
+		stdout:   stdout,
+		stderr:   &stderr,
+		cancel:   cancel,
+		tempFile: tempFile,
 	}, nil
 }
 
-func buildArgs(cfg ClientConfig, opts QueryOptions) []string {
+// maxSystemPromptArg is the longest system prompt passed on the command
+// line. Linux rejects a single argument over 128 KiB with E2BIG; longer
+// prompts go through a temporary file instead.
+const maxSystemPromptArg = 64 << 10
+
+// writeSystemPromptFile writes prompt to a new temporary file and returns
+// its path. The caller removes the file.
+func writeSystemPromptFile(prompt string) (string, error) {
+	f, err := os.CreateTemp("", "cc-system-prompt-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("writing system prompt file: %w", err)
+	}
+	_, err = f.WriteString(prompt)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("writing system prompt file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// processEnv returns the environment for a claude process, or nil to
+// inherit the parent's unchanged.
+func processEnv(cfg ClientConfig, opts QueryOptions) []string {
+	var query []string
+	if opts.MaxTokens != nil {
+		query = append(query, "CLAUDE_CODE_MAX_OUTPUT_TOKENS="+strconv.Itoa(*opts.MaxTokens))
+	}
+	query = append(query, opts.Env...)
+	if len(cfg.Env) == 0 && len(query) == 0 {
+		return nil
+	}
+	return mergeEnv(os.Environ(), cfg.Env, query)
+}
+
+// mergeEnv combines "KEY=value" lists in order. When a key appears more than
+// once, the last value wins and takes the position of the first occurrence.
+func mergeEnv(lists ...[]string) []string {
+	var merged []string
+	index := make(map[string]int)
+	for _, list := range lists {
+		for _, kv := range list {
+			key, _, _ := strings.Cut(kv, "=")
+			if i, ok := index[key]; ok {
+				merged[i] = kv
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, kv)
+		}
+	}
+	return merged
+}
+
+// ErrReservedFlag is returned by [Client.Query] when ExtraArgs contain a flag
+// the SDK relies on for parsing the CLI output (--print or --output-format).
+var ErrReservedFlag = errors.New("reserved claude flag")
+
+// ErrSystemPromptConflict is returned by [Client.Query] when both
+// [QueryOptions].SystemPrompt and SystemPromptFile are set.
+var ErrSystemPromptConflict = errors.New("SystemPrompt and SystemPromptFile are mutually exclusive")
+
+// reservedFlags are flags ExtraArgs may not override: the NDJSON parser
+// depends on print mode with stream-json output.
+var reservedFlags = []string{"-p", "--print", "--output-format"}
+
+// checkExtraArgs rejects any reserved flag in args, in either the "--flag"
+// or "--flag=value" form.
+func checkExtraArgs(args []string) error {
+	for _, arg := range args {
+		name, _, _ := strings.Cut(arg, "=")
+		if slices.Contains(reservedFlags, name) {
+			return fmt.Errorf("%w: %s cannot be set via ExtraArgs", ErrReservedFlag, name)
+		}
+	}
+	return nil
+}
+
+func buildArgs(cfg ClientConfig, opts QueryOptions) ([]string, error) {
+	if err := checkExtraArgs(cfg.ExtraArgs); err != nil {
+		return nil, err
+	}
+	if err := checkExtraArgs(opts.ExtraArgs); err != nil {
+		return nil, err
+	}
+
 	args := []string{
 		"--print",
 		"--output-format=stream-json",
@@ -82,13 +195,23 @@ func buildArgs(cfg ClientConfig, opts QueryOptions) []string {
 	if model == "" {
 		model = cfg.Model
 	}
+	if alias, ok := cfg.ModelAliases[model]; ok {
+		model = alias
+	}
 	if model != "" {
 		args = append(args, "--model="+model)
 	}
 
-	// Always pass --system-prompt to replace the ~3k token default.
+	// Always pass a system prompt to replace the ~3k token default.
 	// An empty value gives the model no system prompt at all.
-	args = append(args, "--system-prompt="+opts.SystemPrompt)
+	if opts.SystemPromptFile != "" {
+		if opts.SystemPrompt != "" {
+			return nil, ErrSystemPromptConflict
+		}
+		args = append(args, "--system-prompt-file="+opts.SystemPromptFile)
+	} else {
+		args = append(args, "--system-prompt="+opts.SystemPrompt)
+	}
 
 	if opts.Streaming {
 		args = append(args, "--include-partial-messages")
@@ -98,7 +221,19 @@ func buildArgs(cfg ClientConfig, opts QueryOptions) []string {
 		args = append(args, "--effort="+opts.Effort)
 	}
 
-	return args
+	if cfg.SamplingFlags {
+		if opts.Temperature != nil {
+			args = append(args, "--temperature="+strconv.FormatFloat(*opts.Temperature, 'g', -1, 64))
+		}
+		if opts.TopP != nil {
+			args = append(args, "--top-p="+strconv.FormatFloat(*opts.TopP, 'g', -1, 64))
+		}
+	}
+
+	args = append(args, cfg.ExtraArgs...)
+	args = append(args, opts.ExtraArgs...)
+
+	return args, nil
 }
 
 // wait waits for the process to exit and returns any error.
@@ -106,11 +241,13 @@ func (p *process) wait() error {
 	return p.cmd.Wait()
 }
 
-// kill terminates the process and cleans up all context resources.
+// kill terminates the process, closes its stdout so pending reads return,
+// and cleans up all context resources and any temporary system prompt file.
 func (p *process) kill() {
 	p.cancel()
-	if p.timeoutCancel != nil {
-		p.timeoutCancel()
+	p.stdout.Close()
+	if p.tempFile != "" {
+		os.Remove(p.tempFile)
 	}
 }
 
@@ -124,6 +261,27 @@ func (p *process) getStderr() *bytes.Buffer {
 	return p.stderr
 }
 
+// readerProcess stands in for a claude process when output comes from a
+// [SpawnFunc] or a [ScriptedProcess]. wait returns waitErr, which is nil for
+// spawned output.
+type readerProcess struct {
+	stdout  io.ReadCloser
+	stderr  bytes.Buffer
+	cancel  context.CancelFunc
+	waitErr error
+}
+
+func (p *readerProcess) wait() error { return p.waitErr }
+
+func (p *readerProcess) kill() {
+	p.cancel()
+	p.stdout.Close()
+}
+
+func (p *readerProcess) getStdout() io.ReadCloser { return p.stdout }
+
+func (p *readerProcess) getStderr() *bytes.Buffer { return &p.stderr }
+
 // ProcessError is returned by [Stream.Next] or [Stream.Result] when the
 // claude CLI process exits with a non-zero exit code. It wraps the exit
 // code and any output written to stderr, which typically contains
@@ -171,3 +329,130 @@ type RateLimitError struct {
 func (e *RateLimitError) Error() string {
 	return e.Message
 }
+
+// QueueFullError is returned by [Client.Query] when all
+// [ClientConfig].MaxConcurrent slots stayed busy for the whole
+// [ClientConfig].MaxQueueWait. Servers typically answer it with 503 and a
+// Retry-After header.
+type QueueFullError struct {
+	// MaxConcurrent is the client's concurrency limit.
+	MaxConcurrent int
+
+	// Wait is how long Query waited for a slot.
+	Wait time.Duration
+}
+
+// Error describes the saturated queue.
+func (e *QueueFullError) Error() string {
+	return fmt.Sprintf("all %d claude process slots busy after waiting %s", e.MaxConcurrent, e.Wait)
+}
+
+// ModelError is returned by [Client.Query] when the requested model is not in
+// [ClientConfig].AllowedModels.
+type ModelError struct {
+	// Model is the rejected model name.
+	Model string
+
+	// Allowed lists the valid choices, including model aliases.
+	Allowed []string
+}
+
+// Error names the rejected model and the valid choices.
+func (e *ModelError) Error() string {
+	return fmt.Sprintf("unknown model %q: must be one of %s", e.Model, strings.Join(e.Allowed, ", "))
+}
+
+// ClientClosedError is returned by [Client.Query] once [Client.Drain] has
+// been called. Servers typically answer it with 503 while shutting down.
+type ClientClosedError struct{}
+
+// Error reports that the client no longer accepts queries.
+func (e *ClientClosedError) Error() string {
+	return "claude client is draining: no new queries accepted"
+}
+
+// Kinds of [UpstreamError].
+const (
+	UpstreamOverloaded     = "overloaded"           // the API is temporarily overloaded; retry later
+	UpstreamAPIError       = "api_error"            // the API failed to handle the request
+	UpstreamAuthentication = "authentication_error" // the CLI's credentials were rejected
+)
+
+// UpstreamError is returned by [Stream.Next] when the Claude Code CLI reports
+// that the Anthropic API failed the request, other than by rate limiting
+// (see [RateLimitError]). Kind is one of [UpstreamOverloaded],
+// [UpstreamAPIError], or [UpstreamAuthentication]; Message is the text the
+// CLI reported alongside it.
+//
+//	var upErr *cchat.UpstreamError
+//	if errors.As(err, &upErr) && upErr.Kind == cchat.UpstreamOverloaded {
+//		// back off and retry
+//	}
+type UpstreamError struct {
+	Kind    string
+	Message string
+}
+
+// Error returns the error kind and message.
+func (e *UpstreamError) Error() string {
+	return e.Kind + ": " + e.Message
+}
+
+// upstreamKind maps the error field of an assistant message to an
+// [UpstreamError] kind, or "" if it is not an upstream API error. Both the
+// API's error type names and the CLI's own spellings are recognized.
+func upstreamKind(errField string) string {
+	switch errField {
+	case "overloaded", "overloaded_error":
+		return UpstreamOverloaded
+	case "api_error", "server_error":
+		return UpstreamAPIError
+	case "authentication_error", "authentication_failed":
+		return UpstreamAuthentication
+	}
+	return ""
+}
+
+// TimeoutError is returned by [Client.Query] or [Stream.Next] when the claude
+// process was terminated because its timeout, [QueryOptions].Timeout or
+// [ClientConfig].DefaultTimeout, elapsed. It
+// unwraps to [context.DeadlineExceeded], so errors.Is checks for deadlines
+// keep working. Deadlines on the caller's own context are reported as a
+// plain wrapped [context.DeadlineExceeded] instead.
+//
+//	var timeoutErr *cchat.TimeoutError
+//	if errors.As(err, &timeoutErr) {
+//		http.Error(w, timeoutErr.Error(), http.StatusGatewayTimeout)
+//	}
+type TimeoutError struct {
+	// Timeout is the timeout that elapsed.
+	Timeout time.Duration
+}
+
+// Error returns a message naming the elapsed timeout.
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("claude process exceeded timeout of %s", e.Timeout)
+}
+
+// Unwrap returns [context.DeadlineExceeded].
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// IdleTimeoutError is returned by [Stream.Next] when the claude process was
+// killed because it produced no output for [ClientConfig].IdleTimeout. Like
+// [TimeoutError], it unwraps to [context.DeadlineExceeded].
+type IdleTimeoutError struct {
+	// Timeout is the configured idle timeout that elapsed.
+	Timeout time.Duration
+}
+
+// Error returns a message naming the elapsed idle timeout.
+func (e *IdleTimeoutError) Error() string {
+	return fmt.Sprintf("claude process produced no output for %s", e.Timeout)
+}
+
+// Unwrap returns [context.DeadlineExceeded].
+func (e *IdleTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
@@ -0,0 +1,162 @@
+package cchat
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBuildArgs_ExtraArgs(t *testing.T) {
+	cfg := ClientConfig{ExtraArgs: []string{"--client-flag", "--model=opus"}}
+	opts := QueryOptions{Model: "haiku", ExtraArgs: []string{"--query-flag=1"}}
+
+	args, err := buildArgs(cfg, opts)
+	if err != nil {
+		t.Fatalf("buildArgs: %v", err)
+	}
+
+	n := len(args)
+	want := []string{"--client-flag", "--model=opus", "--query-flag=1"}
+	if n < len(want) || !slices.Equal(args[n-len(want):], want) {
+		t.Fatalf("args should end with %v, got %v", want, args)
+	}
+	builtin := slices.Index(args, "--model=haiku")
+	if builtin < 0 || builtin > slices.Index(args, "--model=opus") {
+		t.Errorf("built-in flags must come before extra args: %v", args)
+	}
+}
+
+func TestBuildArgs_ModelAliases(t *testing.T) {
+	aliases := map[string]string{"gpt-4o": "opus", "gpt-3.5-turbo": "haiku"}
+	tests := []struct {
+		name string
+		cfg  ClientConfig
+		opts QueryOptions
+		want string
+	}{
+		{name: "query_alias", opts: QueryOptions{Model: "gpt-4o"}, want: "--model=opus"},
+		{name: "default_alias", cfg: ClientConfig{Model: "gpt-3.5-turbo"}, want: "--model=haiku"},
+		{name: "passthrough", opts: QueryOptions{Model: "sonnet"}, want: "--model=sonnet"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.ModelAliases = aliases
+			args, err := buildArgs(tt.cfg, tt.opts)
+			if err != nil {
+				t.Fatalf("buildArgs: %v", err)
+			}
+			if !slices.Contains(args, tt.want) {
+				t.Errorf("args = %v, want %s", args, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildArgs_ReservedFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  ClientConfig
+		opts QueryOptions
+	}{
+		{name: "print", cfg: ClientConfig{ExtraArgs: []string{"--print"}}},
+		{name: "short_print", opts: QueryOptions{ExtraArgs: []string{"-p"}}},
+		{name: "output_format_value", opts: QueryOptions{ExtraArgs: []string{"--output-format=json"}}},
+		{name: "output_format_separate", cfg: ClientConfig{ExtraArgs: []string{"--output-format", "text"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := buildArgs(tt.cfg, tt.opts)
+			if !errors.Is(err, ErrReservedFlag) {
+				t.Errorf("error = %v, want ErrReservedFlag", err)
+			}
+		})
+	}
+}
+
+func TestBuildArgs_SystemPromptFile(t *testing.T) {
+	args, err := buildArgs(ClientConfig{}, QueryOptions{SystemPromptFile: "/tmp/prompt.txt"})
+	if err != nil {
+		t.Fatalf("buildArgs: %v", err)
+	}
+	if !slices.Contains(args, "--system-prompt-file=/tmp/prompt.txt") {
+		t.Errorf("args = %v, want --system-prompt-file", args)
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--system-prompt=") {
+			t.Errorf("args = %v, must not also pass --system-prompt", args)
+		}
+	}
+
+	_, err = buildArgs(ClientConfig{}, QueryOptions{SystemPrompt: "Be brief.", SystemPromptFile: "/tmp/prompt.txt"})
+	if !errors.Is(err, ErrSystemPromptConflict) {
+		t.Errorf("error = %v, want ErrSystemPromptConflict", err)
+	}
+}
+
+func TestBuildArgs_Sampling(t *testing.T) {
+	temp, topP := 0.7, 0.95
+	tests := []struct {
+		name string
+		cfg  ClientConfig
+		opts QueryOptions
+		want []string
+	}{
+		{name: "set", cfg: ClientConfig{SamplingFlags: true}, opts: QueryOptions{Temperature: &temp, TopP: &topP}, want: []string{"--temperature=0.7", "--top-p=0.95"}},
+		{name: "temperature_only", cfg: ClientConfig{SamplingFlags: true}, opts: QueryOptions{Temperature: &temp}, want: []string{"--temperature=0.7"}},
+		{name: "nil", cfg: ClientConfig{SamplingFlags: true}},
+		{name: "disabled", opts: QueryOptions{Temperature: &temp, TopP: &topP}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := buildArgs(tt.cfg, tt.opts)
+			if err != nil {
+				t.Fatalf("buildArgs: %v", err)
+			}
+			var got []string
+			for _, arg := range args {
+				if strings.HasPrefix(arg, "--temperature") || strings.HasPrefix(arg, "--top-p") {
+					got = append(got, arg)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("sampling args = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	got := mergeEnv(
+		[]string{"HOME=/root", "FOO=parent", "PATH=/bin"},
+		[]string{"FOO=client", "BAR=client"},
+		[]string{"BAR=query"},
+	)
+	want := []string{"HOME=/root", "FOO=client", "PATH=/bin", "BAR=query"}
+	if !slices.Equal(got, want) {
+		t.Errorf("mergeEnv = %v, want %v", got, want)
+	}
+}
+
+func TestProcessEnv_MaxTokens(t *testing.T) {
+	if env := processEnv(ClientConfig{}, QueryOptions{}); env != nil {
+		t.Errorf("processEnv without overrides = %v, want nil", env)
+	}
+
+	n := 256
+	env := processEnv(ClientConfig{}, QueryOptions{MaxTokens: &n})
+	if !slices.Contains(env, "CLAUDE_CODE_MAX_OUTPUT_TOKENS=256") {
+		t.Errorf("env lacks CLAUDE_CODE_MAX_OUTPUT_TOKENS=256")
+	}
+
+	env = processEnv(ClientConfig{}, QueryOptions{
+		MaxTokens: &n,
+		Env:       []string{"CLAUDE_CODE_MAX_OUTPUT_TOKENS=1000"},
+	})
+	if !slices.Contains(env, "CLAUDE_CODE_MAX_OUTPUT_TOKENS=1000") || slices.Contains(env, "CLAUDE_CODE_MAX_OUTPUT_TOKENS=256") {
+		t.Errorf("explicit Env should override MaxTokens")
+	}
+}
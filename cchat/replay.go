@@ -0,0 +1,67 @@
+package cchat
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// NewReplayClient creates a [Client] whose queries replay claude output
+// recorded in the NDJSON file at path, e.g. with [ClientConfig].RawOutput or
+// [ccwire.Encode], instead of starting the claude CLI. Every query receives
+// the whole recording, whatever its prompt and options. With a positive
+// delay, each line after the first is held back that long to simulate the
+// timing of a streamed reply; see [ReplaySpawner].
+//
+// The returned Client works wherever one from [NewClient] does, e.g. wrapped
+// in an oai.Client or as the server's Client, which makes it suitable for
+// deterministic integration tests and offline demos. The file is read once,
+// here.
+func NewReplayClient(cfg *ClientConfig, path string, delay time.Duration) (*Client, error) {
+	output, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithSpawner(cfg, ReplaySpawner(output, delay)), nil
+}
+
+// ReplaySpawner returns a [SpawnFunc] that answers every query with output,
+// recorded NDJSON claude output. When delay is positive, each non-empty line
+// after the first is written delay after the previous one; the output stops
+// early, with the context's error, once the query context is done.
+func ReplaySpawner(output []byte, delay time.Duration) SpawnFunc {
+	return func(ctx context.Context, _ string, _ QueryOptions) (io.ReadCloser, error) {
+		if delay <= 0 {
+			return io.NopCloser(bytes.NewReader(output)), nil
+		}
+		r, w := io.Pipe()
+		go replayLines(ctx, w, output, delay)
+		return r, nil
+	}
+}
+
+// replayLines writes output to w line by line, waiting delay before every
+// non-empty line but the first. It returns when the output is written, ctx
+// is done, or the reading side of w has been closed.
+func replayLines(ctx context.Context, w *io.PipeWriter, output []byte, delay time.Duration) {
+	first := true
+	for line := range bytes.Lines(output) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			if !first {
+				select {
+				case <-ctx.Done():
+					w.CloseWithError(ctx.Err())
+					return
+				case <-time.After(delay):
+				}
+			}
+			first = false
+		}
+		if _, err := w.Write(line); err != nil {
+			return // the stream was closed
+		}
+	}
+	w.Close()
+}
@@ -0,0 +1,121 @@
+package cchat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+)
+
+// writeFixture encodes msgs into an NDJSON file and returns its path.
+func writeFixture(t *testing.T, msgs []ccwire.Message) string {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		if err := ccwire.Encode(&buf, msg); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "session.ndjson")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+var replayMessages = []ccwire.Message{
+	&ccwire.SystemMessage{Subtype: "init", SessionID: "sess-1", Model: "claude-haiku"},
+	&ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start"}, SessionID: "sess-1"},
+	&ccwire.ResultMessage{Subtype: "success", Result: "PONG", SessionID: "sess-1"},
+}
+
+func TestReplayClient(t *testing.T) {
+	path := writeFixture(t, replayMessages)
+	tests := []struct {
+		name    string
+		delay   time.Duration
+		minTime time.Duration
+	}{
+		{"no_delay", 0, 0},
+		{"delay", 20 * time.Millisecond, 40 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewReplayClient(&ClientConfig{}, path, tt.delay)
+			if err != nil {
+				t.Fatalf("NewReplayClient: %v", err)
+			}
+			// Each query replays the whole recording
+			for range 2 {
+				start := time.Now()
+				stream, err := client.Query(context.Background(), "ignored", QueryOptions{})
+				if err != nil {
+					t.Fatalf("Query: %v", err)
+				}
+				var got []ccwire.Message
+				for {
+					msg, err := stream.Next()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatalf("Next: %v", err)
+					}
+					got = append(got, msg)
+				}
+				stream.Close()
+				if !reflect.DeepEqual(got, replayMessages) {
+					t.Errorf("replayed %+v, want %+v", got, replayMessages)
+				}
+				if elapsed := time.Since(start); elapsed < tt.minTime {
+					t.Errorf("replay took %v, want at least %v", elapsed, tt.minTime)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayClient_Cancel(t *testing.T) {
+	client, err := NewReplayClient(&ClientConfig{}, writeFixture(t, replayMessages), time.Hour)
+	if err != nil {
+		t.Fatalf("NewReplayClient: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.Query(ctx, "ignored", QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	defer stream.Close()
+	if _, err := stream.Next(); err != nil {
+		t.Fatalf("first Next: %v", err)
+	}
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() {
+		_, err := stream.Next()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Next after cancel returned a message, want an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Next did not return after the context was cancelled")
+	}
+}
+
+func TestNewReplayClient_MissingFile(t *testing.T) {
+	_, err := NewReplayClient(&ClientConfig{}, filepath.Join(t.TempDir(), "missing.ndjson"), 0)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err = %v, want os.ErrNotExist", err)
+	}
+}
@@ -1,13 +1,34 @@
 package cchat
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"os/exec"
+	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/codewandler/cc-sdk-go/ccwire"
 )
 
+// ErrStreamClosed is returned by [Stream.Next] once [Stream.Close] has been
+// called, including to a Next call that was blocked when Close ran.
+var ErrStreamClosed = errors.New("stream closed")
+
+// ErrStreamCancelled is returned by [Stream.Next] once [Stream.Cancel] has
+// been called. It wraps [context.Canceled], so callers that already handle
+// a cancelled query context treat it the same way.
+var ErrStreamCancelled = fmt.Errorf("stream cancelled: %w", context.Canceled)
+
+// messageSource yields parsed messages. It is a [*ccwire.Parser] over the
+// process output, or a fixed list for [NewFakeStream].
+type messageSource interface {
+	Next() (ccwire.Message, error)
+}
+
 // Stream reads typed [ccwire.Message] values from a running Claude Code
 // process. Messages are parsed incrementally from the process's stdout
 // NDJSON output via a [ccwire.Parser].
@@ -15,22 +36,116 @@ import (
 // A Stream holds two resources that must be released: the underlying
 // subprocess and a concurrency semaphore slot on the parent [Client].
 // Callers MUST call [Stream.Close] when finished, typically via defer.
-// Close is idempotent and safe to call multiple times.
+// Close is idempotent and safe to call multiple times, including from a
+// different goroutine than the one calling [Stream.Next].
+//
+// To stop a query early but still read how it ended, call [Stream.Cancel]:
+// it kills only the subprocess, Next then reports [ErrStreamCancelled], and
+// Close remains required to release the slot. To stop reading but let the
+// query finish, e.g. to learn its usage, call [Stream.CloseGraceful]
+// instead of Close. The lifecycle is therefore Query, any number of Next
+// calls, an optional Cancel, and Close or CloseGraceful.
 type Stream struct {
+	ctx       context.Context    // query context; nil in tests that build a Stream directly
+	callerCtx context.Context    // ctx before the timeout was applied
+	cancel    context.CancelFunc // stops the timeout timer; may be nil
+	timeout   time.Duration      // QueryOptions.Timeout or ClientConfig.DefaultTimeout
 	proc      processInterface
-	parser    *ccwire.Parser
+	parser    messageSource
 	client    *Client
 	done      bool
 	result    *ccwire.ResultMessage
+	assistant *ccwire.AssistantMessage // last one seen, for ResultOrPartial
+	closed    atomic.Bool
 	closeOnce sync.Once
+	stop      chan struct{} // closed by Close; nil unless auto-closing
+	autoClose atomic.Bool   // Close was called because ctx was done
+	waitOnce  sync.Once
+	waitErr   error
+	idle      time.Duration // ClientConfig.IdleTimeout
+	idleTimer *time.Timer   // kills the process after idle; nil when disabled
+	idled     atomic.Bool   // the idle timer fired
+	cancelled atomic.Bool   // Cancel was called
+	span      Span          // the query's span; nil unless started by Client.Query
+	procSpan  Span          // the process's span, a child of span
+}
+
+func newStream(callerCtx, ctx context.Context, cancel context.CancelFunc, proc processInterface, client *Client) *Stream {
+	var stdout io.Reader = proc.getStdout()
+	if client.cfg.RawOutput != nil {
+		stdout = io.TeeReader(stdout, &rawOutput{w: client.cfg.RawOutput})
+	}
+	s := &Stream{
+		ctx:       ctx,
+		callerCtx: callerCtx,
+		cancel:    cancel,
+		proc:      proc,
+		parser:    ccwire.NewParser(stdout, ccwire.WithMaxLineBytes(client.cfg.MaxMessageBytes)),
+		client:    client,
+		idle:      client.cfg.IdleTimeout,
+	}
+	if s.idle > 0 {
+		s.idleTimer = time.AfterFunc(s.idle, func() {
+			s.idled.Store(true)
+			s.proc.kill()
+		})
+		s.idleTimer.Stop() // armed by each read in Next
+	}
+	return s
+}
+
+// rawOutput copies process output to [ClientConfig].RawOutput. It never
+// fails, so the tee cannot disturb parsing: after the first write error it
+// logs it and drops the rest of the output.
+type rawOutput struct {
+	w      io.Writer
+	failed bool
+}
+
+func (r *rawOutput) Write(p []byte) (int, error) {
+	if !r.failed {
+		if _, err := r.w.Write(p); err != nil {
+			r.failed = true
+			log.Printf("cchat: writing raw output failed, no longer copying it: %v", err)
+		}
+	}
+	return len(p), nil
 }
 
-func newStream(proc *process, client *Client) *Stream {
-	return &Stream{
-		proc:   proc,
-		parser: ccwire.NewParser(proc.getStdout()),
-		client: client,
+// readMessage reads the next message from the parser. With an idle timeout,
+// the process is killed if no line arrives in time.
+func (s *Stream) readMessage() (ccwire.Message, error) {
+	if s.idleTimer == nil {
+		return s.parser.Next()
 	}
+	s.idleTimer.Reset(s.idle)
+	defer s.idleTimer.Stop()
+	return s.parser.Next()
+}
+
+// closeOnDone closes s when ctx is done, unless s is closed first.
+func (s *Stream) closeOnDone(ctx context.Context) {
+	s.stop = make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.autoClose.Store(true)
+			s.Close()
+		case <-s.stop:
+		}
+	}()
+}
+
+// closedErr is the error Next returns once the stream is closed. A stream
+// closed by [QueryOptions].AutoCloseOnContextDone reports the context error
+// rather than [ErrStreamClosed], as if the process had been interrupted.
+func (s *Stream) closedErr() error {
+	if s.autoClose.Load() {
+		if err := s.contextErr(); err != nil {
+			return err
+		}
+	}
+	return ErrStreamClosed
 }
 
 // Next reads and returns the next [ccwire.Message] from the stream.
@@ -40,23 +155,72 @@ func newStream(proc *process, client *Client) *Stream {
 // the process exits with a non-zero code, Next returns a [*ProcessError]
 // containing the exit code and stderr contents. If a rate limit error
 // is detected in an AssistantMessage, Next returns a [*RateLimitError].
+// If the process died because the query context was cancelled or its
+// deadline passed, Next returns an error wrapping [context.Canceled] or
+// [context.DeadlineExceeded] instead, so callers can tell an interrupted
+// stream from a clean finish with [errors.Is]. When the deadline was
+// [QueryOptions].Timeout or [ClientConfig].DefaultTimeout, the error is a
+// [*TimeoutError].
 // Subsequent calls to Next after EOF return (nil, [io.EOF]) immediately.
+// After [Stream.Close], Next returns [ErrStreamClosed]; a Next blocked
+// reading from the process when Close is called returns it promptly. If
+// the stream was closed by [QueryOptions].AutoCloseOnContextDone, Next
+// returns the context error instead. If the process produced no output for
+// [ClientConfig].IdleTimeout, it is killed and Next returns an
+// [*IdleTimeoutError], now and on later calls. After [Stream.Cancel], Next
+// returns [ErrStreamCancelled], including to a Next blocked when Cancel ran.
 //
 // The concrete message types returned are [*ccwire.SystemMessage],
 // [*ccwire.AssistantMessage], [*ccwire.ResultMessage], and
 // [*ccwire.StreamEventMessage]. The last [*ccwire.ResultMessage] seen is
 // cached and available via [Stream.Result].
 func (s *Stream) Next() (ccwire.Message, error) {
+	msg, err := s.next()
+	if s.span != nil {
+		if result, ok := msg.(*ccwire.ResultMessage); ok {
+			s.span.SetAttributes(resultAttributes(result)...)
+		} else if err != nil && err != io.EOF && !s.closed.Load() {
+			s.span.RecordError(err)
+		}
+	}
+	return msg, err
+}
+
+// next implements [Stream.Next].
+func (s *Stream) next() (ccwire.Message, error) {
+	if s.closed.Load() {
+		return nil, s.closedErr()
+	}
+	if s.idled.Load() {
+		return nil, &IdleTimeoutError{Timeout: s.idle}
+	}
+	if s.cancelled.Load() {
+		return nil, ErrStreamCancelled
+	}
 	if s.done {
 		return nil, io.EOF
 	}
 
-	msg, err := s.parser.Next()
+	msg, err := s.readMessage()
+	if err != nil && s.closed.Load() {
+		// Close killed the process and closed stdout under us
+		return nil, s.closedErr()
+	}
+	if err != nil && s.idled.Load() {
+		return nil, &IdleTimeoutError{Timeout: s.idle}
+	}
+	if err != nil && s.cancelled.Load() {
+		return nil, ErrStreamCancelled
+	}
 	if err == io.EOF {
 		s.done = true
 		// Wait for the process to finish
-		if waitErr := s.proc.wait(); waitErr != nil {
-			if exitErr, ok := waitErr.(*exec.ExitError); ok {
+		if waitErr := s.reap(); waitErr != nil {
+			if ctxErr := s.contextErr(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			// *exec.ExitError, or the exit status of a ScriptedProcess
+			if exitErr, ok := waitErr.(interface{ ExitCode() int }); ok {
 				return nil, &ProcessError{
 					ExitCode: exitErr.ExitCode(),
 					Stderr:   s.proc.getStderr().String(),
@@ -68,33 +232,84 @@ func (s *Stream) Next() (ccwire.Message, error) {
 		return nil, io.EOF
 	}
 	if err != nil {
+		if ctxErr := s.contextErr(); ctxErr != nil {
+			return nil, ctxErr
+		}
 		return nil, err
 	}
 
-	// Check for rate limit error in AssistantMessage
-	if am, ok := msg.(*ccwire.AssistantMessage); ok && am.Error == "rate_limit" {
-		// Extract error message from content blocks
-		var errorMsg string
-		for _, block := range am.Message.Content {
-			if block.Type == "text" {
-				errorMsg = block.Text
-				break
-			}
+	// Check for an error reported in an AssistantMessage
+	if am, ok := msg.(*ccwire.AssistantMessage); ok && am.Error != "" {
+		switch kind := upstreamKind(am.Error); {
+		case am.Error == "rate_limit":
+			return nil, &RateLimitError{Message: assistantErrorText(am, "rate limit exceeded")}
+		case kind != "":
+			return nil, &UpstreamError{Kind: kind, Message: assistantErrorText(am, am.Error)}
 		}
-		if errorMsg == "" {
-			errorMsg = "rate limit exceeded"
-		}
-		return nil, &RateLimitError{Message: errorMsg}
 	}
 
 	// Cache result message
-	if rm, ok := msg.(*ccwire.ResultMessage); ok {
-		s.result = rm
+	switch m := msg.(type) {
+	case *ccwire.ResultMessage:
+		s.result = m
+	case *ccwire.AssistantMessage:
+		s.assistant = m
 	}
 
 	return msg, nil
 }
 
+// assistantErrorText returns the first text block of an error-carrying
+// assistant message, or fallback if there is none.
+func assistantErrorText(am *ccwire.AssistantMessage, fallback string) string {
+	for _, block := range am.Message.Content {
+		if block.Type == "text" && block.Text != "" {
+			return block.Text
+		}
+	}
+	return fallback
+}
+
+// reap waits for the process to exit. It is safe to call from both Next and
+// Close; the process is waited on only once.
+func (s *Stream) reap() error {
+	s.waitOnce.Do(func() {
+		s.waitErr = s.proc.wait()
+	})
+	return s.waitErr
+}
+
+// reapWithin is like reap but gives up after d, reporting whether the
+// process was reaped in time. The wait continues in the background, so the
+// process is still reaped once it exits.
+func (s *Stream) reapWithin(d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.reap()
+		close(done)
+	}()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// contextErr returns a wrapped context error if the query context is done,
+// or nil otherwise.
+func (s *Stream) contextErr() error {
+	if s.ctx == nil || s.ctx.Err() == nil {
+		return nil
+	}
+	if err := timeoutErr(s.timeout, s.callerCtx, s.ctx); err != nil {
+		return err
+	}
+	return fmt.Errorf("claude process interrupted: %w", s.ctx.Err())
+}
+
 // Result is a convenience method that drains the stream by calling [Next]
 // repeatedly until [io.EOF], then returns the final [*ccwire.ResultMessage].
 // All intermediate messages are discarded.
@@ -117,22 +332,131 @@ func (s *Stream) Result() (*ccwire.ResultMessage, error) {
 	}
 }
 
+// ResultOrPartial is like [Stream.Result], but tolerates a process that
+// dies before emitting its [*ccwire.ResultMessage]: if the stream ends with
+// [io.EOF] or a [*ProcessError] after at least one [*ccwire.AssistantMessage],
+// it returns a synthesized result whose Result is the concatenated text of
+// the last assistant message, with Subtype "partial", and partial set to
+// true. Otherwise it behaves like Result, with partial false.
+//
+// This lets callers return whatever was generated instead of failing
+// outright. Other errors, such as a cancelled context, are returned as-is.
+func (s *Stream) ResultOrPartial() (result *ccwire.ResultMessage, partial bool, err error) {
+	result, err = s.Result()
+	var procErr *ProcessError
+	if s.assistant == nil || !(err == io.ErrUnexpectedEOF || errors.As(err, &procErr)) {
+		return result, false, err
+	}
+
+	var text strings.Builder
+	for _, block := range s.assistant.Message.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return &ccwire.ResultMessage{
+		Subtype:   "partial",
+		Result:    text.String(),
+		SessionID: s.assistant.SessionID,
+	}, true, nil
+}
+
+// Cancel kills the subprocess of this stream alone, without releasing its
+// concurrency slot, so a caller can stop a query without cancelling the
+// context it shares with others or carrying a cancel func around. A Next
+// blocked in another goroutine returns promptly, and from then on Next
+// returns [ErrStreamCancelled]. [Stream.Close] must still be called to
+// reap the process and release the slot.
+//
+// Cancel is idempotent, safe to call from any goroutine, and does nothing
+// after Close.
+func (s *Stream) Cancel() {
+	if s.closed.Load() || s.cancelled.Swap(true) {
+		return
+	}
+	s.proc.kill()
+}
+
+// CloseGraceful stops the stream without killing the subprocess: it reads
+// and discards the remaining messages until the process exits, then closes
+// the stream like [Stream.Close], releasing the concurrency slot. It returns
+// the [*ccwire.ResultMessage] the process ended with, so a caller that has
+// already read what it needs can still account for the query's usage and
+// cost, or let a cache-warming generation finish. CloseGraceful blocks until
+// then; call it in a goroutine to finish in the background.
+//
+// If ctx is done first, the process is killed as by Close and the context
+// error is returned. Errors ending the stream, such as a [*ProcessError],
+// are returned too, and a stream ending without a result yields
+// [io.ErrUnexpectedEOF]. The stream is closed in every case.
+//
+// CloseGraceful must not be called while a [Stream.Next] call is in
+// progress. It is idempotent and may follow Close; a stream that is already
+// closed is not drained further, and its result is returned if one was read.
+func (s *Stream) CloseGraceful(ctx context.Context) (*ccwire.ResultMessage, error) {
+	defer s.Close()
+	if s.closed.Load() {
+		if s.result == nil {
+			return nil, ErrStreamClosed
+		}
+		return s.result, nil
+	}
+
+	stop := context.AfterFunc(ctx, func() { s.Close() })
+	defer stop()
+	var err error
+	for err == nil {
+		_, err = s.Next()
+	}
+	switch {
+	case ctx.Err() != nil && errors.Is(err, ErrStreamClosed):
+		return s.result, ctx.Err()
+	case err != io.EOF:
+		return s.result, err
+	case s.result == nil:
+		return nil, io.ErrUnexpectedEOF
+	}
+	return s.result, nil
+}
+
 // Close terminates the stream and releases all associated resources. If
-// the subprocess is still running, it is killed and reaped to prevent
-// zombie processes. The concurrency semaphore slot on the parent [Client]
-// is always released, regardless of whether the stream was fully consumed.
+// the subprocess is still running, it is killed, its stdout is closed, and
+// it is reaped to prevent zombie processes. If the process does not exit
+// within [ClientConfig].CloseTimeout, Close logs it and returns, leaving a
+// goroutine to reap it later. Closing stdout makes a [Next]
+// blocked in another goroutine return [ErrStreamClosed] without waiting for
+// the process to exit. The concurrency semaphore slot on the parent
+// [Client] is always released, regardless of whether the stream was fully
+// consumed.
 //
 // Close is idempotent: multiple calls are safe and always return nil.
 // It should be called exactly once per stream, typically via defer
 // immediately after [Client.Query].
 func (s *Stream) Close() error {
 	s.closeOnce.Do(func() {
-		if !s.done {
-			s.proc.kill()
-			s.proc.wait() // Reap the process to prevent zombies
-			s.done = true
+		s.closed.Store(true)
+		if s.idleTimer != nil {
+			s.idleTimer.Stop()
+		}
+		s.proc.kill()
+		// Reap the process to prevent zombies, but don't let a wedged one
+		// hold on to its slot
+		if timeout := s.client.closeTimeout(); !s.reapWithin(timeout) {
+			log.Printf("cchat: claude process did not exit within %s of Close; releasing its slot", timeout)
+		}
+		if s.procSpan != nil {
+			s.procSpan.End()
+		}
+		if s.cancel != nil {
+			s.cancel()
+		}
+		if s.stop != nil {
+			close(s.stop)
+		}
+		s.client.streamClosed()
+		if s.span != nil {
+			s.span.End()
 		}
-		s.client.releaseSem()
 	})
 	return nil
 }
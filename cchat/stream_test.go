@@ -3,10 +3,12 @@ package cchat
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"os/exec"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/codewandler/cc-sdk-go/ccwire"
 )
@@ -163,3 +165,319 @@ func createSuccessfulProcess(t *testing.T) processInterface {
 		cancel: cancel,
 	}
 }
+
+// TestNewScriptedStream verifies that scripted output and exit status take
+// the same Next paths as a real process.
+func TestNewScriptedStream(t *testing.T) {
+	const assistant = `{"type":"assistant","message":{"model":"claude-haiku","content":[{"type":"text","text":"PONG"}]}}` + "\n"
+	const result = `{"type":"result","subtype":"success","session_id":"sess-1","result":"PONG"}` + "\n"
+	const rateLimited = `{"type":"assistant","error":"rate_limit","message":{"content":[{"type":"text","text":"Usage limit reached"}]}}` + "\n"
+	const overloaded = `{"type":"assistant","error":"overloaded_error","message":{"content":[{"type":"text","text":"Overloaded"}]}}` + "\n"
+	const authFailed = `{"type":"assistant","error":"authentication_failed","message":{"content":[]}}` + "\n"
+	ioErr := errors.New("broken pipe")
+
+	tests := []struct {
+		name  string
+		proc  ScriptedProcess
+		check func(t *testing.T, err error)
+	}{
+		{
+			name: "clean_exit",
+			proc: ScriptedProcess{Stdout: io.NopCloser(strings.NewReader(assistant + result))},
+			check: func(t *testing.T, err error) {
+				if err != io.EOF {
+					t.Errorf("err = %v, want io.EOF", err)
+				}
+			},
+		},
+		{
+			name: "exit_code",
+			proc: ScriptedProcess{Stdout: io.NopCloser(strings.NewReader(assistant)), Stderr: "auth failed", ExitCode: 2},
+			check: func(t *testing.T, err error) {
+				var procErr *ProcessError
+				if !errors.As(err, &procErr) {
+					t.Fatalf("err = %v, want *ProcessError", err)
+				}
+				if procErr.ExitCode != 2 || procErr.Stderr != "auth failed" {
+					t.Errorf("ProcessError = %+v, want exit 2 with stderr", procErr)
+				}
+			},
+		},
+		{
+			name: "wait_error",
+			proc: ScriptedProcess{Stdout: io.NopCloser(strings.NewReader(assistant)), WaitErr: ioErr},
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, ioErr) {
+					t.Errorf("err = %v, want %v", err, ioErr)
+				}
+			},
+		},
+		{
+			name: "rate_limit",
+			proc: ScriptedProcess{Stdout: io.NopCloser(strings.NewReader(rateLimited))},
+			check: func(t *testing.T, err error) {
+				var rateErr *RateLimitError
+				if !errors.As(err, &rateErr) || rateErr.Message != "Usage limit reached" {
+					t.Errorf("err = %v, want *RateLimitError with the message text", err)
+				}
+			},
+		},
+		{
+			name: "overloaded",
+			proc: ScriptedProcess{Stdout: io.NopCloser(strings.NewReader(overloaded))},
+			check: func(t *testing.T, err error) {
+				var upErr *UpstreamError
+				if !errors.As(err, &upErr) || upErr.Kind != UpstreamOverloaded || upErr.Message != "Overloaded" {
+					t.Errorf("err = %v, want overloaded *UpstreamError", err)
+				}
+			},
+		},
+		{
+			name: "authentication",
+			proc: ScriptedProcess{Stdout: io.NopCloser(strings.NewReader(authFailed))},
+			check: func(t *testing.T, err error) {
+				var upErr *UpstreamError
+				if !errors.As(err, &upErr) || upErr.Kind != UpstreamAuthentication {
+					t.Errorf("err = %v, want authentication *UpstreamError", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := NewScriptedStream(tt.proc)
+			defer stream.Close()
+
+			var err error
+			for err == nil {
+				_, err = stream.Next()
+			}
+			tt.check(t, err)
+		})
+	}
+}
+
+// TestNewStreamFromReader verifies Result and Close on a reader-backed stream.
+func TestNewStreamFromReader(t *testing.T) {
+	stream := NewStreamFromReader(io.NopCloser(strings.NewReader(
+		`{"type":"result","subtype":"success","session_id":"sess-1","result":"PONG"}` + "\n",
+	)))
+	result, err := stream.Result()
+	if err != nil {
+		t.Fatalf("Result failed: %v", err)
+	}
+	if result.Result != "PONG" {
+		t.Errorf("result = %q, want PONG", result.Result)
+	}
+	stream.Close()
+	if _, err := stream.Next(); !errors.Is(err, ErrStreamClosed) {
+		t.Errorf("Next after Close = %v, want ErrStreamClosed", err)
+	}
+}
+
+// hungProcess is a process whose wait blocks until release is closed, like
+// a child stuck in an uninterruptible state.
+type hungProcess struct {
+	stdout  io.ReadCloser
+	release chan struct{}
+	reaped  chan struct{}
+}
+
+func (p *hungProcess) wait() error {
+	<-p.release
+	close(p.reaped)
+	return nil
+}
+
+func (p *hungProcess) kill()                    { p.stdout.Close() }
+func (p *hungProcess) getStdout() io.ReadCloser { return p.stdout }
+func (p *hungProcess) getStderr() *bytes.Buffer { return &bytes.Buffer{} }
+
+// TestStreamClose_CloseTimeout verifies that Close gives up on a process that
+// won't exit, releasing its slot, and that the process is reaped later.
+func TestStreamClose_CloseTimeout(t *testing.T) {
+	client := NewClient(&ClientConfig{MaxConcurrent: 1, CloseTimeout: 50 * time.Millisecond})
+	if err := client.acquireSem(context.Background()); err != nil {
+		t.Fatalf("acquireSem: %v", err)
+	}
+	proc := &hungProcess{
+		stdout:  io.NopCloser(strings.NewReader("")),
+		release: make(chan struct{}),
+		reaped:  make(chan struct{}),
+	}
+	stream := newStream(nil, nil, nil, proc, client)
+
+	done := make(chan struct{})
+	go func() {
+		stream.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not return within CloseTimeout")
+	}
+	if inUse := client.Stats().InUse; inUse != 0 {
+		t.Errorf("InUse = %d after Close, want 0", inUse)
+	}
+
+	close(proc.release)
+	select {
+	case <-proc.reaped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("process was not reaped after it exited")
+	}
+}
+
+// TestStreamResultOrPartial verifies that a stream ending without a result
+// yields the last assistant text as a partial result, while Result stays
+// strict.
+func TestStreamResultOrPartial(t *testing.T) {
+	const first = `{"type":"assistant","session_id":"sess-1","message":{"content":[{"type":"text","text":"Once upon"}]}}` + "\n"
+	const last = `{"type":"assistant","session_id":"sess-1","message":{"content":[{"type":"text","text":"Once upon "},{"type":"thinking","thinking":"hmm"},{"type":"text","text":"a time"}]}}` + "\n"
+	const result = `{"type":"result","subtype":"success","session_id":"sess-1","result":"Once upon a time, the end."}` + "\n"
+
+	tests := []struct {
+		name        string
+		proc        ScriptedProcess
+		wantText    string
+		wantPartial bool
+		wantErr     bool
+	}{
+		{
+			name:     "complete",
+			proc:     ScriptedProcess{Stdout: io.NopCloser(strings.NewReader(first + result))},
+			wantText: "Once upon a time, the end.",
+		},
+		{
+			name:        "eof_without_result",
+			proc:        ScriptedProcess{Stdout: io.NopCloser(strings.NewReader(first + last))},
+			wantText:    "Once upon a time",
+			wantPartial: true,
+		},
+		{
+			name:        "process_died",
+			proc:        ScriptedProcess{Stdout: io.NopCloser(strings.NewReader(first)), ExitCode: 137},
+			wantText:    "Once upon",
+			wantPartial: true,
+		},
+		{
+			name:    "nothing_generated",
+			proc:    ScriptedProcess{Stdout: io.NopCloser(strings.NewReader(""))},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := NewScriptedStream(tt.proc)
+			defer stream.Close()
+
+			got, partial, err := stream.ResultOrPartial()
+			if tt.wantErr {
+				if err != io.ErrUnexpectedEOF {
+					t.Errorf("err = %v, want io.ErrUnexpectedEOF", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResultOrPartial: %v", err)
+			}
+			if got.Result != tt.wantText || partial != tt.wantPartial {
+				t.Errorf("got (%q, partial=%v), want (%q, partial=%v)", got.Result, partial, tt.wantText, tt.wantPartial)
+			}
+			if partial && (got.Subtype != "partial" || got.SessionID != "sess-1") {
+				t.Errorf("partial result = %+v, want subtype partial for sess-1", got)
+			}
+		})
+	}
+
+	stream := NewScriptedStream(ScriptedProcess{Stdout: io.NopCloser(strings.NewReader(first))})
+	defer stream.Close()
+	if _, err := stream.Result(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Result err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+// errWriter fails every write.
+type errWriter struct{ calls int }
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	w.calls++
+	return 0, errors.New("disk full")
+}
+
+// TestStreamRawOutput verifies that ClientConfig.RawOutput receives the
+// process output byte for byte without changing what Next returns, and that
+// a failing writer does not break parsing.
+func TestStreamRawOutput(t *testing.T) {
+	output := `{"type":"system","subtype":"init","session_id":"s1"}` + "\n" +
+		"not json\n" +
+		`{"type":"result","subtype":"success","session_id":"s1","result":"ok"}` + "\n"
+	newRawStream := func(raw io.Writer) *Stream {
+		proc := &readerProcess{stdout: io.NopCloser(strings.NewReader(output)), cancel: func() {}}
+		return newStream(nil, nil, nil, proc, NewClient(&ClientConfig{RawOutput: raw}))
+	}
+
+	var buf bytes.Buffer
+	result, err := newRawStream(&buf).Result()
+	if err != nil || result.Result != "ok" {
+		t.Fatalf("Result() = %+v, %v, want ok", result, err)
+	}
+	if buf.String() != output {
+		t.Errorf("raw output = %q, want %q", buf.String(), output)
+	}
+
+	failing := &errWriter{}
+	result, err = newRawStream(failing).Result()
+	if err != nil || result.Result != "ok" {
+		t.Fatalf("Result() with a failing writer = %+v, %v, want ok", result, err)
+	}
+	if failing.calls != 1 {
+		t.Errorf("failing writer called %d times, want 1", failing.calls)
+	}
+}
+
+// TestStreamCloseGraceful verifies that CloseGraceful drains the rest of the
+// output for the result, and kills the process once its context is done.
+func TestStreamCloseGraceful(t *testing.T) {
+	output := `{"type":"assistant","message":{"model":"claude-haiku","content":[{"type":"text","text":"Hi"}]}}` + "\n" +
+		`{"type":"assistant","message":{"model":"claude-haiku","content":[{"type":"text","text":"there"}]}}` + "\n" +
+		`{"type":"result","subtype":"success","result":"Hi there","usage":{"input_tokens":12,"output_tokens":3}}` + "\n"
+	stream := NewScriptedStream(ScriptedProcess{Stdout: io.NopCloser(strings.NewReader(output))})
+	if _, err := stream.Next(); err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+
+	result, err := stream.CloseGraceful(context.Background())
+	if err != nil {
+		t.Fatalf("CloseGraceful: %v", err)
+	}
+	if result.Result != "Hi there" || result.Usage.OutputTokens != 3 {
+		t.Errorf("result = %+v, want the final result with usage", result)
+	}
+	if _, err := stream.Next(); err != ErrStreamClosed {
+		t.Errorf("Next after CloseGraceful = %v, want ErrStreamClosed", err)
+	}
+	if again, err := stream.CloseGraceful(context.Background()); err != nil || again != result {
+		t.Errorf("second CloseGraceful = %v, %v, want the same result", again, err)
+	}
+
+	// A process that never finishes is killed when ctx is done.
+	r, w := io.Pipe()
+	defer w.Close()
+	stream = NewScriptedStream(ScriptedProcess{Stdout: r})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := stream.CloseGraceful(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CloseGraceful error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("CloseGraceful took %v after its context was done", elapsed)
+	}
+	if _, err := stream.Next(); err != ErrStreamClosed {
+		t.Errorf("Next after cancelled CloseGraceful = %v, want ErrStreamClosed", err)
+	}
+}
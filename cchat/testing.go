@@ -0,0 +1,171 @@
+package cchat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+)
+
+// The helpers in this file are testing utilities: they build a [Stream] over
+// canned data, without a claude process or a [Client], so that code consuming
+// streams can be tested deterministically. Close must still be called on the
+// returned streams; it releases nothing but closes the underlying reader.
+
+// ScriptedProcess describes the observable behavior of a claude process for
+// [NewScriptedStream]: the NDJSON it writes to stdout, what it writes to
+// stderr, and how it exits once stdout is drained.
+type ScriptedProcess struct {
+	// Stdout is the NDJSON output parsed by [Stream.Next].
+	Stdout io.ReadCloser
+
+	// Stderr is reported in the [*ProcessError] when ExitCode is non-zero.
+	Stderr string
+
+	// ExitCode is the process exit code. When non-zero, Next returns a
+	// [*ProcessError] after the last message instead of [io.EOF].
+	ExitCode int
+
+	// WaitErr, when set and ExitCode is zero, is returned by Next after the
+	// last message, as a failure to wait for the process would be.
+	WaitErr error
+}
+
+// NewStreamFromReader returns a [Stream] that parses NDJSON from r as if it
+// were the stdout of a claude process that exits cleanly. It is shorthand for
+// [NewScriptedStream] with only Stdout set.
+func NewStreamFromReader(r io.ReadCloser) *Stream {
+	return NewScriptedStream(ScriptedProcess{Stdout: r})
+}
+
+// NewScriptedStream returns a [Stream] that parses p.Stdout and then reports
+// p's exit status, exercising the same [Stream.Next] paths as a real process:
+// [*RateLimitError] for rate-limited assistant messages, [*ProcessError] for
+// a non-zero ExitCode, and [io.EOF] for a clean exit.
+func NewScriptedStream(p ScriptedProcess) *Stream {
+	proc := &readerProcess{
+		stdout:  p.Stdout,
+		cancel:  func() {},
+		waitErr: p.WaitErr,
+	}
+	proc.stderr.WriteString(p.Stderr)
+	if p.ExitCode != 0 {
+		proc.waitErr = scriptedExit(p.ExitCode)
+	}
+	return &Stream{
+		proc:   proc,
+		parser: ccwire.NewParser(p.Stdout),
+	}
+}
+
+// scriptedExit is the wait error of a [ScriptedProcess] with a non-zero exit
+// code. Like *exec.ExitError, it reports the code via ExitCode.
+type scriptedExit int
+
+func (e scriptedExit) ExitCode() int { return int(e) }
+
+func (e scriptedExit) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+
+// NewFakeStream returns a [Stream] that yields messages in order and then
+// [io.EOF]. As with a real stream, the last [*ccwire.ResultMessage] is cached
+// for [Stream.Result], and an [*ccwire.AssistantMessage] with a rate_limit
+// error is reported as a [*RateLimitError].
+func NewFakeStream(messages []ccwire.Message) *Stream {
+	return &Stream{
+		proc: &readerProcess{
+			stdout: io.NopCloser(strings.NewReader("")),
+			cancel: func() {},
+		},
+		parser: &sliceSource{messages: messages},
+	}
+}
+
+// sliceSource is a messageSource over a fixed list of messages.
+type sliceSource struct {
+	messages []ccwire.Message
+	next     int
+}
+
+func (s *sliceSource) Next() (ccwire.Message, error) {
+	if s.next >= len(s.messages) {
+		return nil, io.EOF
+	}
+	msg := s.messages[s.next]
+	s.next++
+	return msg, nil
+}
+
+// RecordingTracer is a [Tracer] that keeps every span it starts, for tests
+// that check what is traced. It is safe for concurrent use.
+type RecordingTracer struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+// RecordedSpan is a span started by a [RecordingTracer]. Read its fields
+// only once it has ended.
+type RecordedSpan struct {
+	Name       string
+	Parent     *RecordedSpan // nil for a root span
+	Attributes map[string]any
+	Errors     []error
+	Ended      int // number of End calls
+
+	mu sync.Mutex
+}
+
+// recordedSpanKey is the context key of the current *RecordedSpan.
+type recordedSpanKey struct{}
+
+// Start implements [Tracer].
+func (t *RecordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(recordedSpanKey{}).(*RecordedSpan)
+	span := &RecordedSpan{Name: name, Parent: parent, Attributes: map[string]any{}}
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+// Spans returns the spans started so far, in start order.
+func (t *RecordingTracer) Spans() []*RecordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*RecordedSpan(nil), t.spans...)
+}
+
+// Span returns the first span named name, or nil if there is none.
+func (t *RecordingTracer) Span(name string) *RecordedSpan {
+	for _, span := range t.Spans() {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+// SetAttributes implements [Span].
+func (s *RecordedSpan) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range attrs {
+		s.Attributes[a.Key] = a.Value
+	}
+}
+
+// RecordError implements [Span].
+func (s *RecordedSpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Errors = append(s.Errors, err)
+}
+
+// End implements [Span].
+func (s *RecordedSpan) End() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Ended++
+}
@@ -0,0 +1,84 @@
+package cchat
+
+import (
+	"context"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+)
+
+// Tracer starts spans for distributed tracing, e.g. with OpenTelemetry. It
+// is the small subset of a tracing API that the SDK needs, so that tracing
+// costs no dependency; an adapter for OpenTelemetry is a few lines, see the
+// README. Set it as [ClientConfig].Tracer.
+//
+// With a tracer, [Client.Query] starts a "cchat.query" span, which lasts
+// until the stream is closed and includes the wait for a concurrency slot,
+// and within it a "cchat.process" span for the lifetime of the claude
+// process. Both are children of the span in the query context, if any.
+type Tracer interface {
+	// Start starts a span named name as a child of the span in ctx, if
+	// any, and returns a context carrying the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a [Tracer]. Its methods must be safe for
+// concurrent use, since a stream may be closed from a different goroutine
+// than the one reading it.
+type Span interface {
+	// SetAttributes adds attributes to the span, replacing any with the
+	// same key.
+	SetAttributes(attrs ...Attribute)
+
+	// RecordError records err as the cause of the span failing.
+	RecordError(err error)
+
+	// End ends the span. It is called exactly once.
+	End()
+}
+
+// Attribute is a key-value pair describing a [Span]. Value is a string, int,
+// int64, float64 or bool.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// The attribute keys of the SDK's spans. They follow the OpenTelemetry
+// semantic conventions for generative AI where one exists.
+const (
+	AttrModel         = "gen_ai.request.model"
+	AttrInputTokens   = "gen_ai.usage.input_tokens"
+	AttrOutputTokens  = "gen_ai.usage.output_tokens"
+	AttrResponseModel = "gen_ai.response.model"
+	AttrDurationMS    = "claude.duration_ms"
+)
+
+// StartSpan is like [Tracer.Start], but returns ctx and a span that does
+// nothing if tracer is nil, so callers need not check for one. attrs are set
+// on the new span.
+func StartSpan(ctx context.Context, tracer Tracer, name string, attrs ...Attribute) (context.Context, Span) {
+	if tracer == nil {
+		return ctx, noopSpan{}
+	}
+	ctx, span := tracer.Start(ctx, name)
+	if len(attrs) > 0 {
+		span.SetAttributes(attrs...)
+	}
+	return ctx, span
+}
+
+// resultAttributes returns the usage and duration attributes of result.
+func resultAttributes(result *ccwire.ResultMessage) []Attribute {
+	u := result.Usage
+	return []Attribute{
+		{AttrInputTokens, u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens},
+		{AttrOutputTokens, u.OutputTokens},
+		{AttrDurationMS, result.DurationMS},
+	}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
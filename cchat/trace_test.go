@@ -0,0 +1,88 @@
+package cchat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTracer_QuerySpans(t *testing.T) {
+	tracer := &RecordingTracer{}
+	client := NewClientWithSpawner(&ClientConfig{Model: "sonnet", Tracer: tracer}, func(context.Context, string, QueryOptions) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(
+			`{"type":"result","subtype":"success","duration_ms":1500,"usage":{"input_tokens":10,"cache_read_input_tokens":5,"output_tokens":3}}` + "\n")), nil
+	})
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	stream, err := client.Query(ctx, "ping", QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if _, err := stream.Result(); err != nil {
+		t.Fatalf("Result: %v", err)
+	}
+	if span := tracer.Span("cchat.query"); span == nil || span.Ended != 0 {
+		t.Fatalf("query span = %+v before Close, want one that has not ended", span)
+	}
+	stream.Close()
+	stream.Close()
+
+	query, proc := tracer.Span("cchat.query"), tracer.Span("cchat.process")
+	if query.Parent != root.(*RecordedSpan) || proc == nil || proc.Parent != query {
+		t.Fatalf("spans = %+v, want root > cchat.query > cchat.process", tracer.Spans())
+	}
+	want := map[string]any{AttrModel: "sonnet", AttrInputTokens: 15, AttrOutputTokens: 3, AttrDurationMS: 1500}
+	for k, v := range want {
+		if query.Attributes[k] != v {
+			t.Errorf("query attribute %s = %v, want %v", k, query.Attributes[k], v)
+		}
+	}
+	if query.Ended != 1 || proc.Ended != 1 {
+		t.Errorf("query and process spans ended %d and %d times, want once each", query.Ended, proc.Ended)
+	}
+	if len(query.Errors) != 0 {
+		t.Errorf("query span errors = %v, want none", query.Errors)
+	}
+}
+
+func TestTracer_QueryErrors(t *testing.T) {
+	t.Run("rejected", func(t *testing.T) {
+		tracer := &RecordingTracer{}
+		client := NewClientWithSpawner(&ClientConfig{AllowedModels: []string{"haiku"}, Tracer: tracer}, func(context.Context, string, QueryOptions) (io.ReadCloser, error) {
+			t.Error("spawner called for a rejected model")
+			return nil, nil
+		})
+		_, err := client.Query(context.Background(), "ping", QueryOptions{Model: "opus"})
+		var modelErr *ModelError
+		if !errors.As(err, &modelErr) {
+			t.Fatalf("err = %v, want a *ModelError", err)
+		}
+		spans := tracer.Spans()
+		if len(spans) != 1 || spans[0].Name != "cchat.query" || spans[0].Ended != 1 || len(spans[0].Errors) != 1 {
+			t.Errorf("spans = %+v, want one ended cchat.query span with the error", spans)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		tracer := &RecordingTracer{}
+		client := NewClientWithSpawner(&ClientConfig{Tracer: tracer}, func(context.Context, string, QueryOptions) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(
+				`{"type":"assistant","error":"rate_limit","message":{"content":[{"type":"text","text":"slow down"}]}}` + "\n")), nil
+		})
+		stream, err := client.Query(context.Background(), "ping", QueryOptions{})
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		_, err = stream.Next()
+		stream.Close()
+		var rateErr *RateLimitError
+		if !errors.As(err, &rateErr) {
+			t.Fatalf("Next error = %v, want a *RateLimitError", err)
+		}
+		if query := tracer.Span("cchat.query"); len(query.Errors) != 1 || query.Errors[0] != err {
+			t.Errorf("query span errors = %v, want [%v]", query.Errors, err)
+		}
+	})
+}
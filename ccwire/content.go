@@ -97,6 +97,55 @@ func (e StreamEvent) DeltaText() string {
 	return ""
 }
 
+// DeltaThinking extracts the incremental chain-of-thought text from a
+// content_block_delta event whose delta type is "thinking_delta". It returns
+// the string from the delta's "thinking" field.
+//
+// For events that are not content_block_delta, or for delta types other than
+// "thinking_delta", DeltaThinking returns an empty string.
+func (e StreamEvent) DeltaThinking() string {
+	delta, ok := e.Raw["delta"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	if dt, ok := delta["type"].(string); !ok || dt != "thinking_delta" {
+		return ""
+	}
+	if thinking, ok := delta["thinking"].(string); ok {
+		return thinking
+	}
+	return ""
+}
+
+// DeltaPartialJSON extracts a fragment of a tool_use block's input from a
+// content_block_delta event whose delta type is "input_json_delta". It
+// returns the string from the delta's "partial_json" field; concatenating the
+// fragments of a block in order yields the complete input JSON.
+//
+// For events that are not content_block_delta, or for delta types other than
+// "input_json_delta", DeltaPartialJSON returns an empty string.
+func (e StreamEvent) DeltaPartialJSON() string {
+	delta, ok := e.Raw["delta"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	if dt, ok := delta["type"].(string); !ok || dt != "input_json_delta" {
+		return ""
+	}
+	if partial, ok := delta["partial_json"].(string); ok {
+		return partial
+	}
+	return ""
+}
+
+// ContentBlock returns the "content_block" object of a content_block_start
+// event, which carries the block's type and, for tool_use blocks, its id and
+// name. It returns nil for other events.
+func (e StreamEvent) ContentBlock() map[string]any {
+	block, _ := e.Raw["content_block"].(map[string]any)
+	return block
+}
+
 // Index returns the zero-based content block index from the event. This field
 // is present on content_block_start, content_block_delta, and
 // content_block_stop events.
@@ -0,0 +1,76 @@
+package ccwire
+
+import "testing"
+
+func TestStreamEvent_DeltaThinking(t *testing.T) {
+	tests := []struct {
+		name  string
+		event map[string]any
+		want  string
+	}{
+		{
+			name: "thinking_delta",
+			event: map[string]any{
+				"type":  "content_block_delta",
+				"delta": map[string]any{"type": "thinking_delta", "thinking": "hmm"},
+			},
+			want: "hmm",
+		},
+		{
+			name: "text_delta",
+			event: map[string]any{
+				"type":  "content_block_delta",
+				"delta": map[string]any{"type": "text_delta", "text": "hello"},
+			},
+			want: "",
+		},
+		{
+			name:  "no_delta",
+			event: map[string]any{"type": "message_stop"},
+			want:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := ParseStreamEvent(&StreamEventMessage{Event: tt.event})
+			if got := ev.DeltaThinking(); got != tt.want {
+				t.Errorf("DeltaThinking() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamEvent_DeltaPartialJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		event map[string]any
+		want  string
+	}{
+		{
+			name: "input_json_delta",
+			event: map[string]any{
+				"type":  "content_block_delta",
+				"delta": map[string]any{"type": "input_json_delta", "partial_json": `{"city": "Par`},
+			},
+			want: `{"city": "Par`,
+		},
+		{
+			name: "text_delta",
+			event: map[string]any{
+				"type":  "content_block_delta",
+				"delta": map[string]any{"type": "text_delta", "text": "hello"},
+			},
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := ParseStreamEvent(&StreamEventMessage{Event: tt.event})
+			if got := ev.DeltaPartialJSON(); got != tt.want {
+				t.Errorf("DeltaPartialJSON() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
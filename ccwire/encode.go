@@ -0,0 +1,51 @@
+package ccwire
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Encode writes msg to w as a single NDJSON line in the form the Claude Code
+// CLI emits, with the "type" field first, so that a [Parser] reading the
+// output returns an equal message. It is the inverse of [Parser.Next] and is
+// meant for recording CLI output as test fixtures and replaying it.
+//
+// HTML characters are not escaped. [json.Number] values in a
+// [StreamEventMessage] are written verbatim, so numbers keep their
+// precision.
+func Encode(w io.Writer, msg Message) error {
+	if v := reflect.ValueOf(msg); msg == nil || v.Kind() == reflect.Pointer && v.IsNil() {
+		return errors.New("ccwire: cannot encode a nil message")
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(msg); err != nil {
+		return fmt.Errorf("failed to encode %s message: %w", msg.MsgType(), err)
+	}
+	object := bytes.TrimSpace(body.Bytes())
+	if object[0] != '{' {
+		return fmt.Errorf("failed to encode %s message: not a JSON object", msg.MsgType())
+	}
+	fields := object[1:] // everything after the opening brace
+
+	typ, err := json.Marshal(msg.MsgType())
+	if err != nil {
+		return err
+	}
+	var line bytes.Buffer
+	line.WriteString(`{"type":`)
+	line.Write(typ)
+	if fields[0] != '}' {
+		line.WriteByte(',')
+	}
+	line.Write(fields)
+	line.WriteByte('\n')
+	_, err = w.Write(line.Bytes())
+	return err
+}
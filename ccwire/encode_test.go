@@ -0,0 +1,154 @@
+package ccwire
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestEncode_RoundTrip verifies that every message type encodes to a line
+// that the parser reads back as an equal message.
+func TestEncode_RoundTrip(t *testing.T) {
+	stopReason := "end_turn"
+	parent := "toolu_0"
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{
+			name: "system",
+			msg: &SystemMessage{
+				Subtype:   "init",
+				SessionID: "sess-1",
+				Model:     "claude-sonnet-4-5",
+				CWD:       "/tmp",
+				Tools:     []string{"Bash", "Read"},
+			},
+		},
+		{
+			name: "assistant",
+			msg: &AssistantMessage{
+				Message: AssistantInner{
+					ID:    "msg_1",
+					Type:  "message",
+					Role:  "assistant",
+					Model: "claude-sonnet-4-5",
+					Content: []ContentBlock{
+						{Type: "text", Text: "<b>Hi</b> & bye"},
+						{Type: "tool_use", ID: "toolu_1", Name: "Bash", Input: map[string]any{"command": "ls", "timeout": float64(30)}},
+					},
+					StopReason: &stopReason,
+					Usage:      Usage{InputTokens: 10, OutputTokens: 5, CacheReadInputTokens: 3},
+				},
+				SessionID:       "sess-1",
+				ParentToolUseID: &parent,
+			},
+		},
+		{
+			name: "assistant_error",
+			msg: &AssistantMessage{
+				Message: AssistantInner{Content: []ContentBlock{{Type: "text", Text: "Usage limit reached"}}},
+				Error:   "rate_limit",
+			},
+		},
+		{
+			name: "result",
+			msg: &ResultMessage{
+				Subtype:      "success",
+				Result:       "done",
+				DurationMS:   1234,
+				SessionID:    "sess-1",
+				TotalCostUSD: 0.0125,
+				StopReason:   &stopReason,
+				Usage:        ResultUsage{InputTokens: 10, OutputTokens: 5},
+				ModelUsage:   map[string]any{"claude-sonnet-4-5": map[string]any{"inputTokens": float64(10)}},
+				PermissionDenials: []PermissionDenial{
+					{ToolName: "Write", ToolUseID: "toolu_2", ToolInput: map[string]any{"file_path": "/etc/passwd"}},
+				},
+			},
+		},
+		{
+			name: "stream_event",
+			msg: &StreamEventMessage{
+				Event: map[string]any{
+					"type":  "content_block_delta",
+					"index": json.Number("0"),
+					"delta": map[string]any{"type": "text_delta", "text": "Hello"},
+					"usage": map[string]any{"output_tokens": json.Number("12345678901234567890"), "ratio": json.Number("0.5")},
+				},
+				SessionID: "sess-1",
+			},
+		},
+		{
+			name: "empty",
+			msg:  &ResultMessage{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, tt.msg); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			line := buf.String()
+			if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+				t.Fatalf("Encode wrote %q, want a single line", line)
+			}
+			if prefix := `{"type":"` + string(tt.msg.MsgType()) + `"`; !strings.HasPrefix(line, prefix) {
+				t.Errorf("line = %s, want it to start with %s", line, prefix)
+			}
+
+			p := NewParser(&buf)
+			got, err := p.Next()
+			if err != nil {
+				t.Fatalf("Next: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Errorf("round trip = %+v, want %+v", got, tt.msg)
+			}
+			if _, err := p.Next(); err != io.EOF {
+				t.Errorf("second Next error = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+// TestEncode_Stream verifies that encoded messages written one after another
+// parse back in order, as a recorded fixture would.
+func TestEncode_Stream(t *testing.T) {
+	msgs := []Message{
+		&SystemMessage{Subtype: "init", SessionID: "s"},
+		&StreamEventMessage{Event: map[string]any{"type": "message_stop"}, SessionID: "s"},
+		&ResultMessage{Subtype: "success", Result: "ok", SessionID: "s"},
+	}
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		if err := Encode(&buf, msg); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+
+	p := NewParser(&buf)
+	for i, want := range msgs {
+		got, err := p.Next()
+		if err != nil {
+			t.Fatalf("Next %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("message %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestEncode_Nil(t *testing.T) {
+	var result *ResultMessage
+	for _, msg := range []Message{nil, result} {
+		if err := Encode(io.Discard, msg); err == nil {
+			t.Errorf("Encode(%#v) error = nil, want an error", msg)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package ccwire
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 )
@@ -15,16 +16,101 @@ import (
 // A Parser is not safe for concurrent use. Callers should synchronize access
 // externally if multiple goroutines need to read from the same parser.
 type Parser struct {
-	scanner *bufio.Scanner
+	scanner      *bufio.Scanner
+	maxLineBytes int
+	onUnknown    func(typ string, raw []byte)
+	strict       bool
+}
+
+// DefaultMaxLineBytes is the default maximum size of a single NDJSON line
+// accepted by a [Parser]. Override it with [WithMaxLineBytes].
+const DefaultMaxLineBytes = 10 * 1024 * 1024
+
+// initialBufferBytes is the initial scanner buffer size; it grows on demand
+// up to the configured maximum line size.
+const initialBufferBytes = 1024 * 1024
+
+// LineTooLongError is returned by [Parser.Next] when a line exceeds the
+// parser's maximum line size. Raise the limit with [WithMaxLineBytes] (or
+// cchat's ClientConfig.MaxMessageBytes) to accept larger messages.
+type LineTooLongError struct {
+	// Limit is the maximum line size in bytes that was exceeded.
+	Limit int
+}
+
+// Error returns a human-readable description including the configured limit.
+func (e *LineTooLongError) Error() string {
+	return fmt.Sprintf("NDJSON line exceeds maximum size of %d bytes", e.Limit)
+}
+
+// MalformedLineError is returned by [Parser.Next] in strict mode for a line
+// that is not a JSON object; see [WithStrictMode].
+type MalformedLineError struct {
+	// Line is a copy of the offending line.
+	Line []byte
+
+	// Err is the error from decoding the line.
+	Err error
+}
+
+// Error returns a description of the decoding failure.
+func (e *MalformedLineError) Error() string {
+	return fmt.Sprintf("malformed NDJSON line: %v", e.Err)
+}
+
+// Unwrap returns the underlying decoding error.
+func (e *MalformedLineError) Unwrap() error {
+	return e.Err
+}
+
+// ParserOption configures optional [Parser] behavior. Pass options to
+// [NewParser].
+type ParserOption func(*Parser)
+
+// WithUnknownHandler registers fn to be called for every line whose "type"
+// value is not one of the known [MessageType] constants. The line is still
+// skipped by [Parser.Next]; the handler merely lets forward-compatible callers
+// observe new CLI message kinds. raw is a copy of the line and may be retained.
+func WithUnknownHandler(fn func(typ string, raw []byte)) ParserOption {
+	return func(p *Parser) {
+		p.onUnknown = fn
+	}
+}
+
+// WithStrictMode makes [Parser.Next] return a [*MalformedLineError] for a
+// line that is not a JSON object, instead of skipping it, so protocol drift
+// or corrupted output surfaces during development. The following call to
+// Next resumes with the next line. Lines with an unknown "type" are still
+// skipped.
+func WithStrictMode() ParserOption {
+	return func(p *Parser) {
+		p.strict = true
+	}
+}
+
+// WithMaxLineBytes sets the maximum size of a single NDJSON line. Lines
+// longer than n cause [Parser.Next] to return a [*LineTooLongError]. Values
+// of n less than or equal to zero leave the default of [DefaultMaxLineBytes].
+func WithMaxLineBytes(n int) ParserOption {
+	return func(p *Parser) {
+		if n > 0 {
+			p.maxLineBytes = n
+		}
+	}
 }
 
 // NewParser creates a [Parser] that reads NDJSON lines from r. The parser
-// allocates a 1 MB initial buffer and allows individual lines up to 10 MB,
-// which accommodates large assistant responses and tool results.
-func NewParser(r io.Reader) *Parser {
-	scanner := bufio.NewScanner(r)
-	scanner.Buffer(make([]byte, 0, 1024*1024), 10*1024*1024) // 10MB max line
-	return &Parser{scanner: scanner}
+// allocates a 1 MB initial buffer and by default allows individual lines up
+// to 10 MB, which accommodates large assistant responses and tool results.
+// Use [WithMaxLineBytes] to change the limit.
+func NewParser(r io.Reader, opts ...ParserOption) *Parser {
+	p := &Parser{maxLineBytes: DefaultMaxLineBytes}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.scanner = bufio.NewScanner(r)
+	p.scanner.Buffer(make([]byte, 0, min(initialBufferBytes, p.maxLineBytes)), p.maxLineBytes)
+	return p
 }
 
 // envelope is used for initial type discrimination.
@@ -33,11 +119,14 @@ type envelope struct {
 }
 
 // Next reads and returns the next typed [Message] from the NDJSON stream.
-// It skips empty lines and lines with unrecognized "type" values.
+// It skips empty lines and lines with unrecognized "type" values, reporting
+// the latter to the handler registered with [WithUnknownHandler], if any.
 //
 // Next returns [io.EOF] when the underlying reader is exhausted. Parse errors
 // on recognized message types are returned as wrapped errors. Malformed lines
-// that cannot be unmarshaled into an envelope are silently skipped.
+// that cannot be unmarshaled into an envelope are silently skipped, or
+// reported as a [*MalformedLineError] with [WithStrictMode]. A line longer
+// than the maximum line size yields a [*LineTooLongError].
 func (p *Parser) Next() (Message, error) {
 	for p.scanner.Scan() {
 		line := p.scanner.Bytes()
@@ -47,6 +136,9 @@ func (p *Parser) Next() (Message, error) {
 
 		var env envelope
 		if err := json.Unmarshal(line, &env); err != nil {
+			if p.strict {
+				return nil, &MalformedLineError{Line: append([]byte(nil), line...), Err: err}
+			}
 			// Skip malformed lines
 			continue
 		}
@@ -56,12 +148,18 @@ func (p *Parser) Next() (Message, error) {
 			return nil, fmt.Errorf("failed to parse %s message: %w", env.Type, err)
 		}
 		if msg == nil {
+			if p.onUnknown != nil {
+				p.onUnknown(env.Type, append([]byte(nil), line...))
+			}
 			continue
 		}
 		return msg, nil
 	}
 
 	if err := p.scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, &LineTooLongError{Limit: p.maxLineBytes}
+		}
 		return nil, fmt.Errorf("scanner error: %w", err)
 	}
 	return nil, io.EOF
@@ -1,7 +1,10 @@
 package ccwire
 
 import (
+	"encoding/json"
+	"errors"
 	"io"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -103,6 +106,44 @@ func TestParser_MalformedJSON(t *testing.T) {
 	}
 }
 
+// TestParser_StrictMode verifies that WithStrictMode reports malformed lines
+// instead of skipping them, and that parsing resumes after the error.
+func TestParser_StrictMode(t *testing.T) {
+	input := "not json at all\n" + `{"type":"system","session_id":"s1"}` + "\n" + `{"type":"future_type"}`
+
+	parser := NewParser(strings.NewReader(input), WithStrictMode())
+	_, err := parser.Next()
+	var lineErr *MalformedLineError
+	if !errors.As(err, &lineErr) {
+		t.Fatalf("expected *MalformedLineError, got %v", err)
+	}
+	if string(lineErr.Line) != "not json at all" {
+		t.Errorf("Line = %q, want the malformed line", lineErr.Line)
+	}
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("error %v does not wrap the JSON syntax error", err)
+	}
+	if msg, err := parser.Next(); err != nil {
+		t.Fatalf("unexpected error after malformed line: %v", err)
+	} else if _, ok := msg.(*SystemMessage); !ok {
+		t.Fatalf("expected *SystemMessage, got %T", msg)
+	}
+	// Unknown types are still skipped in strict mode
+	if _, err := parser.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	// Without strict mode the same line is skipped
+	msg, err := NewParser(strings.NewReader(input)).Next()
+	if err != nil {
+		t.Fatalf("unexpected error in lenient mode: %v", err)
+	}
+	if _, ok := msg.(*SystemMessage); !ok {
+		t.Errorf("expected *SystemMessage in lenient mode, got %T", msg)
+	}
+}
+
 // TestParser_UnknownMessageType verifies unknown message types are skipped.
 func TestParser_UnknownMessageType(t *testing.T) {
 	input := `{"type":"future_type","some_field":"value"}`
@@ -117,6 +158,39 @@ func TestParser_UnknownMessageType(t *testing.T) {
 	}
 }
 
+// TestParser_UnknownHandler verifies that WithUnknownHandler observes skipped
+// lines with their raw bytes while known messages are still returned.
+func TestParser_UnknownHandler(t *testing.T) {
+	future := `{"type":"future_type","some_field":"value"}`
+	input := future + "\n" + `{"type":"system","session_id":"s1"}`
+
+	var gotType string
+	var gotRaw []byte
+	calls := 0
+	parser := NewParser(strings.NewReader(input), WithUnknownHandler(func(typ string, raw []byte) {
+		calls++
+		gotType = typ
+		gotRaw = raw
+	}))
+
+	msg, err := parser.Next()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := msg.(*SystemMessage); !ok {
+		t.Fatalf("expected *SystemMessage after unknown line, got %T", msg)
+	}
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+	if gotType != "future_type" {
+		t.Errorf("type = %q, want %q", gotType, "future_type")
+	}
+	if string(gotRaw) != future {
+		t.Errorf("raw = %q, want %q", gotRaw, future)
+	}
+}
+
 // TestParser_ValidMessages verifies that valid messages are parsed correctly.
 func TestParser_ValidMessages(t *testing.T) {
 	tests := []struct {
@@ -236,3 +310,112 @@ func TestParser_EmptyStream(t *testing.T) {
 		t.Errorf("expected nil message, got %T", msg)
 	}
 }
+
+// TestParser_MaxLineBytes verifies that lines over the configured limit yield
+// a typed LineTooLongError and that raising the limit accepts them.
+func TestParser_MaxLineBytes(t *testing.T) {
+	line := `{"type":"result","result":"` + strings.Repeat("x", 200) + `"}`
+
+	t.Run("exceeds_limit", func(t *testing.T) {
+		parser := NewParser(strings.NewReader(line), WithMaxLineBytes(64))
+		_, err := parser.Next()
+
+		var tooLong *LineTooLongError
+		if !errors.As(err, &tooLong) {
+			t.Fatalf("expected *LineTooLongError, got %T: %v", err, err)
+		}
+		if tooLong.Limit != 64 {
+			t.Errorf("Limit = %d, want 64", tooLong.Limit)
+		}
+	})
+
+	t.Run("within_limit", func(t *testing.T) {
+		parser := NewParser(strings.NewReader(line), WithMaxLineBytes(1024))
+		msg, err := parser.Next()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := msg.(*ResultMessage); !ok {
+			t.Errorf("expected *ResultMessage, got %T", msg)
+		}
+	})
+
+	t.Run("zero_keeps_default", func(t *testing.T) {
+		parser := NewParser(strings.NewReader(line), WithMaxLineBytes(0))
+		if parser.maxLineBytes != DefaultMaxLineBytes {
+			t.Errorf("maxLineBytes = %d, want %d", parser.maxLineBytes, DefaultMaxLineBytes)
+		}
+	})
+}
+
+// TestParser_AssistantError verifies that the error field of an assistant
+// message is parsed along with the text block describing it.
+func TestParser_AssistantError(t *testing.T) {
+	input := `{"type":"assistant","error":"rate_limit","session_id":"s1","message":{"content":[{"type":"text","text":"Usage limit reached"}]}}`
+	msg, err := NewParser(strings.NewReader(input)).Next()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	am, ok := msg.(*AssistantMessage)
+	if !ok {
+		t.Fatalf("expected *AssistantMessage, got %T", msg)
+	}
+	if am.Error != "rate_limit" {
+		t.Errorf("Error = %q, want rate_limit", am.Error)
+	}
+	if len(am.Message.Content) != 1 || am.Message.Content[0].Text != "Usage limit reached" {
+		t.Errorf("Content = %+v, want the limit text block", am.Message.Content)
+	}
+}
+
+func TestParser_ToolErrorsAndPermissionDenials(t *testing.T) {
+	input := `{"type":"assistant","message":{"content":[` +
+		`{"type":"tool_use","id":"toolu_1","name":"Bash","input":{"command":"ls"}},` +
+		`{"type":"tool_result","tool_use_id":"toolu_1","content":"command not found","is_error":true},` +
+		`{"type":"tool_result","tool_use_id":"toolu_2","content":"ok"},` +
+		`{"type":"text","text":"Done"}]}}` + "\n" +
+		`{"type":"result","subtype":"success","result":"Done","permission_denials":[{"tool_name":"Write","tool_use_id":"toolu_3","tool_input":{"file_path":"/etc/hosts"}}]}`
+	p := NewParser(strings.NewReader(input))
+
+	msg, err := p.Next()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	errs := msg.(*AssistantMessage).ToolErrors()
+	if len(errs) != 1 || errs[0].ToolUseID != "toolu_1" || errs[0].Content != "command not found" {
+		t.Errorf("ToolErrors() = %+v, want the single failing tool_result", errs)
+	}
+
+	msg, err = p.Next()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	denials := msg.(*ResultMessage).PermissionDenials
+	if len(denials) != 1 || denials[0].ToolName != "Write" || denials[0].ToolInput["file_path"] != "/etc/hosts" {
+		t.Errorf("PermissionDenials = %+v", denials)
+	}
+}
+
+func TestAssistantMessage_ToolUses(t *testing.T) {
+	input := `{"type":"assistant","message":{"content":[` +
+		`{"type":"thinking","thinking":"I should look first"},` +
+		`{"type":"text","text":"Let me check."},` +
+		`{"type":"tool_use","id":"toolu_1","name":"Bash","input":{"command":"ls","timeout":30}},` +
+		`{"type":"tool_result","tool_use_id":"toolu_1","content":"a.go"},` +
+		`{"type":"tool_use","id":"toolu_2","name":"Read","input":{"file_path":"a.go"}}]}}`
+	msg, err := NewParser(strings.NewReader(input)).Next()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []ToolUse{
+		{ID: "toolu_1", Name: "Bash", Input: map[string]any{"command": "ls", "timeout": float64(30)}},
+		{ID: "toolu_2", Name: "Read", Input: map[string]any{"file_path": "a.go"}},
+	}
+	if got := msg.(*AssistantMessage).ToolUses(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ToolUses() = %+v, want %+v", got, want)
+	}
+	if got := (&AssistantMessage{}).ToolUses(); got != nil {
+		t.Errorf("ToolUses() of an empty message = %+v, want nil", got)
+	}
+}
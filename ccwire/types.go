@@ -38,6 +38,9 @@
 //	    }
 //	}
 //
+// [Encode] does the reverse and writes a message as an NDJSON line, e.g. to
+// record CLI output as a test fixture that a Parser can replay.
+//
 // This is the lowest-level package in the cc-sdk dependency chain. It has no
 // dependencies outside the Go standard library.
 package ccwire
@@ -111,14 +114,59 @@ type AssistantMessage struct {
 	// the context of a tool-use turn and references the parent tool_use block ID.
 	ParentToolUseID *string `json:"parent_tool_use_id"`
 
-	// Error indicates an error type if the assistant message represents an error
-	// condition rather than a successful response. Common values include "rate_limit".
+	// Error names the failure when the CLI reports an API error as an
+	// assistant message instead of a model response. It is empty for normal
+	// responses. Values seen include "rate_limit", "overloaded_error",
+	// "api_error", "server_error", and "authentication_failed". The
+	// human-readable description is carried as a text block in
+	// Message.Content:
+	//
+	//	{"type":"assistant","error":"rate_limit","session_id":"...",
+	//	 "message":{"content":[{"type":"text","text":"Usage limit reached"}]}}
 	Error string `json:"error,omitempty"`
 }
 
 // MsgType returns [TypeAssistant].
 func (m *AssistantMessage) MsgType() MessageType { return TypeAssistant }
 
+// ToolErrors returns the "tool_result" content blocks whose IsError is set,
+// in order. A failing tool otherwise leaves no trace in the message text.
+func (m *AssistantMessage) ToolErrors() []ContentBlock {
+	var errs []ContentBlock
+	for _, block := range m.Message.Content {
+		if block.Type == "tool_result" && block.IsError {
+			errs = append(errs, block)
+		}
+	}
+	return errs
+}
+
+// ToolUses returns the tool invocations of the message's "tool_use" content
+// blocks, in order.
+func (m *AssistantMessage) ToolUses() []ToolUse {
+	var uses []ToolUse
+	for _, block := range m.Message.Content {
+		if block.Type == "tool_use" {
+			uses = append(uses, ToolUse{ID: block.ID, Name: block.Name, Input: block.Input})
+		}
+	}
+	return uses
+}
+
+// ToolUse is a tool invocation taken from a "tool_use" [ContentBlock]; see
+// [AssistantMessage.ToolUses].
+type ToolUse struct {
+	// ID is the unique tool-use identifier, referenced by the ToolUseID of
+	// the matching "tool_result" block.
+	ID string `json:"id"`
+
+	// Name is the name of the tool being invoked.
+	Name string `json:"name"`
+
+	// Input contains the tool invocation arguments as key-value pairs.
+	Input map[string]any `json:"input,omitempty"`
+}
+
 // AssistantInner is the nested message object within an [AssistantMessage].
 // It mirrors the Anthropic API message structure with content blocks, stop
 // reason, and token usage.
@@ -183,6 +231,23 @@ type ResultMessage struct {
 
 	// ModelUsage contains per-model usage breakdown as raw key-value pairs.
 	ModelUsage map[string]any `json:"modelUsage"`
+
+	// PermissionDenials lists the tool uses the CLI refused to run during
+	// the session. Empty when every tool use was allowed.
+	PermissionDenials []PermissionDenial `json:"permission_denials"`
+}
+
+// PermissionDenial describes a tool use the CLI refused to run because the
+// session's permission settings did not allow it.
+type PermissionDenial struct {
+	// ToolName is the name of the denied tool.
+	ToolName string `json:"tool_name"`
+
+	// ToolUseID references the denied "tool_use" block.
+	ToolUseID string `json:"tool_use_id"`
+
+	// ToolInput contains the arguments the tool was called with.
+	ToolInput map[string]any `json:"tool_input"`
 }
 
 // MsgType returns [TypeResult].
@@ -17,7 +17,8 @@ Flags:
 		Can be overridden per-request via the model field in the request body.
 	-api-key string
 		Bearer token for authenticating incoming requests. When set, every
-		request must include an "Authorization: Bearer <token>" header.
+		request must include an "Authorization: Bearer <token>" header;
+		/v1/messages also accepts an "x-api-key: <token>" header.
 		If empty, authentication is disabled. Also read from the
 		CC_PROXY_API_KEY environment variable when the flag is not provided.
 	-claude-path string
@@ -25,11 +26,115 @@ Flags:
 	-max-concurrent int
 		Maximum number of concurrent claude subprocesses. Zero means
 		unlimited. (default 0)
+	-max-queue-wait duration
+		How long a request waits for a free subprocess slot when
+		-max-concurrent is reached before failing with 503 and a
+		Retry-After header. Zero waits indefinitely. (default 0)
 	-timeout duration
 		Per-request timeout applied to each claude subprocess. (default 5m)
+	-max-request-timeout duration
+		Let clients override -timeout for a single request with an
+		X-CC-Timeout header, e.g. "X-CC-Timeout: 20m", capped at this
+		value. Zero ignores the header. (default 0)
 	-work-dir string
 		Working directory for spawned claude processes. If empty, the
 		proxy's own working directory is used.
+	-max-message-bytes int
+		Maximum size in bytes of a single NDJSON message read from a
+		claude subprocess. Zero uses the default of 10 MB. (default 0)
+	-max-request-bytes int
+		Maximum size in bytes of a request body. Larger requests are
+		rejected with 413. Zero uses the default of 10 MB; raise it for
+		long histories with inline images. (default 0)
+	-allowed-origins string
+		Comma-separated list of browser origins allowed via CORS, or "*"
+		for any origin. If empty, CORS is disabled.
+	-system-fingerprint string
+		Value reported as system_fingerprint on every response. If empty,
+		it is derived from the resolved model and the claude CLI version.
+	-allowed-models string
+		Comma-separated list of model names requests may use, e.g.
+		"sonnet,opus,haiku,claude-opus-4-1". Other models are rejected
+		with 400 before a process is spawned. Requests using a
+		-model-aliases name are checked against the model it maps to.
+		If empty, any model is passed to the CLI.
+	-model-aliases string
+		Comma-separated name=model pairs rewriting requested model names
+		before they reach the claude CLI, e.g.
+		"gpt-4o=opus,gpt-3.5-turbo=haiku". Models without an alias are
+		passed through unchanged.
+	-advertise-model-aliases
+		Also list the -model-aliases names in GET /v1/models.
+		(default false)
+	-messages-api
+		Also serve the Anthropic Messages API on POST /v1/messages, for
+		clients built on Anthropic's SDKs. (default false)
+	-batch-api
+		Also serve POST /v1/batch, which takes a JSON array of chat
+		completion requests, runs them concurrently within
+		-max-concurrent, and returns their results in order. Streaming
+		is not supported in batches. (default false)
+	-metrics
+		Serve Prometheus metrics (request counts, latencies, token usage,
+		and process counters) on GET /metrics. (default false)
+	-flush-interval duration
+		Coalesce streamed text into at most one chunk per interval, e.g.
+		50ms, instead of one SSE event per token. Tool call and finish
+		chunks are never delayed. (default 0, disabled)
+	-sse-message-events
+		Precede every chat and legacy completion stream event with an
+		"event: message" line, for strict SSE parsers that expect an
+		event field. Conforming clients are unaffected. (default false)
+	-omit-null-finish-reason
+		Leave finish_reason out of chat stream chunks until the final
+		one instead of sending "finish_reason":null, for clients that
+		reject the null. (default false)
+	-sampling-flags
+		Pass temperature and top_p to the CLI as --temperature and
+		--top-p. Only for claude builds or wrappers that accept these
+		flags; the stock CLI has none. (default false)
+	-strict-sampling
+		Reject requests that set temperature or top_p with 400 instead of
+		ignoring them, unless -sampling-flags is set. (default false)
+	-price-table string
+		Path to a JSON file of per-model prices in US dollars per million
+		tokens, e.g. {"claude-sonnet-4-5": {"input": 3, "output": 15,
+		"cache_write": 3.75, "cache_read": 0.3}}. A key also covers the
+		models starting with it. When set, non-streaming chat completions
+		report their cost as x_cc_cost_usd, computed from the token usage
+		when the CLI reports no cost of its own. If empty, no cost is
+		reported.
+	-compress
+		Compress JSON responses with gzip or deflate for clients that
+		accept it. Streams are never compressed. (default false)
+	-delimit-content
+		Enclose the text of all but assistant messages in tags with a random
+		name and tell the model it is content only, so that it cannot
+		pose as assistant turns or tool calls. Recommended for public
+		deployments. (default false)
+	-system-prefix string
+		Text put before the system messages of every request, e.g.
+		branding or guardrails that clients cannot change. Added even
+		to requests without a system message.
+	-system-suffix string
+		Text put after the system messages of every request, before the
+		tool instructions unless -system-suffix-last is set.
+	-system-suffix-last
+		Put -system-suffix after the tool and response format
+		instructions instead, at the very end of the system prompt.
+		(default false)
+	-empty-response-text string
+		Content sent for a chat reply with neither text nor tool calls,
+		e.g. when the model refused, for clients that retry empty
+		replies. If empty, such replies are sent empty.
+	-replay string
+		Path to a file of recorded claude NDJSON output, e.g. captured
+		with cchat's RawOutput. Every request is answered by replaying
+		it instead of running claude, for demos and tests without the
+		CLI. If empty, claude is run as usual.
+	-replay-delay duration
+		Pause between the lines of a -replay recording, to simulate a
+		streamed reply. (default 0)
 
 Environment variables:
 
@@ -39,7 +144,12 @@ Environment variables:
 Endpoints:
 
 	POST /v1/chat/completions   OpenAI-compatible chat completion (streaming and non-streaming)
+	POST /v1/completions        Legacy text completion with a flat prompt
+	POST /v1/messages           Anthropic Messages API (only with -messages-api)
+	POST /v1/batch              Concurrent non-streaming chat completions (only with -batch-api)
 	GET  /v1/models             Lists available models
+	GET  /stats                 Concurrency and query counters as JSON
+	GET  /metrics               Prometheus metrics (only with -metrics)
 
 The server performs a graceful shutdown on SIGINT or SIGTERM, allowing
 in-flight requests to complete before exiting.
@@ -48,15 +158,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/codewandler/cc-sdk-go/cchat"
+	"github.com/codewandler/cc-sdk-go/oai"
 	"github.com/codewandler/cc-sdk-go/server"
 )
 
@@ -67,8 +180,34 @@ func main() {
 		apiKey        = flag.String("api-key", "", "API key for Bearer auth (empty = no auth)")
 		claudePath    = flag.String("claude-path", "claude", "Path to claude binary")
 		maxConcurrent = flag.Int("max-concurrent", 0, "Max concurrent claude processes (0 = unlimited)")
+		maxQueueWait  = flag.Duration("max-queue-wait", 0, "Max wait for a free process slot (0 = wait indefinitely)")
 		timeout       = flag.Duration("timeout", 5*time.Minute, "Per-request timeout")
+		maxReqTimeout = flag.Duration("max-request-timeout", 0, "Max per-request timeout set with X-CC-Timeout (0 = header ignored)")
 		workDir       = flag.String("work-dir", "", "Working directory for claude processes")
+		maxMsgBytes   = flag.Int("max-message-bytes", 0, "Max NDJSON message size in bytes (0 = 10MB default)")
+		maxReqBytes   = flag.Int64("max-request-bytes", 0, "Max request body size in bytes (0 = 10MB default)")
+		origins       = flag.String("allowed-origins", "", "Comma-separated CORS origins (empty = CORS disabled)")
+		fingerprint   = flag.String("system-fingerprint", "", "Pinned system_fingerprint (empty = derived from model and CLI version)")
+		allowedModels = flag.String("allowed-models", "", "Comma-separated model allowlist (empty = any model)")
+		aliases       = flag.String("model-aliases", "", "Comma-separated name=model aliases (e.g. gpt-4o=opus)")
+		advertise     = flag.Bool("advertise-model-aliases", false, "List model aliases in /v1/models")
+		messagesAPI   = flag.Bool("messages-api", false, "Serve the Anthropic Messages API on /v1/messages")
+		batchAPI      = flag.Bool("batch-api", false, "Serve concurrent chat completion batches on /v1/batch")
+		metrics       = flag.Bool("metrics", false, "Serve Prometheus metrics on /metrics")
+		flushInterval = flag.Duration("flush-interval", 0, "Coalesce streamed text deltas per interval (0 = send each delta)")
+		sseEvents     = flag.Bool("sse-message-events", false, "Add an \"event: message\" line to chat stream events")
+		priceTable    = flag.String("price-table", "", "JSON file of per-model prices per million tokens (empty = no cost reporting)")
+		compress      = flag.Bool("compress", false, "Compress JSON responses for clients that accept gzip or deflate")
+		delimit       = flag.Bool("delimit-content", false, "Delimit non-assistant message text against prompt injection")
+		sysPrefix     = flag.String("system-prefix", "", "Text put before the system messages of every request")
+		sysSuffix     = flag.String("system-suffix", "", "Text put after the system messages of every request")
+		suffixLast    = flag.Bool("system-suffix-last", false, "Put -system-suffix after the tool and response format instructions")
+		emptyText     = flag.String("empty-response-text", "", "Content for chat replies with no text or tool calls (empty = send them empty)")
+		omitNull      = flag.Bool("omit-null-finish-reason", false, "Omit finish_reason from chat stream chunks until the final one")
+		samplingFlags = flag.Bool("sampling-flags", false, "Pass temperature and top_p to the CLI as flags")
+		strictSample  = flag.Bool("strict-sampling", false, "Reject temperature and top_p instead of ignoring them")
+		replay        = flag.String("replay", "", "Answer requests from recorded claude NDJSON output (empty = run claude)")
+		replayDelay   = flag.Duration("replay-delay", 0, "Pause between the lines of a -replay recording")
 	)
 	flag.Parse()
 
@@ -77,18 +216,79 @@ func main() {
 		*apiKey = os.Getenv("CC_PROXY_API_KEY")
 	}
 
-	client := cchat.NewClient(&cchat.ClientConfig{
-		CLIPath:        *claudePath,
-		Model:          *model,
-		MaxConcurrent:  *maxConcurrent,
-		DefaultTimeout: *timeout,
-		WorkDir:        *workDir,
-	})
+	clientCfg := &cchat.ClientConfig{
+		CLIPath:         *claudePath,
+		Model:           *model,
+		AllowedModels:   splitList(*allowedModels),
+		MaxConcurrent:   *maxConcurrent,
+		MaxQueueWait:    *maxQueueWait,
+		DefaultTimeout:  *timeout,
+		WorkDir:         *workDir,
+		MaxMessageBytes: *maxMsgBytes,
+		SamplingFlags:   *samplingFlags,
+	}
+	var client *cchat.Client
+	if *replay != "" {
+		var err error
+		client, err = cchat.NewReplayClient(clientCfg, *replay, *replayDelay)
+		if err != nil {
+			log.Fatalf("reading -replay: %v", err)
+		}
+	} else {
+		client = cchat.NewClient(clientCfg)
+	}
+
+	allowedOrigins := splitList(*origins)
+
+	modelAliases := make(map[string]string)
+	for _, pair := range splitList(*aliases) {
+		name, target, ok := strings.Cut(pair, "=")
+		name, target = strings.TrimSpace(name), strings.TrimSpace(target)
+		if !ok || name == "" || target == "" {
+			log.Fatalf("invalid -model-aliases entry %q: want name=model", pair)
+		}
+		modelAliases[name] = target
+	}
+
+	var prices oai.PriceTable
+	if *priceTable != "" {
+		data, err := os.ReadFile(*priceTable)
+		if err != nil {
+			log.Fatalf("reading -price-table: %v", err)
+		}
+		if err := json.Unmarshal(data, &prices); err != nil {
+			log.Fatalf("parsing -price-table %s: %v", *priceTable, err)
+		}
+	}
+
+	promptFormat := oai.PromptFormat{
+		DelimitContent:          *delimit,
+		SystemPrefix:            *sysPrefix,
+		SystemSuffix:            *sysSuffix,
+		SuffixAfterInstructions: *suffixLast,
+	}
 
 	srv := server.New(server.Config{
-		Addr:   *addr,
-		APIKey: *apiKey,
-		Client: client,
+		Addr:                  *addr,
+		APIKey:                *apiKey,
+		AllowedOrigins:        allowedOrigins,
+		SystemFingerprint:     *fingerprint,
+		ModelAliases:          modelAliases,
+		AdvertiseModelAliases: *advertise,
+		MessagesAPI:           *messagesAPI,
+		BatchAPI:              *batchAPI,
+		MetricsEnabled:        *metrics,
+		FlushInterval:         *flushInterval,
+		EnableCompression:     *compress,
+		SSEMessageEvents:      *sseEvents,
+		OmitNullFinishReason:  *omitNull,
+		MaxRequestTimeout:     *maxReqTimeout,
+		MaxRequestBytes:       *maxReqBytes,
+		StrictSampling:        *strictSample,
+		PriceTable:            prices,
+		EmptyResponseText:     *emptyText,
+		PromptFormat:          promptFormat,
+		Client:                client,
 	})
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
@@ -106,8 +306,32 @@ func main() {
 	if *maxConcurrent > 0 {
 		fmt.Fprintf(os.Stderr, "max concurrent: %d\n", *maxConcurrent)
 	}
+	if *replay != "" {
+		fmt.Fprintf(os.Stderr, "replaying: %s\n", *replay)
+	}
+	if len(modelAliases) > 0 {
+		fmt.Fprintf(os.Stderr, "model aliases: %s\n", *aliases)
+	}
+	if *allowedModels != "" {
+		fmt.Fprintf(os.Stderr, "allowed models: %s\n", *allowedModels)
+	}
+	if len(allowedOrigins) > 0 {
+		fmt.Fprintf(os.Stderr, "cors origins: %s\n", strings.Join(allowedOrigins, ", "))
+	}
 
 	if err := srv.ListenAndServe(ctx); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// splitList splits a comma-separated flag value, trimming spaces and dropping
+// empty entries. It returns nil for an empty value.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -4,6 +4,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -11,6 +12,7 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/codewandler/cc-sdk-go/oai"
 )
@@ -19,15 +21,58 @@ const defaultSystemPrompt = "You are a helpful assistant."
 
 func main() {
 	var (
-		model  = flag.String("model", "", "Model name (e.g. sonnet, opus, haiku)")
-		system = flag.String("system", defaultSystemPrompt, "System prompt")
+		model       = flag.String("model", "", "Model name (e.g. sonnet, opus, haiku)")
+		system      = flag.String("system", defaultSystemPrompt, "System prompt")
+		historyPath = flag.String("history", "", "JSONL file to resume the conversation from and append it to on exit")
+		toolsDir    = flag.String("tools-exec", "", "Directory of tool scripts, one executable per tool, run automatically for tool calls")
+		toolTimeout = flag.Duration("tools-timeout", 30*time.Second, "Time limit for each tool script")
 	)
 	flag.Parse()
 
 	client := oai.NewClientDefault()
 
-	var history []oai.ChatMessage
-	history = append(history, oai.ChatMessage{Role: "system", Content: *system})
+	var tools *toolExecutor
+	var toolDefs []oai.Tool
+	if *toolsDir != "" {
+		tools = &toolExecutor{dir: *toolsDir, timeout: *toolTimeout}
+		defs, err := tools.definitions()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: tools: %v\n", err)
+			os.Exit(1)
+		}
+		toolDefs = defs
+		for _, t := range defs {
+			fmt.Printf("(tool %s)\n", t.Function.Name)
+		}
+	}
+
+	history, err := loadHistory(*historyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	saved := len(history)
+	if len(history) == 0 || history[0].Role != "system" {
+		history = append([]oai.ChatMessage{{Role: "system", Content: *system}}, history...)
+		if saved > 0 {
+			saved++ // the file has no system message; don't add one to it
+		}
+	}
+	if saved > 0 {
+		fmt.Printf("(resumed %d messages from %s)\n", saved, *historyPath)
+	}
+	rewrite := false // set when a command changed messages already saved
+	defer func() {
+		var err error
+		if rewrite {
+			err = saveHistory(*historyPath, history, os.O_TRUNC)
+		} else {
+			err = saveHistory(*historyPath, history[saved:], os.O_APPEND)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: saving history: %v\n", err)
+		}
+	}()
 
 	// Read stdin lines in a background goroutine so we can select on signals.
 	lines := make(chan string)
@@ -63,6 +108,12 @@ func main() {
 		case "":
 			continue
 		}
+		if cmd, ok := strings.CutPrefix(strings.TrimSpace(line), "/"); ok {
+			if command(cmd, model, &history) {
+				rewrite = true
+			}
+			continue
+		}
 
 		history = append(history, oai.ChatMessage{Role: "user", Content: line})
 
@@ -76,7 +127,7 @@ func main() {
 			}
 		}()
 
-		err := turn(turnCtx, client, *model, lines, &history)
+		err := turn(turnCtx, client, *model, toolDefs, tools, lines, &history)
 		turnCancel()
 
 		if err != nil {
@@ -89,12 +140,15 @@ func main() {
 	}
 }
 
-// turn sends history to the model, streams the response, and loops on tool calls.
-func turn(ctx context.Context, client *oai.Client, model string, lines <-chan string, history *[]oai.ChatMessage) error {
+// turn sends history to the model, streams the response, and loops on tool
+// calls. Tool results come from tools when it has a matching script and are
+// read from lines otherwise.
+func turn(ctx context.Context, client *oai.Client, model string, toolDefs []oai.Tool, tools *toolExecutor, lines <-chan string, history *[]oai.ChatMessage) error {
 	for {
 		req := oai.ChatCompletionRequest{
 			Model:    model,
 			Messages: *history,
+			Tools:    toolDefs,
 		}
 
 		stream, err := client.CreateChatCompletionStream(ctx, req)
@@ -102,11 +156,7 @@ func turn(ctx context.Context, client *oai.Client, model string, lines <-chan st
 			return err
 		}
 
-		var (
-			text       strings.Builder
-			toolCalls  []oai.ToolCall
-			finishStop bool
-		)
+		var acc oai.ChunkAccumulator
 
 		fmt.Print("assistant> ")
 		for {
@@ -118,33 +168,35 @@ func turn(ctx context.Context, client *oai.Client, model string, lines <-chan st
 				stream.Close()
 				return err
 			}
-			if len(chunk.Choices) == 0 {
-				continue
-			}
-			c := chunk.Choices[0]
-
-			if c.Delta.Content != nil {
-				fmt.Print(*c.Delta.Content)
-				text.WriteString(*c.Delta.Content)
-			}
-			if len(c.Delta.ToolCalls) > 0 {
-				toolCalls = mergeToolCallDeltas(toolCalls, c.Delta.ToolCalls)
-			}
-			if c.FinishReason != nil {
-				finishStop = *c.FinishReason == "stop"
+			acc.Add(chunk)
+			if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != nil {
+				fmt.Print(*chunk.Choices[0].Delta.Content)
 			}
 		}
 		stream.Close()
 		fmt.Println()
 
+		choice := acc.Response().Choices[0]
+		text, toolCalls := choice.Message.StringContent(), choice.Message.ToolCalls
+		finishStop := choice.FinishReason == "stop"
+
 		if len(toolCalls) > 0 {
 			*history = append(*history, oai.ChatMessage{
 				Role:      "assistant",
-				Content:   text.String(),
+				Content:   text,
 				ToolCalls: toolCalls,
 			})
 			for _, tc := range toolCalls {
 				fmt.Printf("\n[tool_call] %s(%s)\n", tc.Function.Name, tc.Function.Arguments)
+				if result, ok := tools.run(ctx, tc); ok {
+					fmt.Printf("[tool_result] %s\n", result)
+					*history = append(*history, oai.ChatMessage{
+						Role:       "tool",
+						ToolCallID: tc.ID,
+						Content:    result,
+					})
+					continue
+				}
 				fmt.Printf("result for %s> ", tc.ID)
 				select {
 				case line, ok := <-lines:
@@ -164,27 +216,90 @@ func turn(ctx context.Context, client *oai.Client, model string, lines <-chan st
 		}
 
 		// Normal stop — record assistant message and return to user prompt.
-		if finishStop || text.Len() > 0 {
+		if finishStop || text != "" {
 			*history = append(*history, oai.ChatMessage{
 				Role:    "assistant",
-				Content: text.String(),
+				Content: text,
 			})
 		}
 		return nil
 	}
 }
 
-// mergeToolCallDeltas accumulates streamed tool call deltas into complete tool calls.
-// Each delta may carry a new tool call (with Index and ID set) or append to an
-// existing one (same Index, only Function.Arguments populated).
-func mergeToolCallDeltas(existing []oai.ToolCall, deltas []oai.ToolCall) []oai.ToolCall {
-	for _, d := range deltas {
-		if d.ID != "" {
-			existing = append(existing, d)
-		} else if len(existing) > 0 {
-			last := &existing[len(existing)-1]
-			last.Function.Arguments += d.Function.Arguments
+// loadHistory reads the conversation saved at path. A missing file, or an
+// empty path, yields an empty history.
+func loadHistory(path string) ([]oai.ChatMessage, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	msgs, err := oai.UnmarshalHistory(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return msgs, nil
+}
+
+// saveHistory writes msgs to the conversation file at path, creating it if
+// needed; flag is os.O_APPEND to add to it or os.O_TRUNC to replace it. It
+// does nothing for an empty path.
+func saveHistory(path string, msgs []oai.ChatMessage, flag int) error {
+	if path == "" || (len(msgs) == 0 && flag == os.O_APPEND) {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|flag, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := oai.MarshalHistory(f, msgs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// command runs the slash command input (without the leading slash) against
+// the session state. history[0] is always the system message. It reports
+// whether messages already in history were changed or removed.
+func command(input string, model *string, history *[]oai.ChatMessage) bool {
+	name, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case "model":
+		if arg != "" {
+			*model = arg
 		}
+		if *model == "" {
+			fmt.Println("model: (default)")
+		} else {
+			fmt.Printf("model: %s\n", *model)
+		}
+		return false
+
+	case "system":
+		if arg == "" {
+			fmt.Printf("system: %s\n", (*history)[0].StringContent())
+			return false
+		}
+		(*history)[0].Content = arg
+		fmt.Println("(system prompt replaced)")
+		return true
+
+	case "clear":
+		*history = (*history)[:1]
+		fmt.Println("(history cleared)")
+		return true
+
+	default:
+		fmt.Println("commands: /model [name], /system [text], /clear, exit")
+		return false
 	}
-	return existing
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/codewandler/cc-sdk-go/oai"
+)
+
+// toolExecutor answers tool calls by running scripts from a directory. The
+// script for a tool is the executable file named after it; it receives the
+// call's JSON arguments on stdin and its stdout is the tool result. An
+// optional <name>.json next to it holds the tool's description and
+// parameters schema, as in a function definition.
+type toolExecutor struct {
+	dir     string
+	timeout time.Duration
+}
+
+// definitions returns a tool definition for every executable in the
+// directory, sorted by name.
+func (e *toolExecutor) definitions() ([]oai.Tool, error) {
+	entries, err := os.ReadDir(e.dir)
+	if err != nil {
+		return nil, err
+	}
+	var tools []oai.Tool
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, ".json") || e.script(name) == "" {
+			continue
+		}
+		def := oai.FunctionDefinition{Name: name, Parameters: map[string]any{"type": "object"}}
+		if data, err := os.ReadFile(filepath.Join(e.dir, name+".json")); err == nil {
+			if err := json.Unmarshal(data, &def); err != nil {
+				return nil, fmt.Errorf("%s.json: %w", name, err)
+			}
+			def.Name = name
+		}
+		tools = append(tools, oai.Tool{Type: "function", Function: def})
+	}
+	return tools, nil
+}
+
+// script returns the path of the executable for tool name, or "" if there
+// is none. Names that could escape the directory never match.
+func (e *toolExecutor) script(name string) string {
+	if e == nil || name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return ""
+	}
+	path := filepath.Join(e.dir, name)
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+		return ""
+	}
+	return path
+}
+
+// run executes the script for tc and returns the tool result. It reports
+// false if no script matches, so the caller can fall back to asking the
+// user. A failing or timed-out script yields a result describing the error,
+// including its stderr, so the model can react to it.
+func (e *toolExecutor) run(ctx context.Context, tc oai.ToolCall) (string, bool) {
+	path := e.script(tc.Function.Name)
+	if path == "" {
+		return "", false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = strings.NewReader(tc.Function.Arguments)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", e.timeout)
+	}
+	if err != nil {
+		msg := "error: " + err.Error()
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			msg += "\n" + s
+		}
+		return msg, true
+	}
+	return strings.TrimRight(stdout.String(), "\n"), true
+}
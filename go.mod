@@ -2,4 +2,4 @@ module github.com/codewandler/cc-sdk-go
 
 go 1.25.6
 
-require github.com/matoous/go-nanoid/v2 v2.1.0 // indirect
+require github.com/matoous/go-nanoid/v2 v2.1.0
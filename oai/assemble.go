@@ -0,0 +1,156 @@
+package oai
+
+import (
+	"io"
+	"strings"
+)
+
+// ChunkAccumulator assembles streamed [ChatCompletionChunk] values into a
+// complete [ChatCompletionResponse], the shape a non-streaming request would
+// have returned. Feed it every chunk with [ChunkAccumulator.Add], then call
+// [ChunkAccumulator.Response]. It is useful for callers that print deltas as
+// they arrive but still need the final message, e.g. to append it to the
+// conversation history. The zero value is ready to use.
+type ChunkAccumulator struct {
+	resp      ChatCompletionResponse
+	role      string
+	content   strings.Builder
+	reasoning strings.Builder
+	toolCalls []ToolCall
+	finish    string
+}
+
+// Add merges chunk into the accumulated response. Only the first choice is
+// considered, as the bridge always produces exactly one.
+func (a *ChunkAccumulator) Add(chunk *ChatCompletionChunk) {
+	if chunk.ID != "" {
+		a.resp.ID = chunk.ID
+	}
+	if chunk.Created != 0 {
+		a.resp.Created = chunk.Created
+	}
+	if chunk.Model != "" {
+		a.resp.Model = chunk.Model
+	}
+	if chunk.SystemFingerprint != "" {
+		a.resp.SystemFingerprint = chunk.SystemFingerprint
+	}
+	if chunk.Usage != nil {
+		a.resp.Usage = chunk.Usage
+	}
+	if len(chunk.Choices) == 0 {
+		return
+	}
+
+	c := chunk.Choices[0]
+	if c.Delta.Role != "" {
+		a.role = c.Delta.Role
+	}
+	if c.Delta.Content != nil {
+		a.content.WriteString(*c.Delta.Content)
+	}
+	if c.Delta.ReasoningContent != nil {
+		a.reasoning.WriteString(*c.Delta.ReasoningContent)
+	}
+	if len(c.Delta.ToolCalls) > 0 {
+		a.toolCalls = MergeToolCallDeltas(a.toolCalls, c.Delta.ToolCalls)
+	}
+	if c.FinishReason != nil {
+		a.finish = *c.FinishReason
+	}
+}
+
+// Response returns the response assembled from the chunks added so far. The
+// role defaults to "assistant" if no chunk carried one.
+func (a *ChunkAccumulator) Response() *ChatCompletionResponse {
+	resp := a.resp
+	resp.Object = "chat.completion"
+
+	msg := ChatMessage{
+		Role:             a.role,
+		ReasoningContent: a.reasoning.String(),
+	}
+	for _, tc := range a.toolCalls {
+		tc.Index = nil // stream-only; complete messages carry none
+		msg.ToolCalls = append(msg.ToolCalls, tc)
+	}
+	if msg.Role == "" {
+		msg.Role = "assistant"
+	}
+	if text := a.content.String(); text != "" {
+		msg.Content = text
+	}
+	resp.Choices = []Choice{{Index: 0, Message: msg, FinishReason: a.finish}}
+	return &resp
+}
+
+// MergeToolCallDeltas accumulates streamed tool call deltas into complete
+// tool calls and returns the extended slice. A delta with an Index belongs to
+// the call with the same Index: the first such delta starts the call, later
+// ones append their Function.Arguments and fill in any ID, Type or name not
+// yet seen. This lets the deltas of several in-flight calls be interleaved.
+//
+// Deltas without an Index are merged the way older producers emit them: a
+// delta with an ID starts a new call, and one without appends its arguments
+// to the most recent call.
+func MergeToolCallDeltas(calls []ToolCall, deltas []ToolCall) []ToolCall {
+	for _, d := range deltas {
+		if d.Index != nil {
+			if tc := toolCallAt(calls, *d.Index); tc != nil {
+				if tc.ID == "" {
+					tc.ID = d.ID
+				}
+				if tc.Type == "" {
+					tc.Type = d.Type
+				}
+				if tc.Function.Name == "" {
+					tc.Function.Name = d.Function.Name
+				}
+				tc.Function.Arguments += d.Function.Arguments
+				continue
+			}
+			index := *d.Index
+			d.Index = &index // don't alias the caller's chunk
+			calls = append(calls, d)
+		} else if d.ID != "" {
+			calls = append(calls, d)
+		} else if len(calls) > 0 {
+			last := &calls[len(calls)-1]
+			last.Function.Arguments += d.Function.Arguments
+		}
+	}
+	return calls
+}
+
+// toolCallAt returns the call in calls with the given stream index, or nil.
+func toolCallAt(calls []ToolCall, index int) *ToolCall {
+	for i := range calls {
+		if calls[i].Index != nil && *calls[i].Index == index {
+			return &calls[i]
+		}
+	}
+	return nil
+}
+
+// AssembleStream reads stream to the end and returns the assembled response,
+// including the session's [SystemInfo] and duration. It does not close the
+// stream. If [ChatCompletionStream.Recv] fails, the error is returned
+// unchanged and the partial response is discarded.
+func AssembleStream(stream *ChatCompletionStream) (*ChatCompletionResponse, error) {
+	var acc ChunkAccumulator
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		acc.Add(chunk)
+	}
+
+	resp := acc.Response()
+	resp.SystemInfo = stream.SystemInfo()
+	resp.DurationMS = stream.DurationMS()
+	return resp, nil
+}
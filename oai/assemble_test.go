@@ -0,0 +1,91 @@
+package oai
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChunkAccumulator(t *testing.T) {
+	str := func(s string) *string { return &s }
+	chunk := func(delta ChunkDelta, finish *string) *ChatCompletionChunk {
+		return &ChatCompletionChunk{ID: "chatcmpl-1", Created: 42, Model: "claude-haiku", Choices: []ChunkChoice{{Delta: delta, FinishReason: finish}}}
+	}
+
+	var acc ChunkAccumulator
+	for _, c := range []*ChatCompletionChunk{
+		chunk(ChunkDelta{Role: "assistant"}, nil),
+		chunk(ChunkDelta{ReasoningContent: str("think")}, nil),
+		chunk(ChunkDelta{Content: str("Let me ")}, nil),
+		chunk(ChunkDelta{Content: str("check.")}, nil),
+		chunk(ChunkDelta{ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: FunctionCall{Name: "a", Arguments: `{"x":`}}}}, nil),
+		chunk(ChunkDelta{ToolCalls: []ToolCall{{Function: FunctionCall{Arguments: `1}`}}}}, nil),
+		chunk(ChunkDelta{ToolCalls: []ToolCall{{ID: "call_2", Type: "function", Function: FunctionCall{Name: "b", Arguments: `{}`}}}}, str("tool_calls")),
+	} {
+		acc.Add(c)
+	}
+
+	resp := acc.Response()
+	if resp.ID != "chatcmpl-1" || resp.Object != "chat.completion" || resp.Created != 42 || resp.Model != "claude-haiku" {
+		t.Errorf("resp = %+v", resp)
+	}
+	if len(resp.Choices) != 1 {
+		t.Fatalf("choices = %d, want 1", len(resp.Choices))
+	}
+	choice := resp.Choices[0]
+	if choice.FinishReason != "tool_calls" {
+		t.Errorf("finish_reason = %q", choice.FinishReason)
+	}
+	msg := choice.Message
+	if msg.Role != "assistant" || msg.Content != "Let me check." || msg.ReasoningContent != "think" {
+		t.Errorf("message = %+v", msg)
+	}
+	want := []ToolCall{
+		{ID: "call_1", Type: "function", Function: FunctionCall{Name: "a", Arguments: `{"x":1}`}},
+		{ID: "call_2", Type: "function", Function: FunctionCall{Name: "b", Arguments: `{}`}},
+	}
+	if !reflect.DeepEqual(msg.ToolCalls, want) {
+		t.Errorf("tool calls = %+v\nwant %+v", msg.ToolCalls, want)
+	}
+}
+
+func TestChunkAccumulator_Empty(t *testing.T) {
+	var acc ChunkAccumulator
+	msg := acc.Response().Choices[0].Message
+	if msg.Role != "assistant" || msg.Content != nil || msg.ToolCalls != nil {
+		t.Errorf("message = %+v", msg)
+	}
+}
+
+func TestMergeToolCallDeltas_Interleaved(t *testing.T) {
+	idx := func(i int) *int { return &i }
+	deltas := [][]ToolCall{
+		{{Index: idx(0), ID: "call_a", Type: "function", Function: FunctionCall{Name: "a"}}},
+		{{Index: idx(1), ID: "call_b", Type: "function", Function: FunctionCall{Name: "b", Arguments: `{"y":`}}},
+		{{Index: idx(0), Function: FunctionCall{Arguments: `{"x":`}}},
+		{{Index: idx(1), Function: FunctionCall{Arguments: `2}`}}, {Index: idx(0), Function: FunctionCall{Arguments: `1}`}}},
+	}
+	var calls []ToolCall
+	for _, d := range deltas {
+		calls = MergeToolCallDeltas(calls, d)
+	}
+
+	if len(calls) != 2 {
+		t.Fatalf("calls = %+v, want 2", calls)
+	}
+	for i, want := range []ToolCall{
+		{ID: "call_a", Type: "function", Function: FunctionCall{Name: "a", Arguments: `{"x":1}`}},
+		{ID: "call_b", Type: "function", Function: FunctionCall{Name: "b", Arguments: `{"y":2}`}},
+	} {
+		got := calls[i]
+		if got.Index == nil || *got.Index != i {
+			t.Errorf("calls[%d].Index = %v, want %d", i, got.Index, i)
+		}
+		got.Index = nil
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("calls[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+	if *deltas[0][0].Index != 0 || deltas[0][0].Function.Arguments != "" {
+		t.Error("merging must not modify the deltas")
+	}
+}
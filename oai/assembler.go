@@ -0,0 +1,64 @@
+package oai
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// PromptAssembler turns the messages of a chat request into the prompt text
+// and system prompt passed to the claude CLI; see [RequestToQueryUsing]. The
+// Claude Code CLI takes a single prompt, so every assembler flattens the
+// conversation one way or another.
+//
+// [PromptFormat] is the default assembler, with role prefixes such as
+// "[user]: ". Other strategies, e.g. XML-tagged or ChatML-style turns, can be
+// plugged in without touching the rest of the bridge. An assembler is
+// responsible for the whole system prompt: if req has Tools or a JSON
+// ResponseFormat it should include [ToolCallInstructions] and
+// [ResponseFormat.Instructions], or tool calls and JSON output will not
+// work. Tool calls in the reply are always parsed from <tool_call> tags.
+type PromptAssembler interface {
+	Assemble(req *ChatCompletionRequest) (prompt, system string)
+}
+
+var (
+	assemblersMu sync.RWMutex
+	assemblers   = map[string]PromptAssembler{"default": PromptFormat{}}
+)
+
+// RegisterPromptAssembler makes assembler available under name, so that it
+// can be selected by [LookupPromptAssembler], e.g. from configuration.
+// "default" is registered as the zero [PromptFormat]. It panics if name is
+// empty or already registered, or if assembler is nil.
+func RegisterPromptAssembler(name string, assembler PromptAssembler) {
+	assemblersMu.Lock()
+	defer assemblersMu.Unlock()
+	if name == "" || assembler == nil {
+		panic("oai: RegisterPromptAssembler needs a name and an assembler")
+	}
+	if _, dup := assemblers[name]; dup {
+		panic(fmt.Sprintf("oai: prompt assembler %q registered twice", name))
+	}
+	assemblers[name] = assembler
+}
+
+// LookupPromptAssembler returns the assembler registered under name.
+func LookupPromptAssembler(name string) (PromptAssembler, bool) {
+	assemblersMu.RLock()
+	defer assemblersMu.RUnlock()
+	assembler, ok := assemblers[name]
+	return assembler, ok
+}
+
+// PromptAssemblers returns the names of all registered assemblers, sorted.
+func PromptAssemblers() []string {
+	assemblersMu.RLock()
+	defer assemblersMu.RUnlock()
+	names := make([]string, 0, len(assemblers))
+	for name := range assemblers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,92 @@
+package oai
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// chatMLAssembler renders turns ChatML-style and records its calls.
+type chatMLAssembler struct {
+	calls int
+}
+
+func (a *chatMLAssembler) Assemble(req *ChatCompletionRequest) (prompt, system string) {
+	a.calls++
+	for _, msg := range req.Messages {
+		prompt += "<|im_start|>" + msg.Role + "\n" + msg.StringContent() + "<|im_end|>\n"
+	}
+	return prompt, "chatml" + ToolCallInstructions(req.Tools)
+}
+
+func TestRequestToQueryUsing_CustomAssembler(t *testing.T) {
+	maxTokens := 50
+	req := &ChatCompletionRequest{
+		Model:     "haiku",
+		Messages:  []ChatMessage{{Role: "user", Content: "Hello"}},
+		MaxTokens: &maxTokens,
+	}
+	assembler := &chatMLAssembler{}
+
+	prompt, opts := RequestToQueryUsing(req, assembler)
+
+	if assembler.calls != 1 {
+		t.Errorf("Assemble called %d times, want 1", assembler.calls)
+	}
+	if want := "<|im_start|>user\nHello<|im_end|>\n"; prompt != want {
+		t.Errorf("prompt = %q, want %q", prompt, want)
+	}
+	if opts.SystemPrompt != "chatml" {
+		t.Errorf("SystemPrompt = %q, want %q", opts.SystemPrompt, "chatml")
+	}
+	if opts.Model != "haiku" || opts.MaxTokens == nil || *opts.MaxTokens != 50 {
+		t.Errorf("opts = %+v, want model and max tokens taken from the request", opts)
+	}
+}
+
+func TestRequestToQueryUsing_NilIsDefault(t *testing.T) {
+	req := &ChatCompletionRequest{
+		Messages: []ChatMessage{
+			{Role: "system", Content: "Be concise."},
+			{Role: "user", Content: "Hello"},
+		},
+		Tools: []Tool{{Type: "function", Function: FunctionDefinition{Name: "get_weather"}}},
+	}
+
+	wantPrompt, wantOpts := RequestToQuery(req)
+	prompt, opts := RequestToQueryUsing(req, nil)
+
+	if prompt != wantPrompt || !reflect.DeepEqual(opts, wantOpts) {
+		t.Errorf("RequestToQueryUsing(req, nil) = %q, %+v; want %q, %+v", prompt, opts, wantPrompt, wantOpts)
+	}
+}
+
+func TestPromptAssemblerRegistry(t *testing.T) {
+	if a, ok := LookupPromptAssembler("default"); !ok || a != (PromptFormat{}) {
+		t.Errorf(`LookupPromptAssembler("default") = %v, %v; want the zero PromptFormat`, a, ok)
+	}
+	if _, ok := LookupPromptAssembler("test-chatml"); ok {
+		t.Fatal("test-chatml registered before RegisterPromptAssembler")
+	}
+
+	assembler := &chatMLAssembler{}
+	RegisterPromptAssembler("test-chatml", assembler)
+	t.Cleanup(func() {
+		assemblersMu.Lock()
+		delete(assemblers, "test-chatml")
+		assemblersMu.Unlock()
+	})
+	if a, ok := LookupPromptAssembler("test-chatml"); !ok || a != assembler {
+		t.Errorf(`LookupPromptAssembler("test-chatml") = %v, %v; want the registered assembler`, a, ok)
+	}
+	if names := PromptAssemblers(); !slices.Equal(names, []string{"default", "test-chatml"}) {
+		t.Errorf("PromptAssemblers() = %q, want [default test-chatml]", names)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	RegisterPromptAssembler("test-chatml", assembler)
+}
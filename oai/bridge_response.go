@@ -1,6 +1,9 @@
 package oai
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -13,11 +16,14 @@ import (
 // last [ccwire.AssistantMessage] (which may be nil if only a result was received).
 //
 // When hasTools is true, the response text is scanned for <tool_call> XML tags
-// using [ParseToolCalls]. If tool calls are found, the response's FinishReason
-// is set to "tool_calls"; otherwise it is "stop".
+// using [ParseToolCalls]. The assistant's native tool_use blocks are added to
+// the tool calls as well, whether or not hasTools is set. If the model
+// refused, the response's FinishReason is [FinishReasonContentFilter]; if
+// there are tool calls, it is "tool_calls"; otherwise it is "stop".
 //
 // Token usage is derived from the result's Usage field, with all input token
 // categories (direct, cache-read, cache-creation) summed into PromptTokens.
+// The result's DurationMS is carried over as-is.
 func ResultToResponse(result *ccwire.ResultMessage, assistant *ccwire.AssistantMessage, hasTools bool) *ChatCompletionResponse {
 	resp := &ChatCompletionResponse{
 		ID:      fmt.Sprintf("chatcmpl-%s", result.SessionID),
@@ -34,37 +40,276 @@ func ResultToResponse(result *ccwire.ResultMessage, assistant *ccwire.AssistantM
 		text = result.Result
 	}
 
-	msg := ChatMessage{
-		Role: "assistant",
-	}
-	finishReason := "stop"
-
-	if hasTools {
-		cleanText, toolCalls := ParseToolCalls(text)
-		if len(toolCalls) > 0 {
-			msg.ToolCalls = toolCalls
-			finishReason = "tool_calls"
-		}
-		if cleanText != "" {
-			msg.Content = cleanText
-		}
-	} else {
-		msg.Content = text
-	}
+	msg := textToChatMessage(text, hasTools)
+	msg.ToolCalls = append(msg.ToolCalls, nativeToolCalls(assistant)...)
 
 	resp.Choices = []Choice{
 		{
 			Index:        0,
 			Message:      msg,
-			FinishReason: finishReason,
+			FinishReason: finishReason(len(msg.ToolCalls) > 0, false, refused(assistant)),
 		},
 	}
 
 	resp.Usage = usageFromResult(result)
+	resp.DurationMS = result.DurationMS
+
+	return resp
+}
+
+// AssistantToChatMessage converts a single assistant turn into a
+// [ChatMessage] with role "assistant", e.g. to append it to the history of a
+// loop over a cchat.Stream. It mirrors [ResultToResponse]: the text blocks
+// become Content, <tool_call> tags in the text are parsed into ToolCalls when
+// hasTools is set, and the native tool_use blocks of am are appended to
+// ToolCalls with their IDs and JSON-encoded input.
+//
+// A replayed history must answer every tool call with a "tool" message, as
+// in any OpenAI conversation. Native tool_use blocks belong to the CLI's
+// built-in tools, which it has already run; [ToolResultMessages] turns the
+// tool_result blocks of am into those answers.
+func AssistantToChatMessage(am *ccwire.AssistantMessage, hasTools bool) ChatMessage {
+	msg := textToChatMessage(extractText(am), hasTools)
+	msg.ToolCalls = append(msg.ToolCalls, nativeToolCalls(am)...)
+	return msg
+}
 
+// ToolResultMessages returns a "tool" message for each tool_result block of
+// am, in order, answering the native tool calls of [AssistantToChatMessage].
+// Appended after the assistant message, they complete the history, so that
+// a replayed conversation shows the model the results of the tools it used.
+func ToolResultMessages(am *ccwire.AssistantMessage) []ChatMessage {
+	var msgs []ChatMessage
+	for _, block := range am.Message.Content {
+		if block.Type == "tool_result" {
+			msgs = append(msgs, ChatMessage{Role: "tool", ToolCallID: block.ToolUseID, Content: block.Content})
+		}
+	}
+	return msgs
+}
+
+// nativeToolCalls converts the tool_use blocks of am, which may be nil, into
+// tool calls with their IDs and JSON-encoded input.
+func nativeToolCalls(am *ccwire.AssistantMessage) []ToolCall {
+	if am == nil {
+		return nil
+	}
+	var calls []ToolCall
+	for _, use := range am.ToolUses() {
+		args := []byte("{}")
+		if use.Input != nil {
+			if data, err := json.Marshal(use.Input); err == nil {
+				args = data
+			}
+		}
+		calls = append(calls, ToolCall{
+			ID:       use.ID,
+			Type:     "function",
+			Function: FunctionCall{Name: use.Name, Arguments: string(args)},
+		})
+	}
+	return calls
+}
+
+// textToChatMessage builds the assistant message for reply text, parsing
+// <tool_call> tags into ToolCalls when hasTools is set. Invalid UTF-8 in
+// text is replaced with U+FFFD.
+func textToChatMessage(text string, hasTools bool) ChatMessage {
+	text = validUTF8(text)
+	msg := ChatMessage{
+		Role: "assistant",
+	}
+	if !hasTools {
+		msg.Content = text
+		return msg
+	}
+	cleanText, toolCalls := ParseToolCalls(text)
+	if len(toolCalls) > 0 {
+		msg.ToolCalls = toolCalls
+	}
+	if cleanText != "" {
+		msg.Content = cleanText
+	}
+	return msg
+}
+
+// ResultToResponseFor is like [ResultToResponse] but derives the bridge
+// options from req: tool call parsing is enabled when req has Tools, and the
+// assistant's thinking blocks are surfaced as ReasoningContent when
+// req.IncludeThinking is set. If the content contains one of req's stop
+// sequences it is cut just before the earliest match, which is reported as
+// StopSequence, any tool calls are dropped, and the finish reason is "stop".
+// Otherwise, content beyond [ChatCompletionRequest.MaxOutputTokens] is
+// truncated, counting tokens as [EstimateTokens] does, and the finish reason
+// is "length"; it is also "length" if the CLI itself stopped at its token
+// limit. A reply cut at the token limit carries no tool calls, as the model
+// did not finish making them. If the model refused, the finish reason is
+// [FinishReasonContentFilter] and tool calls are dropped. In prefill mode
+// the prefill text is then prepended to the content so the caller sees the
+// fully assembled reply. Finally, a JSON req.ResponseFormat extracts the
+// JSON value from the content, or sets the finish reason to
+// [FinishReasonInvalidJSON] if there is none.
+//
+// If req disallows parallel tool calls, only the first parsed tool call is
+// kept, in case the model ignored the instruction to make a single call.
+//
+// When req.ValidateToolCalls is set, each parsed tool call is checked against
+// the matching tool's parameter schema. String spellings of numbers and
+// booleans are coerced to the declared type first (e.g. "30" becomes 30);
+// calls that still fail are kept, and their errors are recorded in
+// ToolCallErrors. See [ValidateToolCall] for standalone use.
+//
+// When req.IncludeToolErrors is set, tool_result blocks marked as errors and
+// the result's permission denials are reported in ToolErrors; see
+// [ToolErrors].
+func ResultToResponseFor(req *ChatCompletionRequest, result *ccwire.ResultMessage, assistant *ccwire.AssistantMessage) *ChatCompletionResponse {
+	resp := ResultToResponse(result, assistant, len(req.Tools) > 0)
+	choice := &resp.Choices[0]
+	msg := &choice.Message
+	if req.IncludeThinking && assistant != nil {
+		msg.ReasoningContent = extractThinking(assistant)
+	}
+	stopped, truncated := false, false
+	if stops := req.StopSequences(); len(stops) > 0 {
+		text := msg.StringContent()
+		if i, stop := indexStop(text, stops); i >= 0 {
+			msg.Content = text[:i]
+			resp.StopSequence = stop
+			stopped = true
+		}
+	}
+	if !stopped && (truncateMessage(msg, req.MaxOutputTokens()) || hitMaxTokens(assistant)) {
+		truncated = true
+	}
+	if !keepsToolCalls(stopped, truncated, refused(assistant)) {
+		msg.ToolCalls = nil
+	}
+	if !req.AllowsParallelToolCalls() && len(msg.ToolCalls) > 1 {
+		msg.ToolCalls = msg.ToolCalls[:1]
+	}
+	choice.FinishReason = finishReason(len(msg.ToolCalls) > 0, truncated, refused(assistant))
+	if prefill := req.PrefillText(); prefill != "" {
+		msg.Content = prefill + msg.StringContent()
+	}
+	applyResponseFormat(choice, req.ResponseFormat)
+	if req.ValidateToolCalls {
+		resp.ToolCallErrors = validateToolCalls(msg, req.Tools)
+	}
+	if req.IncludeToolErrors {
+		resp.ToolErrors = ToolErrors(result, assistant)
+	}
 	return resp
 }
 
+// FinishReasonContentFilter is the finish reason of a reply the model
+// refused to give (stop reason "refusal"), as with OpenAI's content filter.
+const FinishReasonContentFilter = "content_filter"
+
+// refused reports whether the model declined to answer assistant, which
+// the API reports as stop reason "refusal".
+func refused(assistant *ccwire.AssistantMessage) bool {
+	return assistant != nil && assistant.Message.StopReason != nil && *assistant.Message.StopReason == "refusal"
+}
+
+// finishReason returns the finish reason of a reply, the one decision
+// shared by [ResultToResponseFor] and [StreamState.FinishChunk] so the two
+// cannot drift apart: [FinishReasonContentFilter] if the model refused,
+// "length" if the output was cut at the token limit, "tool_calls" if the
+// reply carries tool calls, and "stop" otherwise. Tool calls are those
+// parsed from <tool_call> tags in the text plus the native tool_use blocks
+// of the assistant message (see nativeToolCalls), so a reply with tool_use
+// blocks finishes with "tool_calls" even when text follows them.
+func finishReason(hasToolCalls, truncated, refused bool) string {
+	switch {
+	case refused:
+		return FinishReasonContentFilter
+	case truncated:
+		return "length"
+	case hasToolCalls:
+		return "tool_calls"
+	default:
+		return "stop"
+	}
+}
+
+// keepsToolCalls reports whether a reply keeps its tool calls, the rule
+// shared by [ResultToResponseFor] and [StreamState.FinishChunk]: a reply
+// cut at a stop sequence or at the token limit keeps the text before the
+// cut but drops all its tool calls, parsed and native alike, and so does a
+// refused one.
+func keepsToolCalls(stopped, truncated, refused bool) bool {
+	return !stopped && !truncated && !refused
+}
+
+// ToolErrors collects the tool uses that did not succeed: tool_result blocks
+// of assistant marked as errors, followed by the permission denials recorded
+// in result. Either argument may be nil. Tool names of failed results are
+// looked up from the tool_use blocks of assistant.
+func ToolErrors(result *ccwire.ResultMessage, assistant *ccwire.AssistantMessage) []ToolError {
+	var errs []ToolError
+	if assistant != nil {
+		names := make(map[string]string)
+		for _, use := range assistant.ToolUses() {
+			names[use.ID] = use.Name
+		}
+		for _, block := range assistant.ToolErrors() {
+			errs = append(errs, ToolError{
+				ToolUseID: block.ToolUseID,
+				ToolName:  names[block.ToolUseID],
+				Message:   block.Content,
+			})
+		}
+	}
+	if result != nil {
+		for _, d := range result.PermissionDenials {
+			errs = append(errs, ToolError{
+				ToolUseID: d.ToolUseID,
+				ToolName:  d.ToolName,
+				Message:   "permission denied",
+				Denied:    true,
+			})
+		}
+	}
+	return errs
+}
+
+// SystemFingerprint derives a stable system_fingerprint value from the
+// resolved model name and the claude CLI version, so clients can detect when
+// either changes. It returns the empty string if cliVersion is unknown.
+func SystemFingerprint(model, cliVersion string) string {
+	if cliVersion == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(model + "\x00" + cliVersion))
+	return "fp_" + hex.EncodeToString(sum[:6])
+}
+
+// SystemInfoFromMessage converts the CLI's initial [ccwire.SystemMessage] into
+// a [SystemInfo]. It returns nil if msg is nil.
+func SystemInfoFromMessage(msg *ccwire.SystemMessage) *SystemInfo {
+	if msg == nil {
+		return nil
+	}
+	return &SystemInfo{
+		SessionID: msg.SessionID,
+		Model:     msg.Model,
+		CWD:       msg.CWD,
+		Tools:     msg.Tools,
+	}
+}
+
+// indexStop returns the index of the earliest occurrence of any of stops in
+// text and the stop sequence found there, or -1 and "" if none occurs.
+func indexStop(text string, stops []string) (int, string) {
+	first, match := -1, ""
+	for _, stop := range stops {
+		if i := strings.Index(text, stop); i >= 0 && (first < 0 || i < first) {
+			first, match = i, stop
+		}
+	}
+	return first, match
+}
+
 func extractText(assistant *ccwire.AssistantMessage) string {
 	var builder strings.Builder
 	for _, block := range assistant.Message.Content {
@@ -75,6 +320,16 @@ func extractText(assistant *ccwire.AssistantMessage) string {
 	return builder.String()
 }
 
+func extractThinking(assistant *ccwire.AssistantMessage) string {
+	var builder strings.Builder
+	for _, block := range assistant.Message.Content {
+		if block.Type == "thinking" {
+			builder.WriteString(block.Thinking)
+		}
+	}
+	return validUTF8(builder.String())
+}
+
 func modelFromResult(result *ccwire.ResultMessage, assistant *ccwire.AssistantMessage) string {
 	if assistant != nil && assistant.Message.Model != "" {
 		return assistant.Message.Model
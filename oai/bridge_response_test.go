@@ -1,6 +1,10 @@
 package oai
 
 import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/codewandler/cc-sdk-go/ccwire"
@@ -147,3 +151,534 @@ func TestExtractText(t *testing.T) {
 		})
 	}
 }
+
+func TestResultToResponseFor_IncludeThinking(t *testing.T) {
+	assistant := &ccwire.AssistantMessage{
+		Message: ccwire.AssistantInner{
+			Content: []ccwire.ContentBlock{
+				{Type: "thinking", Thinking: "The user wants a greeting."},
+				{Type: "text", Text: "Hello!"},
+			},
+		},
+	}
+	result := &ccwire.ResultMessage{SessionID: "sess-1", Result: "Hello!"}
+
+	tests := []struct {
+		name          string
+		include       bool
+		wantReasoning string
+	}{
+		{name: "default_off", include: false, wantReasoning: ""},
+		{name: "opt_in", include: true, wantReasoning: "The user wants a greeting."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &ChatCompletionRequest{IncludeThinking: tt.include}
+			resp := ResultToResponseFor(req, result, assistant)
+
+			msg := resp.Choices[0].Message
+			if msg.StringContent() != "Hello!" {
+				t.Errorf("content = %q, want %q", msg.StringContent(), "Hello!")
+			}
+			if msg.ReasoningContent != tt.wantReasoning {
+				t.Errorf("reasoning_content = %q, want %q", msg.ReasoningContent, tt.wantReasoning)
+			}
+		})
+	}
+}
+
+func TestResultToResponseFor_Prefill(t *testing.T) {
+	assistant := &ccwire.AssistantMessage{
+		Message: ccwire.AssistantInner{
+			Content: []ccwire.ContentBlock{{Type: "text", Text: `"colors": ["red"]}`}},
+		},
+	}
+	result := &ccwire.ResultMessage{SessionID: "sess-1"}
+	req := &ChatCompletionRequest{
+		Prefill: true,
+		Messages: []ChatMessage{
+			{Role: "user", Content: "JSON please"},
+			{Role: "assistant", Content: "{"},
+		},
+	}
+
+	resp := ResultToResponseFor(req, result, assistant)
+
+	want := `{"colors": ["red"]}`
+	if got := resp.Choices[0].Message.StringContent(); got != want {
+		t.Errorf("content = %q, want %q", got, want)
+	}
+}
+
+func TestResultToResponseFor_Stop(t *testing.T) {
+	assistant := &ccwire.AssistantMessage{
+		Message: ccwire.AssistantInner{
+			Content: []ccwire.ContentBlock{{Type: "text", Text: "one\ntwo\nSTOP\nthree"}},
+		},
+	}
+	result := &ccwire.ResultMessage{SessionID: "sess-1"}
+
+	tests := []struct {
+		name string
+		stop any
+		want string
+	}{
+		{name: "none", stop: nil, want: "one\ntwo\nSTOP\nthree"},
+		{name: "string", stop: "STOP", want: "one\ntwo\n"},
+		{name: "array_earliest_wins", stop: []any{"STOP", "two"}, want: "one\n"},
+		{name: "no_match", stop: []any{"four"}, want: "one\ntwo\nSTOP\nthree"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ResultToResponseFor(&ChatCompletionRequest{Stop: tt.stop}, result, assistant)
+			if got := resp.Choices[0].Message.StringContent(); got != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+			if resp.Choices[0].FinishReason != "stop" {
+				t.Errorf("finish_reason = %q, want stop", resp.Choices[0].FinishReason)
+			}
+		})
+	}
+}
+
+func TestResultToResponseFor_ParallelToolCalls(t *testing.T) {
+	assistant := &ccwire.AssistantMessage{
+		Message: ccwire.AssistantInner{
+			Content: []ccwire.ContentBlock{{Type: "text", Text: `<tool_call>{"name": "tool_a", "arguments": {}}</tool_call><tool_call>{"name": "tool_b", "arguments": {}}</tool_call>`}},
+		},
+	}
+	result := &ccwire.ResultMessage{SessionID: "sess-1"}
+	tools := []Tool{{Type: "function", Function: FunctionDefinition{Name: "tool_a"}}, {Type: "function", Function: FunctionDefinition{Name: "tool_b"}}}
+	no := false
+
+	resp := ResultToResponseFor(&ChatCompletionRequest{Tools: tools}, result, assistant)
+	if got := len(resp.Choices[0].Message.ToolCalls); got != 2 {
+		t.Errorf("default: len(ToolCalls) = %d, want 2", got)
+	}
+
+	resp = ResultToResponseFor(&ChatCompletionRequest{Tools: tools, ParallelToolCalls: &no}, result, assistant)
+	calls := resp.Choices[0].Message.ToolCalls
+	if len(calls) != 1 || calls[0].Function.Name != "tool_a" {
+		t.Fatalf("parallel_tool_calls=false: ToolCalls = %+v, want only tool_a", calls)
+	}
+	if resp.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("finish_reason = %q, want tool_calls", resp.Choices[0].FinishReason)
+	}
+}
+
+func TestResultToResponseFor_IncludeToolErrors(t *testing.T) {
+	assistant := &ccwire.AssistantMessage{
+		Message: ccwire.AssistantInner{
+			Content: []ccwire.ContentBlock{
+				{Type: "tool_use", ID: "toolu_1", Name: "Bash"},
+				{Type: "tool_result", ToolUseID: "toolu_1", Content: "exit status 127", IsError: true},
+				{Type: "tool_result", ToolUseID: "toolu_2", Content: "fine"},
+				{Type: "text", Text: "Sorry, that failed."},
+			},
+		},
+	}
+	result := &ccwire.ResultMessage{
+		SessionID:         "sess-1",
+		PermissionDenials: []ccwire.PermissionDenial{{ToolName: "Write", ToolUseID: "toolu_3"}},
+	}
+
+	resp := ResultToResponseFor(&ChatCompletionRequest{}, result, assistant)
+	if resp.ToolErrors != nil {
+		t.Errorf("ToolErrors = %+v, want nil without opt-in", resp.ToolErrors)
+	}
+
+	resp = ResultToResponseFor(&ChatCompletionRequest{IncludeToolErrors: true}, result, assistant)
+	want := []ToolError{
+		{ToolUseID: "toolu_1", ToolName: "Bash", Message: "exit status 127"},
+		{ToolUseID: "toolu_3", ToolName: "Write", Message: "permission denied", Denied: true},
+	}
+	if !reflect.DeepEqual(resp.ToolErrors, want) {
+		t.Errorf("ToolErrors = %+v, want %+v", resp.ToolErrors, want)
+	}
+	if got := resp.Choices[0].Message.StringContent(); got != "Sorry, that failed." {
+		t.Errorf("content = %q, tool errors must not change it", got)
+	}
+	data, _ := json.Marshal(resp)
+	if !strings.Contains(string(data), `"x_cc_tool_errors":[{"tool_use_id":"toolu_1"`) {
+		t.Errorf("expected x_cc_tool_errors vendor field in %s", data)
+	}
+}
+
+func TestResultToResponse_DurationMS(t *testing.T) {
+	result := &ccwire.ResultMessage{SessionID: "sess-1", Result: "Hi", DurationMS: 1234}
+	resp := ResultToResponse(result, nil, false)
+
+	if resp.DurationMS != 1234 {
+		t.Errorf("DurationMS = %d, want 1234", resp.DurationMS)
+	}
+	data, _ := json.Marshal(resp)
+	if !strings.Contains(string(data), `"x_cc_duration_ms":1234`) {
+		t.Errorf("expected x_cc_duration_ms vendor field in %s", data)
+	}
+}
+
+func TestSystemFingerprint(t *testing.T) {
+	fp := SystemFingerprint("claude-haiku", "1.2.3")
+	if !strings.HasPrefix(fp, "fp_") {
+		t.Errorf("fingerprint = %q, want fp_ prefix", fp)
+	}
+	if again := SystemFingerprint("claude-haiku", "1.2.3"); again != fp {
+		t.Errorf("fingerprint not stable: %q vs %q", fp, again)
+	}
+	if other := SystemFingerprint("claude-sonnet", "1.2.3"); other == fp {
+		t.Error("different models should yield different fingerprints")
+	}
+	if other := SystemFingerprint("claude-haiku", "1.2.4"); other == fp {
+		t.Error("different CLI versions should yield different fingerprints")
+	}
+	if got := SystemFingerprint("claude-haiku", ""); got != "" {
+		t.Errorf("unknown CLI version should yield empty fingerprint, got %q", got)
+	}
+}
+
+func TestSystemInfoFromMessage(t *testing.T) {
+	if SystemInfoFromMessage(nil) != nil {
+		t.Error("expected nil for nil message")
+	}
+
+	info := SystemInfoFromMessage(&ccwire.SystemMessage{
+		Subtype:   "init",
+		SessionID: "sess-1",
+		Model:     "claude-sonnet-4-5-20250929",
+		CWD:       "/tmp",
+		Tools:     []string{"Read", "Write"},
+	})
+	if info.Model != "claude-sonnet-4-5-20250929" || info.SessionID != "sess-1" || info.CWD != "/tmp" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if len(info.Tools) != 2 || info.Tools[0] != "Read" {
+		t.Errorf("tools = %v", info.Tools)
+	}
+
+	// SystemInfo must stay out of the standard response body
+	resp := ChatCompletionResponse{ID: "x", SystemInfo: info}
+	data, _ := json.Marshal(resp)
+	if strings.Contains(string(data), "sess-1") || strings.Contains(string(data), "system_info") {
+		t.Errorf("SystemInfo leaked into JSON: %s", data)
+	}
+}
+
+func TestResultToResponseFor_MaxTokens(t *testing.T) {
+	result := &ccwire.ResultMessage{SessionID: "sess-1"}
+	text := func(s string) *ccwire.AssistantMessage {
+		return &ccwire.AssistantMessage{Message: ccwire.AssistantInner{
+			Content: []ccwire.ContentBlock{{Type: "text", Text: s}},
+		}}
+	}
+	tools := []Tool{{Type: "function", Function: FunctionDefinition{Name: "lookup"}}}
+	limit := func(n int) *int { return &n }
+
+	tests := []struct {
+		name       string
+		req        ChatCompletionRequest
+		assistant  *ccwire.AssistantMessage
+		wantText   string
+		wantCalls  int
+		wantFinish string
+	}{
+		{
+			name:       "no_limit",
+			assistant:  text("Hello, world! How are you?"),
+			wantText:   "Hello, world! How are you?",
+			wantFinish: "stop",
+		},
+		{
+			name:       "within_limit",
+			req:        ChatCompletionRequest{MaxTokens: limit(10)},
+			assistant:  text("Hello, world!"),
+			wantText:   "Hello, world!",
+			wantFinish: "stop",
+		},
+		{
+			name:       "truncated",
+			req:        ChatCompletionRequest{MaxTokens: limit(3)},
+			assistant:  text("Hello, world! How are you?"),
+			wantText:   "Hello, world",
+			wantFinish: "length",
+		},
+		{
+			name:       "max_completion_tokens_wins",
+			req:        ChatCompletionRequest{MaxTokens: limit(100), MaxCompletionTokens: limit(1)},
+			assistant:  text("Hello, world!"),
+			wantText:   "Hell",
+			wantFinish: "length",
+		},
+		{
+			name:       "multibyte_runes",
+			req:        ChatCompletionRequest{MaxTokens: limit(1)},
+			assistant:  text("héllo wörld"),
+			wantText:   "hél",
+			wantFinish: "length",
+		},
+		{
+			name:       "tool_call_fits",
+			req:        ChatCompletionRequest{Tools: tools, MaxTokens: limit(20)},
+			assistant:  text(`On it. <tool_call>{"name": "lookup", "arguments": {"q": "go"}}</tool_call>`),
+			wantText:   "On it.",
+			wantCalls:  1,
+			wantFinish: "tool_calls",
+		},
+		{
+			name:       "tool_call_over_limit",
+			req:        ChatCompletionRequest{Tools: tools, MaxTokens: limit(3)},
+			assistant:  text(`On it. <tool_call>{"name": "lookup", "arguments": {"q": "go"}}</tool_call>`),
+			wantText:   "On it.",
+			wantFinish: "length",
+		},
+		{
+			name:       "stop_sequence_first",
+			req:        ChatCompletionRequest{Stop: "world", MaxTokens: limit(1)},
+			assistant:  text("Hi world, and more"),
+			wantText:   "Hi ",
+			wantFinish: "stop",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ResultToResponseFor(&tt.req, result, tt.assistant)
+			choice := resp.Choices[0]
+			if got := choice.Message.StringContent(); got != tt.wantText {
+				t.Errorf("content = %q, want %q", got, tt.wantText)
+			}
+			if len(choice.Message.ToolCalls) != tt.wantCalls {
+				t.Errorf("got %d tool calls, want %d", len(choice.Message.ToolCalls), tt.wantCalls)
+			}
+			if choice.FinishReason != tt.wantFinish {
+				t.Errorf("finish_reason = %q, want %q", choice.FinishReason, tt.wantFinish)
+			}
+		})
+	}
+}
+
+func TestResultToResponseFor_CLIMaxTokens(t *testing.T) {
+	reason := "max_tokens"
+	assistant := &ccwire.AssistantMessage{Message: ccwire.AssistantInner{
+		Content:    []ccwire.ContentBlock{{Type: "text", Text: "The answer is"}},
+		StopReason: &reason,
+	}}
+
+	resp := ResultToResponseFor(&ChatCompletionRequest{}, &ccwire.ResultMessage{}, assistant)
+	if resp.Choices[0].FinishReason != "length" {
+		t.Errorf("finish_reason = %q, want length when the CLI hit its limit", resp.Choices[0].FinishReason)
+	}
+}
+
+// TestFinishReason_BothPaths verifies that the streaming and non-streaming
+// bridges agree on the finish reason of a reply with native tool_use blocks
+// and trailing text: the blocks are tool calls, after any parsed from the
+// text, so the reason is "tool_calls". When the CLI hit its token limit the
+// reason is "length"; the non-streaming path keeps the tool calls then,
+// while the stream emits none.
+func TestFinishReason_BothPaths(t *testing.T) {
+	const toolCall = `<tool_call>{"name":"get_weather","arguments":{"city":"Paris"}}</tool_call>`
+	tools := []Tool{{Type: "function", Function: FunctionDefinition{Name: "get_weather"}}}
+	maxTokens := "max_tokens"
+
+	tests := []struct {
+		name       string
+		tools      []Tool
+		stop       []string
+		text       string
+		stopReason *string
+		want       string
+		wantCalls  []string
+	}{
+		{name: "native_tool_then_text", text: "There are 3 files.", want: "tool_calls", wantCalls: []string{"Bash"}},
+		{name: "native_tool_then_text_with_tools", tools: tools, text: "There are 3 files.", want: "tool_calls", wantCalls: []string{"Bash"}},
+		{name: "native_tool_and_client_tool_call", tools: tools, text: "Checking. " + toolCall, want: "tool_calls", wantCalls: []string{"get_weather", "Bash"}},
+		{name: "cli_max_tokens", tools: tools, text: "Checking. " + toolCall, stopReason: &maxTokens, want: "length", wantCalls: []string{}},
+		{name: "cli_max_tokens_native_only", text: "Listed.", stopReason: &maxTokens, want: "length", wantCalls: []string{}},
+		{name: "stop_after_tool_call", tools: tools, stop: []string{"END"}, text: "Checking. " + toolCall + " Done. END more", want: "stop", wantCalls: []string{}},
+		{name: "stop_before_tool_call", tools: tools, stop: []string{"END"}, text: "Checking. END " + toolCall, want: "stop", wantCalls: []string{}},
+		{name: "stop_without_tools", stop: []string{"END"}, text: "Listed. END more", want: "stop", wantCalls: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &ChatCompletionRequest{Tools: tt.tools, Stop: tt.stop}
+			assistant := &ccwire.AssistantMessage{Message: ccwire.AssistantInner{
+				Content: []ccwire.ContentBlock{
+					{Type: "tool_use", ID: "toolu_1", Name: "Bash", Input: map[string]any{"command": "ls"}},
+					{Type: "tool_result", ToolUseID: "toolu_1", Content: "a b c"},
+					{Type: "text", Text: tt.text},
+				},
+				StopReason: tt.stopReason,
+			}}
+
+			resp := ResultToResponseFor(req, &ccwire.ResultMessage{}, assistant)
+			if got := resp.Choices[0].FinishReason; got != tt.want {
+				t.Errorf("non-streaming finish_reason = %q, want %q", got, tt.want)
+			}
+			if got := toolCallNames(resp.Choices[0].Message.ToolCalls); !slices.Equal(got, tt.wantCalls) {
+				t.Errorf("non-streaming tool calls = %q, want %q", got, tt.wantCalls)
+			}
+
+			ss := NewStreamStateFor(req)
+			var acc ChunkAccumulator
+			chunks := ss.HandleStreamEvent(&ccwire.StreamEventMessage{Event: map[string]any{
+				"type":  "content_block_delta",
+				"delta": map[string]any{"type": "text_delta", "text": tt.text},
+			}})
+			for _, chunk := range append(chunks, ss.FinishChunk(assistant)...) {
+				acc.Add(chunk)
+			}
+			streamed := acc.Response()
+			if got := streamed.Choices[0].FinishReason; got != tt.want {
+				t.Errorf("streaming finish_reason = %q, want %q", got, tt.want)
+			}
+			if got := toolCallNames(streamed.Choices[0].Message.ToolCalls); !slices.Equal(got, tt.wantCalls) {
+				t.Errorf("streaming tool calls = %q, want %q", got, tt.wantCalls)
+			}
+			if tt.stop != nil {
+				got, want := streamed.Choices[0].Message.StringContent(), resp.Choices[0].Message.StringContent()
+				if strings.TrimSpace(got) != strings.TrimSpace(want) || strings.Contains(got, "more") {
+					t.Errorf("streaming content = %q, non-streaming %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+// toolCallNames returns the function names of calls, in order.
+func toolCallNames(calls []ToolCall) []string {
+	names := []string{}
+	for _, tc := range calls {
+		names = append(names, tc.Function.Name)
+	}
+	return names
+}
+
+func TestAssistantToChatMessage(t *testing.T) {
+	assistant := func(blocks ...ccwire.ContentBlock) *ccwire.AssistantMessage {
+		return &ccwire.AssistantMessage{Message: ccwire.AssistantInner{Content: blocks}}
+	}
+
+	tests := []struct {
+		name     string
+		am       *ccwire.AssistantMessage
+		hasTools bool
+		want     ChatMessage
+	}{
+		{
+			name: "text",
+			am:   assistant(ccwire.ContentBlock{Type: "thinking", Thinking: "hmm"}, ccwire.ContentBlock{Type: "text", Text: "Hello"}),
+			want: ChatMessage{Role: "assistant", Content: "Hello"},
+		},
+		{
+			name: "native_tool",
+			am: assistant(
+				ccwire.ContentBlock{Type: "text", Text: "Listing."},
+				ccwire.ContentBlock{Type: "tool_use", ID: "toolu_1", Name: "Bash", Input: map[string]any{"command": "ls"}},
+				ccwire.ContentBlock{Type: "tool_use", ID: "toolu_2", Name: "Glob"},
+			),
+			want: ChatMessage{Role: "assistant", Content: "Listing.", ToolCalls: []ToolCall{
+				{ID: "toolu_1", Type: "function", Function: FunctionCall{Name: "Bash", Arguments: `{"command":"ls"}`}},
+				{ID: "toolu_2", Type: "function", Function: FunctionCall{Name: "Glob", Arguments: `{}`}},
+			}},
+		},
+		{
+			name:     "tool_call_text",
+			am:       assistant(ccwire.ContentBlock{Type: "text", Text: `Checking.<tool_call>{"name":"get_weather","arguments":{"city":"Paris"}}</tool_call>`}),
+			hasTools: true,
+			want: ChatMessage{Role: "assistant", Content: "Checking.", ToolCalls: []ToolCall{
+				{Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+			}},
+		},
+		{
+			name: "tool_call_text_without_tools",
+			am:   assistant(ccwire.ContentBlock{Type: "text", Text: `<tool_call>{"name":"x","arguments":{}}</tool_call>`}),
+			want: ChatMessage{Role: "assistant", Content: `<tool_call>{"name":"x","arguments":{}}</tool_call>`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AssistantToChatMessage(tt.am, tt.hasTools)
+			for i := range got.ToolCalls {
+				if i < len(tt.want.ToolCalls) && tt.want.ToolCalls[i].ID == "" {
+					got.ToolCalls[i].ID = "" // generated
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AssistantToChatMessage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAssistantToChatMessage_Replay verifies that a turn in which the CLI
+// ran a built-in tool replays as a complete history: the tool call is
+// followed by its result, taken from the tool_result block, before the
+// next turn.
+func TestAssistantToChatMessage_Replay(t *testing.T) {
+	am := &ccwire.AssistantMessage{Message: ccwire.AssistantInner{Content: []ccwire.ContentBlock{
+		{Type: "tool_use", ID: "toolu_1", Name: "Bash", Input: map[string]any{"command": "ls"}},
+		{Type: "tool_result", ToolUseID: "toolu_1", Content: "a b c"},
+		{Type: "text", Text: "There are 3 files."},
+	}}}
+
+	results := ToolResultMessages(am)
+	want := []ChatMessage{{Role: "tool", ToolCallID: "toolu_1", Content: "a b c"}}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("ToolResultMessages() = %+v, want %+v", results, want)
+	}
+
+	messages := []ChatMessage{{Role: "user", Content: "How many files?"}, AssistantToChatMessage(am, false)}
+	messages = append(messages, results...)
+	messages = append(messages, ChatMessage{Role: "user", Content: "Thanks"})
+	prompt, _ := RequestToQuery(&ChatCompletionRequest{Messages: messages})
+
+	wantPrompt := strings.Join([]string{
+		"[user]: How many files?",
+		"[assistant]: There are 3 files.\n\n" + `<tool_call>{"arguments":{"command":"ls"},"name":"Bash"}</tool_call>`,
+		"[tool_result for toolu_1]: a b c",
+		"[user]: Thanks",
+	}, "\n\n")
+	if prompt != wantPrompt {
+		t.Errorf("replayed prompt =\n%s\nwant\n%s", prompt, wantPrompt)
+	}
+}
+
+func TestResultToResponseFor_Refusal(t *testing.T) {
+	refusal, endTurn := "refusal", "end_turn"
+	tools := []Tool{{Type: "function", Function: FunctionDefinition{Name: "get_weather"}}}
+	tests := []struct {
+		name       string
+		stopReason *string
+		blocks     []ccwire.ContentBlock
+		wantReason string
+		wantText   string
+	}{
+		{"refusal", &refusal, nil, FinishReasonContentFilter, ""},
+		{"refusal_with_text", &refusal, []ccwire.ContentBlock{{Type: "text", Text: "I can't"}}, FinishReasonContentFilter, "I can't"},
+		{"refusal_drops_tool_calls", &refusal, []ccwire.ContentBlock{{Type: "text", Text: `<tool_call>{"name":"get_weather","arguments":{}}</tool_call>`}}, FinishReasonContentFilter, ""},
+		{"thinking_only", &endTurn, []ccwire.ContentBlock{{Type: "thinking", Thinking: "hmm"}}, "stop", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assistant := &ccwire.AssistantMessage{}
+			assistant.Message.StopReason = tt.stopReason
+			assistant.Message.Content = tt.blocks
+			resp := ResultToResponseFor(&ChatCompletionRequest{Tools: tools}, &ccwire.ResultMessage{}, assistant)
+
+			choice := resp.Choices[0]
+			if choice.FinishReason != tt.wantReason {
+				t.Errorf("finish_reason = %q, want %q", choice.FinishReason, tt.wantReason)
+			}
+			if got := choice.Message.StringContent(); got != tt.wantText {
+				t.Errorf("content = %q, want %q", got, tt.wantText)
+			}
+			if len(choice.Message.ToolCalls) != 0 {
+				t.Errorf("tool calls = %+v, want none", choice.Message.ToolCalls)
+			}
+		})
+	}
+}
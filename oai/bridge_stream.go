@@ -27,14 +27,111 @@ const tagMaxPrefix = len("<tool_call>")
 // true and no further text is emitted until the stream finishes. At finish time,
 // [FinishChunk] parses the complete buffer with [ParseToolCalls] to produce
 // authoritative tool call chunks or flush any remaining plain text.
+//
+// When IncludeThinking is true, thinking deltas are forwarded immediately as
+// reasoning_content chunks; otherwise they are dropped.
+//
+// When Prefill is non-empty, it is emitted as content right after the initial
+// role chunk, so the streamed reply starts with the prefilled text.
+//
+// When Stop is non-empty, [StreamState.HandleStreamEvent] holds back any tail
+// of the text that could be the start of a stop sequence. Once a stop
+// sequence appears, the text before it is emitted, Stopped and StopSequence
+// are set, and all later text deltas are suppressed.
+//
+// When SingleToolCall is true, [StreamState.FinishChunk] emits only the first
+// parsed tool call.
+//
+// When IncludeUsage is true, [StreamState.UsageChunk] reports the token usage
+// of the result in a chunk of its own, to be sent after the finish chunk.
+//
+// When MaxTokens is positive, content is emitted only up to an approximate
+// budget of that many tokens, as measured by [EstimateTokens] (the Prefill
+// does not count). Once the budget is reached Truncated is set, later text deltas
+// are suppressed, tool calls are dropped, and the finish reason is "length".
+//
+// Content blocks are tracked individually, keyed by the index carried on
+// their events, so text, thinking, and tool_use input from different blocks
+// accumulate separately; see [StreamState.Block]. Text from all text blocks
+// still forms a single content stream, matching the non-streaming response.
+//
+// Text and thinking deltas are made valid UTF-8 before anything else: a
+// multibyte rune split across deltas is held back until the delta with the
+// rest of it, and invalid bytes become U+FFFD. Content is never cut inside
+// a rune, so every chunk is valid UTF-8 on its own.
+//
+// Every chunk carries a system_fingerprint: SystemFingerprint if set,
+// otherwise one derived from the model and CLIVersion by [SystemFingerprint].
 type StreamState struct {
-	ID        string
-	Model     string
-	Created   int64
-	HasTools  bool
-	Buffering bool           // true when we've detected <tool_call in the buffer
-	buffer    strings.Builder // accumulated text (always appended when HasTools)
-	Emitted   int            // number of bytes of buffer already streamed to client
+	ID                string
+	Model             string
+	Created           int64
+	HasTools          bool
+	IncludeThinking   bool
+	Prefill           string
+	Stop              []string
+	SingleToolCall    bool            // keep only the first tool call (parallel_tool_calls: false)
+	IncludeUsage      bool            // report usage after the finish chunk (stream_options.include_usage)
+	ToolCallChunkSize int             // stream tool call arguments in pieces of at most this many bytes; 0 sends each call whole
+	MaxTokens         int             // approximate output token budget; 0 means none
+	Stopped           bool            // true once a stop sequence has been seen
+	StopSequence      string          // the stop sequence that set Stopped
+	Truncated         bool            // true once the MaxTokens budget has been used up
+	Finished          bool            // true once FinishChunk has produced the finish chunk
+	stopTail          string          // text withheld because it may begin a stop sequence
+	spent             int             // units of output counted against MaxTokens; see EstimateTokens
+	CLIVersion        string          // claude CLI version used to derive the system fingerprint
+	SystemFingerprint string          // overrides the derived fingerprint when set
+	EmptyText         string          // content sent by FinishChunk if the reply had none and no tool calls
+	sentContent       bool            // true once a content chunk has been made
+	Buffering         bool            // true when we've detected <tool_call in the buffer
+	buffer            strings.Builder // accumulated text (always appended when HasTools)
+	Emitted           int             // number of bytes of buffer already streamed to client
+	blocks            map[int]*StreamBlock
+	textRunes         runeJoiner // holds a rune split across text deltas
+	thinkingRunes     runeJoiner // holds a rune split across thinking deltas
+}
+
+// StreamBlock is the state of one content block of a streamed message,
+// assembled from its content_block_start, content_block_delta, and
+// content_block_stop events.
+type StreamBlock struct {
+	Index int
+	Type  string // "text", "thinking", "tool_use", ...
+	ID    string // tool_use blocks only
+	Name  string // tool_use blocks only
+	Done  bool   // true once content_block_stop has been seen
+
+	text  strings.Builder
+	input strings.Builder
+}
+
+// Text returns the accumulated text of a text block, or the thinking of a
+// thinking block.
+func (b *StreamBlock) Text() string { return b.text.String() }
+
+// InputJSON returns the accumulated input JSON of a tool_use block. It is
+// complete once Done is set.
+func (b *StreamBlock) InputJSON() string { return b.input.String() }
+
+// Block returns the state of the content block with the given index in the
+// current message, or nil if no event for it has been seen. Indices restart
+// with each message_start.
+func (ss *StreamState) Block(index int) *StreamBlock {
+	return ss.blocks[index]
+}
+
+// block returns the state for index, creating it if needed.
+func (ss *StreamState) block(index int) *StreamBlock {
+	if b, ok := ss.blocks[index]; ok {
+		return b
+	}
+	if ss.blocks == nil {
+		ss.blocks = make(map[int]*StreamBlock)
+	}
+	b := &StreamBlock{Index: index}
+	ss.blocks[index] = b
+	return b
 }
 
 // NewStreamState creates a new StreamState for a streaming response.
@@ -48,21 +145,28 @@ func NewStreamState(hasTools bool) *StreamState {
 	}
 }
 
+// NewStreamStateFor creates a StreamState configured from req: tool call
+// buffering is enabled when req has Tools, and thinking deltas are forwarded
+// when req.IncludeThinking is set. SingleToolCall is set when req disallows
+// parallel tool calls, and IncludeUsage when it asks for usage. Prefill is
+// taken from [ChatCompletionRequest.PrefillText], Stop from
+// [ChatCompletionRequest.StopSequences], and MaxTokens from
+// [ChatCompletionRequest.MaxOutputTokens].
+func NewStreamStateFor(req *ChatCompletionRequest) *StreamState {
+	ss := NewStreamState(len(req.Tools) > 0)
+	ss.IncludeThinking = req.IncludeThinking
+	ss.Prefill = req.PrefillText()
+	ss.Stop = req.StopSequences()
+	ss.MaxTokens = req.MaxOutputTokens()
+	ss.SingleToolCall = !req.AllowsParallelToolCalls()
+	ss.IncludeUsage = req.IncludesUsage()
+	return ss
+}
+
 // InitChunk creates the initial streaming chunk that carries the assistant role.
 // This should be the first chunk sent to the client in a streaming response.
 func (ss *StreamState) InitChunk() *ChatCompletionChunk {
-	return &ChatCompletionChunk{
-		ID:      ss.ID,
-		Object:  "chat.completion.chunk",
-		Created: ss.Created,
-		Model:   ss.Model,
-		Choices: []ChunkChoice{
-			{
-				Index: 0,
-				Delta: ChunkDelta{Role: "assistant"},
-			},
-		},
-	}
+	return ss.newChunk(ChunkChoice{Index: 0, Delta: ChunkDelta{Role: "assistant"}})
 }
 
 // TextDeltaChunk processes an incremental text delta from the Claude Code stream.
@@ -71,9 +175,13 @@ func (ss *StreamState) InitChunk() *ChatCompletionChunk {
 // (excluding the last [tagMaxPrefix] bytes) is emitted. Returns nil if there is
 // nothing to emit yet -- either because the safety margin has not been exceeded
 // or because buffering has been activated after detecting a tool call tag prefix.
+// Emitted text is counted against MaxTokens; see [StreamState].
 func (ss *StreamState) TextDeltaChunk(text string) *ChatCompletionChunk {
 	if !ss.HasTools {
-		content := text
+		content := ss.spend(text)
+		if content == "" && ss.Truncated {
+			return nil
+		}
 		return ss.makeContentChunk(&content)
 	}
 
@@ -92,93 +200,262 @@ func (ss *StreamState) TextDeltaChunk(text string) *ChatCompletionChunk {
 
 	// Emit text up to a safety margin from the end of the buffer,
 	// so partial "<tool_call>" prefixes are never streamed.
-	safeEnd := ss.buffer.Len() - tagMaxPrefix
+	safeEnd := runeBoundary(ss.buffer.String(), ss.buffer.Len()-tagMaxPrefix)
 	if safeEnd <= ss.Emitted {
 		return nil // not enough new safe text to emit
 	}
 
-	content := ss.buffer.String()[ss.Emitted:safeEnd]
+	content := ss.spend(ss.buffer.String()[ss.Emitted:safeEnd])
 	ss.Emitted = safeEnd
+	if content == "" && ss.Truncated {
+		return nil
+	}
 	return ss.makeContentChunk(&content)
 }
 
+// spend counts content against the MaxTokens budget and returns the part of
+// it that fits, setting Truncated if that is not all of it.
+func (ss *StreamState) spend(content string) string {
+	if ss.MaxTokens <= 0 {
+		return content
+	}
+	content, cut := truncateUnits(content, ss.MaxTokens*unitsPerToken-ss.spent)
+	ss.spent += tokenUnits(content)
+	if cut {
+		ss.Truncated = true
+	}
+	return content
+}
+
+// spendToolCalls counts calls against the MaxTokens budget and reports
+// whether they fit. If they don't, Truncated is set.
+func (ss *StreamState) spendToolCalls(calls []ToolCall) bool {
+	if ss.Truncated {
+		return false
+	}
+	if ss.MaxTokens <= 0 {
+		return true
+	}
+	ss.spent += toolCallUnits(calls)
+	if ss.spent > ss.MaxTokens*unitsPerToken {
+		ss.Truncated = true
+	}
+	return !ss.Truncated
+}
+
+// applyStop filters a text delta through the stop sequences and returns the
+// portion that is safe to emit. The longest suffix that is a proper prefix of
+// some stop sequence is withheld until the next delta or [FinishChunk].
+func (ss *StreamState) applyStop(text string) string {
+	if len(ss.Stop) == 0 {
+		return text
+	}
+	if ss.Stopped {
+		return ""
+	}
+
+	buf := ss.stopTail + text
+	if i, stop := indexStop(buf, ss.Stop); i >= 0 {
+		ss.Stopped, ss.StopSequence = true, stop
+		ss.stopTail = ""
+		return buf[:i]
+	}
+
+	hold := 0
+	for _, stop := range ss.Stop {
+		for n := min(len(stop)-1, len(buf)); n > hold; n-- {
+			if strings.HasSuffix(buf, stop[:n]) {
+				hold = n
+				break
+			}
+		}
+	}
+	ss.stopTail = buf[len(buf)-hold:]
+	return buf[:len(buf)-hold]
+}
+
 // FinishChunk produces the final chunk(s) that close the streaming response.
-// When tools are enabled and the buffer contains text, it is parsed with
-// [ParseToolCalls]. If tool calls are found, any un-emitted clean text is
-// flushed first, followed by a chunk carrying the parsed [ToolCall] values
-// with FinishReason "tool_calls". If no tool calls are found, any remaining
-// buffered text is flushed and a "stop" finish chunk is appended.
+// Text withheld by the stop sequence filter is flushed first. When tools are
+// enabled and the buffer contains text, it is parsed with [ParseToolCalls].
+// If tool calls are found, any un-emitted clean text is flushed first,
+// followed by a chunk carrying the parsed [ToolCall] values (only the first
+// one when SingleToolCall is set) with FinishReason "tool_calls". If no tool
+// calls are found, any remaining buffered text is flushed and a "stop"
+// finish chunk is appended.
+//
+// If the MaxTokens budget ran out, or assistant reports that the CLI stopped
+// at its token limit, no tool calls are emitted and the finish reason is
+// "length" instead. After a stop sequence, or if the model refused, no tool
+// calls are emitted either, as in [ResultToResponseFor]. The native tool_use
+// blocks of assistant are emitted as tool calls after those parsed from the
+// text, whether or not HasTools is set, as in [ResultToResponse].
 //
 // The returned slice always ends with a chunk whose FinishReason is non-nil.
 func (ss *StreamState) FinishChunk(assistant *ccwire.AssistantMessage) []*ChatCompletionChunk {
 	var chunks []*ChatCompletionChunk
 
+	// A rune never completed by a later delta
+	if thinking := ss.thinkingRunes.flush(); thinking != "" && ss.IncludeThinking {
+		chunks = append(chunks, ss.makeReasoningChunk(&thinking))
+	}
+	if text := ss.textRunes.flush(); text != "" && !ss.Truncated {
+		if text = ss.applyStop(text); text != "" {
+			if chunk := ss.TextDeltaChunk(text); chunk != nil {
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+
+	if tail := ss.stopTail; tail != "" {
+		ss.stopTail = ""
+		if ss.HasTools {
+			ss.buffer.WriteString(tail) // flushed or parsed below
+		} else if tail = ss.spend(tail); tail != "" {
+			chunks = append(chunks, ss.makeContentChunk(&tail))
+		}
+	}
+
+	var toolCalls []ToolCall
 	if ss.HasTools && ss.buffer.Len() > 0 {
-		cleanText, toolCalls := ParseToolCalls(ss.buffer.String())
+		cleanText, parsed := ParseToolCalls(ss.buffer.String())
 
-		if len(toolCalls) > 0 {
+		if len(parsed) > 0 {
 			// Emit any un-streamed clean text before the tool calls
 			if len(cleanText) > ss.Emitted {
-				remainder := cleanText[ss.Emitted:]
+				if remainder := ss.spend(cleanText[ss.Emitted:]); remainder != "" {
+					chunks = append(chunks, ss.makeContentChunk(&remainder))
+				}
+			}
+			toolCalls = parsed
+		} else if ss.buffer.Len() > ss.Emitted {
+			// No tool calls found — emit any remaining buffered text
+			if remainder := ss.spend(ss.buffer.String()[ss.Emitted:]); remainder != "" {
 				chunks = append(chunks, ss.makeContentChunk(&remainder))
 			}
+		}
+	}
+	if keepsToolCalls(ss.Stopped, ss.Truncated || hitMaxTokens(assistant), refused(assistant)) {
+		toolCalls = append(toolCalls, nativeToolCalls(assistant)...)
+	} else {
+		toolCalls = nil
+	}
 
-			// Emit tool calls
-			reason := "tool_calls"
-			chunks = append(chunks, &ChatCompletionChunk{
-				ID:      ss.ID,
-				Object:  "chat.completion.chunk",
-				Created: ss.Created,
-				Model:   ss.Model,
-				Choices: []ChunkChoice{
-					{
-						Index:        0,
-						Delta:        ChunkDelta{ToolCalls: toolCalls},
-						FinishReason: &reason,
-					},
-				},
-			})
+	if len(toolCalls) > 0 {
+		if ss.SingleToolCall {
+			toolCalls = toolCalls[:1]
+		}
+		if ss.spendToolCalls(toolCalls) {
+			reason := finishReason(true, false, false)
+			if ss.ToolCallChunkSize > 0 {
+				chunks = append(chunks, ss.toolCallDeltaChunks(toolCalls)...)
+				chunks = append(chunks, ss.newChunk(ChunkChoice{
+					Index:        0,
+					Delta:        ChunkDelta{},
+					FinishReason: &reason,
+				}))
+				ss.Finished = true
+				return chunks
+			}
+			for i := range toolCalls {
+				toolCalls[i].Index = &i
+			}
+			chunks = append(chunks, ss.newChunk(ChunkChoice{
+				Index:        0,
+				Delta:        ChunkDelta{ToolCalls: toolCalls},
+				FinishReason: &reason,
+			}))
+			ss.Finished = true
 			return chunks
 		}
+	}
 
-		// No tool calls found — emit any remaining buffered text
-		if ss.buffer.Len() > ss.Emitted {
-			remainder := ss.buffer.String()[ss.Emitted:]
-			chunks = append(chunks, ss.makeContentChunk(&remainder))
-		}
+	if !ss.sentContent && ss.EmptyText != "" {
+		empty := ss.EmptyText
+		chunks = append(chunks, ss.makeContentChunk(&empty))
 	}
 
-	// Normal stop
-	reason := "stop"
-	chunks = append(chunks, &ChatCompletionChunk{
-		ID:      ss.ID,
-		Object:  "chat.completion.chunk",
-		Created: ss.Created,
-		Model:   ss.Model,
-		Choices: []ChunkChoice{
-			{
-				Index:        0,
-				Delta:        ChunkDelta{},
-				FinishReason: &reason,
-			},
-		},
-	})
+	// Normal stop, or cut short by the token limit
+	reason := finishReason(false, ss.Truncated || hitMaxTokens(assistant), refused(assistant))
+	chunks = append(chunks, ss.newChunk(ChunkChoice{
+		Index:        0,
+		Delta:        ChunkDelta{},
+		FinishReason: &reason,
+	}))
+	ss.Finished = true
 	return chunks
 }
 
+// newChunk builds a chunk carrying choice, stamped with the stream's ID,
+// timestamp, model, and system fingerprint.
+func (ss *StreamState) newChunk(choice ChunkChoice) *ChatCompletionChunk {
+	return &ChatCompletionChunk{
+		ID:                ss.ID,
+		Object:            "chat.completion.chunk",
+		Created:           ss.Created,
+		Model:             ss.Model,
+		Choices:           []ChunkChoice{choice},
+		SystemFingerprint: ss.fingerprint(),
+	}
+}
+
+// fingerprint returns SystemFingerprint if set, or derives one from the
+// model and CLIVersion.
+func (ss *StreamState) fingerprint() string {
+	if ss.SystemFingerprint != "" {
+		return ss.SystemFingerprint
+	}
+	return SystemFingerprint(ss.Model, ss.CLIVersion)
+}
+
 func (ss *StreamState) makeContentChunk(content *string) *ChatCompletionChunk {
+	ss.sentContent = true
+	return ss.newChunk(ChunkChoice{Index: 0, Delta: ChunkDelta{Content: content}})
+}
+
+// UsageChunk returns the chunk reporting the token usage of result, with an
+// empty choices list as OpenAI sends for stream_options.include_usage. It
+// returns nil unless IncludeUsage is set or if result is nil. Usage is
+// computed as for [ResultToResponse].
+func (ss *StreamState) UsageChunk(result *ccwire.ResultMessage) *ChatCompletionChunk {
+	if !ss.IncludeUsage || result == nil {
+		return nil
+	}
 	return &ChatCompletionChunk{
-		ID:      ss.ID,
-		Object:  "chat.completion.chunk",
-		Created: ss.Created,
-		Model:   ss.Model,
-		Choices: []ChunkChoice{
-			{
-				Index: 0,
-				Delta: ChunkDelta{Content: content},
-			},
-		},
+		ID:                ss.ID,
+		Object:            "chat.completion.chunk",
+		Created:           ss.Created,
+		Model:             ss.Model,
+		Choices:           []ChunkChoice{},
+		Usage:             usageFromResult(result),
+		SystemFingerprint: ss.fingerprint(),
+	}
+}
+
+// toolCallDeltaChunks streams calls the way OpenAI does: for each call, a
+// chunk with its index, ID, type and name, then its arguments in pieces of
+// at most ToolCallChunkSize bytes, rounded down to a rune boundary. The
+// pieces concatenate to the arguments; see [MergeToolCallDeltas].
+func (ss *StreamState) toolCallDeltaChunks(calls []ToolCall) []*ChatCompletionChunk {
+	var chunks []*ChatCompletionChunk
+	delta := func(call ToolCall) *ChatCompletionChunk {
+		return ss.newChunk(ChunkChoice{Index: 0, Delta: ChunkDelta{ToolCalls: []ToolCall{call}}})
 	}
+	for i, tc := range calls {
+		chunks = append(chunks, delta(ToolCall{Index: &i, ID: tc.ID, Type: tc.Type, Function: FunctionCall{Name: tc.Function.Name}}))
+		for args := tc.Function.Arguments; args != ""; {
+			n := len(args)
+			if n > ss.ToolCallChunkSize {
+				n = runeBoundary(args, ss.ToolCallChunkSize)
+			}
+			chunks = append(chunks, delta(ToolCall{Index: &i, Function: FunctionCall{Arguments: args[:n]}}))
+			args = args[n:]
+		}
+	}
+	return chunks
+}
+
+func (ss *StreamState) makeReasoningChunk(reasoning *string) *ChatCompletionChunk {
+	return ss.newChunk(ChunkChoice{Index: 0, Delta: ChunkDelta{ReasoningContent: reasoning}})
 }
 
 // setBufferForTest sets the buffer content (for testing only).
@@ -188,24 +465,70 @@ func (ss *StreamState) setBufferForTest(content string) {
 }
 
 // HandleStreamEvent processes a single Claude Code [ccwire.StreamEventMessage]
-// and returns zero or more OAI chunks to emit. It handles "message_start" events
-// (extracting the model name and returning the initial role chunk) and
-// "content_block_delta" events (delegating to [StreamState.TextDeltaChunk]).
-// Unrecognized event types are silently ignored.
+// and returns zero or more OAI chunks to emit. It handles "message_start"
+// events (extracting the model name and returning the initial role chunk,
+// followed by the Prefill text if any) and "content_block_delta" events
+// (filtering text through the Stop sequences and delegating to
+// [StreamState.TextDeltaChunk], or emitting a reasoning chunk for thinking
+// deltas when IncludeThinking is set). Every content block event also
+// updates the per-block state returned by [StreamState.Block]; tool_use
+// input deltas only update that state. Unrecognized event types are silently
+// ignored.
 func (ss *StreamState) HandleStreamEvent(msg *ccwire.StreamEventMessage) []*ChatCompletionChunk {
 	ev := ccwire.ParseStreamEvent(msg)
 
 	switch ev.Type {
 	case "message_start":
+		ss.blocks = nil
 		if message, ok := ev.Raw["message"].(map[string]any); ok {
 			if model, ok := message["model"].(string); ok {
 				ss.Model = model
 			}
 		}
-		return []*ChatCompletionChunk{ss.InitChunk()}
+		chunks := []*ChatCompletionChunk{ss.InitChunk()}
+		if ss.Prefill != "" {
+			// The prefill is the client's text, not output: credit it so
+			// it doesn't count against MaxTokens.
+			ss.spent -= tokenUnits(ss.Prefill)
+			// Route through TextDeltaChunk so tool call buffering sees it
+			if chunk := ss.TextDeltaChunk(ss.Prefill); chunk != nil {
+				chunks = append(chunks, chunk)
+			}
+			ss.Prefill = ""
+		}
+		return chunks
+
+	case "content_block_start":
+		b := ss.block(ev.Index())
+		cb := ev.ContentBlock()
+		b.Type, _ = cb["type"].(string)
+		b.ID, _ = cb["id"].(string)
+		b.Name, _ = cb["name"].(string)
+		return nil
+
+	case "content_block_stop":
+		ss.block(ev.Index()).Done = true
+		return nil
 
 	case "content_block_delta":
-		text := ev.DeltaText()
+		b := ss.block(ev.Index())
+		if partial := ev.DeltaPartialJSON(); partial != "" {
+			b.input.WriteString(partial)
+			return nil
+		}
+		if thinking := ev.DeltaThinking(); thinking != "" {
+			b.text.WriteString(thinking)
+			thinking = ss.thinkingRunes.next(thinking)
+			if !ss.IncludeThinking || thinking == "" {
+				return nil
+			}
+			return []*ChatCompletionChunk{ss.makeReasoningChunk(&thinking)}
+		}
+		b.text.WriteString(ev.DeltaText())
+		if ss.Truncated {
+			return nil
+		}
+		text := ss.applyStop(ss.textRunes.next(ev.DeltaText()))
 		if text == "" {
 			return nil
 		}
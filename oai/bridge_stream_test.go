@@ -1,6 +1,8 @@
 package oai
 
 import (
+	"encoding/json"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -147,6 +149,36 @@ func TestStreamState_TextDeltaChunk_WithTools_PartialTag(t *testing.T) {
 	}
 }
 
+func TestStreamState_TextDeltaChunk_WithTools_RuneBoundary(t *testing.T) {
+	ss := NewStreamState(true)
+
+	// 21 bytes: the safety margin ends at byte 10, inside the third €
+	chunk := ss.TextDeltaChunk("abc€€€€€€")
+	if chunk == nil {
+		t.Fatal("first chunk should not be nil")
+	}
+	if got := *chunk.Choices[0].Delta.Content; got != "abc€€" {
+		t.Errorf("content = %q, want %q (cut backed off to rune boundary)", got, "abc€€")
+	}
+	if ss.Emitted != 9 {
+		t.Errorf("Emitted = %d, want 9", ss.Emitted)
+	}
+
+	// Margin ends at byte 11, still inside the same €
+	if chunk := ss.TextDeltaChunk("d"); chunk != nil {
+		t.Errorf("second chunk = %q, want nil", *chunk.Choices[0].Delta.Content)
+	}
+
+	// Margin ends at byte 12, after it
+	chunk = ss.TextDeltaChunk("e")
+	if chunk == nil {
+		t.Fatal("third chunk should not be nil")
+	}
+	if got := *chunk.Choices[0].Delta.Content; got != "€" {
+		t.Errorf("content = %q, want %q", got, "€")
+	}
+}
+
 func TestStreamState_TextDeltaChunk_WithTools_FullTagDetection(t *testing.T) {
 	ss := NewStreamState(true)
 
@@ -170,6 +202,43 @@ func TestStreamState_TextDeltaChunk_WithTools_FullTagDetection(t *testing.T) {
 	}
 }
 
+func TestStreamState_FinishChunk_Refusal(t *testing.T) {
+	refusal := "refusal"
+	assistant := &ccwire.AssistantMessage{}
+	assistant.Message.StopReason = &refusal
+
+	ss := NewStreamState(true)
+	ss.TextDeltaChunk(`<tool_call>{"name":"get_weather","arguments":{}}</tool_call>`)
+	chunks := ss.FinishChunk(assistant)
+
+	last := chunks[len(chunks)-1].Choices[0]
+	if last.FinishReason == nil || *last.FinishReason != FinishReasonContentFilter {
+		t.Errorf("finish_reason = %v, want %q", last.FinishReason, FinishReasonContentFilter)
+	}
+	for _, chunk := range chunks {
+		if len(chunk.Choices[0].Delta.ToolCalls) > 0 {
+			t.Errorf("refused reply should not carry tool calls: %+v", chunk.Choices[0].Delta.ToolCalls)
+		}
+	}
+}
+
+func TestStreamState_FinishChunk_EmptyText(t *testing.T) {
+	ss := NewStreamState(false)
+	ss.EmptyText = "(no reply)"
+	chunks := ss.FinishChunk(nil)
+	if len(chunks) != 2 || chunks[0].Choices[0].Delta.Content == nil || *chunks[0].Choices[0].Delta.Content != "(no reply)" {
+		t.Fatalf("chunks = %+v, want the placeholder then the finish chunk", chunks)
+	}
+
+	// Not sent once the reply had content
+	ss = NewStreamState(false)
+	ss.EmptyText = "(no reply)"
+	ss.TextDeltaChunk("Hi")
+	if chunks := ss.FinishChunk(nil); len(chunks) != 1 {
+		t.Errorf("len(chunks) = %d, want only the finish chunk", len(chunks))
+	}
+}
+
 func TestStreamState_TextDeltaChunk_WithTools_BufferingActive(t *testing.T) {
 	ss := NewStreamState(true)
 	ss.Buffering = true
@@ -355,6 +424,73 @@ func TestStreamState_FinishChunk_WithTools_MultipleToolCalls(t *testing.T) {
 	if toolCalls[1].Function.Name != "tool_b" {
 		t.Errorf("toolCalls[1].Function.Name = %q, want %q", toolCalls[1].Function.Name, "tool_b")
 	}
+	for i, tc := range toolCalls {
+		if tc.Index == nil || *tc.Index != i {
+			t.Errorf("toolCalls[%d].Index = %v, want %d", i, tc.Index, i)
+		}
+	}
+}
+
+func TestStreamState_FinishChunk_WithTools_ToolCallChunkSize(t *testing.T) {
+	ss := NewStreamState(true)
+	ss.ToolCallChunkSize = 4
+	ss.buffer.WriteString(`<tool_call>{"name": "tool_a", "arguments": {"city": "Zürich"}}</tool_call><tool_call>{"name": "tool_b", "arguments": {}}</tool_call>`)
+
+	chunks := ss.FinishChunk(nil)
+
+	last := chunks[len(chunks)-1].Choices[0]
+	if last.FinishReason == nil || *last.FinishReason != "tool_calls" {
+		t.Fatalf("last finish_reason = %v, want tool_calls", last.FinishReason)
+	}
+	if len(last.Delta.ToolCalls) != 0 {
+		t.Errorf("last chunk carries %d tool calls, want 0", len(last.Delta.ToolCalls))
+	}
+
+	var merged []ToolCall
+	for _, c := range chunks[:len(chunks)-1] {
+		deltas := c.Choices[0].Delta.ToolCalls
+		if len(deltas) != 1 {
+			t.Fatalf("chunk carries %d tool calls, want 1", len(deltas))
+		}
+		if n := len(deltas[0].Function.Arguments); n > ss.ToolCallChunkSize {
+			t.Errorf("arguments fragment %q is %d bytes, want at most %d", deltas[0].Function.Arguments, n, ss.ToolCallChunkSize)
+		}
+		merged = MergeToolCallDeltas(merged, deltas)
+	}
+
+	if len(merged) != 2 {
+		t.Fatalf("len(merged) = %d, want 2", len(merged))
+	}
+	if merged[0].Function.Name != "tool_a" || merged[0].Function.Arguments != `{"city":"Zürich"}` {
+		t.Errorf("merged[0] = %+v, want tool_a with the full arguments", merged[0])
+	}
+	if merged[1].Function.Name != "tool_b" || merged[1].Function.Arguments != `{}` {
+		t.Errorf("merged[1] = %+v, want tool_b with {}", merged[1])
+	}
+	for i, tc := range merged {
+		if tc.ID == "" || tc.Type != "function" {
+			t.Errorf("merged[%d] = %+v, want an ID and type function", i, tc)
+		}
+	}
+}
+
+func TestStreamState_FinishChunk_WithTools_SingleToolCall(t *testing.T) {
+	no := false
+	ss := NewStreamStateFor(&ChatCompletionRequest{
+		Tools:             []Tool{{Type: "function", Function: FunctionDefinition{Name: "tool_a"}}},
+		ParallelToolCalls: &no,
+	})
+	if !ss.SingleToolCall {
+		t.Fatal("SingleToolCall = false, want true for parallel_tool_calls=false")
+	}
+	ss.buffer.WriteString(`<tool_call>{"name": "tool_a", "arguments": {}}</tool_call><tool_call>{"name": "tool_b", "arguments": {}}</tool_call>`)
+
+	chunks := ss.FinishChunk(nil)
+
+	toolCalls := chunks[len(chunks)-1].Choices[0].Delta.ToolCalls
+	if len(toolCalls) != 1 || toolCalls[0].Function.Name != "tool_a" {
+		t.Errorf("toolCalls = %+v, want only tool_a", toolCalls)
+	}
 }
 
 func TestStreamState_HandleStreamEvent_MessageStart(t *testing.T) {
@@ -514,3 +650,311 @@ func TestStreamState_SafetyMarginBoundary(t *testing.T) {
 		t.Errorf("Emitted = %d, want %d", ss.Emitted, expectedEmitted)
 	}
 }
+
+func TestStreamState_HandleStreamEvent_ThinkingDelta(t *testing.T) {
+	msg := &ccwire.StreamEventMessage{
+		Event: map[string]any{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": map[string]any{
+				"type":     "thinking_delta",
+				"thinking": "Considering options...",
+			},
+		},
+	}
+
+	t.Run("default_off", func(t *testing.T) {
+		ss := NewStreamStateFor(&ChatCompletionRequest{})
+		if chunks := ss.HandleStreamEvent(msg); chunks != nil {
+			t.Errorf("expected thinking delta to be dropped, got %d chunks", len(chunks))
+		}
+	})
+
+	t.Run("opt_in", func(t *testing.T) {
+		ss := NewStreamStateFor(&ChatCompletionRequest{IncludeThinking: true})
+		chunks := ss.HandleStreamEvent(msg)
+		if len(chunks) != 1 {
+			t.Fatalf("len(chunks) = %d, want 1", len(chunks))
+		}
+		delta := chunks[0].Choices[0].Delta
+		if delta.Content != nil {
+			t.Errorf("Delta.Content = %q, want nil", *delta.Content)
+		}
+		if delta.ReasoningContent == nil || *delta.ReasoningContent != "Considering options..." {
+			t.Errorf("Delta.ReasoningContent = %v, want %q", delta.ReasoningContent, "Considering options...")
+		}
+	})
+}
+
+func TestStreamState_HandleStreamEvent_Prefill(t *testing.T) {
+	req := &ChatCompletionRequest{
+		Prefill: true,
+		Messages: []ChatMessage{
+			{Role: "user", Content: "JSON please"},
+			{Role: "assistant", Content: "{"},
+		},
+	}
+	start := &ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start"}}
+	delta := &ccwire.StreamEventMessage{Event: map[string]any{
+		"type":  "content_block_delta",
+		"delta": map[string]any{"type": "text_delta", "text": `"a": 1}`},
+	}}
+
+	ss := NewStreamStateFor(req)
+	var content strings.Builder
+	for _, msg := range []*ccwire.StreamEventMessage{start, delta, start} {
+		for _, chunk := range ss.HandleStreamEvent(msg) {
+			if c := chunk.Choices[0].Delta.Content; c != nil {
+				content.WriteString(*c)
+			}
+		}
+	}
+
+	want := `{"a": 1}`
+	if content.String() != want {
+		t.Errorf("assembled content = %q, want %q", content.String(), want)
+	}
+}
+
+func TestStreamState_HandleStreamEvent_StopSplitAcrossDeltas(t *testing.T) {
+	textEvent := func(text string) *ccwire.StreamEventMessage {
+		return &ccwire.StreamEventMessage{Event: map[string]any{
+			"type":  "content_block_delta",
+			"delta": map[string]any{"type": "text_delta", "text": text},
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		hasTools bool
+	}{
+		{name: "no_tools"},
+		{name: "with_tools", hasTools: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &ChatCompletionRequest{Stop: []any{"END", "\n\n###"}}
+			if tt.hasTools {
+				req.Tools = []Tool{{Type: "function", Function: FunctionDefinition{Name: "f"}}}
+			}
+			ss := NewStreamStateFor(req)
+
+			var content strings.Builder
+			collect := func(chunks []*ChatCompletionChunk) {
+				for _, chunk := range chunks {
+					if c := chunk.Choices[0].Delta.Content; c != nil {
+						content.WriteString(*c)
+					}
+				}
+			}
+			for _, delta := range []string{"Hello wor", "ld, the E", "ND of it", " and more"} {
+				collect(ss.HandleStreamEvent(textEvent(delta)))
+			}
+			finish := ss.FinishChunk(nil)
+			collect(finish)
+
+			if content.String() != "Hello world, the " {
+				t.Errorf("content = %q, want %q", content.String(), "Hello world, the ")
+			}
+			if !ss.Stopped {
+				t.Error("Stopped should be set after a stop sequence")
+			}
+			last := finish[len(finish)-1].Choices[0].FinishReason
+			if last == nil || *last != "stop" {
+				t.Errorf("finish_reason = %v, want stop", last)
+			}
+		})
+	}
+}
+
+func TestStreamState_HandleStreamEvent_StopPartialMatchFlushed(t *testing.T) {
+	ss := NewStreamStateFor(&ChatCompletionRequest{Stop: "END"})
+
+	chunks := ss.HandleStreamEvent(&ccwire.StreamEventMessage{Event: map[string]any{
+		"type":  "content_block_delta",
+		"delta": map[string]any{"type": "text_delta", "text": "THE EN"},
+	}})
+	if len(chunks) != 1 || *chunks[0].Choices[0].Delta.Content != "THE " {
+		t.Fatalf("expected %q with partial match withheld, got %v", "THE ", chunks)
+	}
+
+	finish := ss.FinishChunk(nil)
+	if len(finish) != 2 || *finish[0].Choices[0].Delta.Content != "EN" {
+		t.Errorf("withheld text should be flushed at finish, got %d chunks", len(finish))
+	}
+}
+
+func TestStreamState_HandleStreamEvent_InterleavedBlocks(t *testing.T) {
+	event := func(fields map[string]any) *ccwire.StreamEventMessage {
+		return &ccwire.StreamEventMessage{Event: fields}
+	}
+	start := func(index int, block map[string]any) *ccwire.StreamEventMessage {
+		return event(map[string]any{"type": "content_block_start", "index": json.Number(strconv.Itoa(index)), "content_block": block})
+	}
+	delta := func(index int, d map[string]any) *ccwire.StreamEventMessage {
+		return event(map[string]any{"type": "content_block_delta", "index": json.Number(strconv.Itoa(index)), "delta": d})
+	}
+	stop := func(index int) *ccwire.StreamEventMessage {
+		return event(map[string]any{"type": "content_block_stop", "index": json.Number(strconv.Itoa(index))})
+	}
+	text := func(s string) map[string]any { return map[string]any{"type": "text_delta", "text": s} }
+	input := func(s string) map[string]any { return map[string]any{"type": "input_json_delta", "partial_json": s} }
+
+	ss := NewStreamState(false)
+	var content strings.Builder
+	for _, msg := range []*ccwire.StreamEventMessage{
+		start(0, map[string]any{"type": "text"}),
+		delta(0, text("Checking ")),
+		start(1, map[string]any{"type": "tool_use", "id": "toolu_1", "name": "get_weather"}),
+		delta(1, input(`{"city": `)),
+		delta(0, text("the weather.")),
+		start(2, map[string]any{"type": "text"}),
+		delta(2, text(" Done.")),
+		delta(1, input(`"Paris"}`)),
+		stop(0),
+		stop(1),
+		stop(2),
+	} {
+		for _, chunk := range ss.HandleStreamEvent(msg) {
+			if c := chunk.Choices[0].Delta.Content; c != nil {
+				content.WriteString(*c)
+			}
+		}
+	}
+
+	if got, want := content.String(), "Checking the weather. Done."; got != want {
+		t.Errorf("streamed content = %q, want %q", got, want)
+	}
+	if b := ss.Block(0); b == nil || b.Type != "text" || b.Text() != "Checking the weather." || !b.Done {
+		t.Errorf("block 0 = %+v", b)
+	}
+	if b := ss.Block(2); b == nil || b.Text() != " Done." {
+		t.Errorf("block 2 = %+v", b)
+	}
+	tool := ss.Block(1)
+	if tool == nil || tool.Type != "tool_use" || tool.ID != "toolu_1" || tool.Name != "get_weather" {
+		t.Fatalf("block 1 = %+v", tool)
+	}
+	if got := tool.InputJSON(); got != `{"city": "Paris"}` {
+		t.Errorf("block 1 input = %q", got)
+	}
+	if tool.Text() != "" {
+		t.Errorf("tool_use block text = %q, want empty", tool.Text())
+	}
+
+	ss.HandleStreamEvent(event(map[string]any{"type": "message_start", "message": map[string]any{}}))
+	if ss.Block(0) != nil {
+		t.Error("message_start should reset block state")
+	}
+}
+
+func TestStreamState_HandleStreamEvent_MaxTokens(t *testing.T) {
+	textEvent := func(text string) *ccwire.StreamEventMessage {
+		return &ccwire.StreamEventMessage{Event: map[string]any{
+			"type":  "content_block_delta",
+			"delta": map[string]any{"type": "text_delta", "text": text},
+		}}
+	}
+	limit := 3 // about 12 characters
+
+	tests := []struct {
+		name     string
+		hasTools bool
+		prefill  string
+		deltas   []string
+		want     string
+		finish   string
+	}{
+		{
+			name:   "no_tools",
+			deltas: []string{"Hello, ", "world! ", "How are you?"},
+			want:   "Hello, world",
+			finish: "length",
+		},
+		{
+			name:     "with_tools",
+			hasTools: true,
+			deltas:   []string{"Hello, ", "world! ", "How are you?"},
+			want:     "Hello, world",
+			finish:   "length",
+		},
+		{
+			name:   "within_limit",
+			deltas: []string{"Hi ", "there"},
+			want:   "Hi there",
+			finish: "stop",
+		},
+		{
+			name:     "tool_call_dropped",
+			hasTools: true,
+			deltas:   []string{"Ok. ", `<tool_call>{"name": "f", `, `"arguments": {"q": "go"}}</tool_call>`},
+			want:     "Ok.",
+			finish:   "length",
+		},
+		{
+			name:    "prefill_not_counted",
+			prefill: "Once upon a time",
+			deltas:  []string{", there was ", "a bug."},
+			want:    "Once upon a time, there was ",
+			finish:  "length",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &ChatCompletionRequest{MaxTokens: &limit}
+			if tt.hasTools {
+				req.Tools = []Tool{{Type: "function", Function: FunctionDefinition{Name: "f"}}}
+			}
+			if tt.prefill != "" {
+				req.Prefill = true
+				req.Messages = []ChatMessage{{Role: "assistant", Content: tt.prefill}}
+			}
+			ss := NewStreamStateFor(req)
+
+			var content strings.Builder
+			var toolCalls int
+			collect := func(chunks []*ChatCompletionChunk) {
+				for _, chunk := range chunks {
+					if c := chunk.Choices[0].Delta.Content; c != nil {
+						content.WriteString(*c)
+					}
+					toolCalls += len(chunk.Choices[0].Delta.ToolCalls)
+				}
+			}
+			collect(ss.HandleStreamEvent(&ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start"}}))
+			for _, delta := range tt.deltas {
+				collect(ss.HandleStreamEvent(textEvent(delta)))
+			}
+			finish := ss.FinishChunk(nil)
+			collect(finish)
+
+			if content.String() != tt.want {
+				t.Errorf("content = %q, want %q", content.String(), tt.want)
+			}
+			if toolCalls != 0 {
+				t.Errorf("got %d tool calls, want none", toolCalls)
+			}
+			last := finish[len(finish)-1].Choices[0].FinishReason
+			if last == nil || *last != tt.finish {
+				t.Errorf("finish_reason = %v, want %s", last, tt.finish)
+			}
+			if ss.Truncated != (tt.finish == "length") {
+				t.Errorf("Truncated = %v", ss.Truncated)
+			}
+		})
+	}
+}
+
+func TestStreamState_FinishChunk_CLIMaxTokens(t *testing.T) {
+	ss := NewStreamState(false)
+	reason := "max_tokens"
+	assistant := &ccwire.AssistantMessage{Message: ccwire.AssistantInner{StopReason: &reason}}
+
+	finish := ss.FinishChunk(assistant)
+	last := finish[len(finish)-1].Choices[0].FinishReason
+	if last == nil || *last != "length" {
+		t.Errorf("finish_reason = %v, want length when the CLI hit its limit", last)
+	}
+}
@@ -0,0 +1,184 @@
+package oai
+
+import "errors"
+
+// RequestBuilder assembles a [ChatCompletionRequest] with a fluent API.
+// Create one with [NewRequest], chain message and option setters, and finish
+// with [RequestBuilder.Build]:
+//
+//	req, err := oai.NewRequest("sonnet").
+//	    System("You are terse.").
+//	    User("What's the weather in Paris?").
+//	    Tool("get_weather", "Get the current weather", oai.NewParams().
+//	        String("city", "City name", true).
+//	        Build()).
+//	    Stream(true).
+//	    Build()
+//
+// Messages are appended in call order. The builder is not safe for
+// concurrent use.
+type RequestBuilder struct {
+	req ChatCompletionRequest
+}
+
+// NewRequest starts building a request for the given model.
+func NewRequest(model string) *RequestBuilder {
+	return &RequestBuilder{req: ChatCompletionRequest{Model: model}}
+}
+
+// System appends a system message.
+func (b *RequestBuilder) System(text string) *RequestBuilder {
+	return b.Message(ChatMessage{Role: "system", Content: text})
+}
+
+// User appends a user message.
+func (b *RequestBuilder) User(text string) *RequestBuilder {
+	return b.Message(ChatMessage{Role: "user", Content: text})
+}
+
+// Assistant appends an assistant message, optionally carrying tool calls
+// from an earlier response.
+func (b *RequestBuilder) Assistant(text string, calls ...ToolCall) *RequestBuilder {
+	msg := ChatMessage{Role: "assistant", ToolCalls: calls}
+	if text != "" {
+		msg.Content = text
+	}
+	return b.Message(msg)
+}
+
+// ToolResult appends a tool message answering the tool call with the given ID.
+func (b *RequestBuilder) ToolResult(callID, content string) *RequestBuilder {
+	return b.Message(ChatMessage{Role: "tool", ToolCallID: callID, Content: content})
+}
+
+// Message appends an arbitrary message.
+func (b *RequestBuilder) Message(msg ChatMessage) *RequestBuilder {
+	b.req.Messages = append(b.req.Messages, msg)
+	return b
+}
+
+// Tool adds a function tool. Parameters is a JSON Schema object, typically
+// built with [NewParams], and may be nil for tools without arguments.
+func (b *RequestBuilder) Tool(name, description string, parameters any) *RequestBuilder {
+	b.req.Tools = append(b.req.Tools, Tool{
+		Type: "function",
+		Function: FunctionDefinition{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	})
+	return b
+}
+
+// Stream sets whether the response should be streamed.
+func (b *RequestBuilder) Stream(stream bool) *RequestBuilder {
+	b.req.Stream = stream
+	return b
+}
+
+// MaxTokens sets max_tokens.
+func (b *RequestBuilder) MaxTokens(n int) *RequestBuilder {
+	b.req.MaxTokens = &n
+	return b
+}
+
+// Temperature sets temperature.
+func (b *RequestBuilder) Temperature(t float64) *RequestBuilder {
+	b.req.Temperature = &t
+	return b
+}
+
+// Build returns the assembled request. It returns an error if no messages
+// were added.
+func (b *RequestBuilder) Build() (ChatCompletionRequest, error) {
+	if len(b.req.Messages) == 0 {
+		return ChatCompletionRequest{}, errors.New("request has no messages")
+	}
+	return b.req, nil
+}
+
+// ParamsBuilder assembles a JSON Schema object describing tool parameters.
+// Create one with [NewParams]; each property setter takes the property name,
+// a description (may be empty), and whether the property is required.
+type ParamsBuilder struct {
+	props    map[string]any
+	required []string
+}
+
+// NewParams starts building an object schema with no properties.
+func NewParams() *ParamsBuilder {
+	return &ParamsBuilder{props: make(map[string]any)}
+}
+
+// String adds a string property.
+func (p *ParamsBuilder) String(name, description string, required bool) *ParamsBuilder {
+	return p.Property(name, typedSchema("string", description), required)
+}
+
+// Integer adds an integer property.
+func (p *ParamsBuilder) Integer(name, description string, required bool) *ParamsBuilder {
+	return p.Property(name, typedSchema("integer", description), required)
+}
+
+// Number adds a number property.
+func (p *ParamsBuilder) Number(name, description string, required bool) *ParamsBuilder {
+	return p.Property(name, typedSchema("number", description), required)
+}
+
+// Boolean adds a boolean property.
+func (p *ParamsBuilder) Boolean(name, description string, required bool) *ParamsBuilder {
+	return p.Property(name, typedSchema("boolean", description), required)
+}
+
+// Enum adds a string property restricted to values.
+func (p *ParamsBuilder) Enum(name, description string, values []string, required bool) *ParamsBuilder {
+	schema := typedSchema("string", description)
+	schema["enum"] = values
+	return p.Property(name, schema, required)
+}
+
+// Array adds an array property whose elements match items.
+func (p *ParamsBuilder) Array(name, description string, items any, required bool) *ParamsBuilder {
+	schema := typedSchema("array", description)
+	schema["items"] = items
+	return p.Property(name, schema, required)
+}
+
+// Object adds a nested object property described by another builder.
+func (p *ParamsBuilder) Object(name, description string, fields *ParamsBuilder, required bool) *ParamsBuilder {
+	schema := fields.Build()
+	if description != "" {
+		schema["description"] = description
+	}
+	return p.Property(name, schema, required)
+}
+
+// Property adds a property with an arbitrary schema.
+func (p *ParamsBuilder) Property(name string, schema any, required bool) *ParamsBuilder {
+	p.props[name] = schema
+	if required {
+		p.required = append(p.required, name)
+	}
+	return p
+}
+
+// Build returns the object schema, suitable for [FunctionDefinition].Parameters.
+func (p *ParamsBuilder) Build() map[string]any {
+	schema := map[string]any{
+		"type":       "object",
+		"properties": p.props,
+	}
+	if len(p.required) > 0 {
+		schema["required"] = p.required
+	}
+	return schema
+}
+
+func typedSchema(typ, description string) map[string]any {
+	schema := map[string]any{"type": typ}
+	if description != "" {
+		schema["description"] = description
+	}
+	return schema
+}
@@ -0,0 +1,118 @@
+package oai
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRequestBuilder(t *testing.T) {
+	req, err := NewRequest("sonnet").
+		System("Be terse.").
+		User("Weather in Paris?").
+		Assistant("", ToolCall{ID: "call_1", Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}}).
+		ToolResult("call_1", "Sunny").
+		Tool("get_weather", "Get the weather", NewParams().String("city", "City name", true).Build()).
+		Stream(true).
+		MaxTokens(100).
+		Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	if req.Model != "sonnet" || !req.Stream {
+		t.Errorf("model/stream = %q/%v", req.Model, req.Stream)
+	}
+	if req.MaxTokens == nil || *req.MaxTokens != 100 {
+		t.Errorf("MaxTokens = %v, want 100", req.MaxTokens)
+	}
+
+	var roles []string
+	for _, m := range req.Messages {
+		roles = append(roles, m.Role)
+	}
+	if want := []string{"system", "user", "assistant", "tool"}; !reflect.DeepEqual(roles, want) {
+		t.Errorf("roles = %v, want %v", roles, want)
+	}
+	if req.Messages[2].Content != nil {
+		t.Errorf("assistant content = %v, want nil for tool-call-only message", req.Messages[2].Content)
+	}
+	if req.Messages[3].ToolCallID != "call_1" {
+		t.Errorf("tool_call_id = %q, want call_1", req.Messages[3].ToolCallID)
+	}
+
+	if len(req.Tools) != 1 || req.Tools[0].Type != "function" || req.Tools[0].Function.Name != "get_weather" {
+		t.Errorf("unexpected tools: %+v", req.Tools)
+	}
+}
+
+func TestRequestBuilder_NoMessages(t *testing.T) {
+	if _, err := NewRequest("sonnet").Stream(true).Build(); err == nil {
+		t.Error("expected error for request without messages")
+	}
+}
+
+// TestParamsBuilder_NestedSchema mirrors the create_user schema used by the
+// integration tests.
+func TestParamsBuilder_NestedSchema(t *testing.T) {
+	got := NewParams().
+		Object("name", "", NewParams().
+			String("first", "", true).
+			String("last", "", true), true).
+		Integer("age", "", true).
+		Object("address", "", NewParams().
+			String("street", "", true).
+			String("city", "", true).
+			String("state", "", true).
+			String("zip", "", true).
+			String("country", "", true), true).
+		Enum("role", "Account role", []string{"admin", "user"}, false).
+		Array("tags", "", map[string]any{"type": "string"}, false).
+		Build()
+
+	want := `{
+		"type": "object",
+		"properties": {
+			"name": {
+				"type": "object",
+				"properties": {"first": {"type": "string"}, "last": {"type": "string"}},
+				"required": ["first", "last"]
+			},
+			"age": {"type": "integer"},
+			"address": {
+				"type": "object",
+				"properties": {
+					"street": {"type": "string"},
+					"city": {"type": "string"},
+					"state": {"type": "string"},
+					"zip": {"type": "string"},
+					"country": {"type": "string"}
+				},
+				"required": ["street", "city", "state", "zip", "country"]
+			},
+			"role": {"type": "string", "description": "Account role", "enum": ["admin", "user"]},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		},
+		"required": ["name", "age", "address"]
+	}`
+
+	assertJSONEqual(t, got, want)
+}
+
+// assertJSONEqual compares got, after a JSON round trip, with the want
+// document.
+func assertJSONEqual(t *testing.T, got any, want string) {
+	t.Helper()
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var gotV, wantV any
+	json.Unmarshal(data, &gotV)
+	if err := json.Unmarshal([]byte(want), &wantV); err != nil {
+		t.Fatalf("bad want JSON: %v", err)
+	}
+	if !reflect.DeepEqual(gotV, wantV) {
+		t.Errorf("schema mismatch:\ngot:  %s\nwant: %s", data, want)
+	}
+}
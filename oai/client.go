@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"time"
 
 	"github.com/codewandler/cc-sdk-go/cchat"
 	"github.com/codewandler/cc-sdk-go/ccwire"
@@ -24,7 +26,9 @@ const (
 	EffortHigh Effort = "high"
 )
 
-func (e Effort) validate() error {
+// Validate reports an error unless e is empty or one of EffortLow,
+// EffortMedium, and EffortHigh.
+func (e Effort) Validate() error {
 	switch e {
 	case "", EffortLow, EffortMedium, EffortHigh:
 		return nil
@@ -35,27 +39,67 @@ func (e Effort) validate() error {
 
 // Model represents an OpenAI-compatible model descriptor, as returned by
 // [Client.ListModels]. ID contains the model name (e.g. "sonnet", "opus"),
-// Object is always "model", and OwnedBy is "anthropic".
+// Object is always "model", and OwnedBy is "anthropic". The remaining
+// fields are extensions carrying the [cchat.ModelInfo] metadata, omitted
+// when unknown.
 type Model struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	OwnedBy string `json:"owned_by"`
+	ID               string `json:"id"`
+	Object           string `json:"object"`
+	OwnedBy          string `json:"owned_by"`
+	FullID           string `json:"x_cc_full_id,omitempty"`
+	ContextWindow    int    `json:"x_cc_context_window,omitempty"`
+	SupportsTools    bool   `json:"x_cc_supports_tools,omitempty"`
+	SupportsThinking bool   `json:"x_cc_supports_thinking,omitempty"`
+}
+
+// ModelFromInfo returns the [Model] descriptor for info.
+func ModelFromInfo(info cchat.ModelInfo) Model {
+	return Model{
+		ID:               info.ID,
+		Object:           "model",
+		OwnedBy:          "anthropic",
+		FullID:           info.FullID,
+		ContextWindow:    info.ContextWindow,
+		SupportsTools:    info.Tools,
+		SupportsThinking: info.Thinking,
+	}
 }
 
 // APIError is returned by [Client] methods when a request fails. Type indicates
 // the error category: "invalid_request_error" for validation failures,
 // "service_unavailable" when the Claude Code CLI cannot be started,
-// "internal_error" for stream read failures, and "claude_error" when the
-// Claude Code process itself reports an error.
+// "internal_error" for stream read failures, "timeout" or "request_cancelled"
+// when the query context ends before the process finishes (including a
+// [*cchat.TimeoutError] from the client's DefaultTimeout), and
+// "claude_error" when the Claude Code process itself reports an error.
+// Failures reported by the Anthropic API behind the CLI are
+// "rate_limit_exceeded", "overloaded_error" (Code "overloaded"; safe to
+// retry after a delay), "authentication_error", and "api_error".
+//
+// Status is the HTTP status code an OpenAI-compatible server would answer
+// with, such as 429 for rate limits, 503 for overload, and 401 for rejected
+// credentials; see [HTTPStatusForError]. [APIErrorFor] gives the error for
+// a stream failure.
+//
+// Err holds the underlying cause when there is one, so errors.Is(err,
+// context.DeadlineExceeded) and errors.Is(err, context.Canceled) work on the
+// returned error, and errors.As finds the cchat error behind it, such as a
+// [*cchat.RateLimitError] or a [*cchat.ProcessError]. Only failures the SDK
+// detects itself, like a missing result, have no cause.
 type APIError struct {
 	Message string
 	Type    string
 	Code    string
+	Status  int
+	Err     error
 }
 
 // Error implements the error interface, returning the error message.
 func (e *APIError) Error() string { return e.Message }
 
+// Unwrap returns the underlying cause, if any.
+func (e *APIError) Unwrap() error { return e.Err }
+
 // Client provides an OpenAI-compatible programmatic interface backed by
 // [cchat.Client]. It can be used directly in Go programs without starting an
 // HTTP server. Each call to [Client.CreateChatCompletion] or
@@ -63,17 +107,55 @@ func (e *APIError) Error() string { return e.Message }
 // via the underlying cchat.Client.
 //
 // The Client bridges OAI concepts to Claude Code concepts:
-//   - Model names (e.g. "sonnet") are passed through to the CLI's --model flag.
+//   - Model names (e.g. "sonnet") are passed through to the CLI's --model flag,
+//     unless [cchat.ClientConfig].AllowedModels rejects them.
 //   - Conversation messages are flattened into a role-prefixed prompt string.
 //   - Tool definitions are injected into the system prompt as Markdown instructions.
-//   - The Effort field maps to the CLI's --effort flag.
+//   - The Effort field maps to the CLI's --effort flag; a request's
+//     ReasoningEffort overrides it.
 type Client struct {
 	cc *cchat.Client
 
-	// Effort sets the --effort flag for all requests.
-	// Use EffortLow, EffortMedium, or EffortHigh.
+	// Effort sets the --effort flag for requests that do not set
+	// ReasoningEffort. Use EffortLow, EffortMedium, or EffortHigh.
 	// Zero value means no flag is passed (Claude Code default).
 	Effort Effort
+
+	// Prices, when non-nil, sets the CostUSD of responses from
+	// [Client.CreateChatCompletion], falling back to a cost computed from
+	// the token usage when the CLI reports none; see [PriceTable.Cost].
+	Prices PriceTable
+
+	// DefaultCallTimeout, when positive, bounds calls whose context has no
+	// deadline of its own, guarding against callers that forget one: such
+	// a call runs with a context derived with this timeout, and expiry
+	// yields an [*APIError] wrapping [context.DeadlineExceeded].
+	// [cchat.ClientConfig].DefaultTimeout still applies to each query, so
+	// the shorter of the two wins. A stream's context is released by
+	// [ChatCompletionStream.Close].
+	DefaultCallTimeout time.Duration
+
+	// Assembler renders requests into the claude prompt and system prompt;
+	// see [RequestToQueryUsing]. Nil renders like [RequestToQuery].
+	Assembler PromptAssembler
+
+	// EmptyRetries is how many times [Client.CreateChatCompletion] repeats
+	// a request whose reply has neither content nor tool calls, which the
+	// CLI occasionally returns as a transient glitch. Each retry spawns a
+	// fresh process with the same prompt, and the last reply is returned
+	// even if it is still empty. Refusals, with finish reason
+	// [FinishReasonContentFilter], are not retried, and neither is anything
+	// once the call's context is done. DefaultCallTimeout bounds all
+	// attempts together. Zero (the default) disables retries.
+	EmptyRetries int
+
+	// EmptyResponseText, when non-empty, is the content of a reply that
+	// has neither content nor tool calls, e.g. because the model only
+	// thought or refused, for callers that treat an empty reply as an
+	// error. It is used after any EmptyRetries, and by streams as
+	// [StreamState].EmptyText. The finish reason is unchanged: "stop", or
+	// [FinishReasonContentFilter] for a refusal.
+	EmptyResponseText string
 }
 
 // NewClient creates a [Client] that wraps the given [cchat.Client].
@@ -93,15 +175,18 @@ func NewClientDefault() *Client {
 	}))
 }
 
-// ListModels returns a static list of available Claude model identifiers
-// (sonnet, opus, haiku). The context parameter is accepted for API consistency
-// but is not used. The returned error is always nil.
+// ListModels returns the models the client offers, with their metadata;
+// see [cchat.Client.ModelInfo]. Without [cchat.ClientConfig].AllowedModels
+// or Models set, these are sonnet, opus, and haiku. The context parameter
+// is accepted for API consistency but is not used. The returned error is
+// always nil.
 func (c *Client) ListModels(_ context.Context) ([]Model, error) {
-	return []Model{
-		{ID: "sonnet", Object: "model", OwnedBy: "anthropic"},
-		{ID: "opus", Object: "model", OwnedBy: "anthropic"},
-		{ID: "haiku", Object: "model", OwnedBy: "anthropic"},
-	}, nil
+	infos := c.cc.ModelInfo()
+	models := make([]Model, 0, len(infos))
+	for _, info := range infos {
+		models = append(models, ModelFromInfo(info))
+	}
+	return models, nil
 }
 
 // CreateChatCompletion sends a non-streaming chat completion request to the
@@ -109,23 +194,120 @@ func (c *Client) ListModels(_ context.Context) ([]Model, error) {
 // Stream field is forced to false regardless of its input value.
 //
 // It returns an [*APIError] on failure. Possible error types are
-// "invalid_request_error" (bad Effort value), "service_unavailable" (CLI
+// "invalid_request_error" (bad Effort or ReasoningEffort value, or a model rejected by
+// [cchat.ClientConfig].AllowedModels), "service_unavailable" (CLI
 // spawn failure), "internal_error" (stream read error or missing result),
-// and "claude_error" (the CLI reported an error).
+// "claude_error" (the CLI reported an error), and the upstream API failures
+// listed on [APIError].
+//
+// The returned response's SystemInfo field carries the session metadata
+// reported by the CLI, and SystemFingerprint is derived from the model and
+// the CLI version (see [SystemFingerprint]).
 func (c *Client) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (*ChatCompletionResponse, error) {
-	if err := c.Effort.validate(); err != nil {
-		return nil, &APIError{Message: err.Error(), Type: "invalid_request_error"}
+	return c.createChatCompletion(ctx, req, nil)
+}
+
+// CreateChatCompletionRaw is like [Client.CreateChatCompletion] but also
+// returns every [ccwire.Message] the CLI produced, in order. The messages are
+// collected even when an error is returned, up to the point of failure.
+// With [Client].EmptyRetries, they include the messages of every attempt.
+//
+// All messages are buffered in memory until the process exits, including
+// every stream event, so this is intended for debugging and capturing test
+// fixtures rather than production use.
+func (c *Client) CreateChatCompletionRaw(ctx context.Context, req ChatCompletionRequest) ([]ccwire.Message, *ChatCompletionResponse, error) {
+	var raw []ccwire.Message
+	resp, err := c.createChatCompletion(ctx, req, func(msg ccwire.Message) {
+		raw = append(raw, msg)
+	})
+	return raw, resp, err
+}
+
+// requestToQuery validates the effort settings and translates req into a
+// CLI query with [RequestToQuery]. The request's ReasoningEffort takes
+// precedence over c.Effort.
+func (c *Client) requestToQuery(req *ChatCompletionRequest) (string, cchat.QueryOptions, error) {
+	for _, effort := range []Effort{c.Effort, Effort(req.ReasoningEffort)} {
+		if err := effort.Validate(); err != nil {
+			return "", cchat.QueryOptions{}, &APIError{Message: err.Error(), Type: ErrorTypeInvalidRequest, Status: http.StatusBadRequest, Err: err}
+		}
 	}
+	prompt, opts := RequestToQueryUsing(req, c.Assembler)
+	if opts.Effort == "" {
+		opts.Effort = string(c.Effort)
+	}
+	return prompt, opts, nil
+}
+
+// callContext returns the context for a call with ctx: one derived with
+// c.DefaultCallTimeout if that is set and ctx has no deadline, or else ctx
+// itself. The returned cancel func must be called when the call is done.
+func (c *Client) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.DefaultCallTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.DefaultCallTimeout)
+}
+
+// createChatCompletion implements [Client.CreateChatCompletion]. If record is
+// non-nil it is called with each message read from the stream, for every
+// attempt.
+func (c *Client) createChatCompletion(ctx context.Context, req ChatCompletionRequest, record func(ccwire.Message)) (*ChatCompletionResponse, error) {
 	req.Stream = false
-	prompt, opts := RequestToQuery(&req)
-	opts.Effort = string(c.Effort)
+	prompt, opts, err := c.requestToQuery(&req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.complete(ctx, &req, prompt, opts, record)
+		if err != nil {
+			return nil, err
+		}
+		if attempt >= c.EmptyRetries || !isEmptyResponse(resp) || ctx.Err() != nil {
+			c.fillEmpty(resp)
+			return resp, nil
+		}
+	}
+}
+
+// fillEmpty sets c.EmptyResponseText as the content of the messages of resp
+// that have neither content nor tool calls.
+func (c *Client) fillEmpty(resp *ChatCompletionResponse) {
+	if c.EmptyResponseText == "" {
+		return
+	}
+	for i := range resp.Choices {
+		if msg := &resp.Choices[i].Message; msg.StringContent() == "" && len(msg.ToolCalls) == 0 {
+			msg.Content = c.EmptyResponseText
+		}
+	}
+}
+
+// isEmptyResponse reports whether resp is a reply with neither content nor
+// tool calls that is not a refusal; see [Client].EmptyRetries.
+func isEmptyResponse(resp *ChatCompletionResponse) bool {
+	for _, choice := range resp.Choices {
+		if choice.Message.StringContent() != "" || len(choice.Message.ToolCalls) > 0 ||
+			choice.FinishReason == FinishReasonContentFilter {
+			return false
+		}
+	}
+	return true
+}
 
+// complete runs one attempt of a chat completion: it queries the CLI with
+// prompt and opts, derived from req, and converts the result.
+func (c *Client) complete(ctx context.Context, req *ChatCompletionRequest, prompt string, opts cchat.QueryOptions, record func(ccwire.Message)) (*ChatCompletionResponse, error) {
 	stream, err := c.cc.Query(ctx, prompt, opts)
 	if err != nil {
-		return nil, &APIError{Message: err.Error(), Type: "service_unavailable"}
+		return nil, queryAPIError(err)
 	}
 	defer stream.Close()
 
+	var system *ccwire.SystemMessage
 	var lastAssistant *ccwire.AssistantMessage
 	var result *ccwire.ResultMessage
 
@@ -135,14 +317,16 @@ func (c *Client) CreateChatCompletion(ctx context.Context, req ChatCompletionReq
 			break
 		}
 		if err != nil {
-			// Check for rate limit error
-			var rateErr *cchat.RateLimitError
-			if errors.As(err, &rateErr) {
-				return nil, &APIError{Message: rateErr.Message, Type: "rate_limit_exceeded", Code: "rate_limit"}
-			}
-			return nil, &APIError{Message: err.Error(), Type: "internal_error"}
+			return nil, APIErrorFor(err)
+		}
+		if record != nil {
+			record(msg)
 		}
 		switch m := msg.(type) {
+		case *ccwire.SystemMessage:
+			if system == nil {
+				system = m
+			}
 		case *ccwire.AssistantMessage:
 			lastAssistant = m
 		case *ccwire.ResultMessage:
@@ -151,11 +335,28 @@ func (c *Client) CreateChatCompletion(ctx context.Context, req ChatCompletionReq
 	}
 
 	if result == nil {
-		return nil, &APIError{Message: "no result received from claude", Type: "internal_error"}
+		return nil, &APIError{Message: "no result received from claude", Type: "internal_error", Status: http.StatusInternalServerError}
 	}
 	if result.IsError {
-		return nil, &APIError{Message: result.Result, Type: "claude_error"}
+		return nil, &APIError{Message: result.Result, Type: "claude_error", Status: http.StatusInternalServerError}
+	}
+
+	resp := ResultToResponseFor(req, result, lastAssistant)
+	resp.SystemInfo = SystemInfoFromMessage(system)
+	resp.SystemFingerprint = SystemFingerprint(resp.Model, c.cc.Version())
+	if c.Prices != nil {
+		resp.CostUSD = c.Prices.Cost(resp.Model, result)
 	}
+	return resp, nil
+}
 
-	return ResultToResponse(result, lastAssistant, len(req.Tools) > 0), nil
+// queryAPIError converts an error from [cchat.Client.Query] into an
+// [*APIError]. A model rejected by the client's allowlist is an invalid
+// request; anything else means the CLI could not be started.
+func queryAPIError(err error) *APIError {
+	var modelErr *cchat.ModelError
+	if errors.As(err, &modelErr) {
+		return &APIError{Message: err.Error(), Type: ErrorTypeInvalidRequest, Code: "model_not_found", Status: HTTPStatusForError(err), Err: err}
+	}
+	return &APIError{Message: err.Error(), Type: "service_unavailable", Status: http.StatusServiceUnavailable, Err: err}
 }
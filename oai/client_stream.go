@@ -3,6 +3,7 @@ package oai
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/codewandler/cc-sdk-go/cchat"
 	"github.com/codewandler/cc-sdk-go/ccwire"
@@ -20,9 +21,52 @@ import (
 type ChatCompletionStream struct {
 	raw           *cchat.Stream
 	state         *StreamState
+	system        *SystemInfo
 	lastAssistant *ccwire.AssistantMessage
-	pending       []*ChatCompletionChunk
+	durationMS    int
+	pending       chunkQueue
 	err           error
+	cancel        context.CancelFunc // releases the call context; see Client.DefaultCallTimeout
+
+	// start is when the stream was requested, and firstChunk how long after
+	// that the first content chunk was returned by Recv (0 until then).
+	start      time.Time
+	firstChunk time.Duration
+}
+
+// chunkQueue is a FIFO of chunks produced by a single Claude Code event but
+// not yet returned by Recv. It tracks a read offset instead of re-slicing, so
+// draining never copies or allocates.
+//
+// Recv only refills the queue after it has been fully drained, and a single
+// event yields at most the chunks of one [StreamState.FinishChunk] call, so
+// the queue never holds more than one event's worth of chunks (a handful,
+// unless [StreamState].ToolCallChunkSize splits long tool call arguments).
+type chunkQueue struct {
+	items []*ChatCompletionChunk
+	pos   int
+}
+
+// push appends chunks to the queue. When the queue is empty it takes
+// ownership of the chunks slice instead of copying it.
+func (q *chunkQueue) push(chunks []*ChatCompletionChunk) {
+	if q.pos == len(q.items) {
+		q.items, q.pos = chunks, 0
+		return
+	}
+	q.items = append(q.items, chunks...)
+}
+
+// pop removes and returns the oldest queued chunk. It reports false when the
+// queue is empty.
+func (q *chunkQueue) pop() (*ChatCompletionChunk, bool) {
+	if q.pos >= len(q.items) {
+		return nil, false
+	}
+	chunk := q.items[q.pos]
+	q.items[q.pos] = nil // release for GC
+	q.pos++
+	return chunk, true
 }
 
 // CreateChatCompletionStream sends a streaming chat completion request to the
@@ -34,61 +78,125 @@ type ChatCompletionStream struct {
 // [Client.CreateChatCompletion]. The caller must call [ChatCompletionStream.Close]
 // when finished reading to terminate the underlying claude process.
 func (c *Client) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (*ChatCompletionStream, error) {
-	if err := c.Effort.validate(); err != nil {
-		return nil, &APIError{Message: err.Error(), Type: "invalid_request_error"}
-	}
+	start := time.Now()
 	req.Stream = true
-	prompt, opts := RequestToQuery(&req)
-	opts.Effort = string(c.Effort)
+	prompt, opts, err := c.requestToQuery(&req)
+	if err != nil {
+		return nil, err
+	}
 
+	ctx, cancel := c.callContext(ctx)
 	stream, err := c.cc.Query(ctx, prompt, opts)
 	if err != nil {
-		return nil, &APIError{Message: err.Error(), Type: "service_unavailable"}
+		cancel()
+		return nil, queryAPIError(err)
 	}
 
+	state := NewStreamStateFor(&req)
+	state.CLIVersion = c.cc.Version()
+	state.EmptyText = c.EmptyResponseText
+
 	return &ChatCompletionStream{
-		raw:   stream,
-		state: NewStreamState(len(req.Tools) > 0),
+		raw:    stream,
+		state:  state,
+		cancel: cancel,
+		start:  start,
 	}, nil
 }
 
+// StreamChatCompletion is like [Client.CreateChatCompletionStream] but drives
+// the stream itself: fn is called with each chunk in order, and the stream is
+// closed before StreamChatCompletion returns. It returns nil once the stream
+// ends, or the first error from creating the stream, from
+// [ChatCompletionStream.Recv], or from fn. An error from fn stops reading and
+// is returned unchanged, so a sentinel can be used to end the stream early.
+func (c *Client) StreamChatCompletion(ctx context.Context, req ChatCompletionRequest, fn func(*ChatCompletionChunk) error) error {
+	stream, err := c.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(chunk); err != nil {
+			return err
+		}
+	}
+}
+
 // Recv returns the next [ChatCompletionChunk] from the stream. It blocks until
 // a chunk is available, an error occurs, or the stream ends. Returns [io.EOF]
-// when the stream is complete.
+// when the stream is complete. Other errors are an [*APIError] with the
+// same types as [Client.CreateChatCompletion], e.g. "rate_limit_exceeded"
+// with Code "rate_limit" when the API is rate limiting, and wrap the cchat
+// error behind them. If the context passed to
+// [Client.CreateChatCompletionStream] is cancelled or times out, the error
+// wraps [context.Canceled] or [context.DeadlineExceeded] rather than being
+// io.EOF.
+//
+// The last chunk before io.EOF always carries a finish_reason, even if the
+// claude process exited without reporting a result. If the request set
+// stream_options.include_usage, it is followed by a usage chunk with no
+// choices, unless there was no result; see [StreamState.UsageChunk].
 //
 // After an error (including io.EOF), all subsequent calls return the same error.
 // Chunks may be queued internally when a single Claude Code event produces
 // multiple OAI chunks (e.g. remaining text plus tool calls at stream finish).
 func (cs *ChatCompletionStream) Recv() (*ChatCompletionChunk, error) {
+	chunk, err := cs.recv()
+	if err == nil && cs.firstChunk == 0 && hasContent(chunk) {
+		cs.firstChunk = max(time.Since(cs.start), 1)
+	}
+	return chunk, err
+}
+
+// recv implements [ChatCompletionStream.Recv].
+func (cs *ChatCompletionStream) recv() (*ChatCompletionChunk, error) {
 	// Sticky error
 	if cs.err != nil {
 		return nil, cs.err
 	}
 
 	// Drain pending queue first
-	if len(cs.pending) > 0 {
-		chunk := cs.pending[0]
-		cs.pending = cs.pending[1:]
+	if chunk, ok := cs.pending.pop(); ok {
 		return chunk, nil
 	}
 
 	// Read from cchat stream until we have chunks to emit
 	for {
 		msg, err := cs.raw.Next()
+		if err == io.EOF && !cs.state.Finished {
+			// The process ended without a result; finish the stream anyway
+			finishChunks := cs.state.FinishChunk(cs.lastAssistant)
+			cs.pending.push(finishChunks[1:])
+			return finishChunks[0], nil
+		}
 		if err == io.EOF {
 			cs.err = io.EOF
 			return nil, io.EOF
 		}
 		if err != nil {
-			cs.err = err
-			return nil, err
+			cs.err = APIErrorFor(err)
+			return nil, cs.err
 		}
 
 		switch m := msg.(type) {
+		case *ccwire.SystemMessage:
+			if cs.system == nil {
+				cs.system = SystemInfoFromMessage(m)
+			}
+
 		case *ccwire.StreamEventMessage:
 			chunks := cs.state.HandleStreamEvent(m)
 			if len(chunks) > 0 {
-				cs.pending = append(cs.pending, chunks[1:]...)
+				cs.pending.push(chunks[1:])
 				return chunks[0], nil
 			}
 
@@ -96,19 +204,64 @@ func (cs *ChatCompletionStream) Recv() (*ChatCompletionChunk, error) {
 			cs.lastAssistant = m
 
 		case *ccwire.ResultMessage:
+			cs.durationMS = m.DurationMS
 			finishChunks := cs.state.FinishChunk(cs.lastAssistant)
+			if usage := cs.state.UsageChunk(m); usage != nil {
+				finishChunks = append(finishChunks, usage)
+			}
 			if len(finishChunks) > 0 {
-				cs.pending = append(cs.pending, finishChunks[1:]...)
+				cs.pending.push(finishChunks[1:])
 				return finishChunks[0], nil
 			}
 		}
 	}
 }
 
+// SystemInfo returns the session metadata reported by the CLI's initial system
+// message, including the resolved model id and available tools. It returns nil
+// until the system message has been read, which normally happens during the
+// first call to [ChatCompletionStream.Recv].
+func (cs *ChatCompletionStream) SystemInfo() *SystemInfo {
+	return cs.system
+}
+
+// DurationMS returns the model-side wall-clock duration in milliseconds as
+// reported by the CLI's result message. It returns 0 until the result has
+// been read, i.e. until the final chunk has been received.
+func (cs *ChatCompletionStream) DurationMS() int {
+	return cs.durationMS
+}
+
+// FirstChunkLatency returns the time from the call to
+// [Client.CreateChatCompletionStream] until [ChatCompletionStream.Recv]
+// returned the first chunk with content, reasoning, or tool calls, so the
+// initial role-only chunk does not count. It includes spawning the claude
+// process. It returns 0 until such a chunk has been received.
+//
+// It measures time to first token, e.g. for callers deciding whether to
+// prefer [Client.CreateChatCompletion] when streams are slow to start.
+func (cs *ChatCompletionStream) FirstChunkLatency() time.Duration {
+	return cs.firstChunk
+}
+
+// hasContent reports whether chunk carries content, reasoning, or tool
+// calls rather than just a role or finish reason.
+func hasContent(chunk *ChatCompletionChunk) bool {
+	for _, choice := range chunk.Choices {
+		d := choice.Delta
+		if (d.Content != nil && *d.Content != "") || (d.ReasoningContent != nil && *d.ReasoningContent != "") || len(d.ToolCalls) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // Close terminates the streaming response and releases resources, including
 // killing the underlying claude CLI process. After Close, any pending or
 // future calls to [ChatCompletionStream.Recv] return [io.EOF].
 func (cs *ChatCompletionStream) Close() error {
 	cs.err = io.EOF
-	return cs.raw.Close()
+	err := cs.raw.Close()
+	cs.cancel()
+	return err
 }
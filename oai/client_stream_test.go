@@ -0,0 +1,57 @@
+package oai
+
+import "testing"
+
+func TestChunkQueue_FIFO(t *testing.T) {
+	var q chunkQueue
+	if _, ok := q.pop(); ok {
+		t.Fatal("pop on empty queue should report false")
+	}
+
+	a, b, c := &ChatCompletionChunk{ID: "a"}, &ChatCompletionChunk{ID: "b"}, &ChatCompletionChunk{ID: "c"}
+	q.push([]*ChatCompletionChunk{a, b})
+	q.push([]*ChatCompletionChunk{c})
+
+	for _, want := range []string{"a", "b", "c"} {
+		got, ok := q.pop()
+		if !ok {
+			t.Fatalf("expected chunk %s, queue empty", want)
+		}
+		if got.ID != want {
+			t.Errorf("pop = %s, want %s", got.ID, want)
+		}
+	}
+	if _, ok := q.pop(); ok {
+		t.Error("queue should be empty after draining")
+	}
+
+	// Refilling a drained queue reuses the pushed slice
+	q.push([]*ChatCompletionChunk{a})
+	if got, _ := q.pop(); got != a {
+		t.Errorf("pop after refill = %v, want a", got)
+	}
+}
+
+// BenchmarkChunkQueue_DrainFinish measures draining a large FinishChunk-sized
+// batch through the queue the way Recv does. The read offset keeps draining
+// allocation-free regardless of batch size.
+func BenchmarkChunkQueue_DrainFinish(b *testing.B) {
+	batch := make([]*ChatCompletionChunk, 1024)
+	for i := range batch {
+		batch[i] = &ChatCompletionChunk{}
+	}
+	work := make([]*ChatCompletionChunk, len(batch))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(work, batch)
+		var q chunkQueue
+		q.push(work[1:])
+		for {
+			if _, ok := q.pop(); !ok {
+				break
+			}
+		}
+	}
+}
@@ -3,12 +3,19 @@ package oai_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"net/http"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/codewandler/cc-sdk-go/cchat"
+	"github.com/codewandler/cc-sdk-go/ccwire"
 	"github.com/codewandler/cc-sdk-go/oai"
 )
 
@@ -19,6 +26,21 @@ func requireCLI(t *testing.T) {
 	}
 }
 
+// fakeCLIClient returns a Client backed by a shell script that discards its
+// input and prints the given NDJSON lines, standing in for the claude CLI.
+// The script reports version 1.2.3 when called with --version.
+func fakeCLIClient(t *testing.T, lines ...string) *oai.Client {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "claude")
+	script := "#!/bin/sh\n" +
+		"if [ \"$1\" = --version ]; then echo '1.2.3 (Claude Code)'; exit 0; fi\n" +
+		"cat >/dev/null\ncat <<'EOF'\n" + strings.Join(lines, "\n") + "\nEOF\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return oai.NewClient(cchat.NewClient(&cchat.ClientConfig{CLIPath: path, MaxConcurrent: 1}))
+}
+
 // testCase defines a chat completion request with validation.
 type testCase struct {
 	Name     string
@@ -626,3 +648,908 @@ func TestTimingSummary(t *testing.T) {
 			status)
 	}
 }
+
+func TestCreateChatCompletionRaw(t *testing.T) {
+	client := fakeCLIClient(t,
+		`{"type":"system","subtype":"init","session_id":"sess-1","model":"claude-haiku"}`,
+		`{"type":"assistant","message":{"model":"claude-haiku","content":[{"type":"text","text":"PONG"}]}}`,
+		`{"type":"result","subtype":"success","session_id":"sess-1","result":"PONG"}`,
+	)
+
+	raw, resp, err := client.CreateChatCompletionRaw(context.Background(), oai.ChatCompletionRequest{
+		Model:    "haiku",
+		Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}},
+	})
+	if err != nil {
+		t.Fatalf("CreateChatCompletionRaw: %v", err)
+	}
+
+	if len(raw) != 3 {
+		t.Fatalf("len(raw) = %d, want 3", len(raw))
+	}
+	if _, ok := raw[0].(*ccwire.SystemMessage); !ok {
+		t.Errorf("raw[0] = %T, want *ccwire.SystemMessage", raw[0])
+	}
+	if _, ok := raw[2].(*ccwire.ResultMessage); !ok {
+		t.Errorf("raw[2] = %T, want *ccwire.ResultMessage", raw[2])
+	}
+	if got := resp.Choices[0].Message.StringContent(); got != "PONG" {
+		t.Errorf("content = %q, want %q", got, "PONG")
+	}
+}
+
+// TestCompletionResponseFormat checks that json_object output from the real
+// CLI comes back as parseable JSON.
+func TestCompletionResponseFormat(t *testing.T) {
+	requireCLI(t)
+	t.Parallel()
+	client := oai.NewClientDefault()
+
+	resp, err := client.CreateChatCompletion(context.Background(), oai.ChatCompletionRequest{
+		Model: "haiku",
+		Messages: []oai.ChatMessage{
+			{Role: "user", Content: "Give me an object with keys city and country for the capital of France."},
+		},
+		ResponseFormat: &oai.ResponseFormat{Type: "json_object"},
+	})
+	if err != nil {
+		t.Fatalf("completion error: %v", err)
+	}
+
+	requireFinish(t, resp, "stop")
+	var out map[string]any
+	if err := json.Unmarshal([]byte(resp.Choices[0].Message.StringContent()), &out); err != nil {
+		t.Fatalf("content is not valid JSON: %v\n%s", err, resp.Choices[0].Message.StringContent())
+	}
+}
+
+func TestCreateChatCompletion_SystemFingerprintStable(t *testing.T) {
+	client := fakeCLIClient(t,
+		`{"type":"assistant","message":{"model":"claude-haiku","content":[{"type":"text","text":"PONG"}]}}`,
+		`{"type":"result","subtype":"success","session_id":"sess-1","result":"PONG"}`,
+	)
+	req := oai.ChatCompletionRequest{
+		Model:    "haiku",
+		Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}},
+	}
+
+	first, err := client.CreateChatCompletion(context.Background(), req)
+	if err != nil {
+		t.Fatalf("first completion: %v", err)
+	}
+	second, err := client.CreateChatCompletion(context.Background(), req)
+	if err != nil {
+		t.Fatalf("second completion: %v", err)
+	}
+
+	want := oai.SystemFingerprint("claude-haiku", "1.2.3")
+	if first.SystemFingerprint == "" || first.SystemFingerprint != want {
+		t.Errorf("first fingerprint = %q, want %q", first.SystemFingerprint, want)
+	}
+	if second.SystemFingerprint != first.SystemFingerprint {
+		t.Errorf("fingerprint changed between responses: %q vs %q", first.SystemFingerprint, second.SystemFingerprint)
+	}
+}
+
+func TestCreateChatCompletionStream_DurationMS(t *testing.T) {
+	client := fakeCLIClient(t,
+		`{"type":"stream_event","event":{"type":"message_start","message":{"model":"claude-haiku"}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","delta":{"type":"text_delta","text":"PONG"}}}`,
+		`{"type":"result","subtype":"success","session_id":"sess-1","result":"PONG","duration_ms":321}`,
+	)
+
+	stream, err := client.CreateChatCompletionStream(context.Background(), oai.ChatCompletionRequest{
+		Model:    "haiku",
+		Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}},
+	})
+	if err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+	defer stream.Close()
+
+	if got := stream.DurationMS(); got != 0 {
+		t.Errorf("DurationMS before completion = %d, want 0", got)
+	}
+	for {
+		if _, err := stream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+	}
+	if got := stream.DurationMS(); got != 321 {
+		t.Errorf("DurationMS = %d, want 321", got)
+	}
+}
+
+// TestClient_DefaultCallTimeout verifies that DefaultCallTimeout becomes the
+// query timeout only for calls whose context has no deadline.
+func TestClient_DefaultCallTimeout(t *testing.T) {
+	var (
+		gotDeadline time.Time
+		gotTimeout  time.Duration
+	)
+	cc := cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(ctx context.Context, _ string, opts cchat.QueryOptions) (io.ReadCloser, error) {
+		gotDeadline, _ = ctx.Deadline()
+		gotTimeout = opts.Timeout
+		return io.NopCloser(strings.NewReader(`{"type":"result","subtype":"success","result":"PONG"}` + "\n")), nil
+	})
+	client := oai.NewClient(cc)
+	client.DefaultCallTimeout = time.Minute
+	req := oai.ChatCompletionRequest{Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}}}
+
+	withDeadline, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	hourDeadline, _ := withDeadline.Deadline()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want time.Duration // approximate time until the spawn context's deadline
+	}{
+		{"no_deadline", context.Background(), time.Minute},
+		{"deadline", withDeadline, time.Until(hourDeadline)},
+	}
+	check := func(t *testing.T, call string, want time.Duration) {
+		t.Helper()
+		if gotTimeout != 0 {
+			t.Errorf("%s QueryOptions.Timeout = %v, want 0", call, gotTimeout)
+		}
+		if gotDeadline.IsZero() {
+			t.Fatalf("%s spawn context has no deadline", call)
+		}
+		if left := time.Until(gotDeadline); left > want || left < want-10*time.Second {
+			t.Errorf("%s spawn context deadline in %v, want about %v", call, left, want)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotDeadline, gotTimeout = time.Time{}, -1
+			if _, err := client.CreateChatCompletion(tt.ctx, req); err != nil {
+				t.Fatalf("CreateChatCompletion: %v", err)
+			}
+			check(t, "CreateChatCompletion", tt.want)
+
+			gotDeadline, gotTimeout = time.Time{}, -1
+			stream, err := client.CreateChatCompletionStream(tt.ctx, req)
+			if err != nil {
+				t.Fatalf("CreateChatCompletionStream: %v", err)
+			}
+			stream.Close()
+			check(t, "CreateChatCompletionStream", tt.want)
+		})
+	}
+}
+
+// TestClient_DefaultCallTimeout_ShorterDefaultTimeout verifies that a
+// [cchat.ClientConfig].DefaultTimeout shorter than DefaultCallTimeout still
+// ends the call.
+func TestClient_DefaultCallTimeout_ShorterDefaultTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	cc := cchat.NewClientWithSpawner(&cchat.ClientConfig{DefaultTimeout: timeout}, func(ctx context.Context, _ string, _ cchat.QueryOptions) (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		context.AfterFunc(ctx, func() { pw.CloseWithError(ctx.Err()) })
+		return pr, nil
+	})
+	client := oai.NewClient(cc)
+	client.DefaultCallTimeout = time.Hour
+	req := oai.ChatCompletionRequest{Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}}}
+
+	start := time.Now()
+	_, err := client.CreateChatCompletion(context.Background(), req)
+	var timeoutErr *cchat.TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("CreateChatCompletion error = %v, want a *cchat.TimeoutError", err)
+	}
+	if timeoutErr.Timeout != timeout {
+		t.Errorf("TimeoutError.Timeout = %v, want %v", timeoutErr.Timeout, timeout)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("CreateChatCompletion took %v, want about %v", elapsed, timeout)
+	}
+}
+
+// TestCreateChatCompletionStream_NoResult verifies that a stream whose
+// process exits without a result message still ends with a finish chunk,
+// exactly once.
+func TestCreateChatCompletionStream_NoResult(t *testing.T) {
+	client := fakeCLIClient(t,
+		`{"type":"stream_event","event":{"type":"message_start","message":{"model":"claude-haiku"}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","delta":{"type":"text_delta","text":"PONG"}}}`,
+		`{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"PONG"}]}}`,
+	)
+
+	stream, err := client.CreateChatCompletionStream(context.Background(), oai.ChatCompletionRequest{
+		Model:    "haiku",
+		Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}},
+	})
+	if err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+	defer stream.Close()
+
+	var content strings.Builder
+	var reasons []string
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != nil {
+				content.WriteString(*choice.Delta.Content)
+			}
+			if choice.FinishReason != nil {
+				reasons = append(reasons, *choice.FinishReason)
+			}
+		}
+	}
+	if content.String() != "PONG" {
+		t.Errorf("content = %q, want %q", content.String(), "PONG")
+	}
+	if len(reasons) != 1 || reasons[0] != "stop" {
+		t.Errorf("finish reasons = %q, want [stop]", reasons)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Recv after EOF = %v, want io.EOF", err)
+	}
+}
+
+// TestCreateChatCompletionStream_FirstChunkLatency verifies that the latency
+// is recorded at the first content chunk, not the role chunk before it.
+func TestCreateChatCompletionStream_FirstChunkLatency(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	cc := cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+		r, w := io.Pipe()
+		go func() {
+			io.WriteString(w, `{"type":"stream_event","event":{"type":"message_start","message":{"model":"claude-haiku"}}}`+"\n")
+			time.Sleep(delay)
+			io.WriteString(w, `{"type":"stream_event","event":{"type":"content_block_delta","delta":{"type":"text_delta","text":"PONG"}}}`+"\n")
+			io.WriteString(w, `{"type":"result","subtype":"success","result":"PONG"}`+"\n")
+			w.Close()
+		}()
+		return r, nil
+	})
+	stream, err := oai.NewClient(cc).CreateChatCompletionStream(context.Background(), oai.ChatCompletionRequest{
+		Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}},
+	})
+	if err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+	defer stream.Close()
+
+	if chunk, err := stream.Recv(); err != nil || chunk.Choices[0].Delta.Role != "assistant" {
+		t.Fatalf("first Recv = %+v, %v, want the role chunk", chunk, err)
+	}
+	if got := stream.FirstChunkLatency(); got != 0 {
+		t.Errorf("FirstChunkLatency after role chunk = %v, want 0", got)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	latency := stream.FirstChunkLatency()
+	if latency < delay {
+		t.Errorf("FirstChunkLatency = %v, want at least %v", latency, delay)
+	}
+	for {
+		if _, err := stream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+	}
+	if got := stream.FirstChunkLatency(); got != latency {
+		t.Errorf("FirstChunkLatency changed to %v after %v", got, latency)
+	}
+}
+
+// TestCreateChatCompletion_UpstreamErrors verifies that API failures reported
+// by the CLI surface as APIErrors with the matching type and status.
+func TestCreateChatCompletion_UpstreamErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		errField   string
+		wantType   string
+		wantStatus int
+	}{
+		{"rate_limit", "rate_limit", "rate_limit_exceeded", http.StatusTooManyRequests},
+		{"overloaded", "overloaded_error", "overloaded_error", http.StatusServiceUnavailable},
+		{"authentication", "authentication_failed", "authentication_error", http.StatusUnauthorized},
+		{"api_error", "api_error", "api_error", http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fakeCLIClient(t,
+				`{"type":"assistant","error":"`+tt.errField+`","message":{"content":[{"type":"text","text":"upstream said no"}]}}`,
+			)
+			_, err := client.CreateChatCompletion(context.Background(), oai.ChatCompletionRequest{
+				Model:    "haiku",
+				Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}},
+			})
+			var apiErr *oai.APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v, want *oai.APIError", err)
+			}
+			if apiErr.Type != tt.wantType || apiErr.Status != tt.wantStatus {
+				t.Errorf("type/status = %q/%d, want %q/%d", apiErr.Type, apiErr.Status, tt.wantType, tt.wantStatus)
+			}
+			if apiErr.Message != "upstream said no" {
+				t.Errorf("message = %q, want the CLI's text", apiErr.Message)
+			}
+		})
+	}
+}
+
+// TestChatCompletionStream_RateLimit verifies that a rate limit reported
+// mid-stream surfaces from Recv as a typed APIError, as it does for
+// CreateChatCompletion.
+func TestChatCompletionStream_RateLimit(t *testing.T) {
+	output := `{"type":"stream_event","event":{"type":"message_start","message":{"model":"claude-haiku"}}}` + "\n" +
+		`{"type":"assistant","error":"rate_limit","message":{"content":[{"type":"text","text":"slow down"}]}}` + "\n"
+	cc := cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(output)), nil
+	})
+	client := oai.NewClient(cc)
+	req := oai.ChatCompletionRequest{Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}}}
+
+	stream, err := client.CreateChatCompletionStream(context.Background(), req)
+	if err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+	defer stream.Close()
+	for err == nil {
+		_, err = stream.Recv()
+	}
+
+	var apiErr *oai.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Recv error = %v (%T), want *oai.APIError", err, err)
+	}
+	if apiErr.Type != "rate_limit_exceeded" || apiErr.Code != "rate_limit" || apiErr.Status != http.StatusTooManyRequests || apiErr.Message != "slow down" {
+		t.Errorf("APIError = %+v, want rate_limit_exceeded/rate_limit/429", apiErr)
+	}
+	var rateErr *cchat.RateLimitError
+	if !errors.As(err, &rateErr) {
+		t.Errorf("Recv error = %v, want a *cchat.RateLimitError cause", err)
+	}
+	if _, again := stream.Recv(); again != err {
+		t.Errorf("later Recv error = %v, want the same error", again)
+	}
+
+	_, err = client.CreateChatCompletion(context.Background(), req)
+	if !errors.As(err, &apiErr) || apiErr.Type != "rate_limit_exceeded" || apiErr.Code != "rate_limit" {
+		t.Errorf("CreateChatCompletion error = %v, want the same rate limit APIError", err)
+	}
+}
+
+// TestCreateChatCompletion_ErrorCause verifies that errors.As finds the cchat
+// error behind an APIError.
+func TestCreateChatCompletion_ErrorCause(t *testing.T) {
+	req := oai.ChatCompletionRequest{Model: "haiku", Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}}}
+
+	client := fakeCLIClient(t, `{"type":"assistant","error":"rate_limit","message":{"content":[{"type":"text","text":"slow down"}]}}`)
+	_, err := client.CreateChatCompletion(context.Background(), req)
+	var rateErr *cchat.RateLimitError
+	if !errors.As(err, &rateErr) || rateErr.Message != "slow down" {
+		t.Errorf("err = %v, want a *cchat.RateLimitError cause", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "claude")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\ncat >/dev/null\necho boom >&2\nexit 3\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	client = oai.NewClient(cchat.NewClient(&cchat.ClientConfig{CLIPath: path}))
+	_, err = client.CreateChatCompletion(context.Background(), req)
+	var apiErr *oai.APIError
+	var procErr *cchat.ProcessError
+	if !errors.As(err, &apiErr) || !errors.As(err, &procErr) || procErr.ExitCode != 3 {
+		t.Errorf("err = %v, want an *oai.APIError with a *cchat.ProcessError cause", err)
+	}
+
+	client.Effort = "extreme"
+	_, err = client.CreateChatCompletion(context.Background(), req)
+	if !errors.As(err, &apiErr) || apiErr.Unwrap() == nil {
+		t.Errorf("err = %v, want an *oai.APIError with a cause", err)
+	}
+}
+
+func TestCreateChatCompletion_AllowedModels(t *testing.T) {
+	spawn := func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(`{"type":"result","subtype":"success","session_id":"s1","result":"ok"}` + "\n")), nil
+	}
+	req := oai.ChatCompletionRequest{Model: "sonet", Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}}}
+
+	client := oai.NewClient(cchat.NewClientWithSpawner(&cchat.ClientConfig{AllowedModels: cchat.DefaultModels}, spawn))
+	_, err := client.CreateChatCompletion(context.Background(), req)
+	var apiErr *oai.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *oai.APIError", err)
+	}
+	if apiErr.Type != "invalid_request_error" || apiErr.Status != http.StatusBadRequest {
+		t.Errorf("type/status = %q/%d, want invalid_request_error/400", apiErr.Type, apiErr.Status)
+	}
+	if !strings.Contains(apiErr.Message, `"sonet"`) || !strings.Contains(apiErr.Message, "sonnet, opus, haiku") {
+		t.Errorf("message = %q, want the bad model and the valid choices", apiErr.Message)
+	}
+
+	client = oai.NewClient(cchat.NewClientWithSpawner(&cchat.ClientConfig{}, spawn))
+	if _, err := client.CreateChatCompletion(context.Background(), req); err != nil {
+		t.Errorf("without AllowedModels, any model should pass through: %v", err)
+	}
+}
+
+func TestListModels_AllowedModels(t *testing.T) {
+	list := func(cfg *cchat.ClientConfig) []string {
+		models, _ := oai.NewClient(cchat.NewClient(cfg)).ListModels(context.Background())
+		var ids []string
+		for _, m := range models {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+	if got := strings.Join(list(&cchat.ClientConfig{}), ","); got != "sonnet,opus,haiku" {
+		t.Errorf("default models = %s", got)
+	}
+	cfg := &cchat.ClientConfig{
+		AllowedModels: append(cchat.DefaultModels, "claude-opus-4-1"),
+		ModelAliases:  map[string]string{"gpt-4o": "opus"},
+	}
+	if got := strings.Join(list(cfg), ","); got != "sonnet,opus,haiku,claude-opus-4-1,gpt-4o" {
+		t.Errorf("allowed models = %s", got)
+	}
+}
+
+func TestListModels_Metadata(t *testing.T) {
+	cfg := &cchat.ClientConfig{Models: []cchat.ModelInfo{
+		{ID: "sonnet", FullID: "claude-sonnet-4-5-20250929", ContextWindow: 1000000, Tools: true},
+	}}
+	models, _ := oai.NewClient(cchat.NewClient(cfg)).ListModels(context.Background())
+	want := []oai.Model{{
+		ID: "sonnet", Object: "model", OwnedBy: "anthropic",
+		FullID: "claude-sonnet-4-5-20250929", ContextWindow: 1000000, SupportsTools: true,
+	}}
+	if !reflect.DeepEqual(models, want) {
+		t.Errorf("models = %+v, want %+v", models, want)
+	}
+
+	data, _ := json.Marshal(models[0])
+	if got := string(data); got != `{"id":"sonnet","object":"model","owned_by":"anthropic","x_cc_full_id":"claude-sonnet-4-5-20250929","x_cc_context_window":1000000,"x_cc_supports_tools":true}` {
+		t.Errorf("JSON = %s", got)
+	}
+}
+
+func TestAssembleStream(t *testing.T) {
+	client := fakeCLIClient(t,
+		`{"type":"system","subtype":"init","session_id":"sess-1","model":"claude-haiku-4-5"}`,
+		`{"type":"stream_event","event":{"type":"message_start","message":{"model":"claude-haiku"}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","delta":{"type":"text_delta","text":"PO"}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","delta":{"type":"text_delta","text":"NG"}}}`,
+		`{"type":"assistant","message":{"model":"claude-haiku","content":[{"type":"text","text":"PONG"}]}}`,
+		`{"type":"result","subtype":"success","session_id":"sess-1","result":"PONG","duration_ms":321}`,
+	)
+
+	stream, err := client.CreateChatCompletionStream(context.Background(), oai.ChatCompletionRequest{
+		Model:    "haiku",
+		Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}},
+	})
+	if err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+	defer stream.Close()
+
+	resp, err := oai.AssembleStream(stream)
+	if err != nil {
+		t.Fatalf("AssembleStream: %v", err)
+	}
+	if resp.Object != "chat.completion" || resp.Model != "claude-haiku" || resp.DurationMS != 321 {
+		t.Errorf("resp = %+v", resp)
+	}
+	if resp.SystemInfo == nil || resp.SystemInfo.Model != "claude-haiku-4-5" {
+		t.Errorf("system info = %+v", resp.SystemInfo)
+	}
+	requireFinish(t, resp, "stop")
+	if got := resp.Choices[0].Message.StringContent(); got != "PONG" {
+		t.Errorf("content = %q, want PONG", got)
+	}
+}
+
+// TestCreateChatCompletion_ReasoningEffort verifies that a request's
+// reasoning_effort overrides the client's Effort and is validated.
+func TestCreateChatCompletion_ReasoningEffort(t *testing.T) {
+	var got string
+	cc := cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(_ context.Context, _ string, opts cchat.QueryOptions) (io.ReadCloser, error) {
+		got = opts.Effort
+		return io.NopCloser(strings.NewReader(`{"type":"result","subtype":"success","result":"ok"}` + "\n")), nil
+	})
+	client := oai.NewClient(cc)
+	client.Effort = oai.EffortLow
+	msgs := []oai.ChatMessage{{Role: "user", Content: "ping"}}
+
+	tests := []struct {
+		name      string
+		requested string
+		want      string
+	}{
+		{"client_default", "", "low"},
+		{"request_override", "high", "high"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := oai.ChatCompletionRequest{Messages: msgs, ReasoningEffort: tt.requested}
+			if _, err := client.CreateChatCompletion(context.Background(), req); err != nil {
+				t.Fatalf("CreateChatCompletion: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("effort = %q, want %q", got, tt.want)
+			}
+
+			stream, err := client.CreateChatCompletionStream(context.Background(), req)
+			if err != nil {
+				t.Fatalf("CreateChatCompletionStream: %v", err)
+			}
+			stream.Close()
+			if got != tt.want {
+				t.Errorf("stream effort = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		req := oai.ChatCompletionRequest{Messages: msgs, ReasoningEffort: "extreme"}
+		var apiErr *oai.APIError
+		if _, err := client.CreateChatCompletion(context.Background(), req); !errors.As(err, &apiErr) ||
+			apiErr.Type != "invalid_request_error" || apiErr.Status != http.StatusBadRequest {
+			t.Errorf("error = %v, want a 400 invalid_request_error", err)
+		}
+		if _, err := client.CreateChatCompletionStream(context.Background(), req); !errors.As(err, &apiErr) ||
+			apiErr.Type != "invalid_request_error" {
+			t.Errorf("stream error = %v, want invalid_request_error", err)
+		}
+	})
+}
+
+func TestCreateChatCompletionStream_IncludeUsage(t *testing.T) {
+	client := fakeCLIClient(t,
+		`{"type":"stream_event","event":{"type":"message_start","message":{"model":"claude-haiku"}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","delta":{"type":"text_delta","text":"PONG"}}}`,
+		`{"type":"result","subtype":"success","usage":{"input_tokens":10,"output_tokens":2}}`,
+	)
+
+	stream, err := client.CreateChatCompletionStream(context.Background(), oai.ChatCompletionRequest{
+		Model:         "haiku",
+		Messages:      []oai.ChatMessage{{Role: "user", Content: "ping"}},
+		StreamOptions: &oai.StreamOptions{IncludeUsage: true},
+	})
+	if err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+	defer stream.Close()
+
+	var chunks []*oai.ChatCompletionChunk
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want at least 2", len(chunks))
+	}
+	last := chunks[len(chunks)-1]
+	want := oai.Usage{PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12}
+	if len(last.Choices) != 0 || last.Usage == nil || *last.Usage != want {
+		t.Errorf("last chunk = %+v, want usage %+v and no choices", last, want)
+	}
+	if finish := chunks[len(chunks)-2]; len(finish.Choices) != 1 || finish.Choices[0].FinishReason == nil {
+		t.Errorf("chunk before usage = %+v, want the finish chunk", finish)
+	}
+}
+
+func TestClient_Assembler(t *testing.T) {
+	var gotPrompt, gotSystem string
+	cc := cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(_ context.Context, prompt string, opts cchat.QueryOptions) (io.ReadCloser, error) {
+		gotPrompt, gotSystem = prompt, opts.SystemPrompt
+		return io.NopCloser(strings.NewReader(`{"type":"result","subtype":"success","result":"PONG"}` + "\n")), nil
+	})
+	client := oai.NewClient(cc)
+	client.Assembler = assemblerFunc(func(req *oai.ChatCompletionRequest) (string, string) {
+		return "<turn>" + req.Messages[0].StringContent() + "</turn>", "custom"
+	})
+
+	_, err := client.CreateChatCompletion(context.Background(), oai.ChatCompletionRequest{
+		Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}},
+	})
+	if err != nil {
+		t.Fatalf("CreateChatCompletion: %v", err)
+	}
+	if gotPrompt != "<turn>ping</turn>" || gotSystem != "custom" {
+		t.Errorf("prompt, system = %q, %q; want the assembler's", gotPrompt, gotSystem)
+	}
+}
+
+// assemblerFunc implements oai.PromptAssembler with a function.
+type assemblerFunc func(req *oai.ChatCompletionRequest) (prompt, system string)
+
+func (f assemblerFunc) Assemble(req *oai.ChatCompletionRequest) (prompt, system string) {
+	return f(req)
+}
+
+// TestReplayClient records a session with cchat's raw output capture and
+// replays the recording through a client built with cchat.NewReplayClient.
+func TestReplayClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.ndjson")
+	recording, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := strings.Join([]string{
+		`{"type":"system","subtype":"init","session_id":"sess-1","model":"claude-haiku"}`,
+		`{"type":"stream_event","event":{"type":"message_start","message":{"model":"claude-haiku"}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"PO"}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"NG"}}}`,
+		`{"type":"assistant","message":{"model":"claude-haiku","role":"assistant","content":[{"type":"text","text":"PONG"}]}}`,
+		`{"type":"result","subtype":"success","result":"PONG","usage":{"input_tokens":7,"output_tokens":2}}`,
+	}, "\n") + "\n"
+	live := oai.NewClient(cchat.NewClientWithSpawner(&cchat.ClientConfig{RawOutput: recording}, func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(output)), nil
+	}))
+	req := oai.ChatCompletionRequest{Model: "haiku", Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}}}
+
+	want, err := live.CreateChatCompletion(context.Background(), req)
+	if err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+	if err := recording.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := cchat.NewReplayClient(&cchat.ClientConfig{}, path, time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewReplayClient: %v", err)
+	}
+	replay := oai.NewClient(cc)
+
+	got, err := replay.CreateChatCompletion(context.Background(), req)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if !reflect.DeepEqual(got.Choices, want.Choices) || !reflect.DeepEqual(got.Usage, want.Usage) || got.Model != want.Model {
+		t.Errorf("replayed response = %+v, want %+v", got, want)
+	}
+
+	stream, err := replay.CreateChatCompletionStream(context.Background(), req)
+	if err != nil {
+		t.Fatalf("replay stream: %v", err)
+	}
+	defer stream.Close()
+	resp, err := oai.AssembleStream(stream)
+	if err != nil {
+		t.Fatalf("AssembleStream: %v", err)
+	}
+	if content := resp.Choices[0].Message.StringContent(); content != "PONG" {
+		t.Errorf("streamed content = %q, want PONG", content)
+	}
+}
+
+func TestStreamChatCompletion(t *testing.T) {
+	output := strings.Join([]string{
+		`{"type":"stream_event","event":{"type":"message_start","message":{"model":"claude-haiku"}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"PO"}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"NG"}}}`,
+		`{"type":"result","subtype":"success","result":"PONG","usage":{"input_tokens":7,"output_tokens":2}}`,
+	}, "\n") + "\n"
+	var closed int
+	client := oai.NewClient(cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+		return &closeRecorder{Reader: strings.NewReader(output), closed: &closed}, nil
+	}))
+	req := oai.ChatCompletionRequest{Model: "haiku", Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}}}
+
+	t.Run("all chunks", func(t *testing.T) {
+		closed = 0
+		var text strings.Builder
+		var finish string
+		err := client.StreamChatCompletion(context.Background(), req, func(chunk *oai.ChatCompletionChunk) error {
+			if finish != "" {
+				t.Errorf("chunk %+v after finish_reason", chunk)
+			}
+			for _, c := range chunk.Choices {
+				if c.Delta.Content != nil {
+					text.WriteString(*c.Delta.Content)
+				}
+				if c.FinishReason != nil {
+					finish = *c.FinishReason
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("StreamChatCompletion: %v", err)
+		}
+		if text.String() != "PONG" || finish != "stop" {
+			t.Errorf("streamed %q with finish_reason %q, want PONG and stop", text.String(), finish)
+		}
+		if closed != 1 {
+			t.Errorf("stream closed %d times, want 1", closed)
+		}
+	})
+
+	t.Run("early return", func(t *testing.T) {
+		closed = 0
+		errStop := errors.New("stop")
+		var calls int
+		err := client.StreamChatCompletion(context.Background(), req, func(*oai.ChatCompletionChunk) error {
+			calls++
+			return errStop
+		})
+		if err != errStop {
+			t.Errorf("err = %v, want the callback's error", err)
+		}
+		if calls != 1 {
+			t.Errorf("callback called %d times, want 1", calls)
+		}
+		if closed != 1 {
+			t.Errorf("stream closed %d times, want 1", closed)
+		}
+	})
+}
+
+// closeRecorder is an io.ReadCloser that counts calls to Close.
+type closeRecorder struct {
+	io.Reader
+	closed *int
+}
+
+func (r *closeRecorder) Close() error {
+	*r.closed++
+	return nil
+}
+
+func TestCreateChatCompletion_EmptyRetries(t *testing.T) {
+	const empty = `{"type":"result","subtype":"success","result":""}`
+	const pong = `{"type":"assistant","message":{"model":"claude-haiku","content":[{"type":"text","text":"PONG"}]}}` + "\n" +
+		`{"type":"result","subtype":"success","result":"PONG"}`
+	req := oai.ChatCompletionRequest{Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}}}
+
+	tests := []struct {
+		name        string
+		retries     int
+		outputs     []string
+		wantContent string
+		wantCalls   int
+	}{
+		{"disabled", 0, []string{empty, pong}, "", 1},
+		{"retried", 2, []string{empty, pong}, "PONG", 2},
+		{"exhausted", 1, []string{empty, empty, pong}, "", 2},
+		{"not empty", 2, []string{pong, empty}, "PONG", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prompts []string
+			client := oai.NewClient(cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(_ context.Context, prompt string, _ cchat.QueryOptions) (io.ReadCloser, error) {
+				output := tt.outputs[len(prompts)]
+				prompts = append(prompts, prompt)
+				return io.NopCloser(strings.NewReader(output + "\n")), nil
+			}))
+			client.EmptyRetries = tt.retries
+
+			resp, err := client.CreateChatCompletion(context.Background(), req)
+			if err != nil {
+				t.Fatalf("CreateChatCompletion: %v", err)
+			}
+			if got := resp.Choices[0].Message.StringContent(); got != tt.wantContent {
+				t.Errorf("content = %q, want %q", got, tt.wantContent)
+			}
+			if len(prompts) != tt.wantCalls {
+				t.Errorf("spawned %d times, want %d", len(prompts), tt.wantCalls)
+			}
+			for _, p := range prompts[1:] {
+				if p != prompts[0] {
+					t.Errorf("retry prompt = %q, want %q", p, prompts[0])
+				}
+			}
+		})
+	}
+
+	t.Run("context done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var calls int
+		client := oai.NewClient(cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+			calls++
+			cancel()
+			return io.NopCloser(strings.NewReader(empty + "\n")), nil
+		}))
+		client.EmptyRetries = 3
+
+		if _, err := client.CreateChatCompletion(ctx, req); err != nil && !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want nil or context.Canceled", err)
+		}
+		if calls != 1 {
+			t.Errorf("spawned %d times after the context was cancelled, want 1", calls)
+		}
+	})
+}
+
+func TestClient_EmptyResponseText(t *testing.T) {
+	const (
+		placeholder = "(no reply)"
+		empty       = `{"type":"result","subtype":"success","result":""}`
+		refusal     = `{"type":"assistant","message":{"model":"claude-haiku","content":[],"stop_reason":"refusal"}}` + "\n" + empty
+		pong        = `{"type":"stream_event","event":{"type":"content_block_delta","delta":{"type":"text_delta","text":"PONG"}}}` + "\n" +
+			`{"type":"assistant","message":{"model":"claude-haiku","content":[{"type":"text","text":"PONG"}]}}` + "\n" +
+			`{"type":"result","subtype":"success","result":"PONG"}`
+	)
+	req := oai.ChatCompletionRequest{Messages: []oai.ChatMessage{{Role: "user", Content: "ping"}}}
+
+	tests := []struct {
+		name        string
+		retries     int
+		outputs     []string
+		wantContent string
+		wantFinish  string
+	}{
+		{"empty", 0, []string{empty}, placeholder, "stop"},
+		{"refusal", 0, []string{refusal}, placeholder, oai.FinishReasonContentFilter},
+		{"not empty", 0, []string{pong}, "PONG", "stop"},
+		{"after retries", 1, []string{empty, empty}, placeholder, "stop"},
+		{"retried", 1, []string{empty, pong}, "PONG", "stop"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			client := oai.NewClient(cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+				output := tt.outputs[min(calls, len(tt.outputs)-1)]
+				calls++
+				return io.NopCloser(strings.NewReader(output + "\n")), nil
+			}))
+			client.EmptyRetries = tt.retries
+			client.EmptyResponseText = placeholder
+
+			resp, err := client.CreateChatCompletion(context.Background(), req)
+			if err != nil {
+				t.Fatalf("CreateChatCompletion: %v", err)
+			}
+			if got := resp.Choices[0].Message.StringContent(); got != tt.wantContent {
+				t.Errorf("content = %q, want %q", got, tt.wantContent)
+			}
+			if got := resp.Choices[0].FinishReason; got != tt.wantFinish {
+				t.Errorf("finish_reason = %q, want %q", got, tt.wantFinish)
+			}
+			if tt.retries > 0 && calls != 2 {
+				t.Errorf("spawned %d times, want 2", calls)
+			}
+
+			if tt.retries > 0 {
+				return // streams are not retried
+			}
+			calls = 0
+			var content strings.Builder
+			var finish string
+			err = client.StreamChatCompletion(context.Background(), req, func(chunk *oai.ChatCompletionChunk) error {
+				for _, choice := range chunk.Choices {
+					if choice.Delta.Content != nil {
+						content.WriteString(*choice.Delta.Content)
+					}
+					if choice.FinishReason != nil {
+						finish = *choice.FinishReason
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("StreamChatCompletion: %v", err)
+			}
+			if content.String() != tt.wantContent {
+				t.Errorf("streamed content = %q, want %q", content.String(), tt.wantContent)
+			}
+			if finish != tt.wantFinish {
+				t.Errorf("streamed finish_reason = %q, want %q", finish, tt.wantFinish)
+			}
+		})
+	}
+}
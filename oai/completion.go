@@ -0,0 +1,164 @@
+package oai
+
+import "errors"
+
+// CompletionRequest represents a legacy OpenAI text completion request, as
+// accepted by the /v1/completions endpoint. Instead of a messages array it
+// carries a flat Prompt, which [CompletionRequest.ChatRequest] translates into
+// a single user message so the regular chat bridge can be reused.
+//
+// Prompt may be a plain string or an array containing a single string.
+// Temperature, TopP, and MaxTokens are handled as on [ChatCompletionRequest].
+// Stop and N are accepted for API compatibility but are not forwarded to the
+// Claude Code CLI.
+type CompletionRequest struct {
+	Model       string   `json:"model"`
+	Prompt      any      `json:"prompt"`
+	Stream      bool     `json:"stream,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	Stop        any      `json:"stop,omitempty"`
+	N           *int     `json:"n,omitempty"`
+	User        string   `json:"user,omitempty"`
+}
+
+// PromptString returns the request's prompt as a single string. It accepts a
+// plain JSON string or an array holding exactly one string. Batched prompts
+// (arrays with more than one element) are not supported and return an error,
+// as does a missing or empty prompt.
+func (r *CompletionRequest) PromptString() (string, error) {
+	var prompt string
+	switch v := r.Prompt.(type) {
+	case string:
+		prompt = v
+	case []string:
+		if len(v) != 1 {
+			return "", errors.New("exactly one prompt is supported")
+		}
+		prompt = v[0]
+	case []any:
+		if len(v) != 1 {
+			return "", errors.New("exactly one prompt is supported")
+		}
+		s, ok := v[0].(string)
+		if !ok {
+			return "", errors.New("prompt must be a string")
+		}
+		prompt = s
+	case nil:
+	default:
+		return "", errors.New("prompt must be a string")
+	}
+	if prompt == "" {
+		return "", errors.New("prompt is required")
+	}
+	return prompt, nil
+}
+
+// ChatRequest converts the legacy completion request into an equivalent
+// [ChatCompletionRequest] holding a single user message with the prompt text.
+// The model, streaming flag, and pass-through sampling fields are copied over.
+func (r *CompletionRequest) ChatRequest() (*ChatCompletionRequest, error) {
+	prompt, err := r.PromptString()
+	if err != nil {
+		return nil, err
+	}
+	return &ChatCompletionRequest{
+		Model:       r.Model,
+		Messages:    []ChatMessage{{Role: "user", Content: prompt}},
+		Stream:      r.Stream,
+		MaxTokens:   r.MaxTokens,
+		Temperature: r.Temperature,
+		TopP:        r.TopP,
+		Stop:        r.Stop,
+		N:           r.N,
+		User:        r.User,
+	}, nil
+}
+
+// CompletionResponse represents a legacy OpenAI text completion response.
+// Object is always "text_completion". It is produced from a
+// [ChatCompletionResponse] by [CompletionFromChat].
+type CompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"` // "text_completion"
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   *Usage             `json:"usage,omitempty"`
+}
+
+// CompletionChoice is a single legacy completion alternative. Text carries the
+// generated output; Logprobs is always null since the Claude Code CLI does not
+// report token probabilities. FinishReason is nil for intermediate streaming
+// chunks and non-nil on the final one.
+type CompletionChoice struct {
+	Text         string  `json:"text"`
+	Index        int     `json:"index"`
+	Logprobs     any     `json:"logprobs"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+// CompletionChunk represents a single server-sent event in a streaming legacy
+// completion response. Object is always "text_completion.chunk".
+type CompletionChunk struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"` // "text_completion.chunk"
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+}
+
+// CompletionFromChat reshapes a chat completion response into the legacy
+// text_completion form. Each choice's message content becomes the choice Text.
+// Tool calls have no legacy representation and are dropped.
+func CompletionFromChat(resp *ChatCompletionResponse) *CompletionResponse {
+	out := &CompletionResponse{
+		ID:      resp.ID,
+		Object:  "text_completion",
+		Created: resp.Created,
+		Model:   resp.Model,
+		Usage:   resp.Usage,
+	}
+	for _, c := range resp.Choices {
+		reason := c.FinishReason
+		out.Choices = append(out.Choices, CompletionChoice{
+			Text:         c.Message.StringContent(),
+			Index:        c.Index,
+			FinishReason: &reason,
+		})
+	}
+	return out
+}
+
+// CompletionChunkFromChat reshapes a streaming chat chunk into a legacy
+// text_completion.chunk. It returns nil for chunks that carry nothing
+// representable in the legacy format, such as the initial role-only chunk or
+// tool call deltas without a finish reason.
+func CompletionChunkFromChat(chunk *ChatCompletionChunk) *CompletionChunk {
+	out := &CompletionChunk{
+		ID:      chunk.ID,
+		Object:  "text_completion.chunk",
+		Created: chunk.Created,
+		Model:   chunk.Model,
+	}
+	for _, c := range chunk.Choices {
+		if c.Delta.Content == nil && c.FinishReason == nil {
+			continue
+		}
+		var text string
+		if c.Delta.Content != nil {
+			text = *c.Delta.Content
+		}
+		out.Choices = append(out.Choices, CompletionChoice{
+			Text:         text,
+			Index:        c.Index,
+			FinishReason: c.FinishReason,
+		})
+	}
+	if len(out.Choices) == 0 {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,137 @@
+package oai
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompletionRequest_PromptString(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{name: "string", body: `{"prompt":"Say hi"}`, want: "Say hi"},
+		{name: "single_element_array", body: `{"prompt":["Say hi"]}`, want: "Say hi"},
+		{name: "missing", body: `{}`, wantErr: true},
+		{name: "empty_string", body: `{"prompt":""}`, wantErr: true},
+		{name: "batched", body: `{"prompt":["a","b"]}`, wantErr: true},
+		{name: "token_array", body: `{"prompt":[1,2,3]}`, wantErr: true},
+		{name: "number", body: `{"prompt":42}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req CompletionRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			got, err := req.PromptString()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got prompt %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("prompt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompletionRequest_ChatRequest(t *testing.T) {
+	req := CompletionRequest{Model: "haiku", Prompt: "Once upon a time", Stream: true}
+
+	chat, err := req.ChatRequest()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if chat.Model != "haiku" || !chat.Stream {
+		t.Errorf("model/stream not copied: %+v", chat)
+	}
+	if len(chat.Messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(chat.Messages))
+	}
+	if chat.Messages[0].Role != "user" || chat.Messages[0].StringContent() != "Once upon a time" {
+		t.Errorf("unexpected message: %+v", chat.Messages[0])
+	}
+
+	prompt, _ := RequestToQuery(chat)
+	if prompt != "[user]: Once upon a time" {
+		t.Errorf("prompt = %q", prompt)
+	}
+}
+
+func TestCompletionFromChat(t *testing.T) {
+	resp := &ChatCompletionResponse{
+		ID:      "chatcmpl-abc",
+		Object:  "chat.completion",
+		Created: 123,
+		Model:   "claude-haiku",
+		Choices: []Choice{{
+			Index:        0,
+			Message:      ChatMessage{Role: "assistant", Content: "Hello there"},
+			FinishReason: "stop",
+		}},
+		Usage: &Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5},
+	}
+
+	out := CompletionFromChat(resp)
+	if out.Object != "text_completion" {
+		t.Errorf("object = %q, want text_completion", out.Object)
+	}
+	if out.ID != resp.ID || out.Model != resp.Model || out.Created != resp.Created {
+		t.Errorf("metadata not copied: %+v", out)
+	}
+	if len(out.Choices) != 1 || out.Choices[0].Text != "Hello there" {
+		t.Fatalf("unexpected choices: %+v", out.Choices)
+	}
+	if out.Choices[0].FinishReason == nil || *out.Choices[0].FinishReason != "stop" {
+		t.Errorf("finish_reason = %v, want stop", out.Choices[0].FinishReason)
+	}
+	if out.Usage != resp.Usage {
+		t.Error("usage not carried over")
+	}
+
+	data, _ := json.Marshal(out)
+	var raw map[string]any
+	json.Unmarshal(data, &raw)
+	choice := raw["choices"].([]any)[0].(map[string]any)
+	if _, ok := choice["message"]; ok {
+		t.Error("legacy choice must not contain message")
+	}
+	if choice["text"] != "Hello there" {
+		t.Errorf("text = %v", choice["text"])
+	}
+}
+
+func TestCompletionChunkFromChat(t *testing.T) {
+	ss := NewStreamState(false)
+	ss.Model = "claude-haiku"
+
+	if c := CompletionChunkFromChat(ss.InitChunk()); c != nil {
+		t.Errorf("role-only init chunk should be skipped, got %+v", c)
+	}
+
+	c := CompletionChunkFromChat(ss.TextDeltaChunk("Hi"))
+	if c == nil {
+		t.Fatal("content chunk should not be skipped")
+	}
+	if c.Object != "text_completion.chunk" {
+		t.Errorf("object = %q", c.Object)
+	}
+	if c.Choices[0].Text != "Hi" || c.Choices[0].FinishReason != nil {
+		t.Errorf("unexpected choice: %+v", c.Choices[0])
+	}
+
+	finish := ss.FinishChunk(nil)
+	c = CompletionChunkFromChat(finish[len(finish)-1])
+	if c == nil || c.Choices[0].FinishReason == nil || *c.Choices[0].FinishReason != "stop" {
+		t.Fatalf("expected stop finish chunk, got %+v", c)
+	}
+}
@@ -0,0 +1,64 @@
+package oai
+
+import (
+	"strings"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+)
+
+// ModelPrice is the price of a model in US dollars per million tokens.
+// CacheWrite and CacheRead price the input tokens written to and read from
+// the prompt cache; Input prices the remaining input tokens.
+type ModelPrice struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheWrite float64 `json:"cache_write"`
+	CacheRead  float64 `json:"cache_read"`
+}
+
+// PriceTable maps model names to their prices, for computing a cost when
+// the CLI does not report one; see [ComputeCost]. A key matches the model
+// it names and any model starting with it, so "claude-sonnet-4-5" also
+// covers the dated "claude-sonnet-4-5-20250929". The longest matching key
+// wins.
+type PriceTable map[string]ModelPrice
+
+// Lookup returns the price for model: the entry with the longest key that
+// model starts with. ok is false if no key matches.
+func (t PriceTable) Lookup(model string) (price ModelPrice, ok bool) {
+	if price, ok := t[model]; ok {
+		return price, true
+	}
+	best := -1
+	for key, p := range t {
+		if len(key) > best && strings.HasPrefix(model, key) {
+			price, best = p, len(key)
+		}
+	}
+	return price, best >= 0
+}
+
+// Cost returns the cost of result in US dollars: the TotalCostUSD reported
+// by the CLI, or, when that is zero, the cost computed from its token usage
+// with [ComputeCost].
+func (t PriceTable) Cost(model string, result *ccwire.ResultMessage) float64 {
+	if result.TotalCostUSD != 0 {
+		return result.TotalCostUSD
+	}
+	return ComputeCost(model, result.Usage, t)
+}
+
+// ComputeCost returns the cost in US dollars of usage by model, priced with
+// the entry of table for model; see [PriceTable.Lookup]. It returns 0 if
+// table has no price for model.
+func ComputeCost(model string, usage ccwire.ResultUsage, table PriceTable) float64 {
+	price, ok := table.Lookup(model)
+	if !ok {
+		return 0
+	}
+	cost := float64(usage.InputTokens)*price.Input +
+		float64(usage.OutputTokens)*price.Output +
+		float64(usage.CacheCreationInputTokens)*price.CacheWrite +
+		float64(usage.CacheReadInputTokens)*price.CacheRead
+	return cost / 1e6
+}
@@ -0,0 +1,54 @@
+package oai
+
+import (
+	"math"
+	"testing"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+)
+
+var testPrices = PriceTable{
+	"claude-sonnet-4-5": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3},
+	"claude-sonnet":     {Input: 1, Output: 1},
+	"claude-haiku-4-5":  {Input: 1, Output: 5},
+}
+
+func TestComputeCost(t *testing.T) {
+	usage := ccwire.ResultUsage{
+		InputTokens:              1000,
+		OutputTokens:             2000,
+		CacheCreationInputTokens: 10000,
+		CacheReadInputTokens:     100000,
+	}
+
+	tests := []struct {
+		model string
+		want  float64
+	}{
+		// 1000*3 + 2000*15 + 10000*3.75 + 100000*0.3 = 100500 per million
+		{"claude-sonnet-4-5", 0.1005},
+		{"claude-sonnet-4-5-20250929", 0.1005},
+		{"claude-sonnet-4-0", 0.003},
+		{"claude-haiku-4-5", 0.011},
+		{"claude-opus-4-1", 0},
+	}
+	for _, tt := range tests {
+		if got := ComputeCost(tt.model, usage, testPrices); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("ComputeCost(%q) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+	if got := ComputeCost("claude-sonnet-4-5", usage, nil); got != 0 {
+		t.Errorf("ComputeCost with nil table = %v, want 0", got)
+	}
+}
+
+func TestPriceTable_Cost(t *testing.T) {
+	result := &ccwire.ResultMessage{Usage: ccwire.ResultUsage{InputTokens: 1000000, OutputTokens: 100000}}
+	if got := testPrices.Cost("claude-haiku-4-5", result); math.Abs(got-1.5) > 1e-9 {
+		t.Errorf("Cost without CLI cost = %v, want 1.5", got)
+	}
+	result.TotalCostUSD = 0.42
+	if got := testPrices.Cost("claude-haiku-4-5", result); got != 0.42 {
+		t.Errorf("Cost with CLI cost = %v, want 0.42", got)
+	}
+}
@@ -0,0 +1,43 @@
+package oai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MarshalHistory writes msgs to w as JSON Lines, one [ChatMessage] per line,
+// in the same JSON shape as a request's messages array. Appending the output
+// of several calls to one file yields a valid history, so a conversation can
+// be saved incrementally.
+func MarshalHistory(w io.Writer, msgs []ChatMessage) error {
+	enc := json.NewEncoder(w)
+	for _, m := range msgs {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnmarshalHistory reads a history written by [MarshalHistory] and returns
+// its messages in order. Content that was a plain string decodes as a string;
+// content parts decode as a []any of JSON objects, which
+// [ChatMessage.StringContent] understands. Tool calls and tool call IDs
+// round-trip unchanged. An empty reader yields no messages and no error.
+func UnmarshalHistory(r io.Reader) ([]ChatMessage, error) {
+	dec := json.NewDecoder(r)
+	var msgs []ChatMessage
+	for {
+		var m ChatMessage
+		err := dec.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("history message %d: %w", len(msgs)+1, err)
+		}
+		msgs = append(msgs, m)
+	}
+}
@@ -0,0 +1,68 @@
+package oai
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHistory_RoundTrip(t *testing.T) {
+	msgs := []ChatMessage{
+		{Role: "system", Content: "You are a helpful assistant."},
+		{Role: "user", Content: "Weather in Paris?"},
+		{Role: "assistant", Content: "Checking.", ToolCalls: []ToolCall{
+			{ID: "call_1", Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+			{ID: "call_2", Type: "function", Function: FunctionCall{Name: "get_time", Arguments: `{}`}},
+		}},
+		{Role: "tool", ToolCallID: "call_1", Content: "18C\nsunny"},
+		{Role: "tool", ToolCallID: "call_2", Content: "12:00"},
+		{Role: "assistant", Content: "It is 18C and sunny."},
+	}
+
+	var buf bytes.Buffer
+	if err := MarshalHistory(&buf, msgs[:3]); err != nil {
+		t.Fatalf("MarshalHistory: %v", err)
+	}
+	// Appending a second batch must still produce a valid history
+	if err := MarshalHistory(&buf, msgs[3:]); err != nil {
+		t.Fatalf("MarshalHistory: %v", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != len(msgs) {
+		t.Errorf("lines = %d, want one per message (%d):\n%s", lines, len(msgs), buf.String())
+	}
+
+	got, err := UnmarshalHistory(&buf)
+	if err != nil {
+		t.Fatalf("UnmarshalHistory: %v", err)
+	}
+	if !reflect.DeepEqual(got, msgs) {
+		t.Errorf("round trip mismatch\n got: %+v\nwant: %+v", got, msgs)
+	}
+}
+
+func TestUnmarshalHistory(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		msgs, err := UnmarshalHistory(strings.NewReader(""))
+		if err != nil || msgs != nil {
+			t.Errorf("UnmarshalHistory(\"\") = %v, %v", msgs, err)
+		}
+	})
+
+	t.Run("content_parts", func(t *testing.T) {
+		msgs, err := UnmarshalHistory(strings.NewReader(`{"role":"user","content":[{"type":"text","text":"hi"}]}` + "\n"))
+		if err != nil {
+			t.Fatalf("UnmarshalHistory: %v", err)
+		}
+		if len(msgs) != 1 || msgs[0].StringContent() != "hi" {
+			t.Errorf("msgs = %+v", msgs)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := UnmarshalHistory(strings.NewReader(`{"role":"user","content":"ok"}` + "\n{oops}\n"))
+		if err == nil || !strings.Contains(err.Error(), "history message 2") {
+			t.Errorf("error = %v, want one naming message 2", err)
+		}
+	})
+}
@@ -14,7 +14,10 @@
 // The bridge functions translate between OAI and Claude Code representations:
 //
 //   - [RequestToQuery] converts an OAI request into a prompt string and
-//     [cchat.QueryOptions] for the Claude Code CLI.
+//     [cchat.QueryOptions] for the Claude Code CLI. [RequestToQueryWith]
+//     does the same with a custom [PromptFormat] for the role prefixes and
+//     system prompt layout, and [RequestToQueryUsing] with any
+//     [PromptAssembler], e.g. one selected by [LookupPromptAssembler].
 //   - [ResultToResponse] converts Claude Code result messages back into an OAI
 //     response.
 //   - [StreamState] manages the stateful translation of streaming events from
@@ -48,28 +51,128 @@
 //	fmt.Println(resp.Choices[0].Message.Content)
 package oai
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"slices"
+)
 
 // ChatCompletionRequest represents an OpenAI-compatible chat completion request.
 // The Model field selects the Claude model variant (e.g. "sonnet", "opus", "haiku").
 // When Tools are provided, tool call instructions are injected into the system prompt
 // by the bridge layer; see [ToolCallInstructions] for details.
 //
-// Fields like Temperature, TopP, Stop, and N are accepted for API compatibility
-// but are not forwarded to the Claude Code CLI.
+// Temperature and TopP are mapped into the query, but only reach the CLI
+// when [cchat.ClientConfig].SamplingFlags is set, as the claude CLI has no
+// sampling flags of its own. MaxTokens and MaxCompletionTokens cap the
+// output; see [ChatCompletionRequest.MaxOutputTokens]. N is accepted for API
+// compatibility but is not forwarded to the Claude Code CLI. Stop is not
+// forwarded either; the bridge instead cuts the output at the first stop
+// sequence, see [ChatCompletionRequest.StopSequences]. ResponseFormat is
+// emulated through system prompt instructions; see [ResponseFormat].
+// ParallelToolCalls set to false limits the reply to a single tool call;
+// see [ChatCompletionRequest.AllowsParallelToolCalls]. ReasoningEffort
+// ("low", "medium", or "high") is passed to the CLI's --effort flag; see
+// [Effort]. StreamOptions with IncludeUsage adds a usage chunk at the end
+// of a stream; see [StreamState.UsageChunk].
+//
+// Fields prefixed with x_cc_ are vendor extensions specific to this proxy.
+// IncludeThinking surfaces the model's thinking blocks as reasoning_content
+// alongside the regular content; it is off by default. Prefill treats a final
+// assistant message as the beginning of the reply rather than a completed
+// turn; see [ChatCompletionRequest.PrefillText]. ValidateToolCalls checks
+// parsed tool calls against the declared parameter schemas; see
+// [ResultToResponseFor]. IncludeToolErrors reports failed and denied tool
+// uses in the response's ToolErrors.
 type ChatCompletionRequest struct {
-	Model            string        `json:"model"`
-	Messages         []ChatMessage `json:"messages"`
-	Stream           bool          `json:"stream,omitempty"`
-	Temperature      *float64      `json:"temperature,omitempty"`
-	MaxTokens        *int          `json:"max_tokens,omitempty"`
-	MaxCompletionTokens *int       `json:"max_completion_tokens,omitempty"`
-	Tools            []Tool        `json:"tools,omitempty"`
-	ToolChoice       any           `json:"tool_choice,omitempty"`
-	Stop             any           `json:"stop,omitempty"`
-	TopP             *float64      `json:"top_p,omitempty"`
-	N                *int          `json:"n,omitempty"`
-	User             string        `json:"user,omitempty"`
+	Model               string          `json:"model"`
+	Messages            []ChatMessage   `json:"messages"`
+	Stream              bool            `json:"stream,omitempty"`
+	StreamOptions       *StreamOptions  `json:"stream_options,omitempty"`
+	Temperature         *float64        `json:"temperature,omitempty"`
+	MaxTokens           *int            `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens,omitempty"`
+	Tools               []Tool          `json:"tools,omitempty"`
+	ToolChoice          any             `json:"tool_choice,omitempty"`
+	ParallelToolCalls   *bool           `json:"parallel_tool_calls,omitempty"`
+	Stop                any             `json:"stop,omitempty"`
+	TopP                *float64        `json:"top_p,omitempty"`
+	N                   *int            `json:"n,omitempty"`
+	User                string          `json:"user,omitempty"`
+	ResponseFormat      *ResponseFormat `json:"response_format,omitempty"`
+	ReasoningEffort     string          `json:"reasoning_effort,omitempty"`
+	IncludeThinking     bool            `json:"x_cc_include_thinking,omitempty"`
+	Prefill             bool            `json:"x_cc_prefill,omitempty"`
+	ValidateToolCalls   bool            `json:"x_cc_validate_tool_calls,omitempty"`
+	IncludeToolErrors   bool            `json:"x_cc_include_tool_errors,omitempty"`
+}
+
+// StreamOptions holds the options of a streaming request. IncludeUsage asks
+// for a final chunk with the token usage of the whole request and no choices.
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage,omitempty"`
+}
+
+// IncludesUsage reports whether the request asked for a usage chunk at the
+// end of the stream.
+func (r *ChatCompletionRequest) IncludesUsage() bool {
+	return r.StreamOptions != nil && r.StreamOptions.IncludeUsage
+}
+
+// PrefillText returns the text the model should continue from when Prefill is
+// set and the last message has role "assistant". It returns the empty string
+// when prefill mode does not apply.
+func (r *ChatCompletionRequest) PrefillText() string {
+	if !r.Prefill || len(r.Messages) == 0 {
+		return ""
+	}
+	last := r.Messages[len(r.Messages)-1]
+	if last.Role != "assistant" || len(last.ToolCalls) > 0 {
+		return ""
+	}
+	return last.StringContent()
+}
+
+// AllowsParallelToolCalls reports whether the model may emit more than one
+// tool call per turn. Only an explicit parallel_tool_calls: false disables it.
+func (r *ChatCompletionRequest) AllowsParallelToolCalls() bool {
+	return r.ParallelToolCalls == nil || *r.ParallelToolCalls
+}
+
+// MaxOutputTokens returns the output token limit: MaxCompletionTokens if set,
+// otherwise MaxTokens, or 0 for no limit. The limit is passed to the CLI and
+// also enforced approximately by the bridge, which truncates longer output
+// and reports finish_reason "length"; see [ResultToResponseFor] and
+// [StreamState].
+func (r *ChatCompletionRequest) MaxOutputTokens() int {
+	limit := r.MaxCompletionTokens
+	if limit == nil {
+		limit = r.MaxTokens
+	}
+	if limit == nil || *limit <= 0 {
+		return 0
+	}
+	return *limit
+}
+
+// StopSequences returns the request's Stop field as a list of strings. Stop
+// may be a single string or an array of strings; empty strings and non-string
+// elements are ignored.
+func (r *ChatCompletionRequest) StopSequences() []string {
+	var stops []string
+	switch v := r.Stop.(type) {
+	case string:
+		stops = append(stops, v)
+	case []string:
+		stops = append(stops, v...)
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				stops = append(stops, s)
+			}
+		}
+	}
+	stops = slices.DeleteFunc(stops, func(s string) bool { return s == "" })
+	return stops
 }
 
 // ChatMessage represents a single message in the conversation history.
@@ -82,12 +185,16 @@ type ChatCompletionRequest struct {
 // For assistant messages that include tool invocations, ToolCalls contains
 // the structured calls. For tool-role messages returning results, ToolCallID
 // identifies which call this result corresponds to.
+//
+// ReasoningContent carries the model's thinking text on assistant responses
+// when the request set IncludeThinking. It is never sent back to the model.
 type ChatMessage struct {
-	Role       string     `json:"role"` // "system", "user", "assistant", "tool"
-	Content    any        `json:"content,omitempty"`
-	Name       string     `json:"name,omitempty"`
-	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
-	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Role             string     `json:"role"` // "system", "user", "assistant", "tool"
+	Content          any        `json:"content,omitempty"`
+	ReasoningContent string     `json:"reasoning_content,omitempty"`
+	Name             string     `json:"name,omitempty"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID       string     `json:"tool_call_id,omitempty"`
 }
 
 // StringContent extracts the textual content from the message as a plain string.
@@ -156,7 +263,12 @@ type FunctionDefinition struct {
 // ID is a unique identifier (prefixed with "call_") generated during parsing.
 // Type is always "function". These are produced by [ParseToolCalls] from
 // <tool_call> XML tags in the model output.
+//
+// Index is only set in streaming chunks, where it identifies which tool call
+// a delta belongs to so that fragments of several calls can be interleaved;
+// see [MergeToolCallDeltas]. It is nil in complete messages.
 type ToolCall struct {
+	Index    *int         `json:"index,omitempty"`
 	ID       string       `json:"id"`
 	Type     string       `json:"type"` // "function"
 	Function FunctionCall `json:"function"`
@@ -4,14 +4,67 @@ package oai
 // It is produced by [ResultToResponse] from Claude Code wire messages, or by
 // [Client.CreateChatCompletion]. The ID is derived from the Claude Code session ID,
 // and Model reflects the actual model used by the Claude backend.
+//
+// SystemInfo is populated by [Client.CreateChatCompletion] with the session
+// metadata reported by the CLI. It is never serialized, keeping the JSON body
+// in the standard OpenAI shape.
+//
+// DurationMS is the wall-clock time the CLI reported for the session, which
+// excludes SDK and process startup overhead. It is serialized as the vendor
+// field x_cc_duration_ms.
+//
+// CostUSD is set only when a [PriceTable] is configured, e.g. with
+// [Client].Prices: it is the cost the CLI reported, or the cost computed
+// from the token usage when the CLI reported none; see [PriceTable.Cost].
+// It is serialized as x_cc_cost_usd.
+//
+// ToolCallErrors is set only when the request enabled ValidateToolCalls and
+// some tool calls do not match their schema. It maps tool call IDs to the
+// validation error and is serialized as x_cc_tool_call_errors.
+//
+// ToolErrors is set only when the request enabled IncludeToolErrors and a
+// tool the CLI ran failed or was denied permission. It is serialized as
+// x_cc_tool_errors.
+//
+// StopSequence is the stop sequence the content was cut at, if any, e.g. to
+// report it as the Anthropic API does. Like SystemInfo, it is never
+// serialized.
 type ChatCompletionResponse struct {
-	ID                string   `json:"id"`
-	Object            string   `json:"object"` // "chat.completion"
-	Created           int64    `json:"created"`
-	Model             string   `json:"model"`
-	Choices           []Choice `json:"choices"`
-	Usage             *Usage   `json:"usage,omitempty"`
-	SystemFingerprint string   `json:"system_fingerprint,omitempty"`
+	ID                string            `json:"id"`
+	Object            string            `json:"object"` // "chat.completion"
+	Created           int64             `json:"created"`
+	Model             string            `json:"model"`
+	Choices           []Choice          `json:"choices"`
+	Usage             *Usage            `json:"usage,omitempty"`
+	SystemFingerprint string            `json:"system_fingerprint,omitempty"`
+	DurationMS        int               `json:"x_cc_duration_ms,omitempty"`
+	CostUSD           float64           `json:"x_cc_cost_usd,omitempty"`
+	ToolCallErrors    map[string]string `json:"x_cc_tool_call_errors,omitempty"`
+	ToolErrors        []ToolError       `json:"x_cc_tool_errors,omitempty"`
+	SystemInfo        *SystemInfo       `json:"-"`
+	StopSequence      string            `json:"-"`
+}
+
+// SystemInfo describes the Claude Code session that served a request, as
+// reported by the CLI's initial system message. It is useful for debugging,
+// e.g. confirming which dated model id an alias like "sonnet" resolved to, or
+// which tools the CLI made available.
+type SystemInfo struct {
+	SessionID string   `json:"session_id"`
+	Model     string   `json:"model"`
+	CWD       string   `json:"cwd"`
+	Tools     []string `json:"tools"`
+}
+
+// ToolError describes a tool use by the CLI that did not succeed. Denied is
+// true when the CLI refused to run the tool; otherwise the tool ran and
+// Message holds the error output it returned. ToolName is empty when the
+// matching tool_use block is not known.
+type ToolError struct {
+	ToolUseID string `json:"tool_use_id"`
+	ToolName  string `json:"tool_name,omitempty"`
+	Message   string `json:"message"`
+	Denied    bool   `json:"denied,omitempty"`
 }
 
 // Choice represents a single completion alternative in the response.
@@ -39,6 +92,11 @@ type ErrorResponse struct {
 	Error ErrorDetail `json:"error"`
 }
 
+// ErrorTypeInvalidRequest is the error type of requests rejected as
+// invalid, e.g. for malformed JSON or an unknown model, as in OpenAI's and
+// Anthropic's APIs. Both [Client] and the server use it.
+const ErrorTypeInvalidRequest = "invalid_request_error"
+
 // ErrorDetail contains the error information within an [ErrorResponse].
 // Type categorizes the error (e.g. "invalid_request_error", "internal_error").
 // Code is an optional machine-readable error code.
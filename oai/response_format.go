@@ -0,0 +1,232 @@
+package oai
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ResponseFormat mirrors the OpenAI response_format request field. Type is
+// "text" (the default), "json_object", or "json_schema". For "json_schema",
+// JSONSchema carries the schema the output must satisfy.
+//
+// The Claude Code CLI has no structured output mode, so JSON formats are
+// implemented by instructing the model through the system prompt (see
+// [ResponseFormat.Instructions]) and cleaning up the reply afterwards (see
+// [ExtractJSON]). Streaming responses only get the instructions; their
+// content is forwarded as generated.
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat describes the expected output of a "json_schema"
+// [ResponseFormat]. Schema is a JSON Schema object. Strict is accepted for
+// API compatibility; the schema is always checked after the fact by
+// [ValidateJSONSchema].
+type JSONSchemaFormat struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Schema      any    `json:"schema,omitempty"`
+	Strict      *bool  `json:"strict,omitempty"`
+}
+
+// FinishReasonInvalidJSON is the finish reason set on a choice whose content
+// could not be parsed as JSON (or did not satisfy the schema) although a JSON
+// [ResponseFormat] was requested. The raw content is still returned.
+const FinishReasonInvalidJSON = "invalid_json"
+
+// IsJSON reports whether the format requests JSON output.
+func (f *ResponseFormat) IsJSON() bool {
+	return f != nil && (f.Type == "json_object" || f.Type == "json_schema")
+}
+
+// Instructions returns system prompt text that asks the model to answer with
+// JSON only, embedding the schema for "json_schema". It returns the empty
+// string when the format does not request JSON.
+func (f *ResponseFormat) Instructions() string {
+	if !f.IsJSON() {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\n## Response Format\n\n")
+	b.WriteString("Respond with a single valid JSON value and nothing else: no prose, no explanations, no Markdown code fences.\n")
+	if f.Type == "json_schema" && f.JSONSchema != nil && f.JSONSchema.Schema != nil {
+		if schema, err := json.Marshal(f.JSONSchema.Schema); err == nil {
+			b.WriteString("The JSON must conform to this JSON Schema:\n\n")
+			b.Write(schema)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// ExtractJSON returns the JSON value contained in text, tolerating the usual
+// ways models wrap it: surrounding whitespace, a Markdown code fence, or prose
+// before and after the value. It reports false if no valid JSON is found, in
+// which case text is returned unchanged.
+func ExtractJSON(text string) (string, bool) {
+	trimmed := strings.TrimSpace(text)
+	if json.Valid([]byte(trimmed)) {
+		return trimmed, true
+	}
+
+	// ```json ... ``` fence
+	if start := strings.Index(trimmed, "```"); start >= 0 {
+		body := trimmed[start+3:]
+		if nl := strings.IndexByte(body, '\n'); nl >= 0 {
+			body = body[nl+1:]
+		}
+		if end := strings.Index(body, "```"); end >= 0 {
+			candidate := strings.TrimSpace(body[:end])
+			if json.Valid([]byte(candidate)) {
+				return candidate, true
+			}
+		}
+	}
+
+	// Outermost object or array embedded in prose
+	for _, pair := range [][2]string{{"{", "}"}, {"[", "]"}} {
+		start := strings.Index(trimmed, pair[0])
+		end := strings.LastIndex(trimmed, pair[1])
+		if start >= 0 && end > start {
+			candidate := trimmed[start : end+1]
+			if json.Valid([]byte(candidate)) {
+				return candidate, true
+			}
+		}
+	}
+
+	return text, false
+}
+
+// ValidateJSONSchema checks a decoded JSON value against a JSON Schema. Only
+// the commonly used keywords are supported: type, properties, required,
+// items, and enum. Unknown keywords are ignored, so a value may pass even if
+// a full validator would reject it.
+//
+// The schema may be decoded JSON or built from Go values such as
+// map[string]any with []string "required" lists; it is normalized through a
+// JSON round trip first.
+func ValidateJSONSchema(value, schema any) error {
+	return validateSchema(value, normalizeSchema(schema), "$")
+}
+
+// normalizeSchema converts schema to the generic form produced by
+// encoding/json (map[string]any, []any, float64), so that schemas written
+// as Go literals can be inspected uniformly.
+func normalizeSchema(schema any) any {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return schema
+	}
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return schema
+	}
+	return normalized
+}
+
+func validateSchema(value, schema any, path string) error {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	if typ, ok := s["type"].(string); ok && !matchesSchemaType(value, typ) {
+		return fmt.Errorf("%s: expected %s", path, typ)
+	}
+
+	if enum, ok := s["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value not in enum", path)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		if required, ok := s["required"].([]any); ok {
+			for _, r := range required {
+				name, _ := r.(string)
+				if _, ok := v[name]; !ok {
+					return fmt.Errorf("%s: missing required property %q", path, name)
+				}
+			}
+		}
+		if props, ok := s["properties"].(map[string]any); ok {
+			for name, propSchema := range props {
+				if pv, ok := v[name]; ok {
+					if err := validateSchema(pv, propSchema, path+"."+name); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	case []any:
+		if items, ok := s["items"]; ok {
+			for i, item := range v {
+				if err := validateSchema(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func matchesSchemaType(value any, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	default:
+		return true
+	}
+}
+
+// applyResponseFormat cleans up choice content for a JSON response format.
+// Valid JSON replaces the content and keeps the "stop" finish reason; anything
+// else is left as-is and flagged with [FinishReasonInvalidJSON]. Content cut
+// at the token limit keeps its "length" finish reason.
+func applyResponseFormat(choice *Choice, format *ResponseFormat) {
+	if !format.IsJSON() || len(choice.Message.ToolCalls) > 0 || choice.FinishReason == "length" {
+		return
+	}
+
+	extracted, ok := ExtractJSON(choice.Message.StringContent())
+	if ok && format.Type == "json_schema" && format.JSONSchema != nil && format.JSONSchema.Schema != nil {
+		var value any
+		json.Unmarshal([]byte(extracted), &value)
+		ok = ValidateJSONSchema(value, format.JSONSchema.Schema) == nil
+	}
+	if !ok {
+		choice.FinishReason = FinishReasonInvalidJSON
+		return
+	}
+	choice.Message.Content = extracted
+}
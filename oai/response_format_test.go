@@ -0,0 +1,173 @@
+package oai
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		want   string
+		wantOK bool
+	}{
+		{name: "plain", text: ` {"a": 1} `, want: `{"a": 1}`, wantOK: true},
+		{name: "fenced", text: "```json\n{\"a\": 1}\n```", want: `{"a": 1}`, wantOK: true},
+		{name: "prose_around_object", text: `Sure! Here it is: {"a": [1, 2]} Hope that helps.`, want: `{"a": [1, 2]}`, wantOK: true},
+		{name: "prose_around_array", text: `Result: [1, 2, 3].`, want: `[1, 2, 3]`, wantOK: true},
+		{name: "invalid", text: `{"a": 1`, want: `{"a": 1`, wantOK: false},
+		{name: "no_json", text: "I cannot do that.", want: "I cannot do that.", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ExtractJSON(tt.text)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ExtractJSON(%q) = (%q, %v), want (%q, %v)", tt.text, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	var schema any
+	json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["name", "tags"],
+		"properties": {
+			"name": {"type": "string"},
+			"age": {"type": "integer"},
+			"role": {"enum": ["admin", "user"]},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		}
+	}`), &schema)
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{name: "valid", value: `{"name": "Ann", "age": 30, "role": "admin", "tags": ["x"]}`},
+		{name: "not_object", value: `[1]`, wantErr: "expected object"},
+		{name: "missing_required", value: `{"name": "Ann"}`, wantErr: `missing required property "tags"`},
+		{name: "wrong_property_type", value: `{"name": 1, "tags": []}`, wantErr: "$.name: expected string"},
+		{name: "non_integer", value: `{"name": "Ann", "age": 1.5, "tags": []}`, wantErr: "$.age: expected integer"},
+		{name: "enum", value: `{"name": "Ann", "role": "root", "tags": []}`, wantErr: "not in enum"},
+		{name: "array_items", value: `{"name": "Ann", "tags": ["x", 2]}`, wantErr: "$.tags[1]: expected string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value any
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			err := ValidateJSONSchema(value, schema)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequestToQuery_ResponseFormat(t *testing.T) {
+	req := &ChatCompletionRequest{
+		Messages: []ChatMessage{{Role: "user", Content: "Give me a user"}},
+		ResponseFormat: &ResponseFormat{
+			Type: "json_schema",
+			JSONSchema: &JSONSchemaFormat{
+				Name:   "user",
+				Schema: map[string]any{"type": "object", "required": []any{"name"}},
+			},
+		},
+	}
+
+	_, opts := RequestToQuery(req)
+	if !strings.Contains(opts.SystemPrompt, "valid JSON") {
+		t.Errorf("SystemPrompt missing JSON instructions: %q", opts.SystemPrompt)
+	}
+	if !strings.Contains(opts.SystemPrompt, `"required":["name"]`) {
+		t.Errorf("SystemPrompt missing schema: %q", opts.SystemPrompt)
+	}
+
+	req.ResponseFormat = &ResponseFormat{Type: "text"}
+	if _, opts := RequestToQuery(req); opts.SystemPrompt != "" {
+		t.Errorf("text format should add no instructions, got %q", opts.SystemPrompt)
+	}
+}
+
+func TestResultToResponseFor_ResponseFormat(t *testing.T) {
+	result := &ccwire.ResultMessage{SessionID: "sess-1"}
+	schema := map[string]any{"type": "object", "required": []any{"name"}}
+
+	tests := []struct {
+		name       string
+		format     *ResponseFormat
+		text       string
+		wantText   string
+		wantFinish string
+	}{
+		{
+			name:       "json_object_stripped",
+			format:     &ResponseFormat{Type: "json_object"},
+			text:       "```json\n{\"ok\": true}\n```",
+			wantText:   `{"ok": true}`,
+			wantFinish: "stop",
+		},
+		{
+			name:       "json_object_malformed",
+			format:     &ResponseFormat{Type: "json_object"},
+			text:       "Sorry, no.",
+			wantText:   "Sorry, no.",
+			wantFinish: FinishReasonInvalidJSON,
+		},
+		{
+			name:       "json_schema_valid",
+			format:     &ResponseFormat{Type: "json_schema", JSONSchema: &JSONSchemaFormat{Schema: schema}},
+			text:       `{"name": "Ann"}`,
+			wantText:   `{"name": "Ann"}`,
+			wantFinish: "stop",
+		},
+		{
+			name:       "json_schema_violation",
+			format:     &ResponseFormat{Type: "json_schema", JSONSchema: &JSONSchemaFormat{Schema: schema}},
+			text:       `{"age": 3}`,
+			wantText:   `{"age": 3}`,
+			wantFinish: FinishReasonInvalidJSON,
+		},
+		{
+			name:       "text_untouched",
+			format:     &ResponseFormat{Type: "text"},
+			text:       "Sorry, no.",
+			wantText:   "Sorry, no.",
+			wantFinish: "stop",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assistant := &ccwire.AssistantMessage{Message: ccwire.AssistantInner{
+				Content: []ccwire.ContentBlock{{Type: "text", Text: tt.text}},
+			}}
+			resp := ResultToResponseFor(&ChatCompletionRequest{ResponseFormat: tt.format}, result, assistant)
+
+			choice := resp.Choices[0]
+			if got := choice.Message.StringContent(); got != tt.wantText {
+				t.Errorf("content = %q, want %q", got, tt.wantText)
+			}
+			if choice.FinishReason != tt.wantFinish {
+				t.Errorf("finish_reason = %q, want %q", choice.FinishReason, tt.wantFinish)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package oai
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// SchemaFromStruct builds a JSON Schema object from a Go struct, suitable for
+// [FunctionDefinition].Parameters. v may be a struct value or a pointer to
+// one; it panics if v is not a struct, since the schema is derived from
+// static type information and a wrong type is a programming error.
+//
+// Property names follow the json struct tags, and fields tagged "-" or
+// unexported are skipped. Fields of embedded structs are promoted. A field
+// is required unless its json tag has omitempty. The jsonschema tag adds
+// more detail as comma-separated entries:
+//
+//	type Args struct {
+//	    City  string   `json:"city" jsonschema:"description=City name"`
+//	    Unit  string   `json:"unit,omitempty" jsonschema:"enum=celsius|fahrenheit"`
+//	    Days  int      `json:"days,omitempty" jsonschema:"required"`
+//	    Tags  []string `json:"tags,omitempty"`
+//	}
+//
+// Supported entries are description=..., enum=a|b|c, and required. A
+// description may contain commas as long as it is the last entry.
+//
+// Go types map as follows: strings to "string", booleans to "boolean",
+// integers to "integer", floats to "number", slices and arrays to "array"
+// with an items schema, structs to nested "object" schemas, and maps with
+// string keys to "object" with additionalProperties. Pointers are
+// dereferenced; interface types produce an empty schema accepting any value.
+func SchemaFromStruct(v any) map[string]any {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		panic("oai: SchemaFromStruct requires a struct, got " + reflect.TypeOf(v).String())
+	}
+	return schemaForType(t)
+}
+
+func schemaForType(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaForType(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaForType(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]any)
+		var required []string
+		addStructFields(t, props, &required)
+		schema := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return map[string]any{}
+	}
+}
+
+// addStructFields adds a property for each exported field of t, promoting
+// the fields of untagged embedded structs.
+func addStructFields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		jsonTag := f.Tag.Get("json")
+		if jsonTag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(jsonTag, ",")
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addStructFields(ft, props, required)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		schema := schemaForType(f.Type)
+		isRequired := !strings.Contains(","+opts+",", ",omitempty,")
+		if applySchemaTag(schema, f.Type, f.Tag.Get("jsonschema")) {
+			isRequired = true
+		}
+
+		props[name] = schema
+		if isRequired {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// applySchemaTag applies the entries of a jsonschema struct tag to schema and
+// reports whether the tag marks the field as required.
+func applySchemaTag(schema map[string]any, t reflect.Type, tag string) (required bool) {
+	if tag == "" {
+		return false
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	entries := strings.Split(tag, ",")
+	for i, entry := range entries {
+		key, value, _ := strings.Cut(entry, "=")
+		switch key {
+		case "required":
+			required = true
+		case "description":
+			// The description swallows the rest of the tag, commas included
+			schema["description"] = strings.Join(append([]string{value}, entries[i+1:]...), ",")
+			return required
+		case "enum":
+			var values []any
+			for _, s := range strings.Split(value, "|") {
+				values = append(values, enumValue(t, s))
+			}
+			schema["enum"] = values
+		}
+	}
+	return required
+}
+
+// enumValue converts an enum tag value to the field's JSON type, falling back
+// to the raw string when it does not parse.
+func enumValue(t reflect.Type, s string) any {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
@@ -0,0 +1,98 @@
+package oai
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSchemaFromStruct_CreateUser mirrors the create_user nested schema used
+// by the integration tests.
+func TestSchemaFromStruct_CreateUser(t *testing.T) {
+	type name struct {
+		First string `json:"first"`
+		Last  string `json:"last"`
+	}
+	type address struct {
+		Street  string `json:"street"`
+		City    string `json:"city"`
+		State   string `json:"state"`
+		Zip     string `json:"zip"`
+		Country string `json:"country"`
+	}
+	type createUser struct {
+		Name    name    `json:"name"`
+		Age     int     `json:"age"`
+		Address address `json:"address"`
+	}
+
+	want := `{
+		"type": "object",
+		"properties": {
+			"name": {
+				"type": "object",
+				"properties": {"first": {"type": "string"}, "last": {"type": "string"}},
+				"required": ["first", "last"]
+			},
+			"age": {"type": "integer"},
+			"address": {
+				"type": "object",
+				"properties": {
+					"street": {"type": "string"},
+					"city": {"type": "string"},
+					"state": {"type": "string"},
+					"zip": {"type": "string"},
+					"country": {"type": "string"}
+				},
+				"required": ["street", "city", "state", "zip", "country"]
+			}
+		},
+		"required": ["name", "age", "address"]
+	}`
+
+	assertJSONEqual(t, SchemaFromStruct(createUser{}), want)
+}
+
+func TestSchemaFromStruct_Tags(t *testing.T) {
+	type base struct {
+		ID string `json:"id" jsonschema:"description=Record ID"`
+	}
+	type args struct {
+		base
+		Unit     string            `json:"unit,omitempty" jsonschema:"enum=celsius|fahrenheit,description=Temperature unit, lowercase"`
+		Days     int               `json:"days,omitempty" jsonschema:"required,enum=1|3|7"`
+		Ratio    *float64          `json:"ratio,omitempty"`
+		Tags     []string          `json:"tags,omitempty"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		Extra    any               `json:"extra,omitempty"`
+		Verbose  bool
+		Ignored  string `json:"-"`
+		internal string
+	}
+
+	want := `{
+		"type": "object",
+		"properties": {
+			"id": {"type": "string", "description": "Record ID"},
+			"unit": {"type": "string", "enum": ["celsius", "fahrenheit"], "description": "Temperature unit, lowercase"},
+			"days": {"type": "integer", "enum": [1, 3, 7]},
+			"ratio": {"type": "number"},
+			"tags": {"type": "array", "items": {"type": "string"}},
+			"labels": {"type": "object", "additionalProperties": {"type": "string"}},
+			"extra": {},
+			"Verbose": {"type": "boolean"}
+		},
+		"required": ["id", "days", "Verbose"]
+	}`
+
+	assertJSONEqual(t, SchemaFromStruct(&args{}), want)
+}
+
+func TestSchemaFromStruct_NotStruct(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), "requires a struct") {
+			t.Errorf("expected panic for non-struct, got %v", r)
+		}
+	}()
+	SchemaFromStruct("nope")
+}
@@ -0,0 +1,102 @@
+package oai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/codewandler/cc-sdk-go/cchat"
+)
+
+// StatusClientClosedRequest is the non-standard status (popularised by
+// nginx) for a request whose client went away before it was answered.
+const StatusClientClosedRequest = 499
+
+// HTTPStatusForError returns the HTTP status an OpenAI-compatible server
+// answers with for err, so that the proxy and custom mounts of the SDK
+// report errors consistently. It returns the Status of an [*APIError] when
+// it has one, and otherwise inspects err with [errors.As] and [errors.Is]:
+//
+//   - [*cchat.ModelError]: 400
+//   - [*cchat.RateLimitError]: 429
+//   - [*cchat.UpstreamError]: 503 if overloaded, 401 for rejected
+//     credentials, 502 otherwise
+//   - [*cchat.QueueFullError], [*cchat.ClientClosedError]: 503
+//   - [context.DeadlineExceeded], including [*cchat.TimeoutError] and
+//     [*cchat.IdleTimeoutError]: 504
+//   - [context.Canceled], including [cchat.ErrStreamCancelled]:
+//     [StatusClientClosedRequest]
+//   - anything else, including [*cchat.ProcessError]: 500
+//
+// A nil err yields 200.
+func HTTPStatusForError(err error) int {
+	var apiErr *APIError
+	var modelErr *cchat.ModelError
+	var rateErr *cchat.RateLimitError
+	var upErr *cchat.UpstreamError
+	var queueErr *cchat.QueueFullError
+	var closedErr *cchat.ClientClosedError
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.As(err, &apiErr) && apiErr.Status != 0:
+		return apiErr.Status
+	case errors.As(err, &modelErr):
+		return http.StatusBadRequest
+	case errors.As(err, &rateErr):
+		return http.StatusTooManyRequests
+	case errors.As(err, &upErr) && upErr.Kind == cchat.UpstreamOverloaded:
+		return http.StatusServiceUnavailable
+	case errors.As(err, &upErr) && upErr.Kind == cchat.UpstreamAuthentication:
+		return http.StatusUnauthorized
+	case errors.As(err, &upErr):
+		return http.StatusBadGateway
+	case errors.As(err, &queueErr), errors.As(err, &closedErr):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return StatusClientClosedRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// APIErrorFor converts an error from [cchat.Stream.Next] into the
+// [*APIError] an OpenAI-compatible server answers with, so that the proxy,
+// [Client], and custom mounts of the SDK report errors alike. Its Status is
+// that of [HTTPStatusForError], and Err is err. An err that already is an
+// [*APIError] is returned as is. Otherwise the type is:
+//
+//   - [*cchat.RateLimitError]: "rate_limit_exceeded", code "rate_limit"
+//   - [*cchat.UpstreamError]: "overloaded_error" (code "overloaded") if
+//     overloaded, "authentication_error" (code "invalid_api_key") for
+//     rejected credentials, "api_error" otherwise
+//   - [context.DeadlineExceeded]: "timeout"
+//   - [context.Canceled]: "request_cancelled"
+//   - anything else: "internal_error"
+func APIErrorFor(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	status := HTTPStatusForError(err)
+	var rateErr *cchat.RateLimitError
+	var upErr *cchat.UpstreamError
+	switch {
+	case errors.As(err, &rateErr):
+		return &APIError{Message: rateErr.Message, Type: "rate_limit_exceeded", Code: "rate_limit", Status: status, Err: err}
+	case errors.As(err, &upErr) && upErr.Kind == cchat.UpstreamOverloaded:
+		return &APIError{Message: upErr.Message, Type: "overloaded_error", Code: "overloaded", Status: status, Err: err}
+	case errors.As(err, &upErr) && upErr.Kind == cchat.UpstreamAuthentication:
+		return &APIError{Message: upErr.Message, Type: "authentication_error", Code: "invalid_api_key", Status: status, Err: err}
+	case errors.As(err, &upErr):
+		return &APIError{Message: upErr.Message, Type: "api_error", Status: status, Err: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &APIError{Message: "Request timed out: " + err.Error(), Type: "timeout", Status: status, Err: err}
+	case errors.Is(err, context.Canceled):
+		return &APIError{Message: "Request cancelled: " + err.Error(), Type: "request_cancelled", Status: status, Err: err}
+	default:
+		return &APIError{Message: "Stream error: " + err.Error(), Type: "internal_error", Status: status, Err: err}
+	}
+}
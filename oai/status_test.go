@@ -0,0 +1,84 @@
+package oai_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/codewandler/cc-sdk-go/cchat"
+	"github.com/codewandler/cc-sdk-go/oai"
+)
+
+func TestHTTPStatusForError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, http.StatusOK},
+		{"api_error", &oai.APIError{Type: "invalid_request_error", Status: http.StatusBadRequest}, http.StatusBadRequest},
+		{"api_error_without_status", &oai.APIError{Type: "internal_error", Err: &cchat.RateLimitError{}}, http.StatusTooManyRequests},
+		{"model", &cchat.ModelError{Model: "gpt-4"}, http.StatusBadRequest},
+		{"rate_limit", &cchat.RateLimitError{Message: "slow down"}, http.StatusTooManyRequests},
+		{"overloaded", &cchat.UpstreamError{Kind: cchat.UpstreamOverloaded}, http.StatusServiceUnavailable},
+		{"authentication", &cchat.UpstreamError{Kind: cchat.UpstreamAuthentication}, http.StatusUnauthorized},
+		{"upstream", &cchat.UpstreamError{Kind: cchat.UpstreamAPIError}, http.StatusBadGateway},
+		{"queue_full", &cchat.QueueFullError{MaxConcurrent: 1, Wait: time.Second}, http.StatusServiceUnavailable},
+		{"client_closed", &cchat.ClientClosedError{}, http.StatusServiceUnavailable},
+		{"timeout", &cchat.TimeoutError{Timeout: time.Second}, http.StatusGatewayTimeout},
+		{"idle_timeout", &cchat.IdleTimeoutError{Timeout: time.Second}, http.StatusGatewayTimeout},
+		{"deadline", fmt.Errorf("claude process interrupted: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{"cancelled", context.Canceled, oai.StatusClientClosedRequest},
+		{"stream_cancelled", cchat.ErrStreamCancelled, oai.StatusClientClosedRequest},
+		{"process", &cchat.ProcessError{ExitCode: 1}, http.StatusInternalServerError},
+		{"other", io.ErrUnexpectedEOF, http.StatusInternalServerError},
+		{"wrapped", fmt.Errorf("query: %w", &cchat.RateLimitError{}), http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := oai.HTTPStatusForError(tt.err); got != tt.want {
+				t.Errorf("HTTPStatusForError(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAPIErrorFor verifies the status, type, and message chosen for each
+// kind of stream failure.
+func TestAPIErrorFor(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		status      int
+		wantType    string
+		wantMessage string
+	}{
+		{"rate_limit", &cchat.RateLimitError{Message: "slow down"}, http.StatusTooManyRequests, "rate_limit_exceeded", "slow down"},
+		{"overloaded", &cchat.UpstreamError{Kind: cchat.UpstreamOverloaded, Message: "Overloaded"}, http.StatusServiceUnavailable, "overloaded_error", "Overloaded"},
+		{"authentication", &cchat.UpstreamError{Kind: cchat.UpstreamAuthentication, Message: "Invalid API key"}, http.StatusUnauthorized, "authentication_error", "Invalid API key"},
+		{"api_error", &cchat.UpstreamError{Kind: cchat.UpstreamAPIError, Message: "Internal server error"}, http.StatusBadGateway, "api_error", "Internal server error"},
+		{"deadline", fmt.Errorf("interrupted: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "timeout", "Request timed out: interrupted: context deadline exceeded"},
+		{"default_timeout", &cchat.TimeoutError{Timeout: time.Second}, http.StatusGatewayTimeout, "timeout", ""},
+		{"cancelled", fmt.Errorf("interrupted: %w", context.Canceled), oai.StatusClientClosedRequest, "request_cancelled", "Request cancelled: interrupted: context canceled"},
+		{"other", io.ErrUnexpectedEOF, http.StatusInternalServerError, "internal_error", "Stream error: unexpected EOF"},
+		{"api_error_value", &oai.APIError{Status: http.StatusTeapot, Type: "custom", Message: "as is"}, http.StatusTeapot, "custom", "as is"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := oai.APIErrorFor(tt.err)
+			if got.Status != tt.status {
+				t.Errorf("Status = %d, want %d", got.Status, tt.status)
+			}
+			if got.Type != tt.wantType {
+				t.Errorf("Type = %q, want %q", got.Type, tt.wantType)
+			}
+			if tt.wantMessage != "" && got.Message != tt.wantMessage {
+				t.Errorf("Message = %q, want %q", got.Message, tt.wantMessage)
+			}
+		})
+	}
+}
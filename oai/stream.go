@@ -27,8 +27,11 @@ type ChunkChoice struct {
 // The first delta in a stream carries the Role ("assistant"). Subsequent
 // deltas carry either Content (text fragments) or ToolCalls. Content is a
 // pointer so that an empty string can be distinguished from an absent field.
+// ReasoningContent carries thinking fragments when the request set
+// IncludeThinking.
 type ChunkDelta struct {
-	Role      string     `json:"role,omitempty"`
-	Content   *string    `json:"content,omitempty"`
-	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	Role             string     `json:"role,omitempty"`
+	Content          *string    `json:"content,omitempty"`
+	ReasoningContent *string    `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
 }
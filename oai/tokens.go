@@ -0,0 +1,104 @@
+package oai
+
+import (
+	"unicode/utf8"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+)
+
+// EstimateTokens returns an approximate token count for text, for budgeting
+// before a request is sent, when the real usage is not yet known. The
+// heuristic is about four characters per token, which holds well for English
+// prose and code, adjusted for characters that tokenize more finely: a
+// two-byte UTF-8 character (accented Latin, Greek, Cyrillic, ...) counts as
+// half a token, and anything wider (CJK, emoji) as a whole token. The result
+// is rounded up, so any non-empty text is at least one token.
+//
+// The estimate is not exact, but it is consistent: appending to text never
+// lowers it. The bridge uses the same measure to enforce max_tokens.
+func EstimateTokens(text string) int {
+	return (tokenUnits(text) + unitsPerToken - 1) / unitsPerToken
+}
+
+// EstimateRequestTokens returns an approximate token count for the input of
+// req, using [EstimateTokens] on the prompt and system prompt that
+// [RequestToQuery] renders from it. This covers the message contents and
+// their role prefixes, tool calls, and the tool definitions with their
+// parameter schemas. It does not include the output or the overhead the CLI
+// adds on its own.
+func EstimateRequestTokens(req *ChatCompletionRequest) int {
+	prompt, opts := RequestToQuery(req)
+	return EstimateTokens(opts.SystemPrompt) + EstimateTokens(prompt)
+}
+
+// unitsPerToken is the number of units in one token, the measure behind
+// [EstimateTokens]: an ASCII character is one unit, so four make a token.
+const unitsPerToken = 4
+
+// runeUnits returns the cost of r in units: its UTF-8 length for one- and
+// two-byte characters, and a whole token for wider ones.
+func runeUnits(r rune) int {
+	if n := utf8.RuneLen(r); n == 1 || n == 2 {
+		return n
+	}
+	return unitsPerToken
+}
+
+// tokenUnits returns the cost of text in units.
+func tokenUnits(text string) int {
+	n := 0
+	for _, r := range text {
+		n += runeUnits(r)
+	}
+	return n
+}
+
+// truncateUnits cuts text to its longest prefix costing at most n units and
+// reports whether anything was cut.
+func truncateUnits(text string, n int) (string, bool) {
+	for i, r := range text {
+		n -= runeUnits(r)
+		if n < 0 {
+			return text[:i], true
+		}
+	}
+	return text, false
+}
+
+// toolCallUnits returns the cost in units of the names and arguments of
+// calls, which count toward the output token limit alongside the content.
+func toolCallUnits(calls []ToolCall) int {
+	n := 0
+	for _, tc := range calls {
+		n += tokenUnits(tc.Function.Name) + tokenUnits(tc.Function.Arguments)
+	}
+	return n
+}
+
+// truncateMessage enforces an approximate limit of maxTokens output tokens on
+// msg and reports whether it had to cut anything. Content over the limit is
+// truncated and any tool calls are dropped, as the model would not have
+// reached them. A limit of 0 or less leaves msg unchanged.
+func truncateMessage(msg *ChatMessage, maxTokens int) bool {
+	if maxTokens <= 0 {
+		return false
+	}
+	limit := maxTokens * unitsPerToken
+	text, cut := truncateUnits(msg.StringContent(), limit)
+	if cut {
+		msg.Content = text
+		msg.ToolCalls = nil
+		return true
+	}
+	if tokenUnits(text)+toolCallUnits(msg.ToolCalls) > limit {
+		msg.ToolCalls = nil
+		return true
+	}
+	return false
+}
+
+// hitMaxTokens reports whether the CLI stopped assistant because it reached
+// its output token limit.
+func hitMaxTokens(assistant *ccwire.AssistantMessage) bool {
+	return assistant != nil && assistant.Message.StopReason != nil && *assistant.Message.StopReason == "max_tokens"
+}
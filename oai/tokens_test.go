@@ -0,0 +1,84 @@
+package oai
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens_Calibration(t *testing.T) {
+	// ref is the count from a BPE tokenizer (cl100k); the estimate should
+	// be in the same ballpark.
+	tests := []struct {
+		text string
+		want int
+		ref  int
+	}{
+		{text: "", want: 0, ref: 0},
+		{text: "Hi", want: 1, ref: 1},
+		{text: "Hello, world!", want: 4, ref: 4},
+		{text: "The quick brown fox jumps over the lazy dog.", want: 11, ref: 10},
+		{text: `{"location": "New York", "unit": "celsius"}`, want: 11, ref: 12},
+		{text: "naïve café", want: 3, ref: 4},
+		{text: "日本語のテキスト", want: 8, ref: 7},
+	}
+
+	for _, tt := range tests {
+		got := EstimateTokens(tt.text)
+		if got != tt.want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+		if diff := got - tt.ref; diff < -tt.ref/3-1 || diff > tt.ref/3+1 {
+			t.Errorf("EstimateTokens(%q) = %d, too far from the reference %d", tt.text, got, tt.ref)
+		}
+	}
+}
+
+func TestEstimateTokens_Monotonic(t *testing.T) {
+	texts := []string{
+		"The quick brown fox jumps over the lazy dog.",
+		"func main() {\n\tfmt.Println(\"héllo, 世界 👋\")\n}",
+		strings.Repeat("token ", 50),
+	}
+	for _, text := range texts {
+		prev := 0
+		for i := range text {
+			got := EstimateTokens(text[:i])
+			if got < prev {
+				t.Fatalf("EstimateTokens(%q) = %d, less than %d for a shorter prefix", text[:i], got, prev)
+			}
+			prev = got
+		}
+		if full := EstimateTokens(text); full < prev || full == 0 {
+			t.Errorf("EstimateTokens(%q) = %d, want at least %d", text, full, max(prev, 1))
+		}
+	}
+}
+
+func TestEstimateRequestTokens(t *testing.T) {
+	req := &ChatCompletionRequest{
+		Messages: []ChatMessage{
+			{Role: "system", Content: "You are terse."},
+			{Role: "user", Content: "What is the weather in Paris?"},
+		},
+	}
+	base := EstimateRequestTokens(req)
+
+	prompt, opts := RequestToQuery(req)
+	if want := EstimateTokens(opts.SystemPrompt) + EstimateTokens(prompt); base != want {
+		t.Errorf("EstimateRequestTokens = %d, want %d", base, want)
+	}
+
+	req.Messages = append(req.Messages, ChatMessage{Role: "user", Content: "And in Berlin?"})
+	more := EstimateRequestTokens(req)
+	if more <= base {
+		t.Errorf("adding a message should raise the estimate: %d -> %d", base, more)
+	}
+
+	req.Tools = []Tool{{Type: "function", Function: FunctionDefinition{
+		Name:       "get_weather",
+		Parameters: map[string]any{"type": "object", "properties": map[string]any{"city": map[string]any{"type": "string"}}},
+	}}}
+	if withTools := EstimateRequestTokens(req); withTools <= more {
+		t.Errorf("adding a tool should raise the estimate: %d -> %d", more, withTools)
+	}
+}
@@ -0,0 +1,93 @@
+package oai
+
+import "strings"
+
+// repairJSON rewrites the near-JSON accepted by [ParseToolCallsLenient] as
+// JSON: single-quoted strings are double-quoted, the Python literals True,
+// False, and None are replaced by their JSON spellings, and bare words
+// followed by a colon are quoted as object keys. Double-quoted strings are
+// copied unchanged. It reports false for a bare word it cannot place or an
+// unterminated string; the result is otherwise not validated, so callers
+// must still decode it.
+func repairJSON(s string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\'':
+			end, ok := repairString(&b, s, i)
+			if !ok {
+				return "", false
+			}
+			i = end
+		case c == '-' || isDigit(c):
+			// Copy numbers whole, so an exponent is not taken for a word
+			j := i + 1
+			for j < len(s) && (isDigit(s[j]) || strings.IndexByte(".eE+-", s[j]) >= 0) {
+				j++
+			}
+			b.WriteString(s[i:j])
+			i = j
+		case isWordStart(c):
+			j := i + 1
+			for j < len(s) && (isWordStart(s[j]) || isDigit(s[j])) {
+				j++
+			}
+			word := s[i:j]
+			switch word {
+			case "true", "True":
+				b.WriteString("true")
+			case "false", "False":
+				b.WriteString("false")
+			case "null", "None":
+				b.WriteString("null")
+			default:
+				if !strings.HasPrefix(strings.TrimLeft(s[j:], " \t\r\n"), ":") {
+					return "", false
+				}
+				b.WriteString(`"` + word + `"`)
+			}
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), true
+}
+
+// repairString copies the string literal starting at s[start] to b as a
+// double-quoted JSON string and returns the index just past it. In a
+// single-quoted string, double quotes are escaped and \' becomes a plain
+// quote; other escapes are kept as they are.
+func repairString(b *strings.Builder, s string, start int) (end int, ok bool) {
+	quote := s[start]
+	b.WriteByte('"')
+	for i := start + 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			if quote == '\'' && s[i+1] == '\'' {
+				b.WriteByte('\'')
+			} else {
+				b.WriteString(s[i : i+2])
+			}
+			i++
+		case c == quote:
+			b.WriteByte('"')
+			return i + 1, true
+		case c == '"':
+			b.WriteString(`\"`) // only reached inside single quotes
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return 0, false
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isWordStart(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c == '$'
+}
@@ -0,0 +1,127 @@
+package oai
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// ValidateToolCall checks that call targets the function described by def and
+// that its arguments satisfy def.Parameters, using [ValidateJSONSchema]. A
+// nil Parameters schema accepts any arguments object.
+func ValidateToolCall(call ToolCall, def FunctionDefinition) error {
+	if call.Function.Name != def.Name {
+		return fmt.Errorf("tool call %s: function %q does not match %q", call.ID, call.Function.Name, def.Name)
+	}
+	var args any
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+		return fmt.Errorf("tool call %s: arguments are not valid JSON: %w", call.ID, err)
+	}
+	if def.Parameters == nil {
+		return nil
+	}
+	if err := ValidateJSONSchema(args, def.Parameters); err != nil {
+		return fmt.Errorf("tool call %s: %w", call.ID, err)
+	}
+	return nil
+}
+
+// coerceToolCall returns call with arguments converted where the schema asks
+// for a scalar type and the model supplied a string spelling of it, such as
+// "30" for an integer or "true" for a boolean. Arguments that do not parse
+// are returned unchanged.
+func coerceToolCall(call ToolCall, def FunctionDefinition) ToolCall {
+	if def.Parameters == nil {
+		return call
+	}
+	var args any
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+		return call
+	}
+	coerced, changed := coerceValue(args, normalizeSchema(def.Parameters))
+	if !changed {
+		return call
+	}
+	data, err := json.Marshal(coerced)
+	if err != nil {
+		return call
+	}
+	call.Function.Arguments = string(data)
+	return call
+}
+
+func coerceValue(value, schema any) (any, bool) {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return value, false
+	}
+
+	switch v := value.(type) {
+	case string:
+		switch s["type"] {
+		case "integer":
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return float64(n), true
+			}
+		case "number":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, true
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, true
+			}
+		}
+	case map[string]any:
+		props, _ := s["properties"].(map[string]any)
+		changed := false
+		for name, pv := range v {
+			if nv, ok := coerceValue(pv, props[name]); ok {
+				v[name] = nv
+				changed = true
+			}
+		}
+		return v, changed
+	case []any:
+		changed := false
+		for i, item := range v {
+			if nv, ok := coerceValue(item, s["items"]); ok {
+				v[i] = nv
+				changed = true
+			}
+		}
+		return v, changed
+	}
+	return value, false
+}
+
+// validateToolCalls coerces the tool calls in msg against the matching tool
+// definitions and returns validation errors keyed by call ID, or nil if all
+// calls are valid.
+func validateToolCalls(msg *ChatMessage, tools []Tool) map[string]string {
+	var errs map[string]string
+	for i, call := range msg.ToolCalls {
+		var def *FunctionDefinition
+		for j := range tools {
+			if tools[j].Function.Name == call.Function.Name {
+				def = &tools[j].Function
+				break
+			}
+		}
+
+		var err error
+		if def == nil {
+			err = fmt.Errorf("tool call %s: unknown function %q", call.ID, call.Function.Name)
+		} else {
+			msg.ToolCalls[i] = coerceToolCall(call, *def)
+			err = ValidateToolCall(msg.ToolCalls[i], *def)
+		}
+		if err != nil {
+			if errs == nil {
+				errs = make(map[string]string)
+			}
+			errs[call.ID] = err.Error()
+		}
+	}
+	return errs
+}
@@ -0,0 +1,108 @@
+package oai
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+)
+
+var createUserDef = FunctionDefinition{
+	Name: "create_user",
+	Parameters: NewParams().
+		String("name", "", true).
+		Integer("age", "", true).
+		Boolean("admin", "", false).
+		Build(),
+}
+
+func TestValidateToolCall(t *testing.T) {
+	tests := []struct {
+		name    string
+		call    ToolCall
+		wantErr string
+	}{
+		{
+			name: "valid",
+			call: ToolCall{ID: "call_1", Function: FunctionCall{Name: "create_user", Arguments: `{"name":"Ann","age":30}`}},
+		},
+		{
+			name:    "missing_required",
+			call:    ToolCall{ID: "call_1", Function: FunctionCall{Name: "create_user", Arguments: `{"name":"Ann"}`}},
+			wantErr: `missing required property "age"`,
+		},
+		{
+			name:    "wrong_type",
+			call:    ToolCall{ID: "call_1", Function: FunctionCall{Name: "create_user", Arguments: `{"name":"Ann","age":"30"}`}},
+			wantErr: "$.age: expected integer",
+		},
+		{
+			name:    "wrong_function",
+			call:    ToolCall{ID: "call_1", Function: FunctionCall{Name: "delete_user", Arguments: `{}`}},
+			wantErr: "does not match",
+		},
+		{
+			name:    "invalid_json",
+			call:    ToolCall{ID: "call_1", Function: FunctionCall{Name: "create_user", Arguments: `{`}},
+			wantErr: "not valid JSON",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateToolCall(tt.call, createUserDef)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResultToResponseFor_ValidateToolCalls(t *testing.T) {
+	text := `<tool_call>{"name":"create_user","arguments":{"name":"Ann","age":"30","admin":"true"}}</tool_call>` +
+		`<tool_call>{"name":"create_user","arguments":{"name":"Bob","age":"thirty"}}</tool_call>` +
+		`<tool_call>{"name":"launch_rocket","arguments":{}}</tool_call>`
+	assistant := &ccwire.AssistantMessage{Message: ccwire.AssistantInner{
+		Content: []ccwire.ContentBlock{{Type: "text", Text: text}},
+	}}
+	result := &ccwire.ResultMessage{SessionID: "sess-1"}
+	tools := []Tool{{Type: "function", Function: createUserDef}}
+
+	t.Run("default_unchanged", func(t *testing.T) {
+		resp := ResultToResponseFor(&ChatCompletionRequest{Tools: tools}, result, assistant)
+		if resp.ToolCallErrors != nil {
+			t.Errorf("ToolCallErrors = %v, want nil without opt-in", resp.ToolCallErrors)
+		}
+		if args := resp.Choices[0].Message.ToolCalls[0].Function.Arguments; !strings.Contains(args, `"age":"30"`) {
+			t.Errorf("arguments should not be coerced by default: %s", args)
+		}
+	})
+
+	t.Run("opt_in", func(t *testing.T) {
+		resp := ResultToResponseFor(&ChatCompletionRequest{Tools: tools, ValidateToolCalls: true}, result, assistant)
+		calls := resp.Choices[0].Message.ToolCalls
+		if len(calls) != 3 {
+			t.Fatalf("expected all 3 tool calls to be kept, got %d", len(calls))
+		}
+
+		args := calls[0].Function.Arguments
+		if !strings.Contains(args, `"age":30`) || !strings.Contains(args, `"admin":true`) {
+			t.Errorf("expected coerced arguments, got %s", args)
+		}
+		if _, ok := resp.ToolCallErrors[calls[0].ID]; ok {
+			t.Errorf("coerced call should be valid, got error %q", resp.ToolCallErrors[calls[0].ID])
+		}
+		if msg := resp.ToolCallErrors[calls[1].ID]; !strings.Contains(msg, "expected integer") {
+			t.Errorf("second call error = %q, want type error", msg)
+		}
+		if msg := resp.ToolCallErrors[calls[2].ID]; !strings.Contains(msg, "unknown function") {
+			t.Errorf("third call error = %q, want unknown function", msg)
+		}
+	})
+}
@@ -21,6 +21,13 @@ import (
 //
 // These tags are later extracted by [ParseToolCalls].
 func ToolCallInstructions(tools []Tool) string {
+	return toolCallInstructions(tools, true)
+}
+
+// toolCallInstructions is [ToolCallInstructions] with control over whether
+// the model may call several tools in one turn. When parallel is false it is
+// told to emit exactly one <tool_call> instead.
+func toolCallInstructions(tools []Tool, parallel bool) string {
 	if len(tools) == 0 {
 		return ""
 	}
@@ -53,7 +60,11 @@ func ToolCallInstructions(tools []Tool) string {
 	}
 
 	b.WriteString("When calling tools, output only <tool_call> tags with no additional text after them.\n")
-	b.WriteString("You may output text before tool calls, and you may call multiple tools.\n")
+	if parallel {
+		b.WriteString("You may output text before tool calls, and you may call multiple tools.\n")
+	} else {
+		b.WriteString("You may output text before the tool call, but call at most one tool per response: output exactly one <tool_call> tag.\n")
+	}
 
 	return b.String()
 }
@@ -72,6 +83,23 @@ var toolCallRe = regexp.MustCompile(`(?s)<tool_call>(.*?)</tool_call>`)
 // match the expected {"name": ..., "arguments": ...} schema -- are silently
 // preserved in the returned text, allowing the caller to see the raw output.
 func ParseToolCalls(text string) (cleanText string, calls []ToolCall) {
+	return parseToolCalls(text, false)
+}
+
+// ParseToolCallsLenient is like [ParseToolCalls], but when the payload of a
+// <tool_call> tag is not valid JSON it tries to repair the near-JSON that
+// smaller models tend to produce before giving up on the tag: single-quoted
+// strings become double-quoted, the Python literals True, False, and None
+// become true, false, and null, and bare object keys are quoted. Payloads
+// that are valid JSON are parsed exactly as by ParseToolCalls, and ones the
+// repairs cannot fix are still preserved in the returned text.
+func ParseToolCallsLenient(text string) (cleanText string, calls []ToolCall) {
+	return parseToolCalls(text, true)
+}
+
+// parseToolCalls implements [ParseToolCalls] and, with lenient set,
+// [ParseToolCallsLenient].
+func parseToolCalls(text string, lenient bool) (cleanText string, calls []ToolCall) {
 	matches := toolCallRe.FindAllStringSubmatchIndex(text, -1)
 	if len(matches) == 0 {
 		return text, nil
@@ -89,8 +117,15 @@ func ParseToolCalls(text string) (cleanText string, calls []ToolCall) {
 			Arguments map[string]any `json:"arguments"`
 		}
 		if err := json.Unmarshal([]byte(jsonStr), &parsed); err != nil {
-			// JSON parse failed - preserve the entire <tool_call> tag in output
-			continue
+			repaired, ok := "", false
+			if lenient {
+				repaired, ok = repairJSON(jsonStr)
+			}
+			parsed.Name, parsed.Arguments = "", nil
+			if !ok || json.Unmarshal([]byte(repaired), &parsed) != nil {
+				// JSON parse failed - preserve the entire <tool_call> tag in output
+				continue
+			}
 		}
 
 		argsJSON, err := json.Marshal(parsed.Arguments)
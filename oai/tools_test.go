@@ -349,6 +349,96 @@ func TestParseToolCalls(t *testing.T) {
 	}
 }
 
+func TestParseToolCallsLenient(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		wantName string
+		wantArgs string // empty when the tag must be left in the text
+	}{
+		{
+			name:     "valid_json",
+			payload:  `{"name": "get_weather", "arguments": {"city": "Paris"}}`,
+			wantName: "get_weather",
+			wantArgs: `{"city":"Paris"}`,
+		},
+		{
+			name:     "single_quotes",
+			payload:  `{'name': 'get_weather', 'arguments': {'city': 'Paris'}}`,
+			wantName: "get_weather",
+			wantArgs: `{"city":"Paris"}`,
+		},
+		{
+			name:     "single_quotes_with_quotes_inside",
+			payload:  `{'name': 'say', 'arguments': {'text': 'She said "hi" and it\'s fine'}}`,
+			wantName: "say",
+			wantArgs: `{"text":"She said \"hi\" and it's fine"}`,
+		},
+		{
+			name:     "python_literals",
+			payload:  `{"name": "search", "arguments": {"exact": True, "fuzzy": False, "limit": None}}`,
+			wantName: "search",
+			wantArgs: `{"exact":true,"fuzzy":false,"limit":null}`,
+		},
+		{
+			name:     "unquoted_keys",
+			payload:  `{name: "search", arguments: {query: "go", max_results: 5, min_score: -1.5e-3}}`,
+			wantName: "search",
+			wantArgs: `{"max_results":5,"min_score":-0.0015,"query":"go"}`,
+		},
+		{
+			name:     "all_repairs",
+			payload:  `{name: 'search', arguments: {'query': 'True story', exact: True}}`,
+			wantName: "search",
+			wantArgs: `{"exact":true,"query":"True story"}`,
+		},
+		{
+			name:    "bare_word_value",
+			payload: `{"name": "search", "arguments": {"query": golang}}`,
+		},
+		{
+			name:    "unterminated_string",
+			payload: `{'name': 'search', 'arguments': {'query': 'go}}`,
+		},
+		{
+			name:    "missing_brace",
+			payload: `{'name': 'search', 'arguments': {'query': 'go'}`,
+		},
+		{
+			name:    "not_json_at_all",
+			payload: `search for go please`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "Let me look. <tool_call>" + tt.payload + "</tool_call>"
+			text, calls := ParseToolCallsLenient(input)
+
+			if tt.wantArgs == "" {
+				if len(calls) != 0 || text != input {
+					t.Errorf("ParseToolCallsLenient() = %q, %+v, want the input back unchanged", text, calls)
+				}
+				return
+			}
+			if len(calls) != 1 {
+				t.Fatalf("ParseToolCallsLenient() returned %d calls, want 1 (text %q)", len(calls), text)
+			}
+			if text != "Let me look." {
+				t.Errorf("text = %q, want %q", text, "Let me look.")
+			}
+			if calls[0].Function.Name != tt.wantName || calls[0].Function.Arguments != tt.wantArgs {
+				t.Errorf("call = %s(%s), want %s(%s)", calls[0].Function.Name, calls[0].Function.Arguments, tt.wantName, tt.wantArgs)
+			}
+			if tt.name != "valid_json" {
+				if _, strict := ParseToolCalls(input); len(strict) != 0 {
+					t.Errorf("ParseToolCalls() accepted %q, want strict parsing unchanged", tt.payload)
+				}
+			}
+		})
+	}
+}
+
 func TestHasToolCallPrefix(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -0,0 +1,61 @@
+package oai
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// validUTF8 returns s with each run of invalid UTF-8 bytes replaced by
+// U+FFFD, as JSON encoding would otherwise do byte by byte.
+func validUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	return strings.ToValidUTF8(s, "�")
+}
+
+// runeJoiner turns a sequence of text deltas into valid UTF-8, holding back
+// the start of a multibyte rune that is split across deltas until the rest
+// of it arrives.
+type runeJoiner struct {
+	tail string // incomplete rune at the end of the last delta
+}
+
+// next returns delta, preceded by the withheld tail of the previous one, as
+// valid UTF-8, withholding any incomplete rune at its end.
+func (j *runeJoiner) next(delta string) string {
+	s := j.tail + delta
+	j.tail = ""
+	// Find where the last rune starts; an incomplete one is withheld
+	start := len(s)
+	for start > 0 && len(s)-start < utf8.UTFMax-1 {
+		start--
+		if utf8.RuneStart(s[start]) {
+			break
+		}
+	}
+	if !utf8.FullRuneInString(s[start:]) {
+		j.tail = s[start:]
+		s = s[:start]
+	}
+	return validUTF8(s)
+}
+
+// flush returns the withheld tail, which can no longer be completed, as
+// U+FFFD, or "" if there is none.
+func (j *runeJoiner) flush() string {
+	tail := j.tail
+	j.tail = ""
+	return validUTF8(tail)
+}
+
+// runeBoundary returns the largest index i <= n at which s does not split a
+// rune, looking back at most [utf8.UTFMax]-1 bytes.
+func runeBoundary(s string, n int) int {
+	for i := n; i > 0 && i > n-utf8.UTFMax; i-- {
+		if i == len(s) || utf8.RuneStart(s[i]) {
+			return i
+		}
+	}
+	return n
+}
@@ -0,0 +1,115 @@
+package oai
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+)
+
+func TestRuneJoiner(t *testing.T) {
+	tests := []struct {
+		name   string
+		deltas []string
+		want   []string
+		flush  string
+	}{
+		{"ascii", []string{"ab", "c"}, []string{"ab", "c"}, ""},
+		{"split_euro", []string{"a\xe2\x82", "\xacb"}, []string{"a", "€b"}, ""},
+		{"split_three_ways", []string{"\xf0\x9f", "\x98", "\x80!"}, []string{"", "", "😀!"}, ""},
+		{"invalid_byte", []string{"a\xffb"}, []string{"a�b"}, ""},
+		{"never_completed", []string{"a\xe2\x82"}, []string{"a"}, "�"},
+		{"stray_continuation", []string{"a\x82"}, []string{"a�"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var j runeJoiner
+			for i, delta := range tt.deltas {
+				if got := j.next(delta); got != tt.want[i] {
+					t.Errorf("next(%q) = %q, want %q", delta, got, tt.want[i])
+				}
+			}
+			if got := j.flush(); got != tt.flush {
+				t.Errorf("flush() = %q, want %q", got, tt.flush)
+			}
+		})
+	}
+}
+
+func TestRuneBoundary(t *testing.T) {
+	s := "ab€cd" // € is bytes 2..4
+	for n, want := range map[int]int{0: 0, 2: 2, 3: 2, 4: 2, 5: 5, 7: 7} {
+		if got := runeBoundary(s, n); got != want {
+			t.Errorf("runeBoundary(%q, %d) = %d, want %d", s, n, got, want)
+		}
+	}
+}
+
+func textDelta(text string) *ccwire.StreamEventMessage {
+	return &ccwire.StreamEventMessage{
+		Event: map[string]any{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": map[string]any{"type": "text_delta", "text": text},
+		},
+	}
+}
+
+func TestStreamState_SplitRunes(t *testing.T) {
+	euro := "€"
+	long := strings.Repeat("x", tagMaxPrefix)
+	tests := []struct {
+		name     string
+		hasTools bool
+		deltas   []string
+		want     string
+	}{
+		{"split_rune", false, []string{"price: " + euro[:1], euro[1:2], euro[2:] + "5"}, "price: €5"},
+		{"invalid_bytes", false, []string{"a\xff", "b"}, "a�b"},
+		{"unfinished_rune", false, []string{"a", euro[:2]}, "a�"},
+		// The safety margin ends inside the €s, which must not be cut
+		{"tools_margin", true, []string{"€€€€€€€€" + long[:len(long)-1], "!", "€€"}, "€€€€€€€€" + long[:len(long)-1] + "!€€"},
+		{"tools_split_rune", true, []string{long + "a" + euro[:1], euro[1:] + long}, long + "a€" + long},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss := NewStreamState(tt.hasTools)
+			var chunks []*ChatCompletionChunk
+			for _, delta := range tt.deltas {
+				chunks = append(chunks, ss.HandleStreamEvent(textDelta(delta))...)
+			}
+			chunks = append(chunks, ss.FinishChunk(nil)...)
+
+			var got strings.Builder
+			for _, chunk := range chunks {
+				if content := chunk.Choices[0].Delta.Content; content != nil {
+					if !utf8.ValidString(*content) {
+						t.Errorf("chunk content %q is not valid UTF-8", *content)
+					}
+					got.WriteString(*content)
+				}
+			}
+			if got.String() != tt.want {
+				t.Errorf("content = %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestResultToResponse_InvalidUTF8(t *testing.T) {
+	assistant := &ccwire.AssistantMessage{}
+	assistant.Message.Content = []ccwire.ContentBlock{
+		{Type: "thinking", Thinking: "hmm\xff"},
+		{Type: "text", Text: "a\xffb"},
+	}
+	resp := ResultToResponseFor(&ChatCompletionRequest{Model: "sonnet", IncludeThinking: true}, &ccwire.ResultMessage{}, assistant)
+
+	msg := resp.Choices[0].Message
+	if got := msg.StringContent(); got != "a�b" {
+		t.Errorf("content = %q, want %q", got, "a�b")
+	}
+	if msg.ReasoningContent != "hmm�" {
+		t.Errorf("reasoning_content = %q, want %q", msg.ReasoningContent, "hmm�")
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/codewandler/cc-sdk-go/oai"
+)
+
+// maxBatchSize is the largest number of requests accepted in one batch.
+const maxBatchSize = 100
+
+// BatchResult is one element of a /v1/batch response. Status is the HTTP
+// status the request would have received on its own, and exactly one of
+// Response and Error is set.
+type BatchResult struct {
+	Status   int                         `json:"status"`
+	Response *oai.ChatCompletionResponse `json:"response,omitempty"`
+	Error    *oai.ErrorDetail            `json:"error,omitempty"`
+}
+
+// handleBatch serves the /v1/batch endpoint. The body is a JSON array of
+// chat completion requests, which are run concurrently, at most as many at
+// a time as the client's MaxConcurrent allows. The response is an array of
+// [BatchResult] in input order; a failed element gets an error in its slot
+// rather than failing the batch, so the response status is 200 whenever the
+// body itself is valid. Streaming is not supported. An X-CC-Timeout header
+// applies to every request of the batch.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Only POST is accepted")
+		return
+	}
+
+	var reqs []json.RawMessage
+	if err := s.decodeBody(w, r, &reqs); err != nil {
+		writeError(w, err.status, err.errType, err.message)
+		return
+	}
+	if len(reqs) == 0 {
+		writeError(w, http.StatusBadRequest, oai.ErrorTypeInvalidRequest, "Batch must contain at least one request")
+		return
+	}
+	if len(reqs) > maxBatchSize {
+		writeError(w, http.StatusBadRequest, oai.ErrorTypeInvalidRequest, fmt.Sprintf("Batch has %d requests, at most %d are allowed", len(reqs), maxBatchSize))
+		return
+	}
+	timeout, err := s.requestTimeout(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, oai.ErrorTypeInvalidRequest, err.Error())
+		return
+	}
+
+	workers := len(reqs)
+	if s.client != nil {
+		if limit := s.client.Stats().MaxConcurrent; limit > 0 {
+			workers = min(workers, limit)
+		}
+	}
+	results := make([]BatchResult, len(reqs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = s.runBatchRequest(r, reqs[i], timeout)
+			}
+		}()
+	}
+	for i := range reqs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	writeJSON(w, results)
+}
+
+// runBatchRequest runs one element of a batch as a non-streaming chat
+// completion with the given process timeout (0 for the client's default),
+// reporting any failure in the result.
+func (s *Server) runBatchRequest(r *http.Request, raw json.RawMessage, timeout time.Duration) BatchResult {
+	fail := func(status int, errType, message string) BatchResult {
+		return BatchResult{Status: status, Error: &oai.ErrorDetail{Message: message, Type: errType}}
+	}
+
+	var req oai.ChatCompletionRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return fail(http.StatusBadRequest, oai.ErrorTypeInvalidRequest, "Invalid JSON: "+err.Error())
+	}
+	if req.Stream {
+		return fail(http.StatusBadRequest, oai.ErrorTypeInvalidRequest, "Streaming is not supported in batches")
+	}
+	if err := s.checkChatRequest(&req); err != nil {
+		return fail(http.StatusBadRequest, oai.ErrorTypeInvalidRequest, err.Error())
+	}
+
+	req.Model = s.resolveModel(req.Model)
+	prompt, opts := s.requestToQuery(&req)
+	opts.AutoCloseOnContextDone = true // free the process if the client goes away
+	opts.Timeout = timeout
+
+	stream, err := s.querier.Query(r.Context(), prompt, opts)
+	if err != nil {
+		return fail(queryErrorStatus(err))
+	}
+	defer stream.Close()
+
+	resp, _, rerr := s.collectResult(r.Context(), stream, &req)
+	if rerr != nil {
+		return fail(rerr.status, rerr.errType, rerr.message)
+	}
+	return BatchResult{Status: http.StatusOK, Response: resp}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codewandler/cc-sdk-go/cchat"
+)
+
+// TestBatch verifies that batch elements run through the chat bridge and come
+// back in input order, with failed and invalid elements reported in their own
+// slots, and that no more than MaxConcurrent run at once.
+func TestBatch(t *testing.T) {
+	var running, peak atomic.Int32
+	client := cchat.NewClientWithSpawner(&cchat.ClientConfig{MaxConcurrent: 2}, func(_ context.Context, prompt string, _ cchat.QueryOptions) (io.ReadCloser, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		if strings.Contains(prompt, "fail") {
+			return io.NopCloser(strings.NewReader(`{"type":"result","subtype":"error_during_execution","is_error":true,"result":"it broke"}` + "\n")), nil
+		}
+		word := prompt[strings.LastIndex(prompt, " ")+1:]
+		return io.NopCloser(strings.NewReader(`{"type":"result","subtype":"success","session_id":"s1","result":"echo ` + word + `"}` + "\n")), nil
+	})
+	handler := New(Config{Client: client, BatchAPI: true}).Handler()
+
+	body := `[
+		{"messages":[{"role":"user","content":"say one"}]},
+		{"messages":[{"role":"user","content":"please fail"}]},
+		{"messages":[{"role":"user","content":"say two"}]},
+		{"messages":[{"role":"user","content":"say three"}],"stream":true},
+		{"messages":[]},
+		{"messages":[{"role":"user","content":"say four"}]}
+	]`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/batch", strings.NewReader(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var results []BatchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	want := []struct {
+		status  int
+		content string
+	}{
+		{http.StatusOK, "echo one"},
+		{http.StatusInternalServerError, ""},
+		{http.StatusOK, "echo two"},
+		{http.StatusBadRequest, ""},
+		{http.StatusBadRequest, ""},
+		{http.StatusOK, "echo four"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, res := range results {
+		if res.Status != want[i].status {
+			t.Errorf("results[%d].Status = %d, want %d", i, res.Status, want[i].status)
+		}
+		if want[i].status != http.StatusOK {
+			if res.Error == nil || res.Response != nil {
+				t.Errorf("results[%d] = %+v, want only an error", i, res)
+			}
+			continue
+		}
+		if res.Response == nil {
+			t.Errorf("results[%d] has no response: %+v", i, res.Error)
+			continue
+		}
+		if got := res.Response.Choices[0].Message.StringContent(); got != want[i].content {
+			t.Errorf("results[%d] content = %q, want %q", i, got, want[i].content)
+		}
+	}
+	if results[1].Error != nil && results[1].Error.Message != "it broke" {
+		t.Errorf("results[1] error = %q, want the claude error", results[1].Error.Message)
+	}
+	if p := peak.Load(); p > 2 {
+		t.Errorf("%d requests ran at once, want at most MaxConcurrent 2", p)
+	}
+}
+
+func TestBatch_InvalidBody(t *testing.T) {
+	handler := New(Config{Client: cchat.NewClient(&cchat.ClientConfig{}), BatchAPI: true}).Handler()
+
+	for _, body := range []string{`{"messages":[]}`, `[]`, `[` + strings.Repeat(`{},`, maxBatchSize) + `{}]`} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/batch", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("body %.20s: status = %d, want 400", body, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	New(Config{Client: cchat.NewClient(&cchat.ClientConfig{})}).Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/batch", strings.NewReader(`[]`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status without BatchAPI = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/codewandler/cc-sdk-go/oai"
+)
+
+// coalescer merges consecutive text-only chunks into one, so token-level
+// deltas go out as fewer, larger SSE events. Text is held until interval has
+// passed since the first held delta; any other chunk (role, reasoning, tool
+// calls, finish) first releases the held text and then passes through
+// immediately. The pull-based stream has no timer, so the interval is
+// checked whenever the caller offers chunks, i.e. as messages arrive from
+// the CLI. A zero interval disables coalescing.
+type coalescer struct {
+	interval time.Duration
+	held     *oai.ChatCompletionChunk // first held chunk; its content is replaced on release
+	text     strings.Builder
+	since    time.Time
+}
+
+// add offers chunks read at now and returns the chunks to write.
+func (c *coalescer) add(now time.Time, chunks []*oai.ChatCompletionChunk) []*oai.ChatCompletionChunk {
+	if c.interval <= 0 {
+		return chunks
+	}
+	var out []*oai.ChatCompletionChunk
+	for _, chunk := range chunks {
+		if !isTextChunk(chunk) {
+			out = append(out, c.flush()...)
+			out = append(out, chunk)
+			continue
+		}
+		if c.held == nil {
+			c.held, c.since = chunk, now
+		}
+		c.text.WriteString(*chunk.Choices[0].Delta.Content)
+	}
+	if c.held != nil && now.Sub(c.since) >= c.interval {
+		out = append(out, c.flush()...)
+	}
+	return out
+}
+
+// flush releases the held text as a single chunk, if any.
+func (c *coalescer) flush() []*oai.ChatCompletionChunk {
+	if c.held == nil {
+		return nil
+	}
+	chunk := *c.held
+	text := c.text.String()
+	chunk.Choices = []oai.ChunkChoice{{Index: c.held.Choices[0].Index, Delta: oai.ChunkDelta{Content: &text}}}
+	c.held = nil
+	c.text.Reset()
+	return []*oai.ChatCompletionChunk{&chunk}
+}
+
+// isTextChunk reports whether chunk carries nothing but a content delta.
+func isTextChunk(chunk *oai.ChatCompletionChunk) bool {
+	if len(chunk.Choices) != 1 || chunk.Usage != nil {
+		return false
+	}
+	c := chunk.Choices[0]
+	d := c.Delta
+	return d.Content != nil && d.Role == "" && d.ReasoningContent == nil && len(d.ToolCalls) == 0 && c.FinishReason == nil
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+	"github.com/codewandler/cc-sdk-go/oai"
+)
+
+func TestCoalescer(t *testing.T) {
+	str := func(s string) *string { return &s }
+	text := func(s string) *oai.ChatCompletionChunk {
+		return &oai.ChatCompletionChunk{ID: "chatcmpl-1", Choices: []oai.ChunkChoice{{Delta: oai.ChunkDelta{Content: str(s)}}}}
+	}
+	contents := func(chunks []*oai.ChatCompletionChunk) []string {
+		var out []string
+		for _, c := range chunks {
+			if d := c.Choices[0].Delta; d.Content != nil {
+				out = append(out, *d.Content)
+			} else {
+				out = append(out, "<other>")
+			}
+		}
+		return out
+	}
+
+	t0 := time.Unix(0, 0)
+	c := coalescer{interval: 100 * time.Millisecond}
+	if got := c.add(t0, []*oai.ChatCompletionChunk{text("a"), text("b")}); len(got) != 0 {
+		t.Fatalf("text within the interval should be held, got %v", contents(got))
+	}
+	if got := c.add(t0.Add(50*time.Millisecond), []*oai.ChatCompletionChunk{text("c")}); len(got) != 0 {
+		t.Fatalf("text within the interval should be held, got %v", contents(got))
+	}
+	got := c.add(t0.Add(100*time.Millisecond), []*oai.ChatCompletionChunk{text("d")})
+	if s := strings.Join(contents(got), ","); s != "abcd" {
+		t.Errorf("after the interval = %s, want abcd", s)
+	}
+	if got[0].ID != "chatcmpl-1" {
+		t.Errorf("coalesced chunk lost its ID: %+v", got[0])
+	}
+
+	// A non-text chunk releases held text and is not delayed itself
+	stop := "stop"
+	finish := &oai.ChatCompletionChunk{Choices: []oai.ChunkChoice{{FinishReason: &stop}}}
+	got = c.add(t0.Add(110*time.Millisecond), []*oai.ChatCompletionChunk{text("e"), finish})
+	if s := strings.Join(contents(got), ","); s != "e,<other>" {
+		t.Errorf("finish = %s, want e,<other>", s)
+	}
+	if got := c.flush(); got != nil {
+		t.Errorf("flush after release = %v, want nil", contents(got))
+	}
+
+	// Disabled: chunks pass through unchanged
+	off := coalescer{}
+	if got := off.add(t0, []*oai.ChatCompletionChunk{text("a"), text("b")}); len(got) != 2 {
+		t.Errorf("disabled coalescer returned %d chunks, want 2", len(got))
+	}
+}
+
+// TestStreamingResponse_FlushInterval counts the SSE frames of a token-level
+// stream with and without coalescing.
+func TestStreamingResponse_FlushInterval(t *testing.T) {
+	messages := []ccwire.Message{
+		&ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start", "message": map[string]any{"model": "claude-haiku"}}},
+	}
+	for _, tok := range strings.Split("The quick brown fox jumps over the lazy dog", " ") {
+		messages = append(messages, &ccwire.StreamEventMessage{Event: map[string]any{
+			"type":  "content_block_delta",
+			"delta": map[string]any{"type": "text_delta", "text": tok + " "},
+		}})
+	}
+	messages = append(messages, &ccwire.ResultMessage{SessionID: "sess-1"})
+
+	frames := func(interval time.Duration) []string {
+		srv := New(Config{FlushInterval: interval})
+		w := httptest.NewRecorder()
+		srv.handleStreamingResponse(context.Background(), w, &mockStream{messages: messages}, &oai.ChatCompletionRequest{})
+		return strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	}
+
+	perDelta := frames(0)
+	coalesced := frames(time.Hour)
+	// role, 9 deltas, finish, [DONE]
+	if len(perDelta) != 12 {
+		t.Errorf("per-delta frames = %d, want 12:\n%s", len(perDelta), strings.Join(perDelta, "\n"))
+	}
+	// role, one coalesced text chunk, finish, [DONE]
+	if len(coalesced) != 4 {
+		t.Fatalf("coalesced frames = %d, want 4:\n%s", len(coalesced), strings.Join(coalesced, "\n"))
+	}
+	if !strings.Contains(coalesced[1], `"content":"The quick brown fox jumps over the lazy dog "`) {
+		t.Errorf("coalesced text frame = %s", coalesced[1])
+	}
+	if coalesced[3] != "data: [DONE]" {
+		t.Errorf("last frame = %s", coalesced[3])
+	}
+}
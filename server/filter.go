@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/codewandler/cc-sdk-go/oai"
+)
+
+// FinishReasonContentFilter is the finish reason of a reply whose output was
+// rejected by [Config].ContentFilter, the same as for a reply the model
+// refused.
+const FinishReasonContentFilter = oai.FinishReasonContentFilter
+
+// errContentFiltered ends a stream whose output was rejected by the content
+// filter, after the closing chunk and [DONE] have been written.
+var errContentFiltered = errors.New("output rejected by the content filter")
+
+// filterRequest applies [Config].ContentFilter to the text of each message of
+// req, replacing it with the filtered text. It returns an error, to be
+// reported as 400, if the filter rejects a message.
+func (s *Server) filterRequest(req *oai.ChatCompletionRequest) error {
+	if s.cfg.ContentFilter == nil {
+		return nil
+	}
+	for i := range req.Messages {
+		msg := &req.Messages[i]
+		text := msg.StringContent()
+		filtered, ok := s.cfg.ContentFilter(msg.Role, text)
+		if !ok {
+			return fmt.Errorf("Message %d was rejected by the content filter", i)
+		}
+		if filtered != text {
+			msg.Content = filtered
+		}
+	}
+	return nil
+}
+
+// filterResponse applies [Config].ContentFilter to the content of resp. If
+// the filter rejects it, the content and any tool calls are removed and the
+// finish reason is [FinishReasonContentFilter].
+func (s *Server) filterResponse(resp *oai.ChatCompletionResponse) {
+	if s.cfg.ContentFilter == nil {
+		return
+	}
+	for i := range resp.Choices {
+		choice := &resp.Choices[i]
+		text := choice.Message.StringContent()
+		if text == "" {
+			continue
+		}
+		filtered, ok := s.cfg.ContentFilter("assistant", text)
+		if !ok {
+			choice.Message.Content = nil
+			choice.Message.ToolCalls = nil
+			choice.FinishReason = FinishReasonContentFilter
+			continue
+		}
+		if filtered != text {
+			choice.Message.Content = filtered
+		}
+	}
+}
+
+// filterChunks applies [Config].ContentFilter to the content deltas of
+// chunks, each on its own. If the filter rejects one, the chunks from there
+// on are replaced by a closing chunk with finish reason
+// [FinishReasonContentFilter], and rejected is true so the caller stops
+// streaming.
+func (s *Server) filterChunks(chunks []*oai.ChatCompletionChunk) (out []*oai.ChatCompletionChunk, rejected bool) {
+	if s.cfg.ContentFilter == nil {
+		return chunks, false
+	}
+	for _, chunk := range chunks {
+		for i := range chunk.Choices {
+			delta := &chunk.Choices[i].Delta
+			if delta.Content == nil || *delta.Content == "" {
+				continue
+			}
+			filtered, ok := s.cfg.ContentFilter("assistant", *delta.Content)
+			if !ok {
+				reason := FinishReasonContentFilter
+				closing := *chunk
+				closing.Choices = []oai.ChunkChoice{{Index: 0, FinishReason: &reason}}
+				closing.Usage = nil
+				return append(out, &closing), true
+			}
+			delta.Content = &filtered
+		}
+		out = append(out, chunk)
+	}
+	return out, false
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codewandler/cc-sdk-go/cchat"
+	"github.com/codewandler/cc-sdk-go/ccwire"
+	"github.com/codewandler/cc-sdk-go/oai"
+)
+
+// secretFilter redacts "secret" and rejects anything mentioning "forbidden".
+func secretFilter(role, text string) (string, bool) {
+	if strings.Contains(text, "forbidden") {
+		return "", false
+	}
+	return strings.ReplaceAll(text, "secret", "[redacted]"), true
+}
+
+// filterServer returns a server using secretFilter whose CLI replies with
+// reply, recording the prompt it was given.
+func filterServer(reply string, prompt *string) *Server {
+	return New(Config{
+		ContentFilter: secretFilter,
+		MessagesAPI:   true,
+		Querier: queryFunc(func(_ context.Context, p string, _ cchat.QueryOptions) (StreamReader, error) {
+			*prompt = p
+			return &mockStream{messages: []ccwire.Message{
+				&ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start", "message": map[string]any{"model": "claude-haiku"}}},
+				&ccwire.StreamEventMessage{Event: map[string]any{
+					"type":  "content_block_delta",
+					"delta": map[string]any{"type": "text_delta", "text": reply},
+				}},
+				&ccwire.AssistantMessage{Message: ccwire.AssistantInner{Model: "claude-haiku", Content: []ccwire.ContentBlock{{Type: "text", Text: reply}}}},
+				&ccwire.ResultMessage{Subtype: "success", Result: reply},
+			}}, nil
+		}),
+	})
+}
+
+func TestContentFilter_Redact(t *testing.T) {
+	var prompt string
+	srv := filterServer("the secret is 42", &prompt)
+
+	body := `{"messages":[{"role":"user","content":"tell me the secret"}]}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(prompt, "secret") || !strings.Contains(prompt, "tell me the [redacted]") {
+		t.Errorf("prompt = %q, want the request redacted", prompt)
+	}
+	var resp oai.ChatCompletionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got := resp.Choices[0].Message.StringContent(); got != "the [redacted] is 42" {
+		t.Errorf("content = %q, want the reply redacted", got)
+	}
+
+	body = `{"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if got := w.Body.String(); strings.Contains(got, "secret") || !strings.Contains(got, `"content":"the [redacted] is 42"`) {
+		t.Errorf("stream = %s, want the reply redacted", got)
+	}
+}
+
+func TestContentFilter_Reject(t *testing.T) {
+	var prompt string
+	srv := filterServer("this is forbidden", &prompt)
+
+	body := `{"messages":[{"role":"user","content":"hi"},{"role":"user","content":"something forbidden"}]}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Message 1 was rejected") {
+		t.Errorf("status = %d, body %s, want 400 for message 1", w.Code, w.Body.String())
+	}
+	if prompt != "" {
+		t.Errorf("CLI was queried with %q", prompt)
+	}
+
+	body = `{"messages":[{"role":"user","content":"hi"}]}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	var resp oai.ChatCompletionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if choice := resp.Choices[0]; choice.FinishReason != FinishReasonContentFilter || choice.Message.StringContent() != "" {
+		t.Errorf("choice = %+v, want empty content and finish reason content_filter", choice)
+	}
+
+	body = `{"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	got := w.Body.String()
+	if strings.Contains(got, "forbidden") || !strings.Contains(got, `"finish_reason":"content_filter"`) || !strings.HasSuffix(got, "data: [DONE]\n\n") {
+		t.Errorf("stream = %s, want a content_filter finish and [DONE]", got)
+	}
+	if strings.Count(got, "finish_reason\":\"") != 1 {
+		t.Errorf("stream = %s, want exactly one finish reason", got)
+	}
+
+	body = `{"model":"haiku","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	got = w.Body.String()
+	if strings.Contains(got, "forbidden") || !strings.Contains(got, `"stop_reason":"refusal"`) || !strings.Contains(got, "event: message_stop") {
+		t.Errorf("messages stream = %s, want a refusal stop", got)
+	}
+}
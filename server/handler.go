@@ -1,11 +1,18 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"maps"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/codewandler/cc-sdk-go/cchat"
 	"github.com/codewandler/cc-sdk-go/ccwire"
@@ -19,37 +26,134 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req oai.ChatCompletionRequest
-	r.Body = http.MaxBytesReader(w, r.Body, 10<<20) // 10MB limit
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+	if err := s.decodeBody(w, r, &req); err != nil {
+		writeError(w, err.status, err.errType, err.message)
 		return
 	}
 
-	if len(req.Messages) == 0 {
-		writeError(w, http.StatusBadRequest, "invalid_request", "Messages array is required")
+	if err := s.checkChatRequest(&req); err != nil {
+		writeError(w, http.StatusBadRequest, oai.ErrorTypeInvalidRequest, err.Error())
+		return
+	}
+	timeout, err := s.requestTimeout(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, oai.ErrorTypeInvalidRequest, err.Error())
 		return
 	}
 
-	prompt, opts := oai.RequestToQuery(&req)
+	req.Model = s.resolveModel(req.Model)
+	prompt, opts := s.requestToQuery(&req)
+	opts.AutoCloseOnContextDone = true // free the process if the client goes away
+	opts.Timeout = timeout
 
-	stream, err := s.client.Query(r.Context(), prompt, opts)
+	stream, err := s.querier.Query(r.Context(), prompt, opts)
 	if err != nil {
-		writeError(w, http.StatusServiceUnavailable, "service_unavailable", "Failed to start claude process: "+err.Error())
+		writeQueryError(w, err)
 		return
 	}
 	defer stream.Close()
 
 	if req.Stream {
-		s.handleStreamingResponse(w, stream, len(req.Tools) > 0)
+		s.handleStreamingResponse(r.Context(), w, stream, &req)
 	} else {
-		s.handleNonStreamingResponse(w, stream, len(req.Tools) > 0)
+		s.handleNonStreamingResponse(r.Context(), w, stream, &req)
 	}
 }
 
-func (s *Server) handleStreamingResponse(w http.ResponseWriter, stream StreamReader, hasTools bool) {
+func (s *Server) handleStreamingResponse(ctx context.Context, w http.ResponseWriter, stream StreamReader, req *oai.ChatCompletionRequest) {
+	encode := func(chunk *oai.ChatCompletionChunk) any { return chunk }
+	if s.cfg.OmitNullFinishReason {
+		encode = encodeChunkOmittingNullFinishReason
+	}
+	s.streamResponse(ctx, w, stream, req, encode)
+}
+
+// compactChunk is [oai.ChatCompletionChunk] with the finish_reason of its
+// choices omitted rather than null until it is set; see
+// encodeChunkOmittingNullFinishReason.
+type compactChunk struct {
+	ID                string          `json:"id"`
+	Object            string          `json:"object"`
+	Created           int64           `json:"created"`
+	Model             string          `json:"model"`
+	Choices           []compactChoice `json:"choices"`
+	Usage             *oai.Usage      `json:"usage,omitempty"`
+	SystemFingerprint string          `json:"system_fingerprint,omitempty"`
+}
+
+// compactChoice is [oai.ChunkChoice] with finish_reason omitted when nil.
+type compactChoice struct {
+	Index        int            `json:"index"`
+	Delta        oai.ChunkDelta `json:"delta"`
+	FinishReason *string        `json:"finish_reason,omitempty"`
+}
+
+// encodeChunkOmittingNullFinishReason reshapes a chat chunk so that
+// finish_reason only appears on the final chunk, for clients that reject the
+// "finish_reason":null OpenAI sends on every other chunk; see
+// [Config].OmitNullFinishReason.
+func encodeChunkOmittingNullFinishReason(chunk *oai.ChatCompletionChunk) any {
+	choices := make([]compactChoice, len(chunk.Choices))
+	for i, choice := range chunk.Choices {
+		choices[i] = compactChoice(choice)
+	}
+	return &compactChunk{
+		ID:                chunk.ID,
+		Object:            chunk.Object,
+		Created:           chunk.Created,
+		Model:             chunk.Model,
+		Choices:           choices,
+		Usage:             chunk.Usage,
+		SystemFingerprint: chunk.SystemFingerprint,
+	}
+}
+
+// streamResponse drains stream as Server-Sent Events. Each chat chunk produced
+// by the bridge is passed through encode before being written, which lets the
+// legacy completions endpoint reshape chunks; a nil result skips the chunk.
+// With [Config].FlushInterval set, text chunks are coalesced first; see
+// [coalescer].
+//
+// If reading the stream fails, an OpenAI-style error event is written,
+// followed by [DONE], so the client can tell a failure from a finished
+// response; see [sseWriter.WriteError]. Nothing is written once the client
+// has gone away: the stream is closed as soon as ctx, the request context,
+// is done, or a write fails; see closeOnDisconnect and abandonStream.
+// Output rejected by [Config].ContentFilter ends the stream with a
+// content_filter finish chunk and [DONE].
+func (s *Server) streamResponse(ctx context.Context, w http.ResponseWriter, stream StreamReader, req *oai.ChatCompletionRequest, encode func(*oai.ChatCompletionChunk) any) {
+	defer closeOnDisconnect(ctx, stream)()
 	sse := newSSEWriter(w)
-	state := oai.NewStreamState(hasTools)
+	if s.cfg.SSEMessageEvents {
+		sse.event = "message"
+	}
+	state := oai.NewStreamStateFor(req)
+	state.SystemFingerprint = s.cfg.SystemFingerprint
+	state.CLIVersion = s.cliVersion()
+	state.EmptyText = s.cfg.EmptyResponseText
 	var lastAssistant *ccwire.AssistantMessage
+	co := coalescer{interval: s.cfg.FlushInterval}
+
+	write := func(chunks []*oai.ChatCompletionChunk) error {
+		chunks, rejected := s.filterChunks(chunks)
+		for _, chunk := range chunks {
+			data := encode(chunk)
+			if data == nil {
+				continue
+			}
+			if err := sse.WriteEvent(data); err != nil {
+				return err
+			}
+		}
+		if rejected {
+			sse.WriteDone()
+			return errContentFiltered
+		}
+		return nil
+	}
+	writeChunks := func(chunks []*oai.ChatCompletionChunk) error {
+		return write(co.add(time.Now(), chunks))
+	}
 
 	for {
 		msg, err := stream.Next()
@@ -57,48 +161,196 @@ func (s *Server) handleStreamingResponse(w http.ResponseWriter, stream StreamRea
 			break
 		}
 		if err != nil {
-			// Check for rate limit error
-			var rateErr *cchat.RateLimitError
-			if errors.As(err, &rateErr) {
-				// For SSE streams, we need to send an error event
-				sse.WriteError(http.StatusTooManyRequests, "rate_limit_exceeded", rateErr.Message)
+			apiErr := oai.APIErrorFor(err)
+			if apiErr.Status == oai.StatusClientClosedRequest || ctx.Err() != nil {
+				// Client is gone; nothing left to write to
 				return
 			}
 			log.Printf("stream error: %v", err)
-			break
+			if err := write(co.flush()); err != nil {
+				return
+			}
+			sse.WriteError(apiErr.Status, apiErr.Type, apiErr.Message)
+			sse.WriteDone()
+			return
 		}
 
+		var chunks []*oai.ChatCompletionChunk
 		switch m := msg.(type) {
 		case *ccwire.StreamEventMessage:
-			chunks := state.HandleStreamEvent(m)
-			for _, chunk := range chunks {
-				if err := sse.WriteEvent(chunk); err != nil {
-					return
-				}
-			}
+			chunks = state.HandleStreamEvent(m)
 
 		case *ccwire.AssistantMessage:
 			lastAssistant = m
 
 		case *ccwire.ResultMessage:
-			// Emit finish chunks
-			finishChunks := state.FinishChunk(lastAssistant)
-			for _, chunk := range finishChunks {
-				if err := sse.WriteEvent(chunk); err != nil {
-					return
-				}
+			s.observeResult(ctx, m, lastAssistant)
+			// Emit finish chunks, then usage if the client asked for it
+			chunks = state.FinishChunk(lastAssistant)
+			if usage := state.UsageChunk(m); usage != nil {
+				chunks = append(chunks, usage)
 			}
 
 			if m.IsError {
 				log.Printf("claude error: %s", m.Result)
 			}
 		}
+		// Called for every message so held text is released on time
+		if err := writeChunks(chunks); err != nil {
+			if err != errContentFiltered {
+				abandonStream(stream, err)
+			}
+			return
+		}
 	}
 
+	if !state.Finished {
+		// claude exited without a result; still tell the client why it ended
+		if err := writeChunks(state.FinishChunk(lastAssistant)); err != nil {
+			return
+		}
+	}
+	if err := write(co.flush()); err != nil {
+		return
+	}
 	sse.WriteDone()
 }
 
-func (s *Server) handleNonStreamingResponse(w http.ResponseWriter, stream StreamReader, hasTools bool) {
+// closeOnDisconnect closes stream once ctx, the request context, is done, so
+// the handler stops promptly when the client goes away, even while blocked
+// in Next, rather than on its next write. The returned function stops
+// watching ctx and must be called when the handler is done with stream.
+func closeOnDisconnect(ctx context.Context, stream StreamReader) (stop func() bool) {
+	return context.AfterFunc(ctx, func() {
+		log.Printf("client disconnected, stopping claude: %v", context.Cause(ctx))
+		stream.Close()
+	})
+}
+
+// abandonStream closes stream after writing an event to the client failed
+// with err, which almost always means the client hung up. Closing it right
+// away kills the claude process, which would otherwise keep generating
+// output nobody reads until the handler's deferred Close.
+func abandonStream(stream StreamReader, err error) {
+	log.Printf("client disconnected mid-stream, stopping claude: %v", err)
+	stream.Close()
+}
+
+// DefaultMaxRequestBytes is the request body size limit used when
+// [Config].MaxRequestBytes is zero.
+const DefaultMaxRequestBytes = 10 << 20 // 10MB
+
+// decodeBody decodes the JSON body of r into v, reading at most
+// [Config].MaxRequestBytes. A body over the limit is reported as 413 with
+// type "request_too_large", so that clients do not mistake it for the 400
+// [oai.ErrorTypeInvalidRequest] of malformed JSON.
+func (s *Server) decodeBody(w http.ResponseWriter, r *http.Request, v any) *requestError {
+	limit := s.cfg.MaxRequestBytes
+	if limit <= 0 {
+		limit = DefaultMaxRequestBytes
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &requestError{http.StatusRequestEntityTooLarge, "request_too_large", fmt.Sprintf("Request body too large: the limit is %d bytes", limit)}
+		}
+		return &requestError{http.StatusBadRequest, oai.ErrorTypeInvalidRequest, "Invalid JSON: " + err.Error()}
+	}
+	return nil
+}
+
+// timeoutHeader overrides the process timeout of a single request; see
+// [Config].MaxRequestTimeout.
+const timeoutHeader = "X-CC-Timeout"
+
+// requestTimeout returns the process timeout requested by the X-CC-Timeout
+// header of r, as a Go duration such as "90s" or a number of seconds, capped
+// at [Config].MaxRequestTimeout. It returns 0, leaving the client's default,
+// when the header is absent or MaxRequestTimeout is not set, and an error to
+// be reported as 400 for a malformed or non-positive value.
+func (s *Server) requestTimeout(r *http.Request) (time.Duration, error) {
+	value := r.Header.Get(timeoutHeader)
+	if value == "" || s.cfg.MaxRequestTimeout <= 0 {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, serr := strconv.ParseFloat(value, 64)
+		if serr != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+			return 0, fmt.Errorf("Invalid %s header %q: want a duration such as 90s", timeoutHeader, value)
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("Invalid %s header %q: must be positive", timeoutHeader, value)
+	}
+	return min(timeout, s.cfg.MaxRequestTimeout), nil
+}
+
+// checkChatRequest validates a chat completion request before a process is
+// spawned for it, returning an error to be reported as 400. It also applies
+// the content filter to the messages.
+func (s *Server) checkChatRequest(req *oai.ChatCompletionRequest) error {
+	if len(req.Messages) == 0 {
+		return errors.New("Messages array is required")
+	}
+	if err := oai.Effort(req.ReasoningEffort).Validate(); err != nil {
+		return err
+	}
+	if err := s.checkSampling(req); err != nil {
+		return err
+	}
+	return s.filterRequest(req)
+}
+
+// checkSampling handles temperature and top_p in a request the client cannot
+// pass to the CLI (see [cchat.Client.SupportsSampling]): with
+// [Config].StrictSampling it returns an error for the caller to report;
+// otherwise the values are ignored, which is logged once per server. A
+// server with only a [Config].Querier leaves them to the querier.
+func (s *Server) checkSampling(req *oai.ChatCompletionRequest) error {
+	if (req.Temperature == nil && req.TopP == nil) || s.client == nil || s.client.SupportsSampling() {
+		return nil
+	}
+	if s.cfg.StrictSampling {
+		return errors.New("temperature and top_p are not supported: the claude CLI has no sampling parameters")
+	}
+	s.samplingWarning.Do(func() {
+		log.Printf("warning: ignoring temperature and top_p, which the claude CLI does not support")
+	})
+	return nil
+}
+
+func (s *Server) handleNonStreamingResponse(ctx context.Context, w http.ResponseWriter, stream StreamReader, req *oai.ChatCompletionRequest) {
+	resp := s.collectResponse(ctx, w, stream, req)
+	if resp == nil {
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// collectResponse drains stream and assembles the final chat completion
+// response. On failure it writes an error response to w and returns nil.
+func (s *Server) collectResponse(ctx context.Context, w http.ResponseWriter, stream StreamReader, req *oai.ChatCompletionRequest) *oai.ChatCompletionResponse {
+	resp, _, err := s.collectResult(ctx, stream, req)
+	if err != nil {
+		writeError(w, err.status, err.errType, err.message)
+		return nil
+	}
+	return resp
+}
+
+// requestError is a failure to be reported to the client as an HTTP error.
+type requestError struct {
+	status           int
+	errType, message string
+}
+
+// collectResult drains stream and assembles the final chat completion
+// response, also returning the result message it was built from. ctx is
+// the request context; see [Server.observeResult].
+func (s *Server) collectResult(ctx context.Context, stream StreamReader, req *oai.ChatCompletionRequest) (*oai.ChatCompletionResponse, *ccwire.ResultMessage, *requestError) {
 	var lastAssistant *ccwire.AssistantMessage
 	var result *ccwire.ResultMessage
 
@@ -108,14 +360,8 @@ func (s *Server) handleNonStreamingResponse(w http.ResponseWriter, stream Stream
 			break
 		}
 		if err != nil {
-			// Check for rate limit error
-			var rateErr *cchat.RateLimitError
-			if errors.As(err, &rateErr) {
-				writeError(w, http.StatusTooManyRequests, "rate_limit_exceeded", rateErr.Message)
-				return
-			}
-			writeError(w, http.StatusInternalServerError, "internal_error", "Stream error: "+err.Error())
-			return
+			apiErr := oai.APIErrorFor(err)
+			return nil, nil, &requestError{apiErr.Status, apiErr.Type, apiErr.Message}
 		}
 
 		switch m := msg.(type) {
@@ -123,45 +369,260 @@ func (s *Server) handleNonStreamingResponse(w http.ResponseWriter, stream Stream
 			lastAssistant = m
 		case *ccwire.ResultMessage:
 			result = m
+			s.observeResult(ctx, m, lastAssistant)
 		}
 	}
 
 	if result == nil {
-		writeError(w, http.StatusInternalServerError, "internal_error", "No result received from claude")
-		return
+		return nil, nil, &requestError{http.StatusInternalServerError, "internal_error", "No result received from claude"}
 	}
 
 	if result.IsError {
-		status := http.StatusInternalServerError
-		writeError(w, status, "claude_error", result.Result)
+		return nil, nil, &requestError{http.StatusInternalServerError, "claude_error", result.Result}
+	}
+
+	resp := oai.ResultToResponseFor(req, result, lastAssistant)
+	resp.SystemFingerprint = s.systemFingerprint(resp.Model)
+	if s.cfg.PriceTable != nil {
+		resp.CostUSD = s.cfg.PriceTable.Cost(resp.Model, result)
+	}
+	if msg := &resp.Choices[0].Message; s.cfg.EmptyResponseText != "" && msg.StringContent() == "" && len(msg.ToolCalls) == 0 {
+		msg.Content = s.cfg.EmptyResponseText
+	}
+	s.filterResponse(resp)
+	return resp, result, nil
+}
+
+// resolveModel returns the Claude model configured as an alias for model,
+// or model itself if it has no alias.
+func (s *Server) resolveModel(model string) string {
+	if alias, ok := s.cfg.ModelAliases[model]; ok {
+		return alias
+	}
+	return model
+}
+
+// systemFingerprint returns the configured fingerprint override, or one
+// derived from model and the claude CLI version.
+func (s *Server) systemFingerprint(model string) string {
+	if s.cfg.SystemFingerprint != "" {
+		return s.cfg.SystemFingerprint
+	}
+	return oai.SystemFingerprint(model, s.cliVersion())
+}
+
+// requestToQuery renders req with [Config].PromptAssembler, or with
+// [Config].PromptFormat if there is none; see [oai.RequestToQueryUsing].
+func (s *Server) requestToQuery(req *oai.ChatCompletionRequest) (string, cchat.QueryOptions) {
+	if s.cfg.PromptAssembler != nil {
+		return oai.RequestToQueryUsing(req, s.cfg.PromptAssembler)
+	}
+	return oai.RequestToQueryWith(req, s.cfg.PromptFormat)
+}
+
+// cliVersion returns the cached claude CLI version, or the empty string if
+// the server has no client.
+func (s *Server) cliVersion() string {
+	if s.client == nil {
+		return ""
+	}
+	return s.client.Version()
+}
+
+// writeQueryError reports a failure to start a query as 503. When the client's
+// queue is full, a Retry-After header suggests waiting as long as the queue
+// wait before trying again. A draining client means the server is shutting
+// down. A model rejected by the client's allowlist is reported as 400
+// instead.
+func writeQueryError(w http.ResponseWriter, err error) {
+	writeQueryErrorWith(w, err, writeError)
+}
+
+// writeQueryErrorWith is like writeQueryError but writes the error body with
+// write, for endpoints with their own error shape.
+func writeQueryErrorWith(w http.ResponseWriter, err error, write func(w http.ResponseWriter, status int, errType, message string)) {
+	var queueErr *cchat.QueueFullError
+	if errors.As(err, &queueErr) {
+		retry := max(1, int(math.Ceil(queueErr.Wait.Seconds())))
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+	}
+	status, errType, message := queryErrorStatus(err)
+	write(w, status, errType, message)
+}
+
+// queryErrorStatus maps an error from [cchat.Client.Query] to an HTTP status,
+// an OpenAI error type, and a message, as described for writeQueryError. The
+// status is that of [oai.HTTPStatusForError], except that any other failure
+// means the process could not be started and is reported as 503.
+func queryErrorStatus(err error) (status int, errType, message string) {
+	var queueErr *cchat.QueueFullError
+	var closedErr *cchat.ClientClosedError
+	var modelErr *cchat.ModelError
+	status = oai.HTTPStatusForError(err)
+	switch {
+	case errors.As(err, &modelErr):
+		return status, oai.ErrorTypeInvalidRequest, err.Error()
+	case errors.As(err, &closedErr):
+		return status, "service_unavailable", "Server is shutting down"
+	case errors.As(err, &queueErr):
+		return status, "service_unavailable", "Server busy: " + err.Error()
+	default:
+		return http.StatusServiceUnavailable, "service_unavailable", "Failed to start claude process: " + err.Error()
+	}
+}
+
+// handleCompletions serves the legacy /v1/completions endpoint. The flat
+// prompt is translated into a single-message chat request, run through the
+// regular chat bridge, and the output is reshaped into text_completion objects.
+func (s *Server) handleCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Only POST is accepted")
 		return
 	}
 
-	resp := oai.ResultToResponse(result, lastAssistant, hasTools)
+	var creq oai.CompletionRequest
+	if err := s.decodeBody(w, r, &creq); err != nil {
+		writeError(w, err.status, err.errType, err.message)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	req, err := creq.ChatRequest()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, oai.ErrorTypeInvalidRequest, "Invalid prompt: "+err.Error())
+		return
+	}
+	if err := s.checkSampling(req); err != nil {
+		writeError(w, http.StatusBadRequest, oai.ErrorTypeInvalidRequest, err.Error())
+		return
+	}
+	if err := s.filterRequest(req); err != nil {
+		writeError(w, http.StatusBadRequest, oai.ErrorTypeInvalidRequest, err.Error())
+		return
+	}
+	timeout, err := s.requestTimeout(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, oai.ErrorTypeInvalidRequest, err.Error())
+		return
+	}
+
+	req.Model = s.resolveModel(req.Model)
+	prompt, opts := s.requestToQuery(req)
+	opts.AutoCloseOnContextDone = true // free the process if the client goes away
+	opts.Timeout = timeout
+
+	stream, err := s.querier.Query(r.Context(), prompt, opts)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	defer stream.Close()
+
+	if req.Stream {
+		s.streamResponse(r.Context(), w, stream, req, encodeCompletionChunk)
+		return
+	}
+
+	resp := s.collectResponse(r.Context(), w, stream, req)
+	if resp == nil {
+		return
+	}
+	writeJSON(w, oai.CompletionFromChat(resp))
 }
 
+// encodeCompletionChunk reshapes a chat chunk for the legacy completions
+// stream. It returns an untyped nil for chunks with no legacy representation so
+// that streamResponse skips them.
+func encodeCompletionChunk(chunk *oai.ChatCompletionChunk) any {
+	if c := oai.CompletionChunkFromChat(chunk); c != nil {
+		return c
+	}
+	return nil
+}
+
+// handleModels lists the models from [Server.modelInfo].
 func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Only GET is accepted")
 		return
 	}
 
-	models := []map[string]any{
-		{"id": "sonnet", "object": "model", "owned_by": "anthropic"},
-		{"id": "opus", "object": "model", "owned_by": "anthropic"},
-		{"id": "haiku", "object": "model", "owned_by": "anthropic"},
+	infos := s.modelInfo()
+	models := make([]oai.Model, 0, len(infos))
+	for _, info := range infos {
+		models = append(models, oai.ModelFromInfo(info))
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]any{
+	writeJSON(w, map[string]any{
 		"object": "list",
 		"data":   models,
 	})
 }
 
+// handleModel answers GET /v1/models/{id} with the model of that id from
+// [Server.modelInfo], or 404 if there is none.
+func (s *Server) handleModel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Only GET is accepted")
+		return
+	}
+
+	id := r.PathValue("id")
+	infos := s.modelInfo()
+	i := slices.IndexFunc(infos, func(m cchat.ModelInfo) bool { return m.ID == id })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, oai.ErrorTypeInvalidRequest, fmt.Sprintf("The model '%s' does not exist", id))
+		return
+	}
+	writeJSON(w, oai.ModelFromInfo(infos[i]))
+}
+
+// modelInfo returns the models the server offers with their metadata (see
+// [cchat.Client.ModelInfo]), or [cchat.DefaultModelInfo] without a client.
+// The configured model aliases follow when AdvertiseModelAliases is set,
+// each with the metadata of the model it maps to.
+func (s *Server) modelInfo() []cchat.ModelInfo {
+	infos := cchat.DefaultModelInfo
+	if s.client != nil {
+		infos = s.client.ModelInfo()
+	}
+	if !s.cfg.AdvertiseModelAliases {
+		return infos
+	}
+	for _, alias := range slices.Sorted(maps.Keys(s.cfg.ModelAliases)) {
+		if slices.ContainsFunc(infos, func(m cchat.ModelInfo) bool { return m.ID == alias }) {
+			continue
+		}
+		info := cchat.ModelInfo{ID: alias}
+		target := s.cfg.ModelAliases[alias]
+		if i := slices.IndexFunc(infos, func(m cchat.ModelInfo) bool { return m.ID == target }); i >= 0 {
+			info = infos[i]
+			info.ID = alias
+		}
+		infos = append(slices.Clip(infos), info)
+	}
+	return infos
+}
+
+// handleStats reports the client's concurrency and query counters as JSON,
+// for capacity planning. A server without a client reports zeros.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Only GET is accepted")
+		return
+	}
+
+	var stats cchat.Stats
+	if s.client != nil {
+		stats = s.client.Stats()
+	}
+	writeJSON(w, stats)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
 func writeError(w http.ResponseWriter, status int, errType, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
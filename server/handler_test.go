@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/codewandler/cc-sdk-go/cchat"
 	"github.com/codewandler/cc-sdk-go/ccwire"
@@ -16,13 +20,19 @@ import (
 )
 
 // mockStream implements StreamReader for testing without spawning real claude processes.
+// Once messages are exhausted it returns err, or io.EOF if err is nil.
 type mockStream struct {
 	messages []ccwire.Message
 	index    int
+	err      error
+	closed   bool
 }
 
 func (m *mockStream) Next() (ccwire.Message, error) {
 	if m.index >= len(m.messages) {
+		if m.err != nil {
+			return nil, m.err
+		}
 		return nil, io.EOF
 	}
 	msg := m.messages[m.index]
@@ -31,17 +41,31 @@ func (m *mockStream) Next() (ccwire.Message, error) {
 }
 
 func (m *mockStream) Close() error {
+	m.closed = true
 	return nil
 }
 
-// mockClient implements the cchat.Client interface for testing.
-type mockClient struct {
-	stream *mockStream
+// failingWriter is a ResponseWriter whose writes fail once n have succeeded,
+// like a connection the client has hung up on.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	n int
 }
 
-func (m *mockClient) Query(ctx context.Context, prompt string, opts cchat.QueryOptions) (*cchat.Stream, error) {
-	// Return a mock stream - type assertion will fail but we'll use our own StreamReader interface
-	return nil, nil
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n <= 0 {
+		return 0, errors.New("write: broken pipe")
+	}
+	w.n--
+	return w.ResponseRecorder.Write(p)
+}
+
+// queryFunc implements Querier with a function, for handler tests that
+// don't need a cchat client.
+type queryFunc func(ctx context.Context, prompt string, opts cchat.QueryOptions) (StreamReader, error)
+
+func (f queryFunc) Query(ctx context.Context, prompt string, opts cchat.QueryOptions) (StreamReader, error) {
+	return f(ctx, prompt, opts)
 }
 
 // TestMaxBytesReader verifies that oversized request bodies are rejected.
@@ -82,7 +106,7 @@ func TestMaxBytesReader(t *testing.T) {
 				var req oai.ChatCompletionRequest
 				r.Body = http.MaxBytesReader(w, r.Body, 10<<20) // 10MB limit
 				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-					writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON: "+err.Error())
+					writeError(w, http.StatusBadRequest, oai.ErrorTypeInvalidRequest, "Invalid JSON: "+err.Error())
 					return
 				}
 
@@ -168,19 +192,76 @@ func TestMaxBytesReaderIntegration(t *testing.T) {
 
 		srv.handleChatCompletions(w, req)
 
-		// Should get a bad request error
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("expected status 400, got %d", w.Code)
+		// Should get a request too large error
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected status 413, got %d", w.Code)
 		}
 
-		// The error should mention it's a JSON/body issue
+		// The error should say the body is too large, not that it is invalid
 		bodyStr := w.Body.String()
-		if !strings.Contains(bodyStr, "Invalid JSON") {
-			t.Errorf("expected error message about invalid JSON, got: %s", bodyStr)
+		if !strings.Contains(bodyStr, "Request body too large") || strings.Contains(bodyStr, "Invalid JSON") {
+			t.Errorf("expected error message about the body size, got: %s", bodyStr)
 		}
 	})
 }
 
+// TestMaxRequestBytes verifies that Config.MaxRequestBytes limits request
+// bodies on every endpoint that reads one, and that a body over the limit
+// is reported as 413 request_too_large rather than 400 invalid JSON.
+func TestMaxRequestBytes(t *testing.T) {
+	srv := New(Config{
+		MaxRequestBytes: 4096,
+		MessagesAPI:     true,
+		BatchAPI:        true,
+		Querier: queryFunc(func(context.Context, string, cchat.QueryOptions) (StreamReader, error) {
+			return &mockStream{messages: []ccwire.Message{
+				&ccwire.AssistantMessage{Message: ccwire.AssistantInner{Model: "claude-haiku", Content: []ccwire.ContentBlock{{Type: "text", Text: "PONG"}}}},
+				&ccwire.ResultMessage{Subtype: "success", Result: "PONG"},
+			}}, nil
+		}),
+	})
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return w
+	}
+	chat := func(content string) string {
+		return `{"model":"haiku","max_tokens":16,"messages":[{"role":"user","content":"` + content + `"}]}`
+	}
+	over := strings.Repeat("x", 5000)
+
+	for path, body := range map[string]string{
+		"/v1/chat/completions": chat("ping"),
+		"/v1/completions":      `{"prompt":"ping"}`,
+		"/v1/messages":         chat("ping"),
+		"/v1/batch":            "[" + chat("ping") + "]",
+	} {
+		if w := post(path, body); w.Code != http.StatusOK {
+			t.Errorf("%s under the limit: status = %d, body %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	for path, body := range map[string]string{
+		"/v1/chat/completions": chat(over),
+		"/v1/completions":      `{"prompt":"` + over + `"}`,
+		"/v1/messages":         chat(over),
+		"/v1/batch":            "[" + chat(over) + "]",
+	} {
+		w := post(path, body)
+		got := w.Body.String()
+		if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(got, `"type":"request_too_large"`) || !strings.Contains(got, "the limit is 4096 bytes") {
+			t.Errorf("%s over the limit: status = %d, body %s; want 413 request_too_large", path, w.Code, got)
+		}
+	}
+
+	for _, path := range []string{"/v1/chat/completions", "/v1/completions", "/v1/batch"} {
+		w := post(path, `{"messages":`)
+		if got := w.Body.String(); w.Code != http.StatusBadRequest || !strings.Contains(got, `"type":"invalid_request_error"`) || !strings.Contains(got, "Invalid JSON") {
+			t.Errorf("%s with invalid JSON: status = %d, body %s; want 400 invalid_request_error", path, w.Code, got)
+		}
+	}
+}
+
 // createRequestBody generates a valid JSON request body of approximately the specified size.
 func createRequestBody(targetSize int) []byte {
 	// Start with a minimal valid request
@@ -209,3 +290,953 @@ func createRequestBody(targetSize int) []byte {
 	result, _ := json.Marshal(paddedReq)
 	return result
 }
+
+// TestCompletions_NonStreaming verifies that the legacy endpoint reshapes a
+// collected chat response into a text_completion object.
+func TestCompletions_NonStreaming(t *testing.T) {
+	srv := New(Config{})
+	stream := &mockStream{messages: []ccwire.Message{
+		&ccwire.AssistantMessage{Message: ccwire.AssistantInner{
+			Model:   "claude-haiku",
+			Content: []ccwire.ContentBlock{{Type: "text", Text: "The end."}},
+		}},
+		&ccwire.ResultMessage{SessionID: "sess-1", Result: "The end."},
+	}}
+
+	w := httptest.NewRecorder()
+	resp := srv.collectResponse(context.Background(), w, stream, &oai.ChatCompletionRequest{})
+	if resp == nil {
+		t.Fatalf("collectResponse failed: %d %s", w.Code, w.Body.String())
+	}
+
+	out := oai.CompletionFromChat(resp)
+	if out.Object != "text_completion" {
+		t.Errorf("object = %q, want text_completion", out.Object)
+	}
+	if out.Choices[0].Text != "The end." {
+		t.Errorf("text = %q, want %q", out.Choices[0].Text, "The end.")
+	}
+}
+
+// TestCompletions_Streaming verifies that streamed chat chunks are emitted as
+// text_completion.chunk events and the role-only chunk is skipped.
+func TestCompletions_Streaming(t *testing.T) {
+	srv := New(Config{})
+	stream := &mockStream{messages: []ccwire.Message{
+		&ccwire.StreamEventMessage{Event: map[string]any{
+			"type":    "message_start",
+			"message": map[string]any{"model": "claude-haiku"},
+		}},
+		&ccwire.StreamEventMessage{Event: map[string]any{
+			"type":  "content_block_delta",
+			"delta": map[string]any{"type": "text_delta", "text": "Hello"},
+		}},
+		&ccwire.ResultMessage{SessionID: "sess-1"},
+	}}
+
+	w := httptest.NewRecorder()
+	srv.streamResponse(context.Background(), w, stream, &oai.ChatCompletionRequest{}, encodeCompletionChunk)
+
+	var events []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected content, finish, and [DONE] events, got %d: %v", len(events), events)
+	}
+	if events[2] != "[DONE]" {
+		t.Errorf("last event = %q, want [DONE]", events[2])
+	}
+
+	var first oai.CompletionChunk
+	if err := json.Unmarshal([]byte(events[0]), &first); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if first.Object != "text_completion.chunk" || first.Choices[0].Text != "Hello" {
+		t.Errorf("unexpected first chunk: %+v", first)
+	}
+}
+
+// TestStreaming_NoResult verifies that a stream ending without a result
+// message still sends a stop finish chunk before [DONE].
+func TestStreaming_NoResult(t *testing.T) {
+	srv := New(Config{})
+	stream := &mockStream{messages: []ccwire.Message{
+		&ccwire.StreamEventMessage{Event: map[string]any{
+			"type":    "message_start",
+			"message": map[string]any{"model": "claude-haiku"},
+		}},
+		&ccwire.StreamEventMessage{Event: map[string]any{
+			"type":  "content_block_delta",
+			"delta": map[string]any{"type": "text_delta", "text": "Hello"},
+		}},
+	}}
+
+	w := httptest.NewRecorder()
+	srv.handleStreamingResponse(context.Background(), w, stream, &oai.ChatCompletionRequest{})
+
+	var events []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	if len(events) < 2 || events[len(events)-1] != "[DONE]" {
+		t.Fatalf("expected chunks then [DONE], got %v", events)
+	}
+
+	var last oai.ChatCompletionChunk
+	if err := json.Unmarshal([]byte(events[len(events)-2]), &last); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if reason := last.Choices[0].FinishReason; reason == nil || *reason != "stop" {
+		t.Errorf("finish_reason before [DONE] = %v, want stop", reason)
+	}
+}
+
+// TestCompletions_StreamingError verifies that the legacy endpoint also ends
+// a failed stream with an error event after the chunks already sent.
+func TestCompletions_StreamingError(t *testing.T) {
+	srv := New(Config{})
+	stream := &mockStream{
+		messages: []ccwire.Message{
+			&ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start"}},
+			&ccwire.StreamEventMessage{Event: map[string]any{
+				"type":  "content_block_delta",
+				"delta": map[string]any{"type": "text_delta", "text": "Hello"},
+			}},
+		},
+		err: &cchat.RateLimitError{Message: "slow down"},
+	}
+
+	w := httptest.NewRecorder()
+	srv.streamResponse(context.Background(), w, stream, &oai.ChatCompletionRequest{}, encodeCompletionChunk)
+
+	var events []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	if len(events) != 3 || events[2] != "[DONE]" {
+		t.Fatalf("expected content, error, and [DONE] events, got %v", events)
+	}
+	if !strings.Contains(events[0], `"Hello"`) {
+		t.Errorf("first event = %s, want the streamed text", events[0])
+	}
+	if !strings.Contains(events[1], `"rate_limit_exceeded"`) || !strings.Contains(events[1], "slow down") {
+		t.Errorf("error event = %s, want rate_limit_exceeded with the message", events[1])
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200 once the stream has started", w.Code)
+	}
+}
+
+// TestCompletions_InvalidPrompt verifies that a missing prompt is rejected
+// before any process is spawned.
+func TestCompletions_InvalidPrompt(t *testing.T) {
+	srv := New(Config{Client: &cchat.Client{}})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/completions", strings.NewReader(`{"model":"haiku"}`))
+	w := httptest.NewRecorder()
+	srv.handleCompletions(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestCollectResponse_Timeout verifies that a stream interrupted by a context
+// deadline produces a 504 instead of a generic 500.
+func TestCollectResponse_Timeout(t *testing.T) {
+	srv := New(Config{})
+	stream := &mockStream{err: fmt.Errorf("interrupted: %w", context.DeadlineExceeded)}
+
+	w := httptest.NewRecorder()
+	if resp := srv.collectResponse(context.Background(), w, stream, &oai.ChatCompletionRequest{}); resp != nil {
+		t.Fatalf("expected nil response, got %+v", resp)
+	}
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusGatewayTimeout)
+	}
+}
+
+// TestCollectResponse_ScriptedErrors verifies that errors raised by a
+// cchat stream over canned CLI output map to the right HTTP status.
+func TestCollectResponse_ScriptedErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		proc       cchat.ScriptedProcess
+		wantStatus int
+		wantType   string
+	}{
+		{
+			name: "rate_limit",
+			proc: cchat.ScriptedProcess{Stdout: io.NopCloser(strings.NewReader(
+				`{"type":"assistant","error":"rate_limit","message":{"content":[{"type":"text","text":"Usage limit reached"}]}}` + "\n",
+			))},
+			wantStatus: http.StatusTooManyRequests,
+			wantType:   "rate_limit_exceeded",
+		},
+		{
+			name:       "process_error",
+			proc:       cchat.ScriptedProcess{Stdout: io.NopCloser(strings.NewReader("")), Stderr: "not logged in", ExitCode: 1},
+			wantStatus: http.StatusInternalServerError,
+			wantType:   "internal_error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{})
+			stream := cchat.NewScriptedStream(tt.proc)
+			defer stream.Close()
+
+			w := httptest.NewRecorder()
+			if resp := srv.collectResponse(context.Background(), w, stream, &oai.ChatCompletionRequest{}); resp != nil {
+				t.Fatalf("expected nil response, got %+v", resp)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantType) {
+				t.Errorf("body = %s, want error type %q", w.Body.String(), tt.wantType)
+			}
+		})
+	}
+}
+
+// TestSystemFingerprintOverride verifies that a configured fingerprint is
+// reported on both non-streaming responses and streaming chunks.
+func TestSystemFingerprintOverride(t *testing.T) {
+	messages := func() []ccwire.Message {
+		return []ccwire.Message{
+			&ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start"}},
+			&ccwire.AssistantMessage{Message: ccwire.AssistantInner{
+				Content: []ccwire.ContentBlock{{Type: "text", Text: "Hi"}},
+			}},
+			&ccwire.ResultMessage{SessionID: "sess-1", Result: "Hi"},
+		}
+	}
+	srv := New(Config{SystemFingerprint: "fp_pinned"})
+
+	w := httptest.NewRecorder()
+	resp := srv.collectResponse(context.Background(), w, &mockStream{messages: messages()}, &oai.ChatCompletionRequest{})
+	if resp == nil {
+		t.Fatalf("collectResponse failed: %s", w.Body.String())
+	}
+	if resp.SystemFingerprint != "fp_pinned" {
+		t.Errorf("response fingerprint = %q, want fp_pinned", resp.SystemFingerprint)
+	}
+
+	w = httptest.NewRecorder()
+	srv.handleStreamingResponse(context.Background(), w, &mockStream{messages: messages()}, &oai.ChatCompletionRequest{})
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk oai.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if chunk.SystemFingerprint != "fp_pinned" {
+			t.Errorf("chunk fingerprint = %q, want fp_pinned", chunk.SystemFingerprint)
+		}
+	}
+}
+
+// TestChatCompletions_Spawner drives the full handler with a cchat client
+// that replays canned NDJSON instead of running the claude CLI.
+func TestChatCompletions_Spawner(t *testing.T) {
+	var gotPrompt string
+	client := cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(ctx context.Context, prompt string, opts cchat.QueryOptions) (io.ReadCloser, error) {
+		gotPrompt = prompt
+		return io.NopCloser(strings.NewReader(
+			`{"type":"system","subtype":"init","session_id":"sess-1","model":"claude-haiku"}` + "\n" +
+				`{"type":"assistant","message":{"model":"claude-haiku","content":[{"type":"text","text":"PONG"}]}}` + "\n" +
+				`{"type":"result","subtype":"success","session_id":"sess-1","result":"PONG"}` + "\n",
+		)), nil
+	})
+	srv := New(Config{Client: client})
+
+	body := `{"model":"haiku","messages":[{"role":"user","content":"ping"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var resp oai.ChatCompletionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got := resp.Choices[0].Message.StringContent(); got != "PONG" {
+		t.Errorf("content = %q, want PONG", got)
+	}
+	if !strings.Contains(gotPrompt, "ping") {
+		t.Errorf("spawner prompt = %q, want it to contain the user message", gotPrompt)
+	}
+}
+
+// TestChatCompletions_Querier verifies that a Config.Querier replaces the
+// client, with its streams and errors handled like the client's, and that
+// temperature reaches it without a client to check sampling support.
+func TestChatCompletions_Querier(t *testing.T) {
+	var gotOpts cchat.QueryOptions
+	srv := New(Config{Querier: queryFunc(func(_ context.Context, prompt string, opts cchat.QueryOptions) (StreamReader, error) {
+		gotOpts = opts
+		if strings.Contains(prompt, "busy") {
+			return nil, &cchat.QueueFullError{MaxConcurrent: 1, Wait: time.Second}
+		}
+		return &mockStream{messages: []ccwire.Message{
+			&ccwire.AssistantMessage{Message: ccwire.AssistantInner{Model: "claude-haiku", Content: []ccwire.ContentBlock{{Type: "text", Text: "PONG"}}}},
+			&ccwire.ResultMessage{Subtype: "success", SessionID: "sess-1", Result: "PONG"},
+		}}, nil
+	})})
+
+	body := `{"model":"haiku","temperature":0.5,"messages":[{"role":"user","content":"ping"}]}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var resp oai.ChatCompletionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got := resp.Choices[0].Message.StringContent(); got != "PONG" {
+		t.Errorf("content = %q, want PONG", got)
+	}
+	if gotOpts.Model != "haiku" || gotOpts.Temperature == nil || *gotOpts.Temperature != 0.5 {
+		t.Errorf("opts = %+v, want model haiku and temperature 0.5", gotOpts)
+	}
+
+	body = `{"messages":[{"role":"user","content":"busy"}]}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("status = %d, Retry-After = %q, want 503 and 1", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+// TestStreamingResponse_ClientGone verifies that a failed write closes the
+// stream right away instead of reading the rest of it.
+func TestStreamingResponse_ClientGone(t *testing.T) {
+	messages := []ccwire.Message{
+		&ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start", "message": map[string]any{"model": "claude-haiku"}}},
+	}
+	for range 10 {
+		messages = append(messages, &ccwire.StreamEventMessage{Event: map[string]any{
+			"type":  "content_block_delta",
+			"delta": map[string]any{"type": "text_delta", "text": "word "},
+		}})
+	}
+
+	for name, serve := range map[string]func(context.Context, http.ResponseWriter, StreamReader, *oai.ChatCompletionRequest){
+		"chat":     New(Config{}).handleStreamingResponse,
+		"messages": New(Config{}).streamMessages,
+	} {
+		t.Run(name, func(t *testing.T) {
+			stream := &mockStream{messages: messages}
+			serve(context.Background(), &failingWriter{ResponseRecorder: httptest.NewRecorder(), n: 2}, stream, &oai.ChatCompletionRequest{})
+			if !stream.closed {
+				t.Error("stream not closed after the write failed")
+			}
+			if stream.index == len(messages) {
+				t.Error("stream read to the end after the client went away")
+			}
+		})
+	}
+}
+
+// blockingStream returns its messages and then blocks in Next until closed,
+// like a claude process that has gone quiet.
+type blockingStream struct {
+	mockStream
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (b *blockingStream) Next() (ccwire.Message, error) {
+	if b.index < len(b.messages) {
+		return b.mockStream.Next()
+	}
+	<-b.done
+	return nil, errors.New("stream closed")
+}
+
+func (b *blockingStream) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	return nil
+}
+
+// TestStreamingResponse_ContextDone verifies that cancelling the request
+// context closes a stream blocked in Next and ends the handler without
+// writing an error event.
+func TestStreamingResponse_ContextDone(t *testing.T) {
+	messages := []ccwire.Message{
+		&ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start", "message": map[string]any{"model": "claude-haiku"}}},
+		&ccwire.StreamEventMessage{Event: map[string]any{
+			"type":  "content_block_delta",
+			"delta": map[string]any{"type": "text_delta", "text": "Hello"},
+		}},
+	}
+
+	for name, serve := range map[string]func(context.Context, http.ResponseWriter, StreamReader, *oai.ChatCompletionRequest){
+		"chat":     New(Config{}).handleStreamingResponse,
+		"messages": New(Config{}).streamMessages,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stream := &blockingStream{mockStream: mockStream{messages: messages}, done: make(chan struct{})}
+			w := httptest.NewRecorder()
+
+			returned := make(chan struct{})
+			go func() {
+				serve(ctx, w, stream, &oai.ChatCompletionRequest{})
+				close(returned)
+			}()
+			time.Sleep(20 * time.Millisecond) // let the handler block in Next
+			cancel()
+
+			select {
+			case <-returned:
+			case <-time.After(time.Second):
+				t.Fatal("handler did not return after the context was cancelled")
+			}
+			select {
+			case <-stream.done:
+			default:
+				t.Error("stream not closed")
+			}
+			if body := w.Body.String(); strings.Contains(body, "error") || !strings.Contains(body, "Hello") {
+				t.Errorf("body = %q, want the text sent before the cancellation and no error event", body)
+			}
+		})
+	}
+}
+
+// TestStreamingResponse_Errors verifies that stream failures end the SSE
+// stream with an error event and [DONE], keeping the status once events have
+// been sent.
+func TestStreamingResponse_Errors(t *testing.T) {
+	started := []ccwire.Message{
+		&ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start"}},
+		&ccwire.StreamEventMessage{Event: map[string]any{
+			"type":  "content_block_delta",
+			"delta": map[string]any{"type": "text_delta", "text": "Hello"},
+		}},
+	}
+
+	tests := []struct {
+		name       string
+		stream     *mockStream
+		wantStatus int
+		wantType   string
+	}{
+		{
+			name:       "mid_stream_rate_limit",
+			stream:     &mockStream{messages: started, err: &cchat.RateLimitError{Message: "slow down"}},
+			wantStatus: http.StatusOK,
+			wantType:   "rate_limit_exceeded",
+		},
+		{
+			name:       "mid_stream_process_error",
+			stream:     &mockStream{messages: started, err: &cchat.ProcessError{ExitCode: 1, Stderr: "boom"}},
+			wantStatus: http.StatusOK,
+			wantType:   "internal_error",
+		},
+		{
+			name:       "before_first_event",
+			stream:     &mockStream{err: &cchat.RateLimitError{Message: "slow down"}},
+			wantStatus: http.StatusTooManyRequests,
+			wantType:   "rate_limit_exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{})
+			w := httptest.NewRecorder()
+			srv.handleStreamingResponse(context.Background(), w, tt.stream, &oai.ChatCompletionRequest{})
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			events := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+			if last := events[len(events)-1]; last != "data: [DONE]" {
+				t.Errorf("last event = %q, want [DONE]", last)
+			}
+			if len(events) < 2 {
+				t.Fatalf("events = %q, want an error event before [DONE]", events)
+			}
+			var payload struct {
+				Error struct {
+					Type string `json:"type"`
+				} `json:"error"`
+			}
+			data := strings.TrimPrefix(events[len(events)-2], "data: ")
+			if err := json.Unmarshal([]byte(data), &payload); err != nil || payload.Error.Type != tt.wantType {
+				t.Errorf("error event = %s, want type %q", data, tt.wantType)
+			}
+		})
+	}
+}
+
+// TestChatCompletions_QueueFull verifies that a saturated client queue is
+// answered with 503 and a Retry-After header.
+func TestChatCompletions_QueueFull(t *testing.T) {
+	client := cchat.NewClientWithSpawner(&cchat.ClientConfig{MaxConcurrent: 1, MaxQueueWait: 100 * time.Millisecond},
+		func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("")), nil
+		})
+	held, err := client.Query(context.Background(), "hold the only slot", cchat.QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer held.Close()
+
+	srv := New(Config{Client: client})
+	body := `{"model":"haiku","messages":[{"role":"user","content":"ping"}]}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+}
+
+// TestChatCompletions_Draining verifies that a draining client gets 503.
+func TestChatCompletions_Draining(t *testing.T) {
+	client := cchat.NewClientWithSpawner(&cchat.ClientConfig{},
+		func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+			t.Error("spawner called after Drain")
+			return io.NopCloser(strings.NewReader("")), nil
+		})
+	client.Drain()
+
+	srv := New(Config{Client: client})
+	body := `{"model":"haiku","messages":[{"role":"user","content":"ping"}]}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "shutting down") {
+		t.Errorf("body = %s, want shutdown message", w.Body.String())
+	}
+}
+
+// TestModelAliases verifies that aliased model names are rewritten before
+// the query, unknown names pass through, and /v1/models lists aliases only
+// when asked to.
+func TestModelAliases(t *testing.T) {
+	var gotModel string
+	client := cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(_ context.Context, _ string, opts cchat.QueryOptions) (io.ReadCloser, error) {
+		gotModel = opts.Model
+		return io.NopCloser(strings.NewReader(`{"type":"result","subtype":"success","session_id":"s1","result":"ok"}` + "\n")), nil
+	})
+	aliases := map[string]string{"gpt-4o": "opus", "gpt-3.5-turbo": "haiku"}
+
+	for model, want := range map[string]string{"gpt-4o": "opus", "gpt-3.5-turbo": "haiku", "sonnet": "sonnet"} {
+		srv := New(Config{Client: client, ModelAliases: aliases})
+		body := `{"model":"` + model + `","messages":[{"role":"user","content":"ping"}]}`
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body %s", model, w.Code, w.Body.String())
+		}
+		if gotModel != want {
+			t.Errorf("model %q resolved to %q, want %q", model, gotModel, want)
+		}
+	}
+
+	listModels := func(cfg Config) []string {
+		w := httptest.NewRecorder()
+		New(cfg).Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+		var list struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		var ids []string
+		for _, m := range list.Data {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+	if got := strings.Join(listModels(Config{ModelAliases: aliases}), ","); got != "sonnet,opus,haiku" {
+		t.Errorf("models = %s, want aliases hidden by default", got)
+	}
+	if got := strings.Join(listModels(Config{ModelAliases: aliases, AdvertiseModelAliases: true}), ","); got != "sonnet,opus,haiku,gpt-3.5-turbo,gpt-4o" {
+		t.Errorf("models = %s, want aliases listed", got)
+	}
+}
+
+// TestEmptyResponseText verifies that a refused reply with no text finishes
+// with content_filter and carries the configured placeholder, streamed or
+// not.
+func TestEmptyResponseText(t *testing.T) {
+	refusal := "refusal"
+	srv := New(Config{
+		EmptyResponseText: "(no reply)",
+		Querier: queryFunc(func(context.Context, string, cchat.QueryOptions) (StreamReader, error) {
+			return &mockStream{messages: []ccwire.Message{
+				&ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start", "message": map[string]any{"model": "claude-haiku"}}},
+				&ccwire.AssistantMessage{Message: ccwire.AssistantInner{Model: "claude-haiku", StopReason: &refusal}},
+				&ccwire.ResultMessage{Subtype: "success"},
+			}}, nil
+		}),
+	})
+
+	body := `{"messages":[{"role":"user","content":"hi"}]}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var resp oai.ChatCompletionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if choice := resp.Choices[0]; choice.Message.StringContent() != "(no reply)" || choice.FinishReason != FinishReasonContentFilter {
+		t.Errorf("choice = %+v, want the placeholder with finish_reason content_filter", choice)
+	}
+
+	body = `{"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if got := w.Body.String(); !strings.Contains(got, `"content":"(no reply)"`) || !strings.Contains(got, `"finish_reason":"content_filter"`) {
+		t.Errorf("stream = %s, want the placeholder and finish_reason content_filter", got)
+	}
+}
+
+// TestModelLookup verifies /v1/models/{id} for a listed model, an advertised
+// alias containing a slash, and an unknown id.
+func TestModelLookup(t *testing.T) {
+	srv := New(Config{ModelAliases: map[string]string{"openai/gpt-4o": "opus"}, AdvertiseModelAliases: true})
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	for path, want := range map[string]oai.Model{
+		"/v1/models/sonnet":        oai.ModelFromInfo(cchat.DefaultModelInfo[0]),
+		"/v1/models/openai/gpt-4o": oai.ModelFromInfo(cchat.ModelInfo{ID: "openai/gpt-4o", FullID: cchat.DefaultModelInfo[1].FullID, ContextWindow: 200000, Tools: true, Thinking: true}),
+	} {
+		w := get(path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body %s", path, w.Code, w.Body.String())
+		}
+		var got oai.Model
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: unmarshal: %v", path, err)
+		}
+		if got != want {
+			t.Errorf("%s: model = %+v, want %+v", path, got, want)
+		}
+	}
+
+	w := get("/v1/models/gpt-5")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unknown model: status = %d, want 404", w.Code)
+	}
+	var errResp oai.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if errResp.Error.Type != "invalid_request_error" || !strings.Contains(errResp.Error.Message, "gpt-5") {
+		t.Errorf("error = %+v", errResp.Error)
+	}
+}
+
+// TestChatCompletions_UnknownModel verifies that a model rejected by the
+// client's allowlist is reported as 400 with the error type oai.Client uses.
+func TestChatCompletions_UnknownModel(t *testing.T) {
+	client := cchat.NewClientWithSpawner(&cchat.ClientConfig{AllowedModels: cchat.DefaultModels},
+		func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+			t.Error("spawner called for an unknown model")
+			return io.NopCloser(strings.NewReader("")), nil
+		})
+
+	srv := New(Config{Client: client})
+	body := `{"model":"sonet","messages":[{"role":"user","content":"ping"}]}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+	var resp oai.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if resp.Error.Type != "invalid_request_error" {
+		t.Errorf("error type = %q, want invalid_request_error as from oai.Client", resp.Error.Type)
+	}
+	if !strings.Contains(resp.Error.Message, "sonet") {
+		t.Errorf("body = %s, want the rejected model named", w.Body.String())
+	}
+}
+
+func TestChatCompletions_ReasoningEffort(t *testing.T) {
+	var got string
+	client := cchat.NewClientWithSpawner(&cchat.ClientConfig{},
+		func(_ context.Context, _ string, opts cchat.QueryOptions) (io.ReadCloser, error) {
+			got = opts.Effort
+			return io.NopCloser(strings.NewReader(`{"type":"result","subtype":"success","result":"ok"}` + "\n")), nil
+		})
+	handler := New(Config{Client: client}).Handler()
+
+	body := `{"reasoning_effort":"high","messages":[{"role":"user","content":"ping"}]}`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if w.Code != http.StatusOK || got != "high" {
+		t.Errorf("status = %d, effort = %q, want 200 and high", w.Code, got)
+	}
+
+	body = `{"reasoning_effort":"extreme","messages":[{"role":"user","content":"ping"}]}`
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "extreme") {
+		t.Errorf("status = %d, body %s, want 400 naming the effort", w.Code, w.Body.String())
+	}
+}
+
+func TestChatCompletions_StrictSampling(t *testing.T) {
+	spawn := func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(`{"type":"result","subtype":"success","result":"ok"}` + "\n")), nil
+	}
+	tests := []struct {
+		name       string
+		strict     bool
+		flags      bool
+		body       string
+		wantStatus int
+	}{
+		{"strict_rejects", true, false, `{"temperature":0.2,"messages":[{"role":"user","content":"ping"}]}`, http.StatusBadRequest},
+		{"strict_without_sampling", true, false, `{"messages":[{"role":"user","content":"ping"}]}`, http.StatusOK},
+		{"strict_with_sampling_flags", true, true, `{"top_p":0.5,"messages":[{"role":"user","content":"ping"}]}`, http.StatusOK},
+		{"lenient_ignores", false, false, `{"temperature":0.2,"messages":[{"role":"user","content":"ping"}]}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := cchat.NewClientWithSpawner(&cchat.ClientConfig{SamplingFlags: tt.flags}, spawn)
+			handler := New(Config{Client: client, StrictSampling: tt.strict}).Handler()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestStats verifies that /stats reports the client's counters.
+func TestStats(t *testing.T) {
+	client := cchat.NewClientWithSpawner(&cchat.ClientConfig{MaxConcurrent: 4},
+		func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("")), nil
+		})
+	held, err := client.Query(context.Background(), "test", cchat.QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer held.Close()
+
+	srv := New(Config{Client: client})
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var stats cchat.Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if stats != (cchat.Stats{InUse: 1, MaxConcurrent: 4, Started: 1}) {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// TestChatCompletions_PriceTable verifies that a configured price table
+// reports a computed cost, and that no cost is reported without one.
+func TestChatCompletions_PriceTable(t *testing.T) {
+	querier := queryFunc(func(context.Context, string, cchat.QueryOptions) (StreamReader, error) {
+		return &mockStream{messages: []ccwire.Message{
+			&ccwire.AssistantMessage{Message: ccwire.AssistantInner{Model: "claude-haiku-4-5", Content: []ccwire.ContentBlock{{Type: "text", Text: "PONG"}}}},
+			&ccwire.ResultMessage{Subtype: "success", Result: "PONG", Usage: ccwire.ResultUsage{InputTokens: 1000000, OutputTokens: 100000}},
+		}}, nil
+	})
+
+	for _, tt := range []struct {
+		prices oai.PriceTable
+		want   float64
+	}{
+		{nil, 0},
+		{oai.PriceTable{"claude-haiku": {Input: 1, Output: 5}}, 1.5},
+	} {
+		srv := New(Config{Querier: querier, PriceTable: tt.prices})
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"messages":[{"role":"user","content":"ping"}]}`)))
+		var resp oai.ChatCompletionResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if resp.CostUSD != tt.want {
+			t.Errorf("cost with %v = %v, want %v", tt.prices, resp.CostUSD, tt.want)
+		}
+	}
+}
+
+// TestRequestTimeout verifies that X-CC-Timeout overrides the process timeout
+// within Config.MaxRequestTimeout and that bad values are rejected.
+func TestRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		max        time.Duration
+		header     string
+		wantStatus int
+		want       time.Duration
+	}{
+		{name: "absent", max: time.Hour, wantStatus: http.StatusOK},
+		{name: "duration", max: time.Hour, header: "90s", wantStatus: http.StatusOK, want: 90 * time.Second},
+		{name: "seconds", max: time.Hour, header: "15", wantStatus: http.StatusOK, want: 15 * time.Second},
+		{name: "capped", max: time.Hour, header: "3h", wantStatus: http.StatusOK, want: time.Hour},
+		{name: "disabled", header: "90s", wantStatus: http.StatusOK},
+		{name: "negative", max: time.Hour, header: "-5s", wantStatus: http.StatusBadRequest},
+		{name: "zero", max: time.Hour, header: "0", wantStatus: http.StatusBadRequest},
+		{name: "malformed", max: time.Hour, header: "soon", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got time.Duration
+			srv := New(Config{MaxRequestTimeout: tt.max, Querier: queryFunc(func(_ context.Context, _ string, opts cchat.QueryOptions) (StreamReader, error) {
+				got = opts.Timeout
+				return &mockStream{messages: []ccwire.Message{&ccwire.ResultMessage{Subtype: "success", Result: "ok"}}}, nil
+			})})
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"messages":[{"role":"user","content":"hi"}]}`))
+			if tt.header != "" {
+				req.Header.Set("X-CC-Timeout", tt.header)
+			}
+			w := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if got != tt.want {
+				t.Errorf("opts.Timeout = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestStreaming_IncludeUsage verifies that stream_options.include_usage adds
+// a usage-only chunk, computed from the result, between the finish chunk and
+// [DONE], and that there is none without it.
+func TestStreaming_IncludeUsage(t *testing.T) {
+	srv := New(Config{
+		Querier: queryFunc(func(context.Context, string, cchat.QueryOptions) (StreamReader, error) {
+			return &mockStream{messages: []ccwire.Message{
+				&ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start", "message": map[string]any{"model": "claude-haiku"}}},
+				&ccwire.StreamEventMessage{Event: map[string]any{
+					"type":  "content_block_delta",
+					"delta": map[string]any{"type": "text_delta", "text": "Hello"},
+				}},
+				&ccwire.ResultMessage{Subtype: "success", Usage: ccwire.ResultUsage{InputTokens: 10, CacheReadInputTokens: 5, OutputTokens: 3}},
+			}}, nil
+		}),
+	})
+	stream := func(body string) []string {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		var events []string
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				events = append(events, data)
+			}
+		}
+		if len(events) < 3 || events[len(events)-1] != "[DONE]" {
+			t.Fatalf("expected chunks then [DONE], got %v", events)
+		}
+		return events
+	}
+
+	events := stream(`{"stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"hi"}]}`)
+	var usage, finish oai.ChatCompletionChunk
+	if err := json.Unmarshal([]byte(events[len(events)-2]), &usage); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if err := json.Unmarshal([]byte(events[len(events)-3]), &finish); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !strings.Contains(events[len(events)-2], `"choices":[]`) || len(usage.Choices) != 0 {
+		t.Errorf("usage chunk = %s, want empty choices", events[len(events)-2])
+	}
+	want := oai.Usage{PromptTokens: 15, CompletionTokens: 3, TotalTokens: 18}
+	if usage.Usage == nil || *usage.Usage != want {
+		t.Errorf("usage = %+v, want %+v", usage.Usage, want)
+	}
+	if len(finish.Choices) != 1 || finish.Choices[0].FinishReason == nil || finish.Usage != nil {
+		t.Errorf("chunk before usage = %s, want the finish chunk without usage", events[len(events)-3])
+	}
+
+	events = stream(`{"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	for _, event := range events {
+		if strings.Contains(event, `"usage"`) {
+			t.Errorf("event %s carries usage without include_usage", event)
+		}
+	}
+}
+
+// chatMLAssembler renders user turns ChatML-style, for TestPromptAssembler.
+type chatMLAssembler struct{}
+
+func (chatMLAssembler) Assemble(req *oai.ChatCompletionRequest) (prompt, system string) {
+	for _, msg := range req.Messages {
+		prompt += "<|im_start|>" + msg.Role + "\n" + msg.StringContent() + "<|im_end|>\n"
+	}
+	return prompt, "chatml"
+}
+
+// TestPromptAssembler verifies that Config.PromptAssembler renders requests
+// in place of Config.PromptFormat.
+func TestPromptAssembler(t *testing.T) {
+	var gotPrompt, gotSystem string
+	srv := New(Config{
+		PromptFormat:    oai.PromptFormat{User: "USER: %s"},
+		PromptAssembler: chatMLAssembler{},
+		Querier: queryFunc(func(_ context.Context, prompt string, opts cchat.QueryOptions) (StreamReader, error) {
+			gotPrompt, gotSystem = prompt, opts.SystemPrompt
+			return &mockStream{messages: []ccwire.Message{
+				&ccwire.ResultMessage{Subtype: "success", Result: "PONG"},
+			}}, nil
+		}),
+	})
+
+	body := `{"messages":[{"role":"user","content":"ping"}]}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	if want := "<|im_start|>user\nping<|im_end|>\n"; gotPrompt != want || gotSystem != "chatml" {
+		t.Errorf("prompt, system = %q, %q; want %q, %q", gotPrompt, gotSystem, want, "chatml")
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/codewandler/cc-sdk-go/anthropic"
+	"github.com/codewandler/cc-sdk-go/ccwire"
+	"github.com/codewandler/cc-sdk-go/oai"
+)
+
+// handleMessages serves the Anthropic-compatible /v1/messages endpoint. The
+// request is translated into a chat request, run through the regular chat
+// bridge, and the output is reshaped into Anthropic messages and events.
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMessagesError(w, http.StatusMethodNotAllowed, "", "Only POST is accepted")
+		return
+	}
+
+	var mreq anthropic.MessagesRequest
+	if err := s.decodeBody(w, r, &mreq); err != nil {
+		writeMessagesError(w, err.status, err.errType, err.message)
+		return
+	}
+
+	req, err := mreq.ChatRequest()
+	if err != nil {
+		writeMessagesError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	if err := s.checkSampling(req); err != nil {
+		writeMessagesError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	if err := s.filterRequest(req); err != nil {
+		writeMessagesError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	timeout, err := s.requestTimeout(r)
+	if err != nil {
+		writeMessagesError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+
+	req.Model = s.resolveModel(req.Model)
+	prompt, opts := s.requestToQuery(req)
+	opts.AutoCloseOnContextDone = true // free the process if the client goes away
+	opts.Timeout = timeout
+
+	stream, err := s.querier.Query(r.Context(), prompt, opts)
+	if err != nil {
+		writeQueryErrorWith(w, err, writeMessagesError)
+		return
+	}
+	defer stream.Close()
+
+	if req.Stream {
+		s.streamMessages(r.Context(), w, stream, req)
+		return
+	}
+
+	resp, result, rerr := s.collectResult(r.Context(), stream, req)
+	if rerr != nil {
+		writeMessagesError(w, rerr.status, rerr.errType, rerr.message)
+		return
+	}
+	writeJSON(w, anthropic.MessagesFromChat(resp, result))
+}
+
+// streamMessages drains stream as Anthropic Server-Sent Events. Chat chunks
+// from the bridge are converted by [anthropic.StreamState]; message_start is
+// sent with the first chunk, once the model is known, and message_stop after
+// the result. A stream that ends without a result is finished the same way,
+// like a chat stream, but with zero usage. On failure, an error event ends
+// the stream instead, as with the Anthropic API. As with chat streams, the
+// stream is closed at once when ctx is done or a write fails.
+func (s *Server) streamMessages(ctx context.Context, w http.ResponseWriter, stream StreamReader, req *oai.ChatCompletionRequest) {
+	defer closeOnDisconnect(ctx, stream)()
+	sse := newSSEWriter(w)
+	state := oai.NewStreamStateFor(req)
+	var events *anthropic.StreamState
+	var lastAssistant *ccwire.AssistantMessage
+
+	writeEvents := func(evs ...anthropic.StreamEvent) error {
+		for _, ev := range evs {
+			if err := sse.WriteNamedEvent(ev.Type, ev); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	writeChunks := func(chunks []*oai.ChatCompletionChunk) error {
+		chunks, rejected := s.filterChunks(chunks)
+		for _, chunk := range chunks {
+			if events == nil {
+				events = anthropic.NewStreamState(chunk.ID, chunk.Model)
+				if err := writeEvents(events.Start()); err != nil {
+					return err
+				}
+			}
+			if err := writeEvents(events.Chunk(chunk)...); err != nil {
+				return err
+			}
+		}
+		if rejected {
+			writeEvents(events.Finish(nil)...)
+			return errContentFiltered
+		}
+		return nil
+	}
+
+	for {
+		msg, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			apiErr := oai.APIErrorFor(err)
+			if apiErr.Status == oai.StatusClientClosedRequest || ctx.Err() != nil {
+				// Client is gone; nothing left to write to
+				return
+			}
+			log.Printf("stream error: %v", err)
+			sse.WriteErrorEvent(apiErr.Status, "error", anthropic.NewErrorResponse(apiErr.Status, apiErr.Message))
+			return
+		}
+
+		switch m := msg.(type) {
+		case *ccwire.StreamEventMessage:
+			if err := writeChunks(state.HandleStreamEvent(m)); err != nil {
+				if err != errContentFiltered {
+					abandonStream(stream, err)
+				}
+				return
+			}
+
+		case *ccwire.AssistantMessage:
+			lastAssistant = m
+
+		case *ccwire.ResultMessage:
+			s.observeResult(ctx, m, lastAssistant)
+			if err := writeChunks(state.FinishChunk(lastAssistant)); err != nil {
+				if err != errContentFiltered {
+					abandonStream(stream, err)
+				}
+				return
+			}
+			if m.IsError {
+				log.Printf("claude error: %s", m.Result)
+			}
+			events.StopSequence = state.StopSequence
+			writeEvents(events.Finish(m)...)
+			return
+		}
+	}
+
+	// claude exited without a result; end the message normally, as chat
+	// streams do, with no usage to report
+	if err := writeChunks(state.FinishChunk(lastAssistant)); err != nil {
+		return
+	}
+	events.StopSequence = state.StopSequence
+	writeEvents(events.Finish(nil)...)
+}
+
+// writeMessagesError writes an Anthropic-shaped error. The error type is
+// derived from status; errType, the OpenAI-style type used elsewhere, is
+// ignored.
+func writeMessagesError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(anthropic.NewErrorResponse(status, message))
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codewandler/cc-sdk-go/anthropic"
+	"github.com/codewandler/cc-sdk-go/cchat"
+)
+
+// messagesServer returns a handler with the messages API enabled, backed by a
+// spawner that replays output.
+func messagesServer(output string) http.Handler {
+	client := cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(output)), nil
+	})
+	return New(Config{Client: client, MessagesAPI: true}).Handler()
+}
+
+const messagesOutput = `{"type":"stream_event","event":{"type":"message_start","message":{"model":"claude-haiku"}}}` + "\n" +
+	`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"PO"}}}` + "\n" +
+	`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"NG"}}}` + "\n" +
+	`{"type":"assistant","message":{"model":"claude-haiku","content":[{"type":"text","text":"PONG"}]}}` + "\n" +
+	`{"type":"result","subtype":"success","session_id":"sess-1","result":"PONG","usage":{"input_tokens":12,"output_tokens":3}}` + "\n"
+
+func TestMessages(t *testing.T) {
+	body := `{"model":"haiku","max_tokens":64,"system":"Reply PONG.","messages":[{"role":"user","content":"ping"}]}`
+	w := httptest.NewRecorder()
+	messagesServer(messagesOutput).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var resp anthropic.MessagesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if resp.Type != "message" || resp.ID != "msg_sess-1" || resp.Model != "claude-haiku" {
+		t.Errorf("resp = %+v", resp)
+	}
+	if len(resp.Content) != 1 || resp.Content[0].Text != "PONG" {
+		t.Errorf("content = %+v", resp.Content)
+	}
+	if resp.StopReason == nil || *resp.StopReason != "end_turn" {
+		t.Errorf("stop_reason = %v", resp.StopReason)
+	}
+	if resp.Usage.InputTokens != 12 || resp.Usage.OutputTokens != 3 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestMessages_Streaming(t *testing.T) {
+	body := `{"model":"haiku","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"ping"}]}`
+	w := httptest.NewRecorder()
+	messagesServer(messagesOutput).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var names []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			names = append(names, name)
+		}
+	}
+	want := "message_start,content_block_start,content_block_delta,content_block_delta,content_block_stop,message_delta,message_stop"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("events = %s\nwant %s\nbody:\n%s", got, want, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "[DONE]") {
+		t.Error("Anthropic streams must not end with [DONE]")
+	}
+	if !strings.Contains(w.Body.String(), `"usage":{"input_tokens":12,"output_tokens":3`) {
+		t.Errorf("message_delta should carry usage:\n%s", w.Body.String())
+	}
+}
+
+// TestMessages_StopSequence verifies that a reply cut at a stop sequence
+// reports it, with the stop reason "stop_sequence", with and without
+// streaming.
+func TestMessages_StopSequence(t *testing.T) {
+	body := `{"model":"haiku","max_tokens":64,"stop_sequences":["XX","NG"],"messages":[{"role":"user","content":"ping"}]}`
+	w := httptest.NewRecorder()
+	messagesServer(messagesOutput).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+
+	var resp anthropic.MessagesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v (status %d)", err, w.Code)
+	}
+	if len(resp.Content) != 1 || resp.Content[0].Text != "PO" {
+		t.Errorf("content = %+v", resp.Content)
+	}
+	if resp.StopReason == nil || *resp.StopReason != "stop_sequence" {
+		t.Errorf("stop_reason = %v, want stop_sequence", resp.StopReason)
+	}
+	if resp.StopSequence == nil || *resp.StopSequence != "NG" {
+		t.Errorf("stop_sequence = %v, want NG", resp.StopSequence)
+	}
+
+	body = strings.Replace(body, `"max_tokens":64,`, `"max_tokens":64,"stream":true,`, 1)
+	w = httptest.NewRecorder()
+	messagesServer(messagesOutput).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	if want := `"delta":{"stop_reason":"stop_sequence","stop_sequence":"NG"}`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("stream missing %s:\n%s", want, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), `"text":"NG"`) {
+		t.Errorf("stream sent text past the stop sequence:\n%s", w.Body.String())
+	}
+}
+
+// TestMessages_StreamingNoResult verifies that a stream ending without a
+// result message is finished normally, as chat streams are, rather than
+// with an error event.
+func TestMessages_StreamingNoResult(t *testing.T) {
+	output := messagesOutput[:strings.Index(messagesOutput, `{"type":"result"`)]
+	body := `{"model":"haiku","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"ping"}]}`
+	w := httptest.NewRecorder()
+	messagesServer(output).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+
+	var names []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			names = append(names, name)
+		}
+	}
+	want := "message_start,content_block_start,content_block_delta,content_block_delta,content_block_stop,message_delta,message_stop"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("events = %s\nwant %s\nbody:\n%s", got, want, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"stop_reason":"end_turn"`) {
+		t.Errorf("message_delta should end the turn:\n%s", w.Body.String())
+	}
+}
+
+func TestMessages_Errors(t *testing.T) {
+	t.Run("invalid_request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		messagesServer("").ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"messages":[]}`)))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d", w.Code)
+		}
+		var resp anthropic.ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if resp.Type != "error" || resp.Error.Type != "invalid_request_error" {
+			t.Errorf("error = %+v", resp)
+		}
+	})
+
+	t.Run("streaming_rate_limit", func(t *testing.T) {
+		output := `{"type":"assistant","error":"rate_limit","message":{"content":[{"type":"text","text":"limit reached"}]}}` + "\n"
+		body := `{"stream":true,"messages":[{"role":"user","content":"ping"}]}`
+		w := httptest.NewRecorder()
+		messagesServer(output).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("status = %d, want 429", w.Code)
+		}
+		want := "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"rate_limit_error\",\"message\":\"limit reached\"}}\n\n"
+		if w.Body.String() != want {
+			t.Errorf("body = %q, want %q", w.Body.String(), want)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		New(Config{}).Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{}`)))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404 when the messages API is disabled", w.Code)
+		}
+	})
+}
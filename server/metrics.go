@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codewandler/cc-sdk-go/ccwire"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the request duration
+// histogram. Claude requests range from sub-second replies to multi-minute
+// agentic turns, hence the wide spread.
+var durationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// metricPaths are the routes reported by path label; anything else is
+// counted as "other" to keep label cardinality bounded.
+var metricPaths = []string{"/v1/chat/completions", "/v1/completions", "/v1/messages", "/v1/batch", "/v1/models", "/stats", "/metrics"}
+
+// metrics collects request and token counters for the /metrics endpoint and
+// renders them in the Prometheus text exposition format. The methods are
+// safe for concurrent use, and a nil *metrics ignores observations.
+type metrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	durations map[string]*histogram
+	tokens    [4]uint64 // input, output, cache_creation, cache_read
+}
+
+type requestKey struct {
+	path   string
+	status int
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+var tokenTypes = [4]string{"input", "output", "cache_creation", "cache_read"}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:  make(map[requestKey]uint64),
+		durations: make(map[string]*histogram),
+	}
+}
+
+// metricPath returns the path label for a request path.
+func metricPath(path string) string {
+	if slices.Contains(metricPaths, path) {
+		return path
+	}
+	return "other"
+}
+
+// observeRequest records a finished HTTP request.
+func (m *metrics) observeRequest(path string, status int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	path = metricPath(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{path, status}]++
+	h, ok := m.durations[path]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[path] = h
+	}
+	secs := d.Seconds()
+	if i := sort.SearchFloat64s(durationBuckets, secs); i < len(durationBuckets) {
+		h.counts[i]++
+	}
+	h.sum += secs
+	h.count++
+}
+
+// observeResult records the token usage of result in the metrics and in the
+// access log fields of ctx, the request context; see [logFields]. assistant,
+// which may be nil, is the last assistant message before result.
+func (s *Server) observeResult(ctx context.Context, result *ccwire.ResultMessage, assistant *ccwire.AssistantMessage) {
+	s.metrics.observeResult(result)
+	logFieldsFrom(ctx).addResult(result, assistant)
+}
+
+// observeResult adds the token usage reported in a result message.
+func (m *metrics) observeResult(result *ccwire.ResultMessage) {
+	if m == nil || result == nil {
+		return
+	}
+	u := result.Usage
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[0] += uint64(u.InputTokens)
+	m.tokens[1] += uint64(u.OutputTokens)
+	m.tokens[2] += uint64(u.CacheCreationInputTokens)
+	m.tokens[3] += uint64(u.CacheReadInputTokens)
+}
+
+// writeTo renders the collected metrics.
+func (m *metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].path != keys[j].path {
+			return keys[i].path < keys[j].path
+		}
+		return keys[i].status < keys[j].status
+	})
+	fmt.Fprintln(w, "# HELP ccproxy_http_requests_total HTTP requests by path and status code.")
+	fmt.Fprintln(w, "# TYPE ccproxy_http_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "ccproxy_http_requests_total{path=%q,code=\"%d\"} %d\n", k.path, k.status, m.requests[k])
+	}
+
+	paths := make([]string, 0, len(m.durations))
+	for p := range m.durations {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	fmt.Fprintln(w, "# HELP ccproxy_http_request_duration_seconds HTTP request duration by path.")
+	fmt.Fprintln(w, "# TYPE ccproxy_http_request_duration_seconds histogram")
+	for _, p := range paths {
+		h := m.durations[p]
+		var cumulative uint64
+		for i, le := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "ccproxy_http_request_duration_seconds_bucket{path=%q,le=%q} %d\n", p, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "ccproxy_http_request_duration_seconds_bucket{path=%q,le=\"+Inf\"} %d\n", p, h.count)
+		fmt.Fprintf(w, "ccproxy_http_request_duration_seconds_sum{path=%q} %g\n", p, h.sum)
+		fmt.Fprintf(w, "ccproxy_http_request_duration_seconds_count{path=%q} %d\n", p, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP ccproxy_tokens_total Tokens reported by claude results, by type.")
+	fmt.Fprintln(w, "# TYPE ccproxy_tokens_total counter")
+	for i, typ := range tokenTypes {
+		fmt.Fprintf(w, "ccproxy_tokens_total{type=%q} %d\n", typ, m.tokens[i])
+	}
+}
+
+// handleMetrics serves the collected metrics, plus the client's process
+// counters, in the Prometheus text exposition format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Only GET is accepted")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.writeTo(w)
+
+	if s.client == nil {
+		return
+	}
+	stats := s.client.Stats()
+	fmt.Fprintln(w, "# HELP ccproxy_active_processes Claude subprocesses currently running.")
+	fmt.Fprintln(w, "# TYPE ccproxy_active_processes gauge")
+	fmt.Fprintf(w, "ccproxy_active_processes %d\n", stats.InUse)
+	fmt.Fprintln(w, "# HELP ccproxy_queued_requests Requests waiting for a free subprocess slot.")
+	fmt.Fprintln(w, "# TYPE ccproxy_queued_requests gauge")
+	fmt.Fprintf(w, "ccproxy_queued_requests %d\n", stats.Waiting)
+	fmt.Fprintln(w, "# HELP ccproxy_max_concurrent_processes Configured subprocess limit; 0 means unlimited.")
+	fmt.Fprintln(w, "# TYPE ccproxy_max_concurrent_processes gauge")
+	fmt.Fprintf(w, "ccproxy_max_concurrent_processes %d\n", stats.MaxConcurrent)
+	fmt.Fprintln(w, "# HELP ccproxy_processes_started_total Claude subprocesses started.")
+	fmt.Fprintln(w, "# TYPE ccproxy_processes_started_total counter")
+	fmt.Fprintf(w, "ccproxy_processes_started_total %d\n", stats.Started)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codewandler/cc-sdk-go/cchat"
+)
+
+func TestMetrics(t *testing.T) {
+	client := cchat.NewClientWithSpawner(&cchat.ClientConfig{MaxConcurrent: 2}, func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(
+			`{"type":"assistant","message":{"model":"claude-haiku","content":[{"type":"text","text":"PONG"}]}}` + "\n" +
+				`{"type":"result","subtype":"success","session_id":"sess-1","result":"PONG",` +
+				`"usage":{"input_tokens":10,"output_tokens":5,"cache_read_input_tokens":100,"cache_creation_input_tokens":7}}` + "\n",
+		)), nil
+	})
+	h := New(Config{Client: client, MetricsEnabled: true, BatchAPI: true}).Handler()
+
+	body := `{"model":"haiku","messages":[{"role":"user","content":"ping"}]}`
+	for range 2 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+		}
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/batch", strings.NewReader("["+body+"]")))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/no/such/path", nil))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	out := w.Body.String()
+	for _, want := range []string{
+		`ccproxy_http_requests_total{path="/v1/chat/completions",code="200"} 2`,
+		`ccproxy_http_requests_total{path="/v1/batch",code="200"} 1`,
+		`ccproxy_http_requests_total{path="other",code="404"} 1`,
+		`ccproxy_http_request_duration_seconds_bucket{path="/v1/chat/completions",le="+Inf"} 2`,
+		`ccproxy_http_request_duration_seconds_count{path="/v1/chat/completions"} 2`,
+		`ccproxy_tokens_total{type="input"} 30`,
+		`ccproxy_tokens_total{type="output"} 15`,
+		`ccproxy_tokens_total{type="cache_read"} 300`,
+		`ccproxy_tokens_total{type="cache_creation"} 21`,
+		`ccproxy_active_processes 0`,
+		`ccproxy_max_concurrent_processes 2`,
+		`ccproxy_processes_started_total 3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetrics_Disabled(t *testing.T) {
+	w := httptest.NewRecorder()
+	New(Config{}).Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 when metrics are disabled", w.Code)
+	}
+}
+
+func TestMetrics_Histogram(t *testing.T) {
+	m := newMetrics()
+	m.observeRequest("/v1/models", 200, 50*time.Millisecond)
+	m.observeRequest("/v1/models", 200, 2*time.Second)
+	m.observeRequest("/v1/models", 200, 10*time.Minute)
+
+	var b strings.Builder
+	m.writeTo(&b)
+	out := b.String()
+	for _, want := range []string{
+		`ccproxy_http_request_duration_seconds_bucket{path="/v1/models",le="0.1"} 1`,
+		`ccproxy_http_request_duration_seconds_bucket{path="/v1/models",le="2.5"} 2`,
+		`ccproxy_http_request_duration_seconds_bucket{path="/v1/models",le="300"} 2`,
+		`ccproxy_http_request_duration_seconds_bucket{path="/v1/models",le="+Inf"} 3`,
+		`ccproxy_http_request_duration_seconds_sum{path="/v1/models"} 602.05`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics output missing %q:\n%s", want, out)
+		}
+	}
+}
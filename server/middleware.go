@@ -1,25 +1,45 @@
 package server
 
 import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"crypto/subtle"
+	"fmt"
+	"io"
 	"log"
+	"maps"
+	"mime"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/codewandler/cc-sdk-go/cchat"
+	"github.com/codewandler/cc-sdk-go/ccwire"
 )
 
-// authMiddleware validates Bearer token authentication.
+// authMiddleware validates Bearer token authentication. On the Anthropic
+// routes (see isAnthropicPath) the key may also be sent in an x-api-key
+// header, as Anthropic's SDKs do; the OpenAI routes accept only Bearer.
 func authMiddleware(apiKey string, next http.Handler) http.Handler {
 	if apiKey == "" {
 		return next // No auth required
 	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
-		if !strings.HasPrefix(auth, "Bearer ") {
+		providedKey, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			providedKey = ""
+			if isAnthropicPath(r.URL.Path) {
+				providedKey = r.Header.Get("X-Api-Key")
+			}
+		}
+		if providedKey == "" {
 			writeError(w, http.StatusUnauthorized, "invalid_api_key", "Invalid API key")
 			return
 		}
-		providedKey := strings.TrimPrefix(auth, "Bearer ")
 		// Use constant-time comparison to prevent timing attacks
 		if subtle.ConstantTimeCompare([]byte(providedKey), []byte(apiKey)) != 1 {
 			writeError(w, http.StatusUnauthorized, "invalid_api_key", "Invalid API key")
@@ -29,19 +49,206 @@ func authMiddleware(apiKey string, next http.Handler) http.Handler {
 	})
 }
 
-// loggingMiddleware logs HTTP requests.
-func loggingMiddleware(next http.Handler) http.Handler {
+// isAnthropicPath reports whether path is served by the Anthropic Messages
+// API, i.e. /v1/messages and any route below it.
+func isAnthropicPath(path string) bool {
+	return path == "/v1/messages" || strings.HasPrefix(path, "/v1/messages/")
+}
+
+// corsMiddleware adds CORS headers for requests whose Origin is in
+// allowedOrigins and answers OPTIONS preflight requests directly, so that
+// browsers never need to authenticate the preflight. The special origin "*"
+// allows any origin. With no allowed origins, CORS is disabled.
+func corsMiddleware(allowedOrigins []string, next http.Handler) http.Handler {
+	if len(allowedOrigins) == 0 {
+		return next // CORS disabled
+	}
+	allowAll := slices.Contains(allowedOrigins, "*")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && (allowAll || slices.Contains(allowedOrigins, origin))
+		if allowed {
+			h := w.Header()
+			if allowAll {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Add("Vary", "Origin")
+			}
+		}
+
+		// Preflight requests never reach the auth layer
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed {
+				h := w.Header()
+				h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Api-Key, Anthropic-Version, X-CC-Timeout")
+				h.Set("Access-Control-Max-Age", "600")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// compressionMiddleware compresses JSON responses with gzip or deflate when
+// the request's Accept-Encoding allows it, preferring gzip. Only responses
+// with a Content-Type of application/json are compressed, so SSE streams,
+// which need every event flushed as written, pass through untouched.
+func compressionMiddleware(enabled bool, next http.Handler) http.Handler {
+	if !enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			w.Header().Add("Vary", "Accept-Encoding")
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding returns the content coding to use for an Accept-Encoding
+// header value: "gzip" or "deflate", or "" if neither is acceptable.
+func acceptedEncoding(header string) string {
+	var deflate bool
+	for part := range strings.SplitSeq(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		switch name {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+// compressWriter compresses the body written through it once the handler
+// has committed to a JSON response. The choice is made when the header is
+// written, from the Content-Type the handler set.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	zw       interface {
+		io.WriteCloser
+		Flush() error
+	} // nil unless compressing
+	wroteHeader bool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if mediaType == "application/json" && h.Get("Content-Encoding") == "" &&
+		code != http.StatusNoContent && code != http.StatusNotModified {
+		h.Del("Content-Length") // length of the uncompressed body
+		h.Set("Content-Encoding", w.encoding)
+		if w.encoding == "gzip" {
+			w.zw = gzip.NewWriter(w.ResponseWriter)
+		} else {
+			w.zw = zlib.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.zw != nil {
+		return w.zw.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends any compressed data buffered so far, then flushes the
+// underlying writer.
+func (w *compressWriter) Flush() {
+	if w.zw != nil {
+		w.zw.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap for http.ResponseController support
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close ends the compressed stream, if any.
+func (w *compressWriter) close() error {
+	if w.zw == nil {
+		return nil
+	}
+	return w.zw.Close()
+}
+
+// loggingMiddleware logs HTTP requests and records them in m, which may be
+// nil. Each log line has the method, path, status, response body size as
+// sent (after compression) and duration, followed by the [logFields] the
+// handler recorded, e.g.
+//
+//	POST /v1/chat/completions 200 312B 2.4s model=claude-sonnet-4-5 prompt_tokens=1200 completion_tokens=85
+func loggingMiddleware(next http.Handler, m *metrics) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		sw := &statusWriter{ResponseWriter: w, status: 200}
-		next.ServeHTTP(sw, r)
-		log.Printf("%s %s %d %s", r.Method, r.URL.Path, sw.status, time.Since(start).Round(time.Millisecond))
+		fields := &logFields{}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), logFieldsKey{}, fields)))
+		elapsed := time.Since(start)
+		m.observeRequest(r.URL.Path, sw.status, elapsed)
+		log.Printf("%s %s %d %dB %s%s", r.Method, r.URL.Path, sw.status, sw.bytes, elapsed.Round(time.Millisecond), fields)
+	})
+}
+
+// tracingMiddleware starts a span with tracer for each request, ending it
+// once the handler returns. It runs inside loggingMiddleware, so that it can
+// add the model and token usage the handler recorded in the [logFields] to
+// the span. A nil tracer disables tracing.
+func tracingMiddleware(tracer cchat.Tracer, next http.Handler) http.Handler {
+	if tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(r.Context(), "http.request")
+		defer span.End()
+		span.SetAttributes(
+			cchat.Attribute{Key: "http.request.method", Value: r.Method},
+			cchat.Attribute{Key: "url.path", Value: r.URL.Path},
+		)
+		sw := &statusWriter{ResponseWriter: w, status: 200}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		span.SetAttributes(cchat.Attribute{Key: "http.response.status_code", Value: sw.status})
+		span.SetAttributes(logFieldsFrom(ctx).attributes()...)
 	})
 }
 
 type statusWriter struct {
 	http.ResponseWriter
 	status int
+	bytes  int64 // body bytes written
 }
 
 func (w *statusWriter) WriteHeader(code int) {
@@ -49,11 +256,98 @@ func (w *statusWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
-// Unwrap for http.Flusher support
+func (w *statusWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap for http.ResponseController support, e.g. flushing SSE events
 func (w *statusWriter) Unwrap() http.ResponseWriter {
 	return w.ResponseWriter
 }
 
+// logFields is what handlers learn about a request for its access log line:
+// the model and token usage of the queries it ran. loggingMiddleware puts
+// one in the request context, where handlers find it with logFieldsFrom.
+// A batch adds the results of its requests from several goroutines.
+type logFields struct {
+	mu               sync.Mutex
+	results          int
+	model            string // of the first result
+	promptTokens     int
+	completionTokens int
+}
+
+// logFieldsKey is the context key of the request's *logFields.
+type logFieldsKey struct{}
+
+// logFieldsFrom returns the log fields of ctx, or nil if it has none, e.g.
+// in handler tests that bypass the middleware.
+func logFieldsFrom(ctx context.Context) *logFields {
+	f, _ := ctx.Value(logFieldsKey{}).(*logFields)
+	return f
+}
+
+// addResult adds the token usage of result, counted as in chat completion
+// responses. The model is taken from assistant, which may be nil, or else
+// from the result's model usage. It does nothing on a nil f.
+func (f *logFields) addResult(result *ccwire.ResultMessage, assistant *ccwire.AssistantMessage) {
+	if f == nil || result == nil {
+		return
+	}
+	u := result.Usage
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results++
+	f.promptTokens += u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens
+	f.completionTokens += u.OutputTokens
+	if f.model != "" {
+		return
+	}
+	if assistant != nil && assistant.Message.Model != "" {
+		f.model = assistant.Message.Model
+	} else if models := slices.Sorted(maps.Keys(result.ModelUsage)); len(models) > 0 {
+		f.model = models[0]
+	}
+}
+
+// String formats the fields for the end of a log line, with a leading
+// space, or returns "" if no query reported a result.
+func (f *logFields) String() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.results == 0 {
+		return ""
+	}
+	model := f.model
+	if model == "" {
+		model = "unknown"
+	}
+	return fmt.Sprintf(" model=%s prompt_tokens=%d completion_tokens=%d", model, f.promptTokens, f.completionTokens)
+}
+
+// attributes returns the fields as span attributes, or nil if no query
+// reported a result or f is nil.
+func (f *logFields) attributes() []cchat.Attribute {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.results == 0 {
+		return nil
+	}
+	attrs := []cchat.Attribute{
+		{Key: cchat.AttrInputTokens, Value: f.promptTokens},
+		{Key: cchat.AttrOutputTokens, Value: f.completionTokens},
+	}
+	if f.model != "" {
+		attrs = append(attrs, cchat.Attribute{Key: cchat.AttrResponseModel, Value: f.model})
+	}
+	return attrs
+}
+
 // recoveryMiddleware catches panics and returns 500.
 func recoveryMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
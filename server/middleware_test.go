@@ -1,12 +1,21 @@
 package server
 
 import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/codewandler/cc-sdk-go/cchat"
+	"github.com/codewandler/cc-sdk-go/ccwire"
 	"github.com/codewandler/cc-sdk-go/oai"
 )
 
@@ -50,6 +59,40 @@ func TestAuthMiddleware_ValidKey(t *testing.T) {
 	}
 }
 
+func TestAuthMiddleware_XAPIKey(t *testing.T) {
+	handler := authMiddleware("secret-key-123", dummyHandler)
+
+	for key, want := range map[string]int{"secret-key-123": http.StatusOK, "wrong-key": http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != want {
+			t.Errorf("x-api-key %q: expected status %d, got %d", key, want, w.Code)
+		}
+	}
+}
+
+// TestAuthMiddleware_XAPIKeyOpenAIRoutes verifies that the OpenAI routes
+// accept only Bearer auth, not the x-api-key header of the Anthropic routes.
+func TestAuthMiddleware_XAPIKeyOpenAIRoutes(t *testing.T) {
+	handler := authMiddleware("secret-key-123", dummyHandler)
+
+	for _, path := range []string{"/v1/chat/completions", "/v1/completions", "/v1/models", "/v1/batch", "/v1/messagesx"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("X-Api-Key", "secret-key-123")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s with x-api-key: expected status 401, got %d", path, w.Code)
+		}
+	}
+}
+
 func TestAuthMiddleware_InvalidKey(t *testing.T) {
 	handler := authMiddleware("secret-key-123", dummyHandler)
 
@@ -170,3 +213,251 @@ func TestAuthMiddleware_DifferentLengthKeys(t *testing.T) {
 		t.Errorf("expected status 401, got %d", w.Code)
 	}
 }
+
+func TestCORSMiddleware_Disabled(t *testing.T) {
+	handler := corsMiddleware(nil, dummyHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected no CORS header when disabled, got %q", got)
+	}
+}
+
+func TestCORSMiddleware_Preflight(t *testing.T) {
+	// Preflight must be answered before auth, without a Bearer token
+	handler := corsMiddleware([]string{"https://app.example.com"}, authMiddleware("secret", dummyHandler))
+
+	req := httptest.NewRequest(http.MethodOptions, "/v1/chat/completions", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Authorization") {
+		t.Errorf("Allow-Headers = %q, want Authorization included", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, "POST") {
+		t.Errorf("Allow-Methods = %q, want POST included", got)
+	}
+}
+
+func TestCORSMiddleware_ActualRequest(t *testing.T) {
+	handler := corsMiddleware([]string{"https://app.example.com"}, dummyHandler)
+
+	tests := []struct {
+		name   string
+		origin string
+		want   string
+	}{
+		{name: "allowed_origin", origin: "https://app.example.com", want: "https://app.example.com"},
+		{name: "unknown_origin", origin: "https://evil.example.com", want: ""},
+		{name: "no_origin", origin: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("expected status 200, got %d", w.Code)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCORSMiddleware_Wildcard(t *testing.T) {
+	handler := corsMiddleware([]string{"*"}, dummyHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	req.Header.Set("Origin", "https://anything.example.com")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Allow-Origin = %q, want *", got)
+	}
+}
+
+func TestCompressionMiddleware_JSON(t *testing.T) {
+	handler := New(Config{EnableCompression: true}).Handler()
+
+	tests := []struct {
+		acceptEncoding string
+		want           string
+		decode         func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip, deflate, br", "gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"deflate, gzip;q=0", "deflate", func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }},
+		{"br", "", func(r io.Reader) (io.Reader, error) { return r, nil }},
+		{"", "", func(r io.Reader) (io.Reader, error) { return r, nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if got := w.Header().Get("Content-Encoding"); got != tt.want {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.want)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			if tt.want != "" && w.Header().Get("Content-Length") != "" {
+				t.Error("compressed response must not carry the uncompressed Content-Length")
+			}
+			body, err := tt.decode(w.Body)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			var models struct {
+				Data []any `json:"data"`
+			}
+			if err := json.NewDecoder(body).Decode(&models); err != nil || len(models.Data) == 0 {
+				t.Errorf("models = %+v, err %v", models, err)
+			}
+		})
+	}
+}
+
+// TestCompressionMiddleware_SSE verifies that streams are neither compressed
+// nor buffered: each event is flushed through the middleware stack, with and
+// without compression enabled.
+func TestCompressionMiddleware_SSE(t *testing.T) {
+	client := cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(messagesOutput)), nil
+	})
+
+	for _, enabled := range []bool{false, true} {
+		handler := New(Config{Client: client, EnableCompression: enabled}).Handler()
+
+		body := `{"model":"haiku","stream":true,"messages":[{"role":"user","content":"ping"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("compression %v: Content-Encoding = %q, SSE must not be compressed", enabled, got)
+		}
+		if !strings.HasPrefix(w.Body.String(), "data: ") || !strings.HasSuffix(w.Body.String(), "data: [DONE]\n\n") {
+			t.Errorf("compression %v: body is not a plain SSE stream:\n%s", enabled, w.Body.String())
+		}
+		if !w.Flushed {
+			t.Errorf("compression %v: SSE events were not flushed through the middleware stack", enabled)
+		}
+	}
+}
+
+// TestLoggingMiddleware_AccessLog verifies that the access log line carries
+// the response size and the model and token usage of the query behind a
+// completion, streamed or not, and no token fields for other requests.
+func TestLoggingMiddleware_AccessLog(t *testing.T) {
+	var logs strings.Builder
+	prev := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(prev) })
+
+	srv := New(Config{Querier: queryFunc(func(context.Context, string, cchat.QueryOptions) (StreamReader, error) {
+		return &mockStream{messages: []ccwire.Message{
+			&ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start", "message": map[string]any{"model": "claude-haiku"}}},
+			&ccwire.AssistantMessage{Message: ccwire.AssistantInner{Model: "claude-haiku", Content: []ccwire.ContentBlock{{Type: "text", Text: "PONG"}}}},
+			&ccwire.ResultMessage{Subtype: "success", Result: "PONG", Usage: ccwire.ResultUsage{InputTokens: 7, CacheReadInputTokens: 3, OutputTokens: 2}},
+		}}, nil
+	})})
+
+	tests := []struct {
+		name, method, path, body string
+		wantFields               string
+	}{
+		{"chat", http.MethodPost, "/v1/chat/completions", `{"messages":[{"role":"user","content":"ping"}]}`, " model=claude-haiku prompt_tokens=10 completion_tokens=2"},
+		{"chat_stream", http.MethodPost, "/v1/chat/completions", `{"stream":true,"messages":[{"role":"user","content":"ping"}]}`, " model=claude-haiku prompt_tokens=10 completion_tokens=2"},
+		{"models", http.MethodGet, "/v1/models", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			w := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			line := strings.TrimSpace(logs.String())
+			prefix := fmt.Sprintf("%s %s %d %dB ", tt.method, tt.path, w.Code, w.Body.Len())
+			if !strings.Contains(line, prefix) {
+				t.Errorf("log = %q, want it to contain %q", line, prefix)
+			}
+			if tt.wantFields != "" && !strings.HasSuffix(line, tt.wantFields) {
+				t.Errorf("log = %q, want it to end with %q", line, tt.wantFields)
+			}
+			if tt.wantFields == "" && strings.Contains(line, "tokens=") {
+				t.Errorf("log = %q, want no token fields", line)
+			}
+		})
+	}
+}
+
+// TestTracingMiddleware verifies that a request span carries the status,
+// model and usage, and that the client's spans nest within it.
+func TestTracingMiddleware(t *testing.T) {
+	tracer := &cchat.RecordingTracer{}
+	client := cchat.NewClientWithSpawner(&cchat.ClientConfig{Tracer: tracer}, func(context.Context, string, cchat.QueryOptions) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(
+			`{"type":"assistant","message":{"model":"claude-haiku","content":[{"type":"text","text":"PONG"}]}}` + "\n" +
+				`{"type":"result","subtype":"success","result":"PONG","usage":{"input_tokens":7,"output_tokens":2}}` + "\n",
+		)), nil
+	})
+	srv := New(Config{Client: client, Tracer: tracer})
+
+	body := `{"model":"haiku","messages":[{"role":"user","content":"ping"}]}`
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+
+	req, query, proc := tracer.Span("http.request"), tracer.Span("cchat.query"), tracer.Span("cchat.process")
+	if req == nil || query == nil || proc == nil || query.Parent != req || proc.Parent != query {
+		t.Fatalf("spans = %+v, want http.request > cchat.query > cchat.process", tracer.Spans())
+	}
+	want := map[string]any{
+		"http.request.method":       http.MethodPost,
+		"url.path":                  "/v1/chat/completions",
+		"http.response.status_code": http.StatusOK,
+		cchat.AttrResponseModel:     "claude-haiku",
+		cchat.AttrInputTokens:       7,
+		cchat.AttrOutputTokens:      2,
+	}
+	for k, v := range want {
+		if req.Attributes[k] != v {
+			t.Errorf("request attribute %s = %v, want %v", k, req.Attributes[k], v)
+		}
+	}
+	for _, span := range []*cchat.RecordedSpan{req, query, proc} {
+		if span.Ended != 1 {
+			t.Errorf("span %s ended %d times, want once", span.Name, span.Ended)
+		}
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/codewandler/cc-sdk-go/cchat"
 	"github.com/codewandler/cc-sdk-go/ccwire"
+	"github.com/codewandler/cc-sdk-go/oai"
 )
 
 // StreamReader is the interface consumed by the server to read messages from a
@@ -17,12 +19,38 @@ import (
 // that don't spawn real processes.
 //
 // Next returns the next parsed message or [io.EOF] when the stream is exhausted.
-// Close releases any resources held by the underlying stream.
+// Close releases any resources held by the underlying stream. Close may be
+// called more than once, and from another goroutine while Next is blocked,
+// in which case it should make Next return promptly, as [cchat.Stream] does.
 type StreamReader interface {
 	Next() (ccwire.Message, error)
 	Close() error
 }
 
+// Querier starts queries on behalf of the server. [cchat.Client] provides
+// the production implementation, via [Config].Client; tests and alternative
+// backends can supply their own through [Config].Querier.
+//
+// Query starts a query for prompt and returns a stream of its messages. The
+// caller closes the stream when done. Errors are reported to the client as
+// with [cchat.Client.Query], so the cchat error types keep their meaning.
+type Querier interface {
+	Query(ctx context.Context, prompt string, opts cchat.QueryOptions) (StreamReader, error)
+}
+
+// clientQuerier adapts a [cchat.Client] to [Querier].
+type clientQuerier struct {
+	client *cchat.Client
+}
+
+func (q clientQuerier) Query(ctx context.Context, prompt string, opts cchat.QueryOptions) (StreamReader, error) {
+	stream, err := q.client.Query(ctx, prompt, opts)
+	if err != nil {
+		return nil, err // not a typed nil *cchat.Stream
+	}
+	return stream, nil
+}
+
 // Config holds the settings used to create a [Server].
 type Config struct {
 	// Addr is the TCP address for the server to listen on, in the form "host:port".
@@ -33,11 +61,160 @@ type Config struct {
 	// When non-empty, every request must include an "Authorization: Bearer <key>"
 	// header whose value matches this key (compared in constant time). When empty,
 	// the auth middleware is bypassed entirely and all requests are allowed through.
+	// Requests to /v1/messages may send the key in an "x-api-key" header
+	// instead, as Anthropic's SDKs do.
 	APIKey string
 
+	// AllowedOrigins lists the browser origins permitted to call the server
+	// via CORS. The special value "*" allows any origin. When empty (the
+	// default), no CORS headers are sent and preflight requests are not
+	// answered, so browsers block cross-origin calls.
+	AllowedOrigins []string
+
+	// SystemFingerprint, when non-empty, is reported as the
+	// system_fingerprint of every chat completion response and chunk. When
+	// empty, a fingerprint is derived from the model and the claude CLI
+	// version; see [oai.SystemFingerprint].
+	SystemFingerprint string
+
+	// ModelAliases maps model names sent by clients to the names passed to
+	// the claude CLI, e.g. "gpt-4o" to "opus", so clients configured for
+	// OpenAI models work unchanged. Models without an alias are passed
+	// through as-is. See also [cchat.ClientConfig].ModelAliases.
+	ModelAliases map[string]string
+
+	// AdvertiseModelAliases lists the ModelAliases names in /v1/models
+	// alongside the Claude model names.
+	AdvertiseModelAliases bool
+
+	// MessagesAPI registers a POST /v1/messages endpoint speaking the
+	// Anthropic Messages API, so tools built on Anthropic's SDKs can use the
+	// proxy unchanged. See the [anthropic] package for what is supported.
+	MessagesAPI bool
+
+	// BatchAPI registers a POST /v1/batch endpoint that accepts a JSON
+	// array of chat completion requests, runs them concurrently within the
+	// client's MaxConcurrent, and returns an array of [BatchResult] in
+	// input order. Streaming requests are rejected per element.
+	BatchAPI bool
+
+	// MetricsEnabled registers a GET /metrics endpoint serving request
+	// counts, latencies, token usage, and process counters in the Prometheus
+	// text exposition format. It is off by default; the endpoint is written
+	// by hand, so enabling it adds no dependencies.
+	MetricsEnabled bool
+
+	// FlushInterval, when positive, coalesces the text deltas of chat and
+	// legacy completion streams: consecutive text is sent as one chunk at
+	// most once per interval instead of one SSE event per token, cutting
+	// the event count for fast streams. Role, reasoning, tool call, and
+	// finish chunks are sent immediately, after any held text. Held text
+	// is released as messages arrive from the CLI, so it can wait longer
+	// than the interval while the model is silent. When zero, every delta
+	// is sent as it arrives.
+	FlushInterval time.Duration
+
+	// SSEMessageEvents precedes every event of chat and legacy completion
+	// streams, including error events and the final [DONE], with an
+	// "event: message" line. The SSE format treats an event without one as
+	// a message event anyway, so conforming clients see no difference; this
+	// is for strict parsers that expect an event field on every event.
+	// Anthropic Messages streams always name their events.
+	SSEMessageEvents bool
+
+	// OmitNullFinishReason leaves finish_reason out of chat stream chunks
+	// until the final one, instead of sending "finish_reason":null as OpenAI
+	// does, for clients that reject the null. Conforming clients are
+	// unaffected.
+	OmitNullFinishReason bool
+
+	// MaxRequestTimeout lets clients override the client's DefaultTimeout
+	// for a single request with an X-CC-Timeout header, e.g. "X-CC-Timeout:
+	// 20m" for a long analysis or "X-CC-Timeout: 15" (seconds) for a quick
+	// classification. Longer timeouts are capped at MaxRequestTimeout, and
+	// malformed or non-positive values are rejected with 400. Zero (the
+	// default) ignores the header.
+	MaxRequestTimeout time.Duration
+
+	// MaxRequestBytes limits the size of request bodies. Larger bodies are
+	// rejected with 413 and error type "request_too_large". Zero means
+	// [DefaultMaxRequestBytes]. Raise it for long histories with inline
+	// images.
+	MaxRequestBytes int64
+
+	// StrictSampling rejects requests that set temperature or top_p with
+	// 400 when the client cannot pass them to the CLI; see
+	// [cchat.ClientConfig].SamplingFlags. When false, they are ignored and
+	// a warning is logged the first time.
+	StrictSampling bool
+
+	// EnableCompression compresses JSON responses, such as non-streaming
+	// chat completions and the model list, with gzip or deflate for
+	// clients that send a matching Accept-Encoding header. SSE streams are
+	// never compressed.
+	EnableCompression bool
+
+	// PriceTable, when non-nil, reports the cost of each non-streaming chat
+	// completion as x_cc_cost_usd: the cost the CLI reported, or one
+	// computed from the token usage when the CLI reported none; see
+	// [oai.PriceTable.Cost].
+	PriceTable oai.PriceTable
+
+	// ContentFilter, when non-nil, lets operators redact or reject content,
+	// e.g. personal data or secrets. It is called with the role and text of
+	// every request message before the prompt is assembled, and with role
+	// "assistant" on the reply content, and returns the text to use in its
+	// place and whether to accept it at all. A rejected request message
+	// fails the request with 400; rejected output is dropped and the reply
+	// finishes with [FinishReasonContentFilter].
+	//
+	// Non-streaming replies are filtered whole. Streams are filtered delta
+	// by delta as they are sent, so text split across deltas is never seen
+	// in one piece; [Config].FlushInterval makes the pieces larger. Only
+	// with tools, where the reply text is held back until it can be parsed
+	// for tool calls, is more of it seen at once. Tool call arguments and
+	// reasoning content are not filtered. The filter may be called from
+	// several goroutines at once.
+	ContentFilter func(role, text string) (string, bool)
+
+	// PromptFormat controls how requests are rendered into the claude
+	// prompt; see [oai.RequestToQueryWith]. Public deployments should set
+	// its DelimitContent, so that message text cannot pose as conversation
+	// turns or tool calls. Its SystemPrefix and SystemSuffix add operator
+	// instructions to every request. The zero value renders like
+	// [oai.RequestToQuery].
+	PromptFormat oai.PromptFormat
+
+	// PromptAssembler, when non-nil, renders requests instead of
+	// PromptFormat, for prompt strategies other than role prefixes; see
+	// [oai.PromptAssembler] and [oai.LookupPromptAssembler].
+	PromptAssembler oai.PromptAssembler
+
+	// EmptyResponseText, when non-empty, is sent as the content of a chat
+	// reply that has neither content nor tool calls, e.g. because the
+	// model only thought or refused, for clients that treat an empty reply
+	// as an error and retry. The finish reason is unchanged: "stop", or
+	// [oai.FinishReasonContentFilter] for a refusal. See also
+	// [oai.Client].EmptyResponseText.
+	EmptyResponseText string
+
+	// Tracer, when non-nil, starts an "http.request" span for every
+	// request, with the model and token usage of its queries. The request
+	// context carries the span, so the spans of a [cchat.Client] using the
+	// same tracer (see [cchat.ClientConfig].Tracer) nest within it.
+	Tracer cchat.Tracer
+
 	// Client is the cchat.Client used to spawn Claude Code subprocesses.
-	// It must be non-nil.
+	// It must be non-nil unless Querier is set.
 	Client *cchat.Client
+
+	// Querier, when non-nil, runs queries instead of Client, e.g. an
+	// in-memory fake in tests or another backend. Client may still be set
+	// alongside it to supply the model list, the CLI version, the
+	// concurrency stats, and the sampling support; without one these fall
+	// back to defaults, and temperature and top_p are passed to the
+	// Querier as given.
+	Querier Querier
 }
 
 // Server is an OpenAI-compatible HTTP server that translates chat completion
@@ -45,36 +222,63 @@ type Config struct {
 // OpenAI format. Use [New] to create an instance and [Server.ListenAndServe]
 // to start serving.
 type Server struct {
-	cfg    Config
-	client *cchat.Client
-	mux    *http.ServeMux
+	cfg             Config
+	client          *cchat.Client // may be nil when cfg.Querier is set
+	querier         Querier
+	mux             *http.ServeMux
+	metrics         *metrics  // nil unless Config.MetricsEnabled
+	samplingWarning sync.Once // logs the first ignored temperature/top_p
 }
 
 // New creates a [Server] with the given configuration and registers the
-// /v1/chat/completions and /v1/models routes. The returned server is ready
-// to be started with [Server.ListenAndServe] or used directly via
-// [Server.Handler] for custom HTTP serving arrangements.
+// /v1/chat/completions, /v1/completions, /v1/models, /v1/models/{id}, and
+// /stats routes. The returned server is ready to be started with
+// [Server.ListenAndServe] or used directly via [Server.Handler] for custom
+// HTTP serving arrangements. With [Config].MessagesAPI, /v1/messages is
+// registered as well, with [Config].BatchAPI, /v1/batch, and with
+// [Config].MetricsEnabled, /metrics.
 func New(cfg Config) *Server {
 	s := &Server{
 		cfg:    cfg,
 		client: cfg.Client,
 		mux:    http.NewServeMux(),
 	}
+	s.querier = cfg.Querier
+	if s.querier == nil {
+		s.querier = clientQuerier{cfg.Client}
+	}
 
 	s.mux.HandleFunc("/v1/chat/completions", s.handleChatCompletions)
+	s.mux.HandleFunc("/v1/completions", s.handleCompletions)
 	s.mux.HandleFunc("/v1/models", s.handleModels)
+	s.mux.HandleFunc("/v1/models/{id...}", s.handleModel)
+	s.mux.HandleFunc("/stats", s.handleStats)
+	if cfg.MessagesAPI {
+		s.mux.HandleFunc("/v1/messages", s.handleMessages)
+	}
+	if cfg.BatchAPI {
+		s.mux.HandleFunc("/v1/batch", s.handleBatch)
+	}
+	if cfg.MetricsEnabled {
+		s.metrics = newMetrics()
+		s.mux.HandleFunc("/metrics", s.handleMetrics)
+	}
 
 	return s
 }
 
 // Handler returns the fully assembled [http.Handler] with the middleware stack
-// applied (panic recovery, request logging, and optional Bearer token auth).
+// applied (panic recovery, request logging and metrics, optional tracing,
+// optional compression, optional CORS, and optional Bearer token auth).
 // This is useful for testing or for mounting the server inside a custom
 // [http.Server].
 func (s *Server) Handler() http.Handler {
 	var h http.Handler = s.mux
 	h = authMiddleware(s.cfg.APIKey, h)
-	h = loggingMiddleware(h)
+	h = corsMiddleware(s.cfg.AllowedOrigins, h)
+	h = compressionMiddleware(s.cfg.EnableCompression, h)
+	h = tracingMiddleware(s.cfg.Tracer, h)
+	h = loggingMiddleware(h, s.metrics)
 	h = recoveryMiddleware(h)
 	return h
 }
@@ -84,14 +288,22 @@ func (s *Server) Handler() http.Handler {
 //
 // When ctx is cancelled, the server initiates a graceful shutdown with a
 // 15-second deadline, allowing in-flight requests (including active SSE streams)
-// to complete before forcibly closing connections. If the server shuts down
+// to complete before forcibly closing connections. The client is drained
+// first (see [cchat.Client.Drain]), so requests arriving during shutdown get
+// 503 instead of spawning a process. If the server shuts down
 // cleanly within the deadline, ListenAndServe returns nil.
+//
+// Request contexts carry the values of ctx but not its cancellation, so
+// in-flight queries are not interrupted when shutdown begins. They are
+// cancelled once the deadline has passed.
 func (s *Server) ListenAndServe(ctx context.Context) error {
+	baseCtx, cancelBase := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelBase()
 	srv := &http.Server{
 		Addr:    s.cfg.Addr,
 		Handler: s.Handler(),
 		BaseContext: func(_ net.Listener) context.Context {
-			return ctx
+			return baseCtx
 		},
 	}
 
@@ -104,6 +316,9 @@ func (s *Server) ListenAndServe(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
 		log.Println("shutting down server...")
+		if s.client != nil {
+			s.client.Drain()
+		}
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 		return srv.Shutdown(shutdownCtx)
@@ -2,8 +2,10 @@ package server
 
 import (
 	"context"
+	"io"
 	"net"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -65,6 +67,9 @@ func TestListenAndServe_GracefulShutdown(t *testing.T) {
 	case <-time.After(2 * time.Second):
 		t.Fatal("shutdown did not complete within 2 seconds")
 	}
+	if !client.Draining() {
+		t.Error("client should be drained on shutdown")
+	}
 }
 
 // TestListenAndServe_ShutdownDeadline verifies that the shutdown deadline is enforced.
@@ -139,3 +144,93 @@ func TestListenAndServe_ShutdownDeadline(t *testing.T) {
 		t.Fatal("shutdown did not complete within 20 seconds (deadline is 15s)")
 	}
 }
+
+// TestListenAndServe_ShutdownCompletesStream verifies that a stream in
+// flight when shutdown begins is not cancelled but runs to completion.
+func TestListenAndServe_ShutdownCompletesStream(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	client := cchat.NewClientWithSpawner(&cchat.ClientConfig{}, func(ctx context.Context, _ string, _ cchat.QueryOptions) (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.Write([]byte(`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"PO"}}}` + "\n"))
+			close(started)
+			select {
+			case <-release:
+			case <-ctx.Done():
+				pw.CloseWithError(ctx.Err())
+				return
+			}
+			pw.Write([]byte(`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"NG"}}}` + "\n" +
+				`{"type":"result","subtype":"success","result":"PONG"}` + "\n"))
+			pw.Close()
+		}()
+		return pr, nil
+	})
+	srv := New(Config{Addr: addr, Client: client})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe(ctx)
+	}()
+
+	// Wait for server to be ready
+	for i := 0; ; i++ {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 9 {
+			t.Fatal("server did not become ready")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	bodyCh := make(chan string, 1)
+	go func() {
+		body := `{"stream":true,"messages":[{"role":"user","content":"ping"}]}`
+		resp, err := http.Post("http://"+addr+"/v1/chat/completions", "application/json", strings.NewReader(body))
+		if err != nil {
+			bodyCh <- "error: " + err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		bodyCh <- string(data)
+	}()
+
+	<-started
+	cancel()
+	for !client.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond) // let a cancelled request context take effect
+	close(release)
+
+	select {
+	case body := <-bodyCh:
+		if !strings.Contains(body, `"NG"`) || !strings.Contains(body, `"finish_reason":"stop"`) || !strings.Contains(body, "data: [DONE]") {
+			t.Errorf("stream body = %q, want it to complete", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not complete")
+	}
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("ListenAndServe = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not complete")
+	}
+}
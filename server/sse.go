@@ -1,19 +1,40 @@
 // Package server provides an OpenAI-compatible HTTP server backed by Claude Code
 // CLI subprocesses.
 //
-// The server exposes two endpoints:
+// The server exposes the following endpoints:
 //
 //   - POST /v1/chat/completions — Accepts OpenAI-format chat completion requests,
 //     translates them into Claude Code subprocess calls via the [oai] bridge, and
 //     returns responses in OpenAI format. Both streaming (Server-Sent Events) and
 //     non-streaming modes are supported.
+//   - POST /v1/completions — Accepts legacy text completion requests with a flat
+//     prompt string, reusing the chat bridge and returning text_completion objects.
+//   - POST /v1/messages — Accepts Anthropic Messages API requests and returns
+//     Anthropic-shaped messages or typed SSE events, reusing the chat bridge
+//     via the [anthropic] adapter. Only registered when
+//     [Config].MessagesAPI is set.
+//   - POST /v1/batch — Accepts a JSON array of non-streaming chat completion
+//     requests, runs them concurrently, and returns their results in input
+//     order. Only registered when [Config].BatchAPI is set.
 //   - GET /v1/models — Returns the list of available Claude models.
+//   - GET /v1/models/{id} — Returns a single model, or 404 if it is not
+//     offered.
+//   - GET /stats — Returns the client's concurrency and query counters as
+//     JSON; see [cchat.Client.Stats].
+//   - GET /metrics — Prometheus metrics. Only registered when
+//     [Config].MetricsEnabled is set.
 //
 // Inbound requests pass through a middleware stack applied in the following order:
 //
 //  1. Panic recovery — catches panics and returns a 500 JSON error.
 //  2. Logging — logs method, path, status code, and duration for every request.
-//  3. Auth — validates Bearer tokens using constant-time comparison. Skipped when
+//  3. Tracing — starts an "http.request" span for every request. Skipped
+//     unless [Config].Tracer is set.
+//  4. Compression — gzip or deflate for JSON responses, never for SSE.
+//     Skipped unless [Config].EnableCompression is set.
+//  5. CORS — answers OPTIONS preflight requests and adds Access-Control-*
+//     headers for allowed origins. Skipped when no origins are configured.
+//  6. Auth — validates Bearer tokens using constant-time comparison. Skipped when
 //     no API key is configured.
 //
 // # Usage
@@ -31,14 +52,21 @@ package server
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 )
 
 // sseWriter wraps an http.ResponseWriter for Server-Sent Events.
+//
+// Each event is written and flushed as a single frame: an optional
+// "event: <name>" line, one "data: <payload>" line, and an empty line, every
+// line ending in a bare "\n" with no other whitespace. Payloads are compact
+// JSON, which never contains a newline, or the literal [DONE] that ends chat
+// and legacy completion streams. No comments or keep-alive events are sent.
 type sseWriter struct {
 	w       http.ResponseWriter
-	flusher http.Flusher
+	rc      *http.ResponseController // flushes through middleware wrappers via Unwrap
+	started bool                     // true once an event has been written and the 200 status sent
+	event   string                   // name for events written without one; see Config.SSEMessageEvents
 }
 
 func newSSEWriter(w http.ResponseWriter) *sseWriter {
@@ -46,46 +74,75 @@ func newSSEWriter(w http.ResponseWriter) *sseWriter {
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	flusher, _ := w.(http.Flusher)
-	return &sseWriter{w: w, flusher: flusher}
+	return &sseWriter{w: w, rc: http.NewResponseController(w)}
+}
+
+// flush sends buffered output to the client. Writers that cannot flush are
+// left to send it when the handler returns.
+func (s *sseWriter) flush() {
+	s.rc.Flush()
 }
 
 // WriteEvent writes a single SSE event with the given data.
 func (s *sseWriter) WriteEvent(data any) error {
+	return s.WriteNamedEvent("", data)
+}
+
+// WriteNamedEvent writes a single SSE event with the given data, preceded by
+// an "event:" line naming it unless name is empty.
+func (s *sseWriter) WriteNamedEvent(name string, data any) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
-	_, err = fmt.Fprintf(s.w, "data: %s\n\n", jsonData)
-	if err != nil {
-		return err
-	}
-	if s.flusher != nil {
-		s.flusher.Flush()
-	}
-	return nil
+	s.started = true
+	return s.writeFrame(name, jsonData)
 }
 
 // WriteDone writes the final [DONE] event.
 func (s *sseWriter) WriteDone() {
-	fmt.Fprint(s.w, "data: [DONE]\n\n")
-	if s.flusher != nil {
-		s.flusher.Flush()
+	s.writeFrame("", []byte("[DONE]"))
+}
+
+// writeFrame writes one event in a single write and flushes it. An empty
+// name falls back to the writer's default event name, if any.
+func (s *sseWriter) writeFrame(name string, data []byte) error {
+	if name == "" {
+		name = s.event
 	}
+	var frame []byte
+	if name != "" {
+		frame = append(frame, "event: "+name+"\n"...)
+	}
+	frame = append(frame, "data: "...)
+	frame = append(frame, data...)
+	frame = append(frame, "\n\n"...)
+	if _, err := s.w.Write(frame); err != nil {
+		return err
+	}
+	s.flush()
+	return nil
 }
 
-// WriteError writes an SSE error event with the appropriate HTTP status code.
-// This is used for unrecoverable errors that occur during streaming.
+// WriteError writes an OpenAI-style SSE error event for an unrecoverable
+// error that occurs during streaming. If no event has been written yet, the
+// response status is set to status first; once the stream has started the
+// 200 status is already on the wire, so only the event is written. Callers
+// should follow it with [sseWriter.WriteDone].
 func (s *sseWriter) WriteError(status int, errType, message string) {
-	s.w.WriteHeader(status)
-	jsonData, _ := json.Marshal(map[string]any{
+	s.WriteErrorEvent(status, "", map[string]any{
 		"error": map[string]string{
 			"message": message,
 			"type":    errType,
 		},
 	})
-	fmt.Fprintf(s.w, "data: %s\n\n", jsonData)
-	if s.flusher != nil {
-		s.flusher.Flush()
+}
+
+// WriteErrorEvent is like [sseWriter.WriteError] but writes data as an event
+// named name, for streams with their own error shape.
+func (s *sseWriter) WriteErrorEvent(status int, name string, data any) {
+	if !s.started {
+		s.w.WriteHeader(status)
 	}
+	s.WriteNamedEvent(name, data)
 }
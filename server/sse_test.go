@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/codewandler/cc-sdk-go/cchat"
+	"github.com/codewandler/cc-sdk-go/ccwire"
+	"github.com/codewandler/cc-sdk-go/oai"
+)
+
+// volatileFields matches the chunk fields that change from run to run.
+var volatileFields = regexp.MustCompile(`"(id":"chatcmpl-|created":)\d+`)
+
+// TestSSEFraming compares chat streams byte for byte with the expected
+// framing, with and without Config.SSEMessageEvents and
+// Config.OmitNullFinishReason.
+func TestSSEFraming(t *testing.T) {
+	messages := func() []ccwire.Message {
+		return []ccwire.Message{
+			&ccwire.StreamEventMessage{Event: map[string]any{"type": "message_start", "message": map[string]any{"model": "claude-haiku"}}},
+			&ccwire.StreamEventMessage{Event: map[string]any{
+				"type":  "content_block_delta",
+				"delta": map[string]any{"type": "text_delta", "text": "Hi"},
+			}},
+		}
+	}
+	const (
+		role  = `data: {"id":"chatcmpl-N","object":"chat.completion.chunk","created":N,"model":"claude-haiku","choices":[{"index":0,"delta":{"role":"assistant"},"finish_reason":null}],"system_fingerprint":"fp"}` + "\n\n"
+		text  = `data: {"id":"chatcmpl-N","object":"chat.completion.chunk","created":N,"model":"claude-haiku","choices":[{"index":0,"delta":{"content":"Hi"},"finish_reason":null}],"system_fingerprint":"fp"}` + "\n\n"
+		stop  = `data: {"id":"chatcmpl-N","object":"chat.completion.chunk","created":N,"model":"claude-haiku","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"system_fingerprint":"fp"}` + "\n\n"
+		fail  = `data: {"error":{"message":"slow down","type":"rate_limit_exceeded"}}` + "\n\n"
+		done  = "data: [DONE]\n\n"
+		event = "event: message\n"
+
+		compactRole = `data: {"id":"chatcmpl-N","object":"chat.completion.chunk","created":N,"model":"claude-haiku","choices":[{"index":0,"delta":{"role":"assistant"}}],"system_fingerprint":"fp"}` + "\n\n"
+		compactText = `data: {"id":"chatcmpl-N","object":"chat.completion.chunk","created":N,"model":"claude-haiku","choices":[{"index":0,"delta":{"content":"Hi"}}],"system_fingerprint":"fp"}` + "\n\n"
+	)
+
+	tests := []struct {
+		name     string
+		events   bool
+		omitNull bool
+		stream   *mockStream
+		want     string
+	}{
+		{
+			name:   "finished",
+			stream: &mockStream{messages: append(messages(), &ccwire.ResultMessage{Subtype: "success", Result: "Hi"})},
+			want:   role + text + stop + done,
+		},
+		{
+			name:   "error",
+			stream: &mockStream{messages: messages(), err: &cchat.RateLimitError{Message: "slow down"}},
+			want:   role + text + fail + done,
+		},
+		{
+			name:   "finished_message_events",
+			events: true,
+			stream: &mockStream{messages: append(messages(), &ccwire.ResultMessage{Subtype: "success", Result: "Hi"})},
+			want:   event + role + event + text + event + stop + event + done,
+		},
+		{
+			name:   "error_message_events",
+			events: true,
+			stream: &mockStream{messages: messages(), err: &cchat.RateLimitError{Message: "slow down"}},
+			want:   event + role + event + text + event + fail + event + done,
+		},
+		{
+			name:     "finished_omit_null_finish_reason",
+			omitNull: true,
+			stream:   &mockStream{messages: append(messages(), &ccwire.ResultMessage{Subtype: "success", Result: "Hi"})},
+			want:     compactRole + compactText + stop + done,
+		},
+		{
+			name:     "error_omit_null_finish_reason",
+			omitNull: true,
+			stream:   &mockStream{messages: messages(), err: &cchat.RateLimitError{Message: "slow down"}},
+			want:     compactRole + compactText + fail + done,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{SystemFingerprint: "fp", SSEMessageEvents: tt.events, OmitNullFinishReason: tt.omitNull})
+			w := httptest.NewRecorder()
+			srv.handleStreamingResponse(context.Background(), w, tt.stream, &oai.ChatCompletionRequest{})
+
+			if got := volatileFields.ReplaceAllString(w.Body.String(), `"${1}N`); got != tt.want {
+				t.Errorf("stream =\n%q\nwant\n%q", got, tt.want)
+			}
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+				t.Errorf("status = %d, Content-Type = %q, want 200 and text/event-stream", w.Code, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}